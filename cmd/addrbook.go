@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/addrman"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+// dnsSeedSource is the source recorded for addresses resolved from the dns
+// seeds, they all share a source group so the seeds can not fill the new table
+const dnsSeedSource = "dnsseed"
+
+// addrBookSaveInterval is how often the address book is written while
+// running, the same interval bitcoin core dumps its peers.dat
+// check: https://github.com/bitcoin/bitcoin/blob/master/src/net.h
+const addrBookSaveInterval = 15 * time.Minute
+
+var (
+	// book keeps the peers we learned across runs, it is
+	// loaded from the file given through --addrbook
+	book *addrman.AddrMan
+	// stopSaving ends the periodic saving of the address book
+	stopSaving = make(chan struct{})
+)
+
+func addrBookFile() string {
+	if addrBookPath != "" {
+		return addrBookPath
+	}
+	return fmt.Sprintf("peers-%s.json", params.Name)
+}
+
+// loadAddrBook loads the address book and keeps saving it every
+// addrBookSaveInterval, until closeAddrBook saves it a last time
+func loadAddrBook() {
+	var err error
+	book, err = addrman.Load(addrBookFile())
+	if err != nil {
+		log.Fatalf("while loading address book: %s", err.Error())
+	}
+
+	go func() {
+		ticker := time.NewTicker(addrBookSaveInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				saveAddrBook()
+			case <-stopSaving:
+				return
+			}
+		}
+	}()
+}
+
+func closeAddrBook() {
+	close(stopSaving)
+	saveAddrBook()
+}
+
+func saveAddrBook() {
+	if err := book.Save(addrBookFile()); err != nil {
+		log.Printf("while saving address book: %s", err.Error())
+	}
+}
+
+// learnAddresses stores the addresses announced through addr and addrv2
+func learnAddresses(remote net.Addr, msg *messages.Message) {
+	var learned []addrman.Address
+	switch payload := msg.Payload.(type) {
+	case *messages.Addr:
+		for idx := range payload.Addresses {
+			learned = append(learned, addrman.FromNetworkAddress(payload.Addresses[idx]))
+		}
+	case *messages.AddrV2:
+		for idx := range payload.Addresses {
+			if payload.Addresses[idx].IsKnown() {
+				learned = append(learned, addrman.FromAddrV2(payload.Addresses[idx]))
+			}
+		}
+	default:
+		return
+	}
+
+	source, _, err := net.SplitHostPort(remote.String())
+	if err != nil {
+		return
+	}
+
+	if added := book.Add(source, learned...); added > 0 {
+		log.Printf("learned %d new addresses from %s", added, remote)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/bloom"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/handshake"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/inventory"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+)
+
+// bloomFalsePositiveRate of the loaded filter, false positives
+// are what keeps the peers from knowing what we are looking for
+const bloomFalsePositiveRate = 0.0001
+
+// maxAnnouncedHeaders is the most headers a peer announces new blocks with
+// after sendheaders (BIP130), bigger headers messages are part of the sync
+const maxAnnouncedHeaders = 8
+
+var (
+	// bloomFilter is loaded into the peers advertising NodeBloom, it
+	// is only built when the --bloom flag is given
+	bloomFilter *bloom.Filter
+	// filteredBlocks are only asked once, whatever peer announced them
+	filteredBlocks = inventory.NewTracker(inventory.DefaultTrackerSize)
+)
+
+func openBloomFilter() {
+	var elements [][]byte
+	for _, element := range strings.Split(bloomElements, ",") {
+		element = strings.TrimSpace(element)
+		if element == "" {
+			continue
+		}
+
+		decoded, err := hex.DecodeString(element)
+		if err != nil {
+			log.Fatalf("invalid bloom filter element %q: %s", element, err.Error())
+		}
+		elements = append(elements, decoded)
+	}
+
+	if len(elements) == 0 {
+		return
+	}
+
+	bloomFilter = bloom.NewFilter(uint32(len(elements)), bloomFalsePositiveRate, rand.Uint32(), messages.BloomUpdateAll)
+	for _, element := range elements {
+		bloomFilter.Add(element)
+	}
+}
+
+// requiredServices are the services outbound peers must advertise, the
+// ones serving bloom filters with --bloom and compact block filters with
+// --sync-filters, otherwise the outbound slots go to peers we can not use
+func requiredServices() uint64 {
+	var services uint64
+	if bloomFilter != nil {
+		services |= messages.NodeBloom
+	}
+	if filterHeaders != nil {
+		services |= messages.NodeCompactFilters
+	}
+	return services
+}
+
+// bloomRelay asks a single peer for the filtered blocks and
+// transactions matching the bloom filter
+type bloomRelay struct {
+	stream *network.Stream
+}
+
+// startBloomFilter loads the bloom filter into the remote, it returns nil
+// when there is no filter or the remote does not serve bloom filters
+func startBloomFilter(stream *network.Stream, info *handshake.PeerInfo) (*bloomRelay, error) {
+	if bloomFilter == nil {
+		return nil, nil
+	}
+
+	if info.Services&messages.NodeBloom == 0 {
+		log.Printf("%s does not serve bloom filters, not loading the filter", stream.RemoteAddr())
+		return nil, nil
+	}
+
+	if err := stream.SendMessage(messages.CmdFilterLoad, bloomFilter.FilterLoad()); err != nil {
+		return nil, fmt.Errorf("while sending filterload: %w", err)
+	}
+	return &bloomRelay{stream: stream}, nil
+}
+
+// handleMessage asks the announced blocks as merkle blocks and prints the
+// matches, it returns true if there is nothing left to do with the message
+func (b *bloomRelay) handleMessage(msg *messages.Message) (bool, error) {
+	if b == nil {
+		return false, nil
+	}
+
+	switch payload := msg.Payload.(type) {
+	case *messages.Inv:
+		// the announcement is still printed
+		var blocks []messages.Hash
+		for _, entry := range payload.Inventory {
+			if entry.Type&^messages.InvWitnessFlag == messages.InvBlock {
+				blocks = append(blocks, entry.Hash)
+			}
+		}
+		return false, b.requestFilteredBlocks(blocks)
+	case *messages.Headers:
+		// peers that were sent sendheaders announce new blocks this way
+		if len(payload.Headers) > maxAnnouncedHeaders {
+			return false, nil
+		}
+
+		blocks := make([]messages.Hash, len(payload.Headers))
+		for idx := range payload.Headers {
+			blocks[idx] = payload.Headers[idx].BlockHash()
+		}
+		return false, b.requestFilteredBlocks(blocks)
+	case *messages.MerkleBlock:
+		return true, b.handleMerkleBlock(payload)
+	case *messages.Tx:
+		if bloomFilter.MatchTxAndUpdate(payload) {
+			fmt.Printf("tx %s from %s matches the bloom filter\n", payload.TxHash(), b.stream.RemoteAddr())
+		}
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (b *bloomRelay) requestFilteredBlocks(hashes []messages.Hash) error {
+	blocks := make([]messages.InvVect, len(hashes))
+	for idx, hash := range hashes {
+		blocks[idx] = messages.InvVect{Type: messages.InvFilteredBlock, Hash: hash}
+	}
+
+	blocks = filteredBlocks.Filter(blocks)
+	if len(blocks) == 0 {
+		return nil
+	}
+	return b.stream.SendMessage(messages.CmdGetData, &messages.GetData{Inventory: blocks})
+}
+
+// handleMerkleBlock prints the matched transactions once the partial merkle
+// tree proves them, they are sent as tx messages right after
+func (b *bloomRelay) handleMerkleBlock(merkleBlock *messages.MerkleBlock) error {
+	txids, _, err := merkleBlock.ExtractMatches()
+	if err != nil {
+		return fmt.Errorf("while checking merkle block %s: %w", merkleBlock.BlockHash(), err)
+	}
+
+	fmt.Printf("merkle block %s from %s [txs=%d] [matched=%d]\n",
+		merkleBlock.BlockHash(), b.stream.RemoteAddr(), merkleBlock.Transactions, len(txids))
+	for _, txid := range txids {
+		fmt.Printf("\t%s\n", txid)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/compactblock"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/handshake"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+)
+
+// pendingBlockTimeout is how long the missing transactions of a compact
+// block are waited for, the full block is requested after it
+const pendingBlockTimeout = 30 * time.Second
+
+// txPool has the transactions compact blocks are rebuilt from, while
+// there is no pool every transaction of the block is requested
+var txPool compactblock.TxPool
+
+// compactRelay follows the compact blocks sent by a single peer
+type compactRelay struct {
+	stream *network.Stream
+	// pending blocks waiting for their missing transactions
+	pending map[messages.Hash]*pendingBlock
+}
+
+type pendingBlock struct {
+	reconstructor *compactblock.Reconstructor
+	received      time.Time
+}
+
+// startCompactBlocks asks the remote to send new blocks straight as compact blocks, it
+// returns nil when compact blocks are not enabled or the remote's version does not know them
+func startCompactBlocks(stream *network.Stream, info *handshake.PeerInfo) (*compactRelay, error) {
+	if !compactBlocks {
+		return nil, nil
+	}
+
+	if min(info.Version, ourProtocolVersion) < messages.CompactBlocksMinVersion {
+		log.Printf("%s does not know compact blocks, its version is %d", stream.RemoteAddr(), info.Version)
+		return nil, nil
+	}
+
+	err := stream.SendMessage(messages.CmdSendCmpct, &messages.SendCmpct{
+		Announce: true,
+		Version:  messages.CompactBlocksVersion,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("while sending sendcmpct: %w", err)
+	}
+
+	return &compactRelay{stream: stream, pending: make(map[messages.Hash]*pendingBlock)}, nil
+}
+
+// handleMessage rebuilds the compact blocks, it returns true if the message was part of it
+func (c *compactRelay) handleMessage(msg *messages.Message) (bool, error) {
+	if c == nil {
+		return false, nil
+	}
+
+	switch payload := msg.Payload.(type) {
+	case *messages.CmpctBlock:
+		return true, c.handleCmpctBlock(payload)
+	case *messages.BlockTxn:
+		return true, c.handleBlockTxn(payload)
+	default:
+		return false, nil
+	}
+}
+
+// expire requests the full block of the compact blocks whose
+// missing transactions were not sent within the pendingBlockTimeout
+func (c *compactRelay) expire() error {
+	if c == nil {
+		return nil
+	}
+
+	for hash, pending := range c.pending {
+		if time.Since(pending.received) < pendingBlockTimeout {
+			continue
+		}
+
+		delete(c.pending, hash)
+		log.Printf("%s did not send the missing transactions of compact block %s, requesting the block", c.stream.RemoteAddr(), hash)
+		if err := c.requestBlock(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *compactRelay) handleCmpctBlock(cmpct *messages.CmpctBlock) error {
+	hash := cmpct.BlockHash()
+	if _, ok := c.pending[hash]; ok {
+		return nil
+	}
+
+	reconstructor, err := compactblock.NewReconstructor(cmpct, txPool)
+	if err != nil {
+		log.Printf("while rebuilding compact block %s from %s: %s", hash, c.stream.RemoteAddr(), err.Error())
+		return c.requestBlock(hash)
+	}
+
+	pending := &pendingBlock{reconstructor: reconstructor, received: time.Now()}
+	if request := reconstructor.Request(); request != nil {
+		c.pending[hash] = pending
+		return c.stream.SendMessage(messages.CmdGetBlockTxn, request)
+	}
+	return c.finish(pending)
+}
+
+func (c *compactRelay) handleBlockTxn(txn *messages.BlockTxn) error {
+	pending, ok := c.pending[txn.BlockHash]
+	if !ok {
+		return nil
+	}
+	delete(c.pending, txn.BlockHash)
+
+	if err := pending.reconstructor.Fill(txn); err != nil {
+		return err
+	}
+	return c.finish(pending)
+}
+
+// finish prints how the block was rebuilt, falling back to the full
+// block when the transactions do not match the header
+func (c *compactRelay) finish(pending *pendingBlock) error {
+	hash := pending.reconstructor.BlockHash()
+
+	block, err := pending.reconstructor.Block()
+	if err != nil {
+		log.Printf("while rebuilding compact block %s from %s: %s", hash, c.stream.RemoteAddr(), err.Error())
+		return c.requestBlock(hash)
+	}
+
+	if pool != nil {
+		pool.RemoveBlock(block)
+	}
+
+	prefilled, fromPool, requested := pending.reconstructor.Stats()
+	fmt.Printf("compact block %s from %s rebuilt in %s [txs=%d] [prefilled=%d] [pool=%d] [requested=%d]\n",
+		hash, c.stream.RemoteAddr(), time.Since(pending.received), len(block.Transactions), prefilled, fromPool, requested)
+	return nil
+}
+
+func (c *compactRelay) requestBlock(hash messages.Hash) error {
+	return c.stream.SendMessage(messages.CmdGetData, &messages.GetData{
+		Inventory: []messages.InvVect{{Type: messages.InvWitnessBlock, Hash: hash}},
+	})
+}
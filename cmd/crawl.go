@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/chaincfg"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/crawler"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/handshake"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+// runCrawl handles the crawl subcommand, it walks the network starting
+// from the seeds and prints every reachable peer as a table or JSON
+func runCrawl(args []string) {
+	fs := flag.NewFlagSet("crawl", flag.ExitOnError)
+	networkName := fs.String("network", chaincfg.MainNet.Name, "mainnet, testnet3, testnet4, signet or regtest")
+	seeds := fs.String("seeds", "", "comma separated list of peers (host or host:port) to start from, defaults to the dns seeds")
+	concurrency := fs.Int("concurrency", crawler.DefaultConcurrency, "maximum number of peers visited at the same time")
+	maxPeers := fs.Int("max-peers", 1000, "stop after visiting this many peers, 0 means no limit")
+	dialTimeout := fs.Duration("dial-timeout", crawler.DefaultDialTimeout, "time to open the connection with each peer")
+	handshakeTimeout := fs.Duration("handshake-timeout", handshake.DefaultTimeout, "time to establish the handshake with each peer")
+	addrTimeout := fs.Duration("addr-timeout", crawler.DefaultAddrTimeout, "time to wait each peer to answer getaddr")
+	allowUnroutable := fs.Bool("allow-unroutable", false, "also visit private and loopback addresses")
+	proxy := fs.String("proxy", "", "SOCKS5 proxy (host:port) used to reach every peer")
+	onionProxy := fs.String("onion-proxy", "", "SOCKS5 proxy (host:port) used to reach .onion peers, enables visiting them")
+	asJSON := fs.Bool("json", false, "print the reachable peers as JSON instead of a table")
+	fs.Parse(args)
+
+	params, err := chaincfg.ByName(*networkName)
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+
+	var seedAddrs []string
+	for _, seed := range strings.Split(*seeds, ",") {
+		if seed = strings.TrimSpace(seed); seed != "" {
+			seedAddrs = append(seedAddrs, withDefaultPort(seed, params.DefaultPort))
+		}
+	}
+
+	// without explicit seeds the crawl starts from the network dns seeds
+	if len(seedAddrs) == 0 {
+		seedAddrs, err = dnsSeedAddrs(params, messages.NodeNetwork, *proxy != "")
+		if err != nil {
+			log.Fatalf("no --seeds given and the dns seeds failed: %s", err.Error())
+		}
+	}
+
+	crawl := crawler.New(crawler.Config{
+		Dial:             crawler.Dialer(params.Magic, *dialTimeout, newDialer(*proxy, *onionProxy, true)),
+		Version:          peerVersion,
+		Concurrency:      *concurrency,
+		MaxPeers:         *maxPeers,
+		HandshakeTimeout: *handshakeTimeout,
+		AddrTimeout:      *addrTimeout,
+		AllowUnroutable:  *allowUnroutable,
+		AllowOnion:       *proxy != "" || *onionProxy != "",
+	})
+
+	// on ctrl+c the crawl stops and what was visited so far is printed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	results := crawl.Run(ctx, seedAddrs)
+
+	reachable := make([]crawler.Result, 0, len(results))
+	for _, result := range results {
+		if result.Reachable {
+			reachable = append(reachable, result)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "visited %d peers, %d reachable\n", len(results), len(reachable))
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reachable); err != nil {
+			log.Fatalf("while encoding results: %s", err.Error())
+		}
+		return
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ADDR\tVERSION\tSERVICES\tUSER AGENT\tSTART HEIGHT\tRELAY\tLEARNED")
+	for _, result := range reachable {
+		fmt.Fprintf(table, "%s\t%d\t%d\t%s\t%d\t%v\t%d\n", result.Addr, result.Version,
+			result.Services, result.UserAgent, result.StartHeight, result.Relay, result.Learned)
+	}
+	table.Flush()
+}
+
+// withDefaultPort appends the port to the address if it has none
+func withDefaultPort(addr string, port uint16) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, strconv.Itoa(int(port)))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/blockfilter"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/handshake"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/inventory"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+)
+
+var (
+	// filterHeaders follows the filter headers of the header chain, it
+	// is only kept when the --sync-filters flag is given
+	filterHeaders *blockfilter.HeaderChain
+	// watched are the scripts looked for in the block filters
+	watched [][]byte
+	// matched blocks are only printed once, whatever peer sent their filter
+	matched = inventory.NewTracker(inventory.DefaultTrackerSize)
+)
+
+func openFilterHeaders() {
+	if !syncFilters {
+		return
+	}
+
+	if chain == nil {
+		log.Fatalf("--sync-filters needs the block headers, use it along with --sync-headers")
+	}
+
+	for _, script := range strings.Split(watchScripts, ",") {
+		script = strings.TrimSpace(script)
+		if script == "" {
+			continue
+		}
+
+		decoded, err := hex.DecodeString(script)
+		if err != nil {
+			log.Fatalf("invalid watched script %q: %s", script, err.Error())
+		}
+		watched = append(watched, decoded)
+	}
+
+	filterHeaders = blockfilter.NewHeaderChain(chain)
+}
+
+// startFiltersSync syncs the filter headers of peers serving compact
+// block filters, it returns nil when filters are not synced with the peer
+func startFiltersSync(stream *network.Stream, info *handshake.PeerInfo) (*blockfilter.Syncer, error) {
+	if filterHeaders == nil || info.Services&messages.NodeCompactFilters == 0 {
+		return nil, nil
+	}
+
+	var onFilter blockfilter.FilterHandler
+	scanFrom := int32(-1)
+	if len(watched) > 0 {
+		onFilter = printMatches
+		scanFrom = int32(scanFromHeight)
+		if scanFrom < 0 {
+			// only the blocks found from now on
+			_, height := chain.Tip()
+			scanFrom = height + 1
+		}
+	}
+
+	syncer := blockfilter.NewSyncer(filterHeaders, stream, scanFrom, onFilter)
+	return syncer, syncer.Start()
+}
+
+// handleFilters gives the message to the syncer, which follows the header
+// chain so it is also told when new headers arrive
+func handleFilters(syncer *blockfilter.Syncer, msg *messages.Message) (bool, error) {
+	if syncer == nil {
+		return false, nil
+	}
+
+	if _, ok := msg.Payload.(*messages.Headers); ok {
+		return false, syncer.Sync()
+	}
+	return syncer.HandleMessage(msg)
+}
+
+func printMatches(hash messages.Hash, height int32, filter *blockfilter.Filter) {
+	match, err := filter.MatchAny(watched)
+	if err != nil {
+		log.Printf("while matching filter of block %s: %s", hash, err.Error())
+		return
+	}
+
+	if !match {
+		return
+	}
+
+	if len(matched.Filter([]messages.InvVect{{Type: messages.InvBlock, Hash: hash}})) > 0 {
+		fmt.Printf("block %s at height %d might have a watched script\n", hash, height)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/headerchain"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+)
+
+// chain follows the best chain of headers of our peers, it
+// is only kept when the --sync-headers flag is given
+var chain *headerchain.Chain
+
+func headersFile() string {
+	if headersPath != "" {
+		return headersPath
+	}
+	return fmt.Sprintf("headers-%s.dat", params.Name)
+}
+
+func openHeaderChain() {
+	if !syncHeaders {
+		return
+	}
+
+	var err error
+	chain, err = headerchain.Open(headersFile(), params)
+	if err != nil {
+		log.Fatalf("while opening header chain: %s", err.Error())
+	}
+
+	hash, height := chain.Tip()
+	log.Printf("loaded headers up to %s at height %d", hash, height)
+}
+
+func closeHeaderChain() {
+	if chain == nil {
+		return
+	}
+
+	if err := chain.Close(); err != nil {
+		log.Printf("while closing header chain: %s", err.Error())
+	}
+}
+
+// startHeadersSync asks the remote for its headers, it returns nil
+// when headers are not being synced
+func startHeadersSync(stream *network.Stream) (*headerchain.Syncer, error) {
+	if chain == nil {
+		return nil, nil
+	}
+
+	syncer := headerchain.NewSyncer(chain, stream, ourProtocolVersion)
+	return syncer, syncer.Start()
+}
+
+// handleHeaders gives the message to the syncer, printing the tip when it changes
+func handleHeaders(syncer *headerchain.Syncer, msg *messages.Message) (bool, error) {
+	if syncer == nil {
+		return false, nil
+	}
+
+	before, _ := chain.Tip()
+	handled, err := syncer.HandleMessage(msg)
+
+	if after, height := chain.Tip(); after != before {
+		fmt.Printf("chain tip %s at height %d\n", after, height)
+	}
+	return handled, err
+}
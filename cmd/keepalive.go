@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/handshake"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/inventory"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+)
+
+// keepConnectionAlive holds the connection open after the handshake,
+// pinging the remote and printing every other message it sends
+func keepConnectionAlive(stream *network.Stream, info *handshake.PeerInfo) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	keepAlive := network.NewKeepAlive(stream, pingInterval, pingTimeout)
+	go func() {
+		err := keepAlive.Run(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("keep alive with %s stopped: %s", stream.RemoteAddr(), err.Error())
+			stream.Close()
+		}
+	}()
+
+	syncer, err := startHeadersSync(stream)
+	if err != nil {
+		log.Printf("while syncing headers from %s: %s", stream.RemoteAddr(), err.Error())
+		return
+	}
+
+	filters, err := startFiltersSync(stream, info)
+	if err != nil {
+		log.Printf("while syncing filters from %s: %s", stream.RemoteAddr(), err.Error())
+		return
+	}
+
+	compact, err := startCompactBlocks(stream, info)
+	if err != nil {
+		log.Printf("while enabling compact blocks with %s: %s", stream.RemoteAddr(), err.Error())
+		return
+	}
+
+	filtered, err := startBloomFilter(stream, info)
+	if err != nil {
+		log.Printf("while loading the bloom filter into %s: %s", stream.RemoteAddr(), err.Error())
+		return
+	}
+
+	relay, err := startMempool(stream, info)
+	if err != nil {
+		log.Printf("while asking the mempool of %s: %s", stream.RemoteAddr(), err.Error())
+		return
+	}
+
+	// announcements are only printed the first time the remote makes them
+	known := inventory.NewTracker(inventory.DefaultTrackerSize)
+
+	for {
+		msg, err := stream.ReadMessage()
+		if err != nil {
+			log.Printf("connection with %s closed: %s", stream.RemoteAddr(), err.Error())
+			return
+		}
+
+		// compact blocks whose transactions never came are requested in full
+		if err := compact.expire(); err != nil {
+			log.Printf("while requesting block from %s: %s", stream.RemoteAddr(), err.Error())
+			stream.Close()
+			return
+		}
+
+		handled, err := keepAlive.HandleMessage(msg)
+		if err == nil && !handled {
+			// sendheaders and feefilter are kept on the peer info
+			handled, err = info.HandleMessage(msg)
+		}
+
+		if err != nil {
+			log.Printf("while handling %s from %s: %s", string(msg.Command), stream.RemoteAddr(), err.Error())
+			stream.Close()
+			return
+		}
+
+		if !handled {
+			handled, err = handleHeaders(syncer, msg)
+			if err != nil {
+				log.Printf("invalid headers from %s: %s", stream.RemoteAddr(), err.Error())
+				stream.Close()
+				return
+			}
+		}
+
+		// new headers are also followed by the filters
+		filtersHandled, err := handleFilters(filters, msg)
+		if err != nil {
+			log.Printf("invalid filters from %s: %s", stream.RemoteAddr(), err.Error())
+			stream.Close()
+			return
+		}
+		handled = handled || filtersHandled
+
+		// the mempool sees every transaction and confirmed block
+		poolHandled, err := relay.handleMessage(msg)
+		if err != nil {
+			log.Printf("while handling %s from %s: %s", string(msg.Command), stream.RemoteAddr(), err.Error())
+			stream.Close()
+			return
+		}
+		handled = handled || poolHandled
+
+		// as does the bloom filter, which also follows the blocks announced through headers
+		bloomHandled, err := filtered.handleMessage(msg)
+		if err != nil {
+			log.Printf("invalid merkle block from %s: %s", stream.RemoteAddr(), err.Error())
+			stream.Close()
+			return
+		}
+		handled = handled || bloomHandled
+
+		if !handled {
+			handled, err = compact.handleMessage(msg)
+			if err != nil {
+				log.Printf("invalid compact block from %s: %s", stream.RemoteAddr(), err.Error())
+				stream.Close()
+				return
+			}
+		}
+
+		if block, ok := msg.Payload.(*messages.Block); ok {
+			if err := block.CheckMerkleRoot(); err != nil {
+				log.Printf("invalid block %s from %s: %s", block.BlockHash(), stream.RemoteAddr(), err.Error())
+				stream.Close()
+				return
+			}
+		}
+
+		if !handled {
+			handled = printInventory(known, stream.RemoteAddr(), msg)
+		}
+
+		if !handled {
+			fmt.Printf("remote's message\n%s\n\n", msg.String())
+			printAddresses(msg)
+			learnAddresses(stream.RemoteAddr(), msg)
+			continue
+		}
+
+		if _, ok := msg.Payload.(*messages.Pong); ok {
+			if latency, ok := keepAlive.Latency(); ok {
+				fmt.Printf("latency to %s: %s\n", stream.RemoteAddr(), latency)
+			}
+		}
+	}
+}
+
+func printAddresses(msg *messages.Message) {
+	switch payload := msg.Payload.(type) {
+	case *messages.Addr:
+		for idx := range payload.Addresses {
+			fmt.Println(payload.Addresses[idx].String())
+		}
+	case *messages.AddrV2:
+		for idx := range payload.Addresses {
+			fmt.Println(payload.Addresses[idx].String())
+		}
+	default:
+		return
+	}
+	fmt.Println()
+}
+
+// printInventory prints the new entries announced through inv, it returns
+// true if the message was an inv, meaning there is nothing left to do with it
+func printInventory(known *inventory.Tracker, remote net.Addr, msg *messages.Message) bool {
+	payload, ok := msg.Payload.(*messages.Inv)
+	if !ok {
+		return false
+	}
+
+	for _, inv := range known.Filter(payload.Inventory) {
+		fmt.Printf("%s announced %s\n", remote, inv.String())
+	}
+	return true
+}
@@ -1,77 +1,69 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
-	"time"
+	"os"
+	"os/signal"
 
+	"github.com/EclesioMeloJunior/btc-handshake/internal/handshake"
 	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
 	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
 )
 
-func listenForHandshakes() {
-	rcv, err := network.Listen()
+// startListening accepts inbound handshakes until interrupted, it returns
+// once the listener is bound so we can dial our own peers meanwhile, the
+// returned channel is closed when the listener stops
+func startListening() <-chan struct{} {
+	lst, err := network.NewListener(network.ListenConfig{
+		Addr:       listenAddr,
+		MaxInbound: int(maxInbound),
+		MaxPerIP:   int(maxPerIP),
+		Handler:    serveInbound,
+		StreamOpts: streamOpts(),
+	})
 	if err != nil {
 		log.Fatalf(err.Error())
 	}
 
-	for v := range rcv {
-		incoming := make([]byte, 1024)
-		n, err := v.WaitResponse(incoming)
-		if err != nil {
-			log.Fatalf("while reading connection: %s", err.Error())
-		}
+	fmt.Printf("listening on %s\n", lst.Addr())
 
-		fmt.Printf("\nreceived from remote (%d bytes): 0x%x\n", n, incoming[:n])
-		remoteVersionMessage := messages.NewMainMessage(nil, &messages.Version{})
-		err = remoteVersionMessage.Decode(bytes.NewReader(incoming[:n]))
-		if err != nil {
-			log.Fatalf("while decoding remote's message: %s", err.Error())
-		}
-		fmt.Printf("remote's message:\n%s\n\n", remoteVersionMessage.String())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
 
-		err = sendOurVersionAndVerAck(v)
-		if err != nil {
+		if err := lst.Serve(ctx); err != nil {
 			log.Fatalf(err.Error())
 		}
-	}
+	}()
+	return done
 }
 
-func sendOurVersionAndVerAck(stream network.Stream) error {
-	rng := rand.New(rand.NewSource(time.Now().Unix()))
-	version := messages.NewVersion(
-		messages.WithNumber(60002),
-		messages.WithServices(messages.NodeNetwork|messages.NodeNetworkLimited),
-		messages.WithAddrRecvFromString(stream.RemoteAddr().String(), 0),
-		messages.WithAddrFrom("0.0.0.0", 8080, 1),
-		messages.WithNonce(rng.Uint64()),
-		messages.WithUserAgent("btc/eclesios-node"),
-	)
-
-	handshakeVersionMessage := messages.NewMainMessage([]byte("version"), version)
-	encodedHandshake, err := handshakeVersionMessage.Encode()
-	if err != nil {
-		log.Fatalf("could not encode version handshake message: %s", err.Error())
-	}
-
-	err = stream.Send(encodedHandshake)
-	if err != nil {
-		return fmt.Errorf("while sending our version: %w", err)
-	}
-
-	// we should send a verack since we received the remote's version
-	verrackMessage := messages.NewMainMessage([]byte("verack"), messages.EmptyPayload{})
-	encodeVerrack, err := verrackMessage.Encode()
-	if err != nil {
-		log.Fatalf("encoding verack: %s", err.Error())
+// serveInbound runs in its own goroutine for every accepted connection,
+// errors only end the connection with that peer
+func serveInbound(_ context.Context, stream *network.Stream) {
+	peerInfo, err := handshake.Inbound(stream, handshake.Config{
+		Version:     ourVersion(messages.WithAddrRecvFromString(stream.RemoteAddr().String(), 0)),
+		Timeout:     handshakeTimeout,
+		Nonces:      nonces,
+		SendHeaders: syncHeaders,
+		FeeFilter:   feeFilterRate,
+	})
+	if errors.Is(err, handshake.ErrSelfConnection) {
+		log.Printf("dropping connection from %s: %s", stream.RemoteAddr(), err.Error())
+		return
 	}
 
-	err = stream.Send(encodeVerrack)
 	if err != nil {
-		log.Fatalf("while sending verack: %s", err.Error())
+		log.Printf("while establishing handshake with %s: %s", stream.RemoteAddr(), err.Error())
+		return
 	}
+	fmt.Printf("handshake established:\n%s\n\n", peerInfo.String())
 
-	return nil
+	keepConnectionAlive(stream, peerInfo)
 }
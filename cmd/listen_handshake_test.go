@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/handshake"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenerDropsSelfConnection(t *testing.T) {
+	lst, err := network.NewListener(network.ListenConfig{
+		Addr:    "127.0.0.1:0",
+		Handler: serveInbound,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go lst.Serve(ctx)
+
+	dial := func(nonces *handshake.Nonces) error {
+		stream, err := network.Dial(lst.Addr().String())
+		require.NoError(t, err)
+		defer stream.Close()
+
+		_, err = handshake.Outbound(stream, handshake.Config{
+			Version: peerVersion(lst.Addr().String()),
+			Timeout: 5 * time.Second,
+			Nonces:  nonces,
+		})
+		return err
+	}
+
+	// dialing the listener of the same process is dropped
+	require.Error(t, dial(nonces))
+
+	// another node is not
+	require.NoError(t, dial(handshake.NewNonces()))
+}
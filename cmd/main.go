@@ -2,25 +2,146 @@ package main
 
 import (
 	"flag"
+	"log"
+	"os"
 	"strings"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/chaincfg"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/connmgr"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/handshake"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
 )
 
 var (
-	peerAddr string
-	peerPort uint
+	networkName  string
+	peerAddr     string
+	peerPort     uint
+	pingInterval time.Duration
+	pingTimeout  time.Duration
+
+	handshakeTimeout time.Duration
+	requestAddrs     bool
+	listen           bool
+	addrBookPath     string
+	syncHeaders      bool
+	headersPath      string
+	syncFilters      bool
+	watchScripts     string
+	scanFromHeight   int
+	outboundPeers    uint
+
+	proxyAddr      string
+	onionProxyAddr string
+	proxyRandomize bool
+	v2Transport    bool
+	compactBlocks  bool
+	bloomElements  string
+	feeFilterRate  int64
+	keepMempool    bool
+
+	// dialer opens every outbound connection, through the proxies if any
+	dialer *network.Router
+
+	listenAddr string
+	maxInbound uint
+	maxPerIP   uint
+
+	// nonces of our outbound handshakes, shared with
+	// the listener so we never handshake with ourselves
+	nonces = handshake.NewNonces()
+
+	// params of the network chosen through the --network flag
+	params *chaincfg.Params
 )
 
 func init() {
-	flag.StringVar(&peerAddr, "peer-addr", "", "address in the format 0.0.0.0")
-	flag.UintVar(&peerPort, "peer-port", 0, "peer valid TCP port")
+	flag.StringVar(&networkName, "network", chaincfg.MainNet.Name, "mainnet, testnet3, testnet4, signet or regtest")
+	flag.StringVar(&peerAddr, "peer-addr", "", "IPv4 (0.0.0.0), IPv6 (::1), hostname or .onion address of the peer")
+	flag.UintVar(&peerPort, "peer-port", 0, "peer valid TCP port, defaults to the network port")
+	flag.DurationVar(&pingInterval, "ping-interval", 2*time.Minute, "interval between pings sent to the peer")
+	flag.DurationVar(&pingTimeout, "ping-timeout", 20*time.Minute, "time to wait for a pong before disconnecting")
+	flag.DurationVar(&handshakeTimeout, "handshake-timeout", handshake.DefaultTimeout, "time to establish the version handshake")
+	flag.BoolVar(&requestAddrs, "getaddr", false, "request the peer known addresses after the handshake")
+	flag.BoolVar(&listen, "listen", false, "accept inbound handshakes, only waiting for them unless --peer-addr or --outbound is given")
+	flag.StringVar(&proxyAddr, "proxy", "", "SOCKS5 proxy (host:port) used to reach every peer, e.g tor at 127.0.0.1:9050")
+	flag.StringVar(&onionProxyAddr, "onion-proxy", "", "SOCKS5 proxy (host:port) used to reach .onion peers, defaults to --proxy")
+	flag.BoolVar(&proxyRandomize, "proxy-randomize", true, "use random proxy credentials per connection so tor isolates each one in its own circuit")
+	flag.BoolVar(&v2Transport, "v2transport", true, "use the BIP324 encrypted transport, falling back to v1 for peers without support")
+	flag.StringVar(&listenAddr, "listen-addr", network.DefaultListenAddr, "address (host:port) to accept inbound connections on")
+	flag.UintVar(&maxInbound, "max-inbound", network.DefaultMaxInbound, "maximum number of inbound connections")
+	flag.UintVar(&maxPerIP, "max-per-ip", network.DefaultMaxPerIP, "maximum number of inbound connections from a single IP")
+	flag.UintVar(&outboundPeers, "outbound", connmgr.DefaultTarget, "number of outbound peers kept connected when no --peer-addr is given")
+	flag.StringVar(&addrBookPath, "addrbook", "", "file where learned peers are kept between runs, defaults to peers-<network>.json")
+	flag.BoolVar(&syncHeaders, "sync-headers", false, "download and validate the block headers of the peers, following the chain tip")
+	flag.StringVar(&headersPath, "headers-file", "", "file where synced headers are kept between runs, defaults to headers-<network>.dat")
+	flag.BoolVar(&syncFilters, "sync-filters", false, "download and check the compact block filters (BIP157) of the peers serving them, needs --sync-headers")
+	flag.StringVar(&watchScripts, "watch", "", "comma separated hex scripts looked for in the block filters, with --sync-filters")
+	flag.IntVar(&scanFromHeight, "scan-from", -1, "height of the first block filter looked at, defaults to the blocks found from now on")
+	flag.BoolVar(&compactBlocks, "compact-blocks", false, "ask peers to send new blocks as compact blocks (BIP152) and rebuild them")
+	flag.Int64Var(&feeFilterRate, "feefilter", 0, "minimum fee rate (sat/kvB) of the transactions peers announce to us (BIP133), 0 sends no feefilter")
+	flag.BoolVar(&keepMempool, "mempool", false, "keep the transactions announced by the peers in memory, asking the peers serving bloom filters for their mempool (BIP35)")
+	flag.StringVar(&bloomElements, "bloom", "", "comma separated hex elements (e.g pubkey hashes) loaded as a BIP37 bloom filter into peers advertising NODE_BLOOM")
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "crawl" {
+		runCrawl(os.Args[2:])
+		return
+	}
+
 	flag.Parse()
 
-	if strings.TrimSpace(peerAddr) != "" && peerPort != 0 {
+	var err error
+	params, err = chaincfg.ByName(networkName)
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+
+	if peerPort == 0 {
+		peerPort = uint(params.DefaultPort)
+	}
+
+	dialer = newDialer(proxyAddr, onionProxyAddr, proxyRandomize)
+	loadAddrBook()
+	defer closeAddrBook()
+	openHeaderChain()
+	defer closeHeaderChain()
+	openFilterHeaders()
+	openBloomFilter()
+	openMempool()
+
+	// inbound peers are served along with the ones we dial, sharing
+	// the nonces so a connection to ourselves is dropped
+	var listening <-chan struct{}
+	if listen {
+		listening = startListening()
+	}
+
+	switch {
+	case strings.TrimSpace(peerAddr) != "":
 		sendHandshakeAndWaitResponse()
-	} else {
-		listenForHandshakes()
+	case listen && !isFlagSet("outbound"):
+		<-listening
+	default:
+		// no peer was given, so they are picked from the
+		// address book or, when it is empty, the dns seeds
+		maintainOutboundPeers()
 	}
 }
+
+// isFlagSet reports whether the flag was given in the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// streamOpts are the options of every inbound and outbound stream
+func streamOpts() []network.StreamOpt {
+	return []network.StreamOpt{network.WithMagic(params.Magic), network.WithV2Transport(v2Transport)}
+}
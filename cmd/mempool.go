@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/handshake"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/mempool"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+)
+
+// mempoolExpireInterval is how often the expired transactions and announcements are dropped
+const mempoolExpireInterval = 30 * time.Second
+
+// pool keeps the transactions announced by every peer, it
+// is only kept when the --mempool flag is given
+var pool *mempool.Pool
+
+func openMempool() {
+	if !keepMempool {
+		return
+	}
+
+	pool = mempool.New(mempool.Config{})
+	txPool = pool
+
+	go func() {
+		ticker := time.NewTicker(mempoolExpireInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			pool.Expire()
+		}
+	}()
+}
+
+// mempoolRelay fetches the transactions a single peer announces
+type mempoolRelay struct {
+	stream *network.Stream
+	info   *handshake.PeerInfo
+}
+
+// startMempool asks the remote for its whole mempool, only peers serving
+// bloom filters answer the mempool message (BIP35), the others would
+// disconnect us. It returns nil when the mempool is not kept
+func startMempool(stream *network.Stream, info *handshake.PeerInfo) (*mempoolRelay, error) {
+	if pool == nil {
+		return nil, nil
+	}
+
+	if info.Services&messages.NodeBloom != 0 {
+		if err := stream.SendMessage(messages.CmdMemPool, messages.EmptyPayload{}); err != nil {
+			return nil, fmt.Errorf("while sending mempool: %w", err)
+		}
+	}
+	return &mempoolRelay{stream: stream, info: info}, nil
+}
+
+// handleMessage asks the announced transactions and keeps them, it
+// returns true if there is nothing left to do with the message
+func (m *mempoolRelay) handleMessage(msg *messages.Message) (bool, error) {
+	if m == nil {
+		return false, nil
+	}
+
+	// the answer honors what the peer negotiated
+	if string(msg.Command) == messages.CmdMemPool {
+		return true, m.announce(pool.Inventory(m.info.FeeFilter(), m.info.WtxidRelay))
+	}
+
+	peer := m.stream.RemoteAddr().String()
+	switch payload := msg.Payload.(type) {
+	case *messages.Inv:
+		// blocks announced along with the transactions are still printed
+		return onlyTxs(payload.Inventory), m.request(pool.Announced(peer, payload.Inventory))
+	case *messages.NotFound:
+		pool.NotFound(peer, payload.Inventory)
+		return onlyTxs(payload.Inventory), m.request(pool.NextRequests(peer))
+	case *messages.GetData:
+		return onlyTxs(payload.Inventory), m.serve(payload.Inventory)
+	case *messages.Tx:
+		entry, err := pool.Add(peer, payload)
+		if err != nil && !errors.Is(err, mempool.ErrAlreadyKnown) {
+			log.Printf("while adding tx %s from %s: %s", payload.TxHash(), peer, err.Error())
+		}
+
+		if entry != nil {
+			count, size := pool.Len()
+			fmt.Printf("mempool %s [txs=%d] [bytes=%d]\n", entry.String(), count, size)
+		}
+		// an answered request makes room for the ones waiting
+		return true, m.request(pool.NextRequests(peer))
+	case *messages.Block:
+		// invalid blocks are dropped along with the peer later on
+		if payload.CheckMerkleRoot() == nil {
+			pool.RemoveBlock(payload)
+		}
+		return false, nil
+	default:
+		return false, nil
+	}
+}
+
+// request asks the transactions to the peer
+func (m *mempoolRelay) request(inv []messages.InvVect) error {
+	if len(inv) == 0 {
+		return nil
+	}
+
+	if err := m.stream.SendMessage(messages.CmdGetData, &messages.GetData{Inventory: inv}); err != nil {
+		return fmt.Errorf("while requesting transactions: %w", err)
+	}
+	return nil
+}
+
+// serve sends the asked transactions we have, the others are notfound
+func (m *mempoolRelay) serve(inv []messages.InvVect) error {
+	var notFound []messages.InvVect
+	for _, entry := range inv {
+		if !entry.Type.IsTx() {
+			continue
+		}
+
+		found, ok := pool.Get(entry.Hash)
+		if !ok {
+			notFound = append(notFound, entry)
+			continue
+		}
+
+		// peers asking by txid without the witness flag get the legacy serialization
+		var tx codec.Encodeable = found.Tx
+		if !entry.Type.WantsWitness() {
+			raw := messages.RawPayload(found.Tx.EncodeNoWitness())
+			tx = &raw
+		}
+
+		if err := m.stream.SendMessage(messages.CmdTx, tx); err != nil {
+			return fmt.Errorf("while sending tx %s: %w", found.TxID, err)
+		}
+	}
+
+	if len(notFound) == 0 {
+		return nil
+	}
+	return m.stream.SendMessage(messages.CmdNotFound, &messages.NotFound{Inventory: notFound})
+}
+
+// announce sends the entries, split in messages of at most MaxInvEntries
+func (m *mempoolRelay) announce(inv []messages.InvVect) error {
+	for len(inv) > 0 {
+		size := min(len(inv), messages.MaxInvEntries)
+		if err := m.stream.SendMessage(messages.CmdInv, &messages.Inv{Inventory: inv[:size]}); err != nil {
+			return fmt.Errorf("while announcing transactions: %w", err)
+		}
+		inv = inv[size:]
+	}
+	return nil
+}
+
+func onlyTxs(inv []messages.InvVect) bool {
+	for _, entry := range inv {
+		if !entry.Type.IsTx() {
+			return false
+		}
+	}
+	return true
+}
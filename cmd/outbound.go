@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/connmgr"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+)
+
+// maintainOutboundPeers keeps --outbound peers connected until interrupted,
+// the peers come from the address book and, if it is empty, the network
+// DNS seeds are resolved to fill it, through the proxy if there is one
+func maintainOutboundPeers() {
+	if newCount, triedCount := book.Len(); newCount+triedCount == 0 {
+		bootstrapAddrBook()
+	}
+
+	manager := connmgr.New(connmgr.Config{
+		Target:    int(outboundPeers),
+		Addresses: book,
+		Dial: func(ctx context.Context, addr string) (*network.Stream, error) {
+			ctx, cancel := context.WithTimeout(ctx, network.DefaultDialTimeout)
+			defer cancel()
+
+			return network.DialVia(ctx, dialer, addr, streamOpts()...)
+		},
+		Version:          peerVersion,
+		HandshakeTimeout: handshakeTimeout,
+		Nonces:           nonces,
+		Services:         requiredServices(),
+		SendHeaders:      syncHeaders,
+		FeeFilter:        feeFilterRate,
+		Serve:            servePeer,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	go func() {
+		for event := range manager.Events() {
+			log.Println(event.String())
+		}
+	}()
+
+	manager.Run(ctx)
+}
+
+func servePeer(_ context.Context, peer *connmgr.Peer) error {
+	if requestAddrs {
+		err := peer.Stream.SendMessage(messages.CmdGetAddr, messages.EmptyPayload{})
+		if err != nil {
+			return err
+		}
+	}
+
+	keepConnectionAlive(peer.Stream, peer.Info)
+	return nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+)
+
+// newDialer routes the connections through the proxies given by the
+// --proxy and --onion-proxy flags, without them peers are dialed directly
+// and onion peers can not be reached
+func newDialer(proxy, onionProxy string, randomize bool) *network.Router {
+	router := &network.Router{}
+
+	if proxy != "" {
+		router.Proxy = &network.SOCKS5{ProxyAddr: proxy, IsolateStreams: randomize}
+	}
+
+	if onionProxy != "" {
+		router.OnionProxy = &network.SOCKS5{ProxyAddr: onionProxy, IsolateStreams: randomize}
+	}
+
+	return router
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/addrman"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/chaincfg"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/crawler"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/dnsseed"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+)
+
+// dnsSeedAddrs returns the host:port addresses the peer discovery starts
+// from, the network dns seeds resolved with the system resolver or, when
+// the connections go through a proxy, the seeds themselves so the proxy
+// resolves them and the dns server does not learn we look for bitcoin peers
+func dnsSeedAddrs(params *chaincfg.Params, services uint64, proxied bool) ([]string, error) {
+	seeder := dnsseed.New(params, nil)
+	if proxied {
+		hosts := seeder.Hosts()
+		if len(hosts) == 0 {
+			return nil, fmt.Errorf("%w: %s has no dns seeds", dnsseed.ErrNoAddresses, params.Name)
+		}
+		return hosts, nil
+	}
+
+	resolved, err := seeder.Resolve(context.Background(), services)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(resolved))
+	for _, addr := range resolved {
+		addrs = append(addrs, addr.String())
+	}
+	return addrs, nil
+}
+
+// bootstrapAddrBook fills the empty address book from the dns seeds, through
+// a proxy the seeds are not resolved, so they are asked for the addresses
+// they know instead
+func bootstrapAddrBook() {
+	proxied := proxyAddr != ""
+	addrs, err := dnsSeedAddrs(params, messages.NodeNetwork|messages.NodeWitness, proxied)
+	if err == nil && proxied {
+		addrs, err = fetchAddrs(addrs)
+	}
+	if err != nil {
+		log.Fatalf("while bootstrapping from dns seeds: %s", err.Error())
+	}
+
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+
+		parsed, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			continue
+		}
+		book.Add(dnsSeedSource, addrman.Address{Host: host, Port: uint16(parsed)})
+	}
+	saveAddrBook()
+}
+
+// fetchAddrs asks every peer for the addresses it knows, through the dialer
+func fetchAddrs(peers []string) ([]string, error) {
+	crawl := crawler.New(crawler.Config{
+		Dial:             crawler.Dialer(params.Magic, network.DefaultDialTimeout, dialer),
+		Version:          peerVersion,
+		MaxPeers:         len(peers),
+		HandshakeTimeout: handshakeTimeout,
+		AllowOnion:       true,
+	})
+
+	var (
+		addrs []string
+		errs  []error
+	)
+	for _, result := range crawl.Run(context.Background(), peers) {
+		if result.Error != "" {
+			errs = append(errs, fmt.Errorf("%s: %s", result.Addr, result.Error))
+		}
+		addrs = append(addrs, result.Addrs...)
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%w: %w", dnsseed.ErrNoAddresses, errors.Join(errs...))
+	}
+	return addrs, nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/chaincfg"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/dnsseed"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSSeedAddrsThroughProxy(t *testing.T) {
+	// the seeds are left for the proxy to resolve
+	addrs, err := dnsSeedAddrs(&chaincfg.MainNet, messages.NodeNetwork, true)
+	require.NoError(t, err)
+	require.Len(t, addrs, len(chaincfg.MainNet.DNSSeeds))
+	require.Equal(t, chaincfg.MainNet.DNSSeeds[0]+":8333", addrs[0])
+
+	_, err = dnsSeedAddrs(&chaincfg.RegTest, messages.NodeNetwork, true)
+	require.ErrorIs(t, err, dnsseed.ErrNoAddresses)
+}
@@ -1,81 +1,72 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"log"
-	"math/rand"
-	"time"
 
+	"github.com/EclesioMeloJunior/btc-handshake/internal/addrman"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/handshake"
 	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
 	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
 )
 
 func sendHandshakeAndWaitResponse() {
-	rng := rand.New(rand.NewSource(time.Now().Unix()))
-	version := messages.NewVersion(
-		messages.WithNumber(60002),
-		messages.WithServices(messages.NodeNetwork|messages.NodeNetworkLimited),
-		messages.WithAddrRecv(peerAddr, uint16(peerPort), 1),
-		messages.WithAddrFrom("0.0.0.0", 8080, 1),
-		messages.WithNonce(rng.Uint64()),
-		messages.WithUserAgent("btc/eclesios-node"),
-	)
-
-	handshakeVersionMessage := messages.NewMainMessage([]byte("version"), version)
-	encodedHandshake, err := handshakeVersionMessage.Encode()
-	if err != nil {
-		log.Fatalf("could not encode version handshake message: %s", err.Error())
+	// the peer was asked for, so it is kept even if it is not routable
+	addr := addrman.Address{Host: peerAddr, Port: uint16(peerPort)}
+	if err := book.AddManual(addr); err != nil {
+		log.Printf("while adding %s to the address book: %s", addr, err.Error())
 	}
 
-	srv, err := network.Dial(fmt.Sprintf("%s:%d", peerAddr, peerPort))
+	// the outcome is saved right away, the connection only
+	// ends with the process, which skips the deferred save
+	srv, peerInfo, err := connectAndHandshake(addr)
+	saveAddrBook()
 	if err != nil {
-		log.Fatalf("while instantiating network server: %s", err.Error())
+		log.Fatalf(err.Error())
 	}
 
-	// sending the encode handshake, as explained in the
-	// protocol wiki, the remote side should send a version message back
-	// as well as the verack message
-	err = srv.Send(encodedHandshake)
-	if err != nil {
-		log.Fatalf("while sending version handshake: %s", err.Error())
-	}
+	keepConnectionAlive(srv, peerInfo)
+}
 
-	// remote should send a version message back and a verack
-	// as described here: https://en.bitcoin.it/wiki/Version_Handshake
-	response := make([]byte, 1024)
-	n, err := srv.WaitResponse(response)
-	if err != nil {
-		log.Fatalf("while waiting response: %s", err.Error())
+func connectAndHandshake(addr addrman.Address) (*network.Stream, *handshake.PeerInfo, error) {
+	if err := book.Attempt(addr.String()); err != nil {
+		log.Printf("while recording the attempt to %s: %s", addr, err.Error())
 	}
 
-	responseReader := bytes.NewReader(response[:n])
+	ctx, cancel := context.WithTimeout(context.Background(), network.DefaultDialTimeout)
+	defer cancel()
 
-	fmt.Printf("\nreceived from remote (%d bytes): 0x%x\n", n, response[:n])
-	remoteVersionMessage := messages.NewMainMessage(nil, &messages.Version{})
-	err = remoteVersionMessage.Decode(responseReader)
+	srv, err := network.DialVia(ctx, dialer, addr.String(), streamOpts()...)
 	if err != nil {
-		log.Fatalf("while decoding remote's message: %s", err.Error())
+		return nil, nil, fmt.Errorf("while instantiating network server: %w", err)
 	}
-	fmt.Printf("remote's message:\n%s\n\n", remoteVersionMessage.String())
 
-	remoteVerAck := messages.NewMainMessage(nil, messages.EmptyPayload{})
-	err = remoteVerAck.Decode(responseReader)
+	// we send our version and the remote should send a version message
+	// back and a verack as described here: https://en.bitcoin.it/wiki/Version_Handshake
+	peerInfo, err := handshake.Outbound(srv, handshake.Config{
+		Version:     peerVersion(addr.String()),
+		Timeout:     handshakeTimeout,
+		Nonces:      nonces,
+		SendHeaders: syncHeaders,
+		FeeFilter:   feeFilterRate,
+	})
 	if err != nil {
-		log.Fatalf("while decoding remote's message: %s", err.Error())
+		srv.Close()
+		return nil, nil, fmt.Errorf("while establishing handshake with %s: %w", addr, err)
 	}
-	fmt.Printf("remote's message\n%s\n\n", remoteVerAck.String())
-
-	// we should send a verack since we received the remote's version
-	verrackMessage := messages.NewMainMessage([]byte("verack"), messages.EmptyPayload{})
-	encodeVerrack, err := verrackMessage.Encode()
-	if err != nil {
-		log.Fatalf("encoding verack: %s", err.Error())
+	fmt.Printf("handshake established:\n%s\n\n", peerInfo.String())
+	if err := book.Good(addr.String(), peerInfo.Services); err != nil {
+		log.Printf("while recording the handshake with %s: %s", addr, err.Error())
 	}
 
-	err = srv.Send(encodeVerrack)
-	if err != nil {
-		log.Fatalf("while sending verack: %s", err.Error())
+	if requestAddrs {
+		err = srv.SendMessage(messages.CmdGetAddr, messages.EmptyPayload{})
+		if err != nil {
+			srv.Close()
+			return nil, nil, fmt.Errorf("while sending getaddr: %w", err)
+		}
 	}
 
+	return srv, peerInfo, nil
 }
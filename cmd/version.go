@@ -0,0 +1,42 @@
+package main
+
+import (
+	"math/rand"
+	"net/netip"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+// ourProtocolVersion is the protocol version we announce
+const ourProtocolVersion = 70016
+
+// ourVersion builds the version message we send to a remote,
+// the addrRecv option describes the remote address
+func ourVersion(addrRecv messages.VersionOpt) *messages.Version {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	opts := []messages.VersionOpt{
+		messages.WithNumber(ourProtocolVersion),
+		messages.WithServices(messages.NodeNetwork | messages.NodeNetworkLimited),
+		addrRecv,
+		messages.WithAddrFrom("0.0.0.0", 8080, 1),
+		messages.WithNonce(rng.Uint64()),
+		messages.WithUserAgent("btc/eclesios-node"),
+	}
+
+	// without a mempool there is no use for the transactions announcements
+	if pool != nil {
+		opts = append(opts, messages.AsRelay())
+	}
+	return messages.NewVersion(opts...)
+}
+
+// peerVersion builds the version message for a peer given as host:port,
+// addresses that are not IPs (e.g onion hosts) leave addrRecv empty
+func peerVersion(addr string) *messages.Version {
+	addrPort, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return ourVersion(func(*messages.Version) {})
+	}
+	return ourVersion(messages.WithAddrRecv(addrPort.Addr().String(), addrPort.Port(), 0))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/handshake"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultHandshakeNegotiatesAddrV2(t *testing.T) {
+	require.GreaterOrEqual(t, uint32(ourProtocolVersion), uint32(messages.AddrV2MinVersion))
+
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lst.Close()
+
+	local, err := net.Dial("tcp", lst.Addr().String())
+	require.NoError(t, err)
+
+	remote, err := lst.Accept()
+	require.NoError(t, err)
+
+	localStream, remoteStream := network.NewStream(local), network.NewStream(remote)
+	defer localStream.Close()
+	defer remoteStream.Close()
+
+	// the remote is another node announcing the same version we do
+	type result struct {
+		info *handshake.PeerInfo
+		err  error
+	}
+	inbound := make(chan result, 1)
+	go func() {
+		info, err := handshake.Inbound(remoteStream, handshake.Config{
+			Version: ourVersion(messages.WithAddrRecvFromString(remoteStream.RemoteAddr().String(), 0)),
+		})
+		inbound <- result{info, err}
+	}()
+
+	info, err := handshake.Outbound(localStream, handshake.Config{
+		Version: peerVersion(lst.Addr().String()),
+	})
+	require.NoError(t, err)
+	require.True(t, info.SendAddrV2)
+
+	res := <-inbound
+	require.NoError(t, res.err)
+	require.True(t, res.info.SendAddrV2)
+}
@@ -0,0 +1,466 @@
+package addrman
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	mrand "math/rand"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+// table dimensions, the same bitcoin core uses in src/addrman_impl.h
+// check: https://github.com/bitcoin/bitcoin/blob/master/src/addrman_impl.h
+const (
+	NewBucketCount   = 1024
+	TriedBucketCount = 256
+	BucketSize       = 64
+
+	// a single source group can only reach this many new buckets, so a
+	// peer flooding us with addresses can not take over the new table
+	newBucketsPerSourceGroup = 64
+	// addresses of a single group can only reach this many tried buckets
+	triedBucketsPerGroup = 8
+)
+
+// thresholds used to decide if an address is not worth keeping
+const (
+	horizon        = 30 * 24 * time.Hour
+	retries        = 3
+	maxFailures    = 10
+	minFailSpan    = 7 * 24 * time.Hour
+	recentAttempt  = 10 * time.Minute
+	maxFutureDrift = 10 * time.Minute
+)
+
+var (
+	ErrUnroutable     = errors.New("address is not routable")
+	ErrInvalidAddress = errors.New("invalid address")
+	ErrUnknownAddress = errors.New("unknown address")
+
+	errAlreadyKnown = errors.New("address already known")
+)
+
+// Address is a peer address kept by the address manager, Host is either
+// an IP or a tor/i2p hostname so addresses learned through addrv2 fit too
+type Address struct {
+	Host     string
+	Port     uint16
+	Services uint64
+	// Time is when the address was last seen in the network
+	Time time.Time
+}
+
+// String returns the address in the host:port form
+func (a Address) String() string {
+	return net.JoinHostPort(a.Host, strconv.Itoa(int(a.Port)))
+}
+
+// FromNetworkAddress converts an address received through the addr message
+func FromNetworkAddress(addr messages.TimestampedNetworkAddress) Address {
+	return Address{
+		Host:     addr.IpV6V4.Unmap().String(),
+		Port:     addr.Port,
+		Services: addr.Services,
+		Time:     time.Unix(int64(addr.Time), 0),
+	}
+}
+
+// FromAddrV2 converts an address received through the addrv2 message
+func FromAddrV2(addr messages.AddrV2Entry) Address {
+	return Address{
+		Host:     addr.Host(),
+		Port:     addr.Port,
+		Services: addr.Services,
+		Time:     time.Unix(int64(addr.Time), 0),
+	}
+}
+
+// entry is the address plus the information gathered
+// while connecting to it, the fields are persisted
+type entry struct {
+	Address
+	Source      string
+	LastAttempt time.Time
+	LastSuccess time.Time
+	Attempts    int
+	Tried       bool
+	// Manual entries were given by the user, they are kept even when not routable
+	Manual bool
+
+	// position in the new or tried table
+	bucket, slot int
+}
+
+// isTerrible reports whether the entry is not worth keeping, it is too old,
+// too far in the future or failed too many times, same as bitcoin core
+func (e *entry) isTerrible(now time.Time) bool {
+	// tried in the last minute, give it a chance
+	if now.Sub(e.LastAttempt) < time.Minute {
+		return false
+	}
+
+	if e.Time.After(now.Add(maxFutureDrift)) {
+		return true
+	}
+
+	if now.Sub(e.Time) > horizon {
+		return true
+	}
+
+	if e.LastSuccess.IsZero() && e.Attempts >= retries {
+		return true
+	}
+
+	return now.Sub(e.LastSuccess) > minFailSpan && e.Attempts >= maxFailures
+}
+
+// chance is the relative probability of selecting the entry,
+// it decreases with every failed attempt since the last success
+func (e *entry) chance(now time.Time) float64 {
+	chance := 1.0
+	if now.Sub(e.LastAttempt) < recentAttempt {
+		chance *= 0.01
+	}
+
+	return chance * math.Pow(0.66, float64(min(e.Attempts, 8)))
+}
+
+// AddrMan stores the addresses we learned in two tables, the new table
+// holds addresses we have heard about and the tried table the ones we
+// managed to connect to. Each address lands in a bucket chosen through
+// a keyed hash of its group and the group of whoever told us about it,
+// so a single source can not fill the tables and eclipse us
+type AddrMan struct {
+	mu  sync.Mutex
+	key [32]byte
+
+	entries map[string]*entry
+	new     [NewBucketCount][BucketSize]*entry
+	tried   [TriedBucketCount][BucketSize]*entry
+
+	newCount, triedCount int
+}
+
+// New returns an empty address manager with a random bucketing key
+func New() *AddrMan {
+	a := &AddrMan{entries: make(map[string]*entry)}
+	if _, err := rand.Read(a.key[:]); err != nil {
+		panic(fmt.Sprintf("while generating addrman key: %s", err.Error()))
+	}
+	return a
+}
+
+// Len returns how many addresses are in the new and tried tables
+func (a *AddrMan) Len() (newCount, triedCount int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.newCount, a.triedCount
+}
+
+// Add stores addresses learned from source (the IP or hostname of the peer or
+// DNS seed that told us about them), it returns how many addresses were added.
+// Addresses already known get their services and last seen time refreshed
+func (a *AddrMan) Add(source string, addrs ...Address) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	added := 0
+	for _, addr := range addrs {
+		if a.add(source, addr, now, false) == nil {
+			added++
+		}
+	}
+	return added
+}
+
+// AddManual stores an address the user asked us to connect to, unlike the
+// learned ones it is kept even when it is not routable (e.g a local regtest
+// node) or a hostname, and it takes its slot in the new table even if it is
+// occupied. Adding a known address marks it as manual
+func (a *AddrMan) AddManual(addr Address) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	err := a.add(addr.Host, addr, time.Now(), true)
+	if errors.Is(err, errAlreadyKnown) {
+		a.entries[addr.String()].Manual = true
+		return nil
+	}
+	return err
+}
+
+func (a *AddrMan) add(source string, addr Address, now time.Time, manual bool) error {
+	if manual && (addr.Port == 0 || addr.Host == "") {
+		return fmt.Errorf("%w: %s", ErrInvalidAddress, addr.String())
+	}
+
+	if !manual {
+		if err := validate(addr); err != nil {
+			return err
+		}
+	}
+
+	// addresses announced too far in the future are
+	// stored as if they were seen a few days ago
+	if addr.Time.IsZero() || addr.Time.After(now.Add(maxFutureDrift)) {
+		addr.Time = now.Add(-5 * 24 * time.Hour)
+	}
+
+	key := addr.String()
+	if existing, ok := a.entries[key]; ok {
+		existing.Services |= addr.Services
+		if addr.Time.After(existing.Time) {
+			existing.Time = addr.Time
+		}
+		return errAlreadyKnown
+	}
+
+	e := &entry{Address: addr, Source: source, Manual: manual}
+	e.bucket = a.newBucket(addr, source)
+	e.slot = a.slot(false, e.bucket, key)
+
+	if occupant := a.new[e.bucket][e.slot]; occupant != nil {
+		// the new address only takes the place of a terrible one
+		if !manual && !occupant.isTerrible(now) {
+			return fmt.Errorf("%w: new bucket %d is full", ErrInvalidAddress, e.bucket)
+		}
+		a.remove(occupant)
+	}
+
+	a.new[e.bucket][e.slot] = e
+	a.entries[key] = e
+	a.newCount++
+	return nil
+}
+
+// Attempt records a connection attempt to the address
+func (a *AddrMan) Attempt(addr string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e, ok := a.entries[addr]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownAddress, addr)
+	}
+
+	e.LastAttempt = time.Now()
+	e.Attempts++
+	return nil
+}
+
+// Good records a successful handshake with the address, the address
+// is moved to the tried table with the services the peer announced
+func (a *AddrMan) Good(addr string, services uint64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e, ok := a.entries[addr]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownAddress, addr)
+	}
+
+	now := time.Now()
+	e.LastSuccess, e.LastAttempt, e.Time = now, now, now
+	e.Services = services
+	e.Attempts = 0
+
+	if !e.Tried {
+		a.makeTried(e)
+	}
+	return nil
+}
+
+// makeTried moves the entry from the new to the tried table, an entry
+// occupying its tried slot is sent back to the new table
+func (a *AddrMan) makeTried(e *entry) {
+	a.new[e.bucket][e.slot] = nil
+	a.newCount--
+
+	bucket := a.triedBucket(e.Address)
+	slot := a.slot(true, bucket, e.String())
+
+	if evicted := a.tried[bucket][slot]; evicted != nil {
+		a.tried[bucket][slot] = nil
+		a.triedCount--
+		evicted.Tried = false
+
+		// the evicted entry goes back to the new table, unless it
+		// collides with another entry there, then it is forgotten
+		evicted.bucket = a.newBucket(evicted.Address, evicted.Source)
+		evicted.slot = a.slot(false, evicted.bucket, evicted.String())
+		if a.new[evicted.bucket][evicted.slot] == nil {
+			a.new[evicted.bucket][evicted.slot] = evicted
+			a.newCount++
+		} else {
+			delete(a.entries, evicted.String())
+		}
+	}
+
+	e.Tried = true
+	e.bucket, e.slot = bucket, slot
+	a.tried[bucket][slot] = e
+	a.triedCount++
+}
+
+// remove forgets an entry from the new table
+func (a *AddrMan) remove(e *entry) {
+	a.new[e.bucket][e.slot] = nil
+	a.newCount--
+	delete(a.entries, e.String())
+}
+
+// Select picks an address to connect to, tried and new addresses are chosen
+// with the same probability and addresses that failed recently are less likely
+// to be picked, newOnly restricts the selection to addresses never connected
+func (a *AddrMan) Select(newOnly bool) (Address, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.newCount == 0 && (newOnly || a.triedCount == 0) {
+		return Address{}, false
+	}
+
+	useTried := !newOnly && a.triedCount > 0 && (a.newCount == 0 || mrand.Intn(2) == 0)
+
+	now := time.Now()
+	factor := 1.0
+	for {
+		var bucket *[BucketSize]*entry
+		if useTried {
+			bucket = &a.tried[mrand.Intn(TriedBucketCount)]
+		} else {
+			bucket = &a.new[mrand.Intn(NewBucketCount)]
+		}
+
+		// scan the bucket from a random slot until an entry is found
+		var e *entry
+		offset := mrand.Intn(BucketSize)
+		for i := 0; i < BucketSize && e == nil; i++ {
+			e = bucket[(offset+i)%BucketSize]
+		}
+
+		if e == nil {
+			continue
+		}
+
+		// the more we fail to select the more likely the next pick is
+		// accepted, so entries with a low chance are eventually returned
+		if mrand.Float64() < factor*e.chance(now) {
+			return e.Address, true
+		}
+		factor *= 1.2
+	}
+}
+
+// Addresses returns every known address, in no particular order,
+// useful to answer getaddr or to list the address book
+func (a *AddrMan) Addresses() []Address {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	addrs := make([]Address, 0, len(a.entries))
+	for _, e := range a.entries {
+		if !e.isTerrible(now) {
+			addrs = append(addrs, e.Address)
+		}
+	}
+	return addrs
+}
+
+func validate(addr Address) error {
+	if addr.Port == 0 || addr.Host == "" {
+		return fmt.Errorf("%w: %s", ErrInvalidAddress, addr.String())
+	}
+
+	ip, err := netip.ParseAddr(addr.Host)
+	if err != nil {
+		// tor and i2p addresses are routable through their own networks
+		if isOverlay(addr.Host) {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrInvalidAddress, addr.String())
+	}
+
+	netaddr := messages.NetworkAddress{IpV6V4: ip, Port: addr.Port}
+	if !netaddr.IsRoutable() {
+		return fmt.Errorf("%w: %s", ErrUnroutable, addr.String())
+	}
+	return nil
+}
+
+func isOverlay(host string) bool {
+	return strings.HasSuffix(host, ".onion") || strings.HasSuffix(host, ".b32.i2p")
+}
+
+// Group returns the network group of a host, addresses in the same group
+// are likely controlled by the same entity: the /16 for IPv4, the /32 for
+// IPv6 and the network plus first character for tor and i2p hostnames
+func Group(host string) string {
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		if isOverlay(host) {
+			return host[strings.Index(host, ".")+1:] + ":" + host[:1]
+		}
+		// e.g a dns seed hostname
+		return "host:" + host
+	}
+
+	ip = ip.Unmap()
+	if ip.Is4() {
+		ip4 := ip.As4()
+		return fmt.Sprintf("4:%d.%d", ip4[0], ip4[1])
+	}
+
+	ip16 := ip.As16()
+	return fmt.Sprintf("6:%x", ip16[:4])
+}
+
+func (a *AddrMan) hash(parts ...[]byte) uint64 {
+	h := sha256.New()
+	h.Write(a.key[:])
+	for _, part := range parts {
+		// the length prefix avoids ambiguity between parts
+		h.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(part))))
+		h.Write(part)
+	}
+	return binary.LittleEndian.Uint64(h.Sum(nil)[:8])
+}
+
+func uint64Bytes(v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, v)
+}
+
+// newBucket depends on the address group and on the source group, each
+// source group only reaches newBucketsPerSourceGroup buckets
+func (a *AddrMan) newBucket(addr Address, source string) int {
+	addrGroup, sourceGroup := []byte(Group(addr.Host)), []byte(Group(source))
+	h1 := a.hash(addrGroup, sourceGroup) % newBucketsPerSourceGroup
+	return int(a.hash(sourceGroup, uint64Bytes(h1)) % NewBucketCount)
+}
+
+// triedBucket depends on the address itself and on its group, each
+// group only reaches triedBucketsPerGroup buckets
+func (a *AddrMan) triedBucket(addr Address) int {
+	h1 := a.hash([]byte(addr.String())) % triedBucketsPerGroup
+	return int(a.hash([]byte(Group(addr.Host)), uint64Bytes(h1)) % TriedBucketCount)
+}
+
+func (a *AddrMan) slot(tried bool, bucket int, addr string) int {
+	table := byte('N')
+	if tried {
+		table = 'T'
+	}
+	return int(a.hash([]byte{table}, uint64Bytes(uint64(bucket)), []byte(addr)) % BucketSize)
+}
@@ -0,0 +1,194 @@
+package addrman_test
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/addrman"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func publicAddr(a, b, c, d byte) addrman.Address {
+	return addrman.Address{
+		Host:     netip.AddrFrom4([4]byte{a, b, c, d}).String(),
+		Port:     8333,
+		Services: messages.NodeNetwork,
+		Time:     time.Now().Add(-time.Hour),
+	}
+}
+
+func TestAddRejectsUnroutableAndInvalid(t *testing.T) {
+	book := addrman.New()
+
+	added := book.Add("1.2.3.4",
+		addrman.Address{Host: "127.0.0.1", Port: 8333},
+		addrman.Address{Host: "192.168.0.10", Port: 8333},
+		addrman.Address{Host: "8.8.8.8", Port: 0},
+		addrman.Address{Host: "not-an-ip", Port: 8333},
+	)
+	require.Zero(t, added)
+
+	newCount, triedCount := book.Len()
+	require.Zero(t, newCount)
+	require.Zero(t, triedCount)
+}
+
+func TestAddAcceptsOverlayHosts(t *testing.T) {
+	book := addrman.New()
+
+	added := book.Add("1.2.3.4",
+		addrman.Address{Host: "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion", Port: 8333},
+		addrman.Address{Host: "ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7dnkdq.b32.i2p", Port: 0},
+	)
+	require.Equal(t, 1, added)
+}
+
+func TestAddManualKeepsUnroutable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	book := addrman.New()
+
+	// e.g a local regtest node given through the command line
+	local := addrman.Address{Host: "127.0.0.1", Port: 18444}
+	require.NoError(t, book.AddManual(local))
+	require.NoError(t, book.AddManual(local))
+	require.NoError(t, book.Attempt(local.String()))
+	require.NoError(t, book.Good(local.String(), messages.NodeNetwork))
+
+	err := book.AddManual(addrman.Address{Host: "127.0.0.1"})
+	require.ErrorIs(t, err, addrman.ErrInvalidAddress)
+
+	// it survives a restart even though Add would refuse it
+	require.NoError(t, book.Save(path))
+	loaded, err := addrman.Load(path)
+	require.NoError(t, err)
+
+	newCount, triedCount := loaded.Len()
+	require.Zero(t, newCount)
+	require.Equal(t, 1, triedCount)
+}
+
+func TestAddRefreshesKnownAddress(t *testing.T) {
+	book := addrman.New()
+
+	addr := publicAddr(8, 8, 8, 8)
+	require.Equal(t, 1, book.Add("1.2.3.4", addr))
+
+	addr.Services = messages.NodeWitness
+	addr.Time = time.Now()
+	require.Zero(t, book.Add("1.2.3.4", addr))
+
+	known := book.Addresses()
+	require.Len(t, known, 1)
+	require.Equal(t, messages.NodeNetwork|messages.NodeWitness, known[0].Services)
+	require.WithinDuration(t, addr.Time, known[0].Time, time.Second)
+}
+
+func TestGoodMovesToTried(t *testing.T) {
+	book := addrman.New()
+
+	addr := publicAddr(8, 8, 8, 8)
+	book.Add("1.2.3.4", addr)
+
+	require.NoError(t, book.Attempt(addr.String()))
+	require.NoError(t, book.Good(addr.String(), messages.NodeNetwork|messages.NodeWitness))
+
+	newCount, triedCount := book.Len()
+	require.Zero(t, newCount)
+	require.Equal(t, 1, triedCount)
+
+	_, ok := book.Select(true)
+	require.False(t, ok)
+
+	selected, ok := book.Select(false)
+	require.True(t, ok)
+	require.Equal(t, addr.String(), selected.String())
+	require.Equal(t, messages.NodeNetwork|messages.NodeWitness, selected.Services)
+
+	require.ErrorIs(t, book.Good("9.9.9.9:8333", 0), addrman.ErrUnknownAddress)
+	require.ErrorIs(t, book.Attempt("9.9.9.9:8333"), addrman.ErrUnknownAddress)
+}
+
+func TestSelectEmpty(t *testing.T) {
+	_, ok := addrman.New().Select(false)
+	require.False(t, ok)
+}
+
+// a single source announcing addresses from many groups can only
+// reach a limited amount of new buckets, so most of the flood is dropped
+func TestSingleSourceCanNotFillNewTable(t *testing.T) {
+	book := addrman.New()
+
+	added := 0
+	for i := 0; i < 20_000; i++ {
+		addr := publicAddr(byte(1+i%200), byte(i/200), byte(i), 1)
+		added += book.Add("5.6.7.8", addr)
+	}
+
+	// 64 buckets with 64 slots each
+	require.LessOrEqual(t, added, 64*64)
+
+	// sources in other groups still find room, only the
+	// buckets shared with the flooding source are full
+	others := 0
+	for i := 0; i < 100; i++ {
+		others += book.Add(fmt.Sprintf("9.%d.11.12", i), publicAddr(44, byte(i), 44, 44))
+	}
+	require.Greater(t, others, 50)
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+
+	book := addrman.New()
+	for i := 0; i < 50; i++ {
+		book.Add(fmt.Sprintf("1.2.3.%d", i), publicAddr(8, byte(i), 8, 8))
+	}
+
+	good := publicAddr(8, 0, 8, 8)
+	require.NoError(t, book.Good(good.String(), messages.NodeNetwork))
+	require.NoError(t, book.Save(path))
+
+	loaded, err := addrman.Load(path)
+	require.NoError(t, err)
+
+	newCount, triedCount := book.Len()
+	loadedNew, loadedTried := loaded.Len()
+	require.Equal(t, newCount, loadedNew)
+	require.Equal(t, triedCount, loadedTried)
+	require.ElementsMatch(t, summarize(book.Addresses()), summarize(loaded.Addresses()))
+
+	// the temporary file is gone after the rename
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+// summarize drops the monotonic clock reading lost when the times are persisted
+func summarize(addrs []addrman.Address) []string {
+	summary := make([]string, len(addrs))
+	for idx, addr := range addrs {
+		summary[idx] = fmt.Sprintf("%s %d %d", addr.String(), addr.Services, addr.Time.UnixNano())
+	}
+	return summary
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	book, err := addrman.Load(filepath.Join(t.TempDir(), "missing.json"))
+	require.NoError(t, err)
+
+	newCount, triedCount := book.Len()
+	require.Zero(t, newCount+triedCount)
+}
+
+func TestLoadCorruptedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	require.NoError(t, os.WriteFile(path, []byte("{not json"), 0o600))
+
+	_, err := addrman.Load(path)
+	require.Error(t, err)
+}
@@ -0,0 +1,142 @@
+package addrman
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileVersion is bumped whenever the persisted format changes
+const fileVersion = 1
+
+type persistedEntry struct {
+	Host        string    `json:"host"`
+	Port        uint16    `json:"port"`
+	Services    uint64    `json:"services"`
+	Time        time.Time `json:"time"`
+	Source      string    `json:"source"`
+	LastAttempt time.Time `json:"last_attempt,omitempty"`
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	Attempts    int       `json:"attempts,omitempty"`
+	Tried       bool      `json:"tried,omitempty"`
+	Manual      bool      `json:"manual,omitempty"`
+}
+
+type persisted struct {
+	Version int              `json:"version"`
+	Key     string           `json:"key"`
+	Entries []persistedEntry `json:"entries"`
+}
+
+// Load reads the address manager saved at path, if the
+// file does not exist an empty address manager is returned
+func Load(path string) (*AddrMan, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return New(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while reading address book: %w", err)
+	}
+
+	var content persisted
+	if err := json.Unmarshal(raw, &content); err != nil {
+		return nil, fmt.Errorf("while decoding address book: %w", err)
+	}
+
+	if content.Version != fileVersion {
+		return nil, fmt.Errorf("unsupported address book version %d, expected %d", content.Version, fileVersion)
+	}
+
+	key, err := hex.DecodeString(content.Key)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("address book key must be 32 hex encoded bytes")
+	}
+
+	a := &AddrMan{entries: make(map[string]*entry)}
+	copy(a.key[:], key)
+
+	// the tried entries go first so they get their slots back, the
+	// positions are recomputed since they only depend on the key
+	now := time.Now()
+	for _, tried := range []bool{true, false} {
+		for _, p := range content.Entries {
+			if p.Tried != tried {
+				continue
+			}
+
+			addr := Address{Host: p.Host, Port: p.Port, Services: p.Services, Time: p.Time}
+			if err := a.add(p.Source, addr, now, p.Manual); err != nil {
+				continue
+			}
+
+			e := a.entries[addr.String()]
+			e.LastAttempt, e.LastSuccess, e.Attempts = p.LastAttempt, p.LastSuccess, p.Attempts
+			if p.Tried {
+				a.makeTried(e)
+			}
+		}
+	}
+
+	return a, nil
+}
+
+// Save writes the address manager to path, the content is written to a
+// temporary file first so a crash never leaves a truncated address book
+func (a *AddrMan) Save(path string) error {
+	a.mu.Lock()
+	content := persisted{
+		Version: fileVersion,
+		Key:     hex.EncodeToString(a.key[:]),
+		Entries: make([]persistedEntry, 0, len(a.entries)),
+	}
+	for _, e := range a.entries {
+		content.Entries = append(content.Entries, persistedEntry{
+			Host:        e.Host,
+			Port:        e.Port,
+			Services:    e.Services,
+			Time:        e.Time,
+			Source:      e.Source,
+			LastAttempt: e.LastAttempt,
+			LastSuccess: e.LastSuccess,
+			Attempts:    e.Attempts,
+			Tried:       e.Tried,
+			Manual:      e.Manual,
+		})
+	}
+	a.mu.Unlock()
+
+	raw, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return fmt.Errorf("while encoding address book: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("while creating temporary address book: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return fmt.Errorf("while writing address book: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("while syncing address book: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("while closing address book: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("while replacing address book: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package bip324
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ChaCha20 as described in RFC 8439, with a 96 bit nonce and a 32 bit block counter
+// check: https://www.rfc-editor.org/rfc/rfc8439#section-2.3
+const (
+	KeySize   = chacha20.KeySize
+	NonceSize = chacha20.NonceSize
+	BlockSize = 64
+	// TagSize is the size of the Poly1305 authentication tag
+	TagSize = chacha20poly1305.Overhead
+)
+
+var ErrAuthentication = errors.New("message authentication failed")
+
+// newChaCha20 returns the keystream of the key and nonce, the
+// sizes are fixed by the array types so it can not fail
+func newChaCha20(key *[KeySize]byte, nonce *[NonceSize]byte) *chacha20.Cipher {
+	cipher, err := chacha20.NewUnauthenticatedCipher(key[:], nonce[:])
+	if err != nil {
+		panic(err)
+	}
+	return cipher
+}
+
+// chacha20XOR encrypts (or decrypts) src into dst starting at the given block counter
+func chacha20XOR(key *[KeySize]byte, nonce *[NonceSize]byte, counter uint32, dst, src []byte) {
+	cipher := newChaCha20(key, nonce)
+	cipher.SetCounter(counter)
+	cipher.XORKeyStream(dst, src)
+}
+
+// chacha20Poly1305Seal encrypts and authenticates plaintext, the tag is
+// appended to the returned ciphertext, check: https://www.rfc-editor.org/rfc/rfc8439#section-2.8
+func chacha20Poly1305Seal(key *[KeySize]byte, nonce *[NonceSize]byte, plaintext, aad []byte) []byte {
+	aead, err := chacha20poly1305.New(key[:])
+	if err != nil {
+		panic(err)
+	}
+	return aead.Seal(nil, nonce[:], plaintext, aad)
+}
+
+// chacha20Poly1305Open checks the tag appended to ciphertext and decrypts it
+func chacha20Poly1305Open(key *[KeySize]byte, nonce *[NonceSize]byte, ciphertext, aad []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key[:])
+	if err != nil {
+		panic(err)
+	}
+
+	plaintext, err := aead.Open(nil, nonce[:], ciphertext, aad)
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return plaintext, nil
+}
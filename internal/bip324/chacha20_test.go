@@ -0,0 +1,158 @@
+package bip324_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/bip324"
+	"github.com/stretchr/testify/require"
+)
+
+const sunscreen = "Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it."
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func sequence(start byte, n int) []byte {
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = start + byte(i)
+	}
+	return out
+}
+
+// https://www.rfc-editor.org/rfc/rfc8439#section-2.4.2
+func TestChaCha20Encryption(t *testing.T) {
+	var key [bip324.KeySize]byte
+	copy(key[:], sequence(0, bip324.KeySize))
+
+	var nonce [bip324.NonceSize]byte
+	copy(nonce[:], mustDecodeHex(t, "000000000000004a00000000"))
+
+	out := make([]byte, len(sunscreen))
+	bip324.ChaCha20XOR(&key, &nonce, 1, out, []byte(sunscreen))
+
+	expected := mustDecodeHex(t, "6e2e359a2568f98041ba0728dd0d6981e97e7aec1d4360c20a27afccfd9fae0b"+
+		"f91b65c5524733ab8f593dabcd62b3571639d624e65152ab8f530c359f0861d8"+
+		"07ca0dbf500d6a6156a38e088a22b65e52bc514d16ccf806818ce91ab7793736"+
+		"5af90bbf74a35be6b40b8eedf2785e42874d")
+	require.Equal(t, expected, out)
+}
+
+// https://www.rfc-editor.org/rfc/rfc8439#section-2.8.2
+func TestChaCha20Poly1305(t *testing.T) {
+	var key [bip324.KeySize]byte
+	copy(key[:], sequence(0x80, bip324.KeySize))
+
+	var nonce [bip324.NonceSize]byte
+	copy(nonce[:], mustDecodeHex(t, "070000004041424344454647"))
+	aad := mustDecodeHex(t, "50515253c0c1c2c3c4c5c6c7")
+
+	sealed := bip324.ChaCha20Poly1305Seal(&key, &nonce, []byte(sunscreen), aad)
+	require.Equal(t, mustDecodeHex(t, "1ae10b594f09e26a7e902ecbd0600691"), sealed[len(sealed)-bip324.TagSize:])
+
+	opened, err := bip324.ChaCha20Poly1305Open(&key, &nonce, sealed, aad)
+	require.NoError(t, err)
+	require.Equal(t, sunscreen, string(opened))
+
+	_, err = bip324.ChaCha20Poly1305Open(&key, &nonce, sealed, []byte("other aad"))
+	require.ErrorIs(t, err, bip324.ErrAuthentication)
+
+	sealed[0] ^= 1
+	_, err = bip324.ChaCha20Poly1305Open(&key, &nonce, sealed, aad)
+	require.ErrorIs(t, err, bip324.ErrAuthentication)
+}
+
+// https://www.rfc-editor.org/rfc/rfc8439#appendix-A.1 test vector #1, the
+// first chunk of a fresh FSChaCha20 is the keystream with a zero nonce
+func TestFSChaCha20Keystream(t *testing.T) {
+	cipher := bip324.NewFSChaCha20([bip324.KeySize]byte{})
+
+	expected := mustDecodeHex(t, "76b8e0ada0f13d90405d6ae55386bd28bdd219b8a08ded1aa836efcc8b770dc7"+
+		"da41597c5157488d7724e03fb8d84a376a43b8f41518a11cc387b669b2ee6586")
+	require.Equal(t, expected, cipher.Crypt(make([]byte, 64)))
+}
+
+func TestFSChaCha20Rekey(t *testing.T) {
+	var key [bip324.KeySize]byte
+	copy(key[:], sequence(1, bip324.KeySize))
+
+	sender, receiver := bip324.NewFSChaCha20(key), bip324.NewFSChaCha20(key)
+
+	// the same chunk encrypts differently every time, also across rekeys
+	chunk := sequence(0, 32)
+	seen := make(map[string]bool)
+	for i := 0; i < 3*bip324.RekeyInterval; i++ {
+		encrypted := sender.Crypt(chunk)
+		require.False(t, seen[string(encrypted)])
+		seen[string(encrypted)] = true
+
+		require.Equal(t, chunk, receiver.Crypt(encrypted))
+	}
+}
+
+// the chunk encrypted right after the first rekey, the vectors of bitcoin core
+// check: https://github.com/bitcoin/bitcoin/blob/master/src/test/crypto_tests.cpp
+func TestFSChaCha20RekeyVectors(t *testing.T) {
+	tests := []struct {
+		plaintext     string
+		key           string
+		rekeyInterval uint32
+		expected      string
+	}{
+		{
+			plaintext:     "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+			key:           "0000000000000000000000000000000000000000000000000000000000000000",
+			rekeyInterval: 256,
+			expected:      "a93df4ef03011f3db95f60d996e1785df5de38fc39bfcb663a47bb5561928349",
+		},
+		{
+			plaintext:     "01",
+			key:           "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+			rekeyInterval: 5,
+			expected:      "ea",
+		},
+		{
+			plaintext:     "e93fdb5c762804b9a706816aca31e35b11d2aa3080108ef46a5b1f1508819c0a",
+			key:           "8ec4c3ccdaea336bdeb245636970be01266509b33f3d2642504eaf412206207a",
+			rekeyInterval: 4096,
+			expected:      "8bfaa4eacff308fdb4a94a5ff25bd9d0c1f84b77f81239f67ff39d6e1ac280c9",
+		},
+	}
+
+	for _, tt := range tests {
+		var key [bip324.KeySize]byte
+		copy(key[:], mustDecodeHex(t, tt.key))
+
+		cipher := bip324.NewFSChaCha20WithInterval(key, tt.rekeyInterval)
+		plaintext := mustDecodeHex(t, tt.plaintext)
+		for i := uint32(0); i < tt.rekeyInterval; i++ {
+			cipher.Crypt(plaintext)
+		}
+		require.Equal(t, tt.expected, hex.EncodeToString(cipher.Crypt(plaintext)))
+	}
+}
+
+func TestFSChaCha20Poly1305Rekey(t *testing.T) {
+	var key [bip324.KeySize]byte
+	copy(key[:], sequence(7, bip324.KeySize))
+
+	sender, receiver := bip324.NewFSChaCha20Poly1305(key), bip324.NewFSChaCha20Poly1305(key)
+	first := sender.Encrypt([]byte("packet"), nil)
+
+	for i := 1; i < 2*bip324.RekeyInterval+5; i++ {
+		ciphertext := sender.Encrypt([]byte("packet"), nil)
+		require.NotEqual(t, first, ciphertext)
+	}
+
+	// the receiver must follow every packet to keep the same nonce and key
+	_, err := receiver.Decrypt(first, nil)
+	require.NoError(t, err)
+
+	_, err = receiver.Decrypt(first, nil)
+	require.ErrorIs(t, err, bip324.ErrAuthentication)
+}
@@ -0,0 +1,183 @@
+package bip324
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+const (
+	GarbageTerminatorSize = 16
+	// MaxGarbageSize is the most garbage a peer may send after its public key
+	MaxGarbageSize = 4095
+	// LengthSize is the size of the encrypted contents length
+	LengthSize = 3
+	// HeaderSize is the size of the encrypted header, it only carries flags
+	HeaderSize = 1
+	// PacketOverhead is how many bytes a packet adds to its contents
+	PacketOverhead = LengthSize + HeaderSize + TagSize
+
+	// IgnoreBit marks decoy packets, the receiver must discard them
+	IgnoreBit = 0x80
+)
+
+var ErrNotInitialized = errors.New("cipher is not initialized")
+
+// Cipher holds the keys of one side of a BIP324 session, our ephemeral
+// key pair, and once initialized with the remote public key, the length
+// and packet ciphers of both directions
+type Cipher struct {
+	key      *PrivateKey
+	ellSwift [EllSwiftSize]byte
+
+	initialized bool
+	sendL       *FSChaCha20
+	sendP       *FSChaCha20Poly1305
+	recvL       *FSChaCha20
+	recvP       *FSChaCha20Poly1305
+
+	sendGarbageTerminator [GarbageTerminatorSize]byte
+	recvGarbageTerminator [GarbageTerminatorSize]byte
+	sessionID             [32]byte
+}
+
+// NewCipher generates the ephemeral key pair of a new session
+func NewCipher() (*Cipher, error) {
+	key, err := GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	ellSwift, err := key.EllSwiftCreate()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCipherWithKey(key, ellSwift), nil
+}
+
+// NewCipherWithKey uses the given key pair, ellSwift must encode the key
+// public key, it is useful to reproduce the BIP324 test vectors
+func NewCipherWithKey(key *PrivateKey, ellSwift [EllSwiftSize]byte) *Cipher {
+	return &Cipher{key: key, ellSwift: ellSwift}
+}
+
+// EllSwift returns our encoded public key, the first bytes sent to the remote
+func (c *Cipher) EllSwift() [EllSwiftSize]byte {
+	return c.ellSwift
+}
+
+// Initialize derives the session keys from the remote encoded public key,
+// the network magic is part of the derivation so sessions of different
+// networks never share keys
+func (c *Cipher) Initialize(theirs [EllSwiftSize]byte, initiator bool, magic messages.Magic) error {
+	secret, err := c.key.EllSwiftECDH(c.ellSwift, theirs, initiator)
+	if err != nil {
+		return fmt.Errorf("while computing shared secret: %w", err)
+	}
+
+	salt := binary.LittleEndian.AppendUint32([]byte("bitcoin_v2_shared_secret"), uint32(magic))
+	prk := hkdfExtract(salt, secret[:])
+
+	derive := func(info string) [32]byte {
+		var out [32]byte
+		copy(out[:], hkdfExpand(prk, []byte(info), 32))
+		return out
+	}
+
+	initiatorL, initiatorP := derive("initiator_L"), derive("initiator_P")
+	responderL, responderP := derive("responder_L"), derive("responder_P")
+	terminators := derive("garbage_terminators")
+	c.sessionID = derive("session_id")
+
+	if initiator {
+		c.sendL, c.sendP = NewFSChaCha20(initiatorL), NewFSChaCha20Poly1305(initiatorP)
+		c.recvL, c.recvP = NewFSChaCha20(responderL), NewFSChaCha20Poly1305(responderP)
+		copy(c.sendGarbageTerminator[:], terminators[:GarbageTerminatorSize])
+		copy(c.recvGarbageTerminator[:], terminators[GarbageTerminatorSize:])
+	} else {
+		c.sendL, c.sendP = NewFSChaCha20(responderL), NewFSChaCha20Poly1305(responderP)
+		c.recvL, c.recvP = NewFSChaCha20(initiatorL), NewFSChaCha20Poly1305(initiatorP)
+		copy(c.sendGarbageTerminator[:], terminators[GarbageTerminatorSize:])
+		copy(c.recvGarbageTerminator[:], terminators[:GarbageTerminatorSize])
+	}
+
+	c.initialized = true
+	return nil
+}
+
+// SessionID identifies the session, both sides have the same value
+// so it can be compared out of band to detect a man in the middle
+func (c *Cipher) SessionID() [32]byte {
+	return c.sessionID
+}
+
+// SendGarbageTerminator is sent right after our garbage
+func (c *Cipher) SendGarbageTerminator() [GarbageTerminatorSize]byte {
+	return c.sendGarbageTerminator
+}
+
+// ReceiveGarbageTerminator marks the end of the remote garbage
+func (c *Cipher) ReceiveGarbageTerminator() [GarbageTerminatorSize]byte {
+	return c.recvGarbageTerminator
+}
+
+// Encrypt builds a packet with the contents, aad is authenticated but
+// not sent, ignore marks the packet as a decoy the remote discards
+func (c *Cipher) Encrypt(contents, aad []byte, ignore bool) ([]byte, error) {
+	if !c.initialized {
+		return nil, ErrNotInitialized
+	}
+
+	if len(contents) >= 1<<(LengthSize*8) {
+		return nil, fmt.Errorf("contents too big: %d bytes", len(contents))
+	}
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(contents)))
+
+	header := byte(0)
+	if ignore {
+		header |= IgnoreBit
+	}
+
+	plaintext := make([]byte, 0, HeaderSize+len(contents))
+	plaintext = append(plaintext, header)
+	plaintext = append(plaintext, contents...)
+
+	packet := c.sendL.Crypt(length[:LengthSize])
+	return append(packet, c.sendP.Encrypt(plaintext, aad)...), nil
+}
+
+// DecryptLength returns the contents length of the next packet, the
+// packet then has HeaderSize + length + TagSize more bytes
+func (c *Cipher) DecryptLength(encrypted [LengthSize]byte) (uint32, error) {
+	if !c.initialized {
+		return 0, ErrNotInitialized
+	}
+
+	var length [4]byte
+	copy(length[:], c.recvL.Crypt(encrypted[:]))
+	return binary.LittleEndian.Uint32(length[:]), nil
+}
+
+// Decrypt authenticates and decrypts the packet that follows the length,
+// it returns the contents and whether the packet is a decoy
+func (c *Cipher) Decrypt(packet, aad []byte) (contents []byte, ignore bool, err error) {
+	if !c.initialized {
+		return nil, false, ErrNotInitialized
+	}
+
+	plaintext, err := c.recvP.Decrypt(packet, aad)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(plaintext) < HeaderSize {
+		return nil, false, fmt.Errorf("%w: packet without header", ErrAuthentication)
+	}
+
+	return plaintext[HeaderSize:], plaintext[0]&IgnoreBit != 0, nil
+}
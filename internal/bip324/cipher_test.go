@@ -0,0 +1,125 @@
+package bip324_test
+
+import (
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/bip324"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+// https://www.rfc-editor.org/rfc/rfc5869#appendix-A.1
+func TestHKDF(t *testing.T) {
+	ikm := make([]byte, 22)
+	for i := range ikm {
+		ikm[i] = 0x0b
+	}
+
+	prk := bip324.HKDFExtract(sequence(0, 13), ikm)
+	require.Equal(t, mustDecodeHex(t, "077709362c2e32df0ddc3f0dc47bba6390b6c73bb50f9c3122ec844ad7c2b3e5"), prk)
+
+	okm := bip324.HKDFExpand(prk, sequence(0xf0, 10), 42)
+	require.Equal(t, mustDecodeHex(t, "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865"), okm)
+}
+
+// sessionPair returns both sides of an initialized session
+func sessionPair(t *testing.T, magic messages.Magic) (*bip324.Cipher, *bip324.Cipher) {
+	initiator, err := bip324.NewCipher()
+	require.NoError(t, err)
+	responder, err := bip324.NewCipher()
+	require.NoError(t, err)
+
+	require.NoError(t, initiator.Initialize(responder.EllSwift(), true, magic))
+	require.NoError(t, responder.Initialize(initiator.EllSwift(), false, magic))
+	return initiator, responder
+}
+
+// decrypt splits the packet as it is read from the wire
+func decrypt(t *testing.T, c *bip324.Cipher, packet, aad []byte) ([]byte, bool, error) {
+	var length [bip324.LengthSize]byte
+	copy(length[:], packet)
+
+	n, err := c.DecryptLength(length)
+	require.NoError(t, err)
+	require.Len(t, packet, int(n)+bip324.PacketOverhead)
+
+	return c.Decrypt(packet[bip324.LengthSize:], aad)
+}
+
+func TestCipherSession(t *testing.T) {
+	initiator, responder := sessionPair(t, messages.MagicMain)
+
+	require.Equal(t, initiator.SessionID(), responder.SessionID())
+	require.Equal(t, initiator.SendGarbageTerminator(), responder.ReceiveGarbageTerminator())
+	require.Equal(t, initiator.ReceiveGarbageTerminator(), responder.SendGarbageTerminator())
+	require.NotEqual(t, initiator.SendGarbageTerminator(), initiator.ReceiveGarbageTerminator())
+
+	garbage := []byte("some garbage")
+	packet, err := initiator.Encrypt(nil, garbage, false)
+	require.NoError(t, err)
+	require.Len(t, packet, bip324.PacketOverhead)
+
+	contents, ignore, err := decrypt(t, responder, packet, garbage)
+	require.NoError(t, err)
+	require.False(t, ignore)
+	require.Empty(t, contents)
+
+	// enough packets in both directions to rekey a few times
+	for i := 0; i < 3*bip324.RekeyInterval; i++ {
+		decoy := i%5 == 0
+		packet, err := initiator.Encrypt([]byte{byte(i), 1, 2}, nil, decoy)
+		require.NoError(t, err)
+
+		contents, ignore, err := decrypt(t, responder, packet, nil)
+		require.NoError(t, err)
+		require.Equal(t, decoy, ignore)
+		require.Equal(t, []byte{byte(i), 1, 2}, contents)
+
+		packet, err = responder.Encrypt([]byte{byte(i)}, nil, false)
+		require.NoError(t, err)
+
+		contents, _, err = decrypt(t, initiator, packet, nil)
+		require.NoError(t, err)
+		require.Equal(t, []byte{byte(i)}, contents)
+	}
+}
+
+func TestCipherTamperedPacket(t *testing.T) {
+	initiator, responder := sessionPair(t, messages.MagicMain)
+
+	packet, err := initiator.Encrypt([]byte("contents"), nil, false)
+	require.NoError(t, err)
+
+	packet[len(packet)-1] ^= 1
+	_, _, err = decrypt(t, responder, packet, nil)
+	require.ErrorIs(t, err, bip324.ErrAuthentication)
+}
+
+func TestCipherWrongGarbage(t *testing.T) {
+	initiator, responder := sessionPair(t, messages.MagicMain)
+
+	packet, err := initiator.Encrypt(nil, []byte("garbage"), false)
+	require.NoError(t, err)
+
+	_, _, err = decrypt(t, responder, packet, []byte("other garbage"))
+	require.ErrorIs(t, err, bip324.ErrAuthentication)
+}
+
+func TestCipherNetworkMismatch(t *testing.T) {
+	initiator, err := bip324.NewCipher()
+	require.NoError(t, err)
+	responder, err := bip324.NewCipher()
+	require.NoError(t, err)
+
+	require.NoError(t, initiator.Initialize(responder.EllSwift(), true, messages.MagicMain))
+	require.NoError(t, responder.Initialize(initiator.EllSwift(), false, messages.MagicTestNet3))
+	require.NotEqual(t, initiator.SessionID(), responder.SessionID())
+}
+
+func TestCipherNotInitialized(t *testing.T) {
+	c, err := bip324.NewCipher()
+	require.NoError(t, err)
+
+	_, err = c.Encrypt(nil, nil, false)
+	require.ErrorIs(t, err, bip324.ErrNotInitialized)
+}
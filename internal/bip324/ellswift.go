@@ -0,0 +1,212 @@
+package bip324
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// the secp256k1 curve y^2 = x^3 + 7 over the field of size p
+// check: https://www.secg.org/sec2-v2.pdf
+var (
+	fieldP, _ = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	curveB    = big.NewInt(7)
+
+	// (p + 1) / 4, since p = 3 mod 4 the square root of a is a^((p+1)/4)
+	sqrtExponent = new(big.Int).Rsh(new(big.Int).Add(fieldP, big.NewInt(1)), 2)
+)
+
+// field arithmetic modulo p, every function returns a new value, the ellswift
+// maps only handle public values so they do not need to be constant time
+func feMod(a *big.Int) *big.Int     { return new(big.Int).Mod(a, fieldP) }
+func feAdd(a, b *big.Int) *big.Int  { return feMod(new(big.Int).Add(a, b)) }
+func feSub(a, b *big.Int) *big.Int  { return feMod(new(big.Int).Sub(a, b)) }
+func feMul(a, b *big.Int) *big.Int  { return feMod(new(big.Int).Mul(a, b)) }
+func feNeg(a *big.Int) *big.Int     { return feMod(new(big.Int).Neg(a)) }
+func feInv(a *big.Int) *big.Int     { return new(big.Int).ModInverse(a, fieldP) }
+func feDiv(a, b *big.Int) *big.Int  { return feMul(a, feInv(b)) }
+func feSquare(a *big.Int) *big.Int  { return feMul(a, a) }
+func feCube(a *big.Int) *big.Int    { return feMul(feSquare(a), a) }
+func feInt(v int64) *big.Int        { return feMod(big.NewInt(v)) }
+func feIsZero(a *big.Int) bool      { return a.Sign() == 0 }
+func feEqual(a, b *big.Int) bool    { return a.Cmp(b) == 0 }
+func feFromBytes(b []byte) *big.Int { return feMod(new(big.Int).SetBytes(b)) }
+
+func feBytes(a *big.Int) [32]byte {
+	var out [32]byte
+	a.FillBytes(out[:])
+	return out
+}
+
+// feSqrt returns a square root of a, if there is one
+func feSqrt(a *big.Int) (*big.Int, bool) {
+	root := new(big.Int).Exp(a, sqrtExponent, fieldP)
+	return root, feEqual(feSquare(root), feMod(a))
+}
+
+// isValidX reports whether there is a point on the curve with the x coordinate
+func isValidX(x *big.Int) bool {
+	_, ok := feSqrt(feAdd(feCube(x), curveB))
+	return ok
+}
+
+// EllSwiftSize is the size of an ElligatorSwift encoded public key
+const EllSwiftSize = 64
+
+// ElligatorSwift encodes public keys as 64 bytes indistinguishable from
+// random, so the handshake can not be fingerprinted by an observer
+// check: https://github.com/bitcoin/bips/blob/master/bip-0324.mediawiki#ellswift-encoding
+var (
+	// minus3Sqrt is the square root of -3 picked by a^((p+1)/4)
+	minus3Sqrt, _ = feSqrt(feInt(-3))
+)
+
+// xSwiftEC decodes the field elements (u, t) into the x coordinate of a curve point
+func xSwiftEC(u, t *big.Int) *big.Int {
+	if feIsZero(u) {
+		u = feInt(1)
+	}
+	if feIsZero(t) {
+		t = feInt(1)
+	}
+
+	u3plus7 := feAdd(feCube(u), curveB)
+	if feIsZero(feAdd(u3plus7, feSquare(t))) {
+		t = feMul(feInt(2), t)
+	}
+
+	x := feDiv(feSub(u3plus7, feSquare(t)), feMul(feInt(2), t))
+	y := feDiv(feAdd(x, t), feMul(minus3Sqrt, u))
+
+	candidates := []*big.Int{
+		feAdd(u, feMul(feInt(4), feSquare(y))),
+		feDiv(feSub(feNeg(feDiv(x, y)), u), feInt(2)),
+		feDiv(feSub(feDiv(x, y), u), feInt(2)),
+	}
+	for _, candidate := range candidates {
+		if isValidX(candidate) {
+			return candidate
+		}
+	}
+
+	// one of the candidates is always on the curve
+	panic("xswiftec found no valid x")
+}
+
+// xSwiftECInv finds t such that xSwiftEC(u, t) = x, case selects one
+// of the 8 inverse branches, nil means the branch has no solution
+func xSwiftECInv(x, u *big.Int, branch int) *big.Int {
+	var v, s *big.Int
+	u3plus7 := feAdd(feCube(u), curveB)
+
+	if branch&2 == 0 {
+		if isValidX(feSub(feNeg(x), u)) {
+			return nil
+		}
+		v = x
+		s = feDiv(feNeg(u3plus7), feAdd(feAdd(feSquare(u), feMul(u, v)), feSquare(v)))
+	} else {
+		s = feSub(x, u)
+		if feIsZero(s) {
+			return nil
+		}
+
+		r, ok := feSqrt(feMul(feNeg(s), feAdd(feMul(feInt(4), u3plus7), feMul(feMul(feInt(3), s), feSquare(u)))))
+		if !ok {
+			return nil
+		}
+		if branch&1 == 1 && feIsZero(r) {
+			return nil
+		}
+		v = feDiv(feSub(feDiv(r, s), u), feInt(2))
+	}
+
+	w, ok := feSqrt(s)
+	if !ok {
+		return nil
+	}
+	// branches 0 and 5 use the negated root, same as the reference implementation
+	if branch&5 == 0 || branch&5 == 5 {
+		w = feNeg(w)
+	}
+
+	// u * (1 -/+ sqrt(-3)) / 2 + v
+	var factor *big.Int
+	if branch&1 == 0 {
+		factor = feSub(feInt(1), minus3Sqrt)
+	} else {
+		factor = feAdd(feInt(1), minus3Sqrt)
+	}
+	return feMul(w, feAdd(feDiv(feMul(u, factor), feInt(2)), v))
+}
+
+// EllSwiftEncode returns a random 64 bytes encoding of the x coordinate
+func EllSwiftEncode(x [32]byte) ([EllSwiftSize]byte, error) {
+	target := feFromBytes(x[:])
+
+	for {
+		var random [33]byte
+		if _, err := rand.Read(random[:]); err != nil {
+			return [EllSwiftSize]byte{}, fmt.Errorf("while generating ellswift encoding: %w", err)
+		}
+
+		u := feFromBytes(random[:32])
+		t := xSwiftECInv(target, u, int(random[32]&7))
+		// the decoding is checked so a branch without solution is never used
+		if t == nil || !feEqual(xSwiftEC(u, t), target) {
+			continue
+		}
+
+		var encoded [EllSwiftSize]byte
+		uBytes, tBytes := feBytes(u), feBytes(t)
+		copy(encoded[:32], uBytes[:])
+		copy(encoded[32:], tBytes[:])
+		return encoded, nil
+	}
+}
+
+// EllSwiftDecode returns the x coordinate encoded in the 64 bytes, every
+// 64 bytes value decodes to a valid x
+func EllSwiftDecode(encoded [EllSwiftSize]byte) [32]byte {
+	u, t := feFromBytes(encoded[:32]), feFromBytes(encoded[32:])
+	return feBytes(xSwiftEC(u, t))
+}
+
+// EllSwiftCreate returns the ElligatorSwift encoding of the key public key
+func (k *PrivateKey) EllSwiftCreate() ([EllSwiftSize]byte, error) {
+	return EllSwiftEncode(k.PublicKeyX())
+}
+
+// EllSwiftECDH derives the BIP324 shared secret between our key and the
+// remote ElligatorSwift encoded public key, both sides get the same secret
+// since the encodings are always hashed in the initiator, responder order
+func (k *PrivateKey) EllSwiftECDH(ours, theirs [EllSwiftSize]byte, initiator bool) ([32]byte, error) {
+	sharedX, err := k.ecdhX(EllSwiftDecode(theirs))
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	initiatorKey, responderKey := ours, theirs
+	if !initiator {
+		initiatorKey, responderKey = theirs, ours
+	}
+
+	return taggedHash("bip324_ellswift_xonly_ecdh", initiatorKey[:], responderKey[:], sharedX[:]), nil
+}
+
+// taggedHash is the BIP340 tagged hash, sha256(sha256(tag) || sha256(tag) || data)
+func taggedHash(tag string, data ...[]byte) [32]byte {
+	tagHash := sha256.Sum256([]byte(tag))
+
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, part := range data {
+		h.Write(part)
+	}
+
+	var out [32]byte
+	copy(out[:], h.Sum(nil))
+	return out
+}
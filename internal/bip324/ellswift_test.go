@@ -0,0 +1,73 @@
+package bip324_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/bip324"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublicKeyX(t *testing.T) {
+	key, err := bip324.PrivateKeyFromBytes(append(make([]byte, 31), 2))
+	require.NoError(t, err)
+
+	// x coordinate of 2G
+	x := key.PublicKeyX()
+	require.Equal(t, "c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5", hex.EncodeToString(x[:]))
+}
+
+func TestPrivateKeyOutOfRange(t *testing.T) {
+	_, err := bip324.PrivateKeyFromBytes(make([]byte, 32))
+	require.ErrorIs(t, err, bip324.ErrInvalidPrivateKey)
+
+	_, err = bip324.PrivateKeyFromBytes(make([]byte, 31))
+	require.ErrorIs(t, err, bip324.ErrInvalidPrivateKey)
+}
+
+// first vector of the BIP324 ellswift_decode_test_vectors.csv, u and t
+// zero are replaced by one
+func TestEllSwiftDecodeZero(t *testing.T) {
+	x := bip324.EllSwiftDecode([bip324.EllSwiftSize]byte{})
+	require.Equal(t, "edd1fd3e327ce90cc7a3542614289aee9682003e9cf7dcc9cf2ca9743be5aa0c", hex.EncodeToString(x[:]))
+}
+
+func TestEllSwiftRoundTrip(t *testing.T) {
+	for i := 0; i < 16; i++ {
+		key, err := bip324.GeneratePrivateKey()
+		require.NoError(t, err)
+
+		encoded, err := key.EllSwiftCreate()
+		require.NoError(t, err)
+		require.Equal(t, key.PublicKeyX(), bip324.EllSwiftDecode(encoded))
+
+		// the encoding is random, the same key has many encodings
+		again, err := key.EllSwiftCreate()
+		require.NoError(t, err)
+		require.NotEqual(t, encoded, again)
+		require.Equal(t, key.PublicKeyX(), bip324.EllSwiftDecode(again))
+	}
+}
+
+func TestEllSwiftECDH(t *testing.T) {
+	initiator, err := bip324.GeneratePrivateKey()
+	require.NoError(t, err)
+	responder, err := bip324.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	initiatorEncoded, err := initiator.EllSwiftCreate()
+	require.NoError(t, err)
+	responderEncoded, err := responder.EllSwiftCreate()
+	require.NoError(t, err)
+
+	fromInitiator, err := initiator.EllSwiftECDH(initiatorEncoded, responderEncoded, true)
+	require.NoError(t, err)
+	fromResponder, err := responder.EllSwiftECDH(responderEncoded, initiatorEncoded, false)
+	require.NoError(t, err)
+	require.Equal(t, fromInitiator, fromResponder)
+
+	// the secret depends on the roles
+	swapped, err := initiator.EllSwiftECDH(initiatorEncoded, responderEncoded, false)
+	require.NoError(t, err)
+	require.NotEqual(t, fromInitiator, swapped)
+}
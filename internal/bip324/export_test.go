@@ -0,0 +1,29 @@
+package bip324
+
+// the primitives are exported to the tests so they can be
+// checked against the test vectors of their specifications
+var (
+	ChaCha20XOR          = chacha20XOR
+	ChaCha20Poly1305Seal = chacha20Poly1305Seal
+	ChaCha20Poly1305Open = chacha20Poly1305Open
+	HKDFExtract          = hkdfExtract
+	HKDFExpand           = hkdfExpand
+)
+
+func XSwiftEC(u, t [32]byte) [32]byte {
+	return feBytes(xSwiftEC(feFromBytes(u[:]), feFromBytes(t[:])))
+}
+
+func XSwiftECInv(x, u [32]byte, branch int) ([32]byte, bool) {
+	t := xSwiftECInv(feFromBytes(x[:]), feFromBytes(u[:]), branch)
+	if t == nil {
+		return [32]byte{}, false
+	}
+	return feBytes(t), true
+}
+
+func NewFSChaCha20WithInterval(key [KeySize]byte, rekeyInterval uint32) *FSChaCha20 {
+	f := NewFSChaCha20(key)
+	f.rekeyInterval = rekeyInterval
+	return f
+}
@@ -0,0 +1,107 @@
+package bip324
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// RekeyInterval is how many packets are encrypted with a key before
+// it is replaced, it gives forward secrecy to the long lived sessions
+const RekeyInterval = 224
+
+// FSChaCha20 is the forward secure stream cipher encrypting the packet
+// lengths, the keystream continues across chunks and after RekeyInterval
+// chunks the next 32 bytes of keystream become the new key
+type FSChaCha20 struct {
+	key [KeySize]byte
+	// rekeyInterval is RekeyInterval, the tests use others
+	rekeyInterval uint32
+	chunkCounter  uint32
+	rekeyCounter  uint64
+
+	// keystream continues across the chunks of the same key
+	keystream *chacha20.Cipher
+}
+
+func NewFSChaCha20(key [KeySize]byte) *FSChaCha20 {
+	f := &FSChaCha20{key: key, rekeyInterval: RekeyInterval}
+	f.keystream = newChaCha20(&f.key, f.nonce())
+	return f
+}
+
+func (f *FSChaCha20) nonce() *[NonceSize]byte {
+	var nonce [NonceSize]byte
+	binary.LittleEndian.PutUint64(nonce[4:], f.rekeyCounter)
+	return &nonce
+}
+
+// Crypt encrypts or decrypts a chunk, both sides must crypt the same chunks in order
+func (f *FSChaCha20) Crypt(chunk []byte) []byte {
+	out := make([]byte, len(chunk))
+	f.keystream.XORKeyStream(out, chunk)
+
+	f.chunkCounter++
+	if f.chunkCounter == f.rekeyInterval {
+		// the new key is the next 32 bytes of keystream
+		var key [KeySize]byte
+		f.keystream.XORKeyStream(key[:], key[:])
+		f.key = key
+		f.chunkCounter = 0
+		f.rekeyCounter++
+		f.keystream = newChaCha20(&f.key, f.nonce())
+	}
+
+	return out
+}
+
+// FSChaCha20Poly1305 is the forward secure AEAD encrypting the packets,
+// every packet uses its own nonce and the key changes every RekeyInterval packets
+type FSChaCha20Poly1305 struct {
+	key           [KeySize]byte
+	packetCounter uint32
+	rekeyCounter  uint64
+}
+
+func NewFSChaCha20Poly1305(key [KeySize]byte) *FSChaCha20Poly1305 {
+	return &FSChaCha20Poly1305{key: key}
+}
+
+func (f *FSChaCha20Poly1305) nonce(packetCounter uint32) *[NonceSize]byte {
+	var nonce [NonceSize]byte
+	binary.LittleEndian.PutUint32(nonce[:4], packetCounter)
+	binary.LittleEndian.PutUint64(nonce[4:], f.rekeyCounter)
+	return &nonce
+}
+
+// Encrypt returns the ciphertext with the authentication tag appended
+func (f *FSChaCha20Poly1305) Encrypt(plaintext, aad []byte) []byte {
+	ciphertext := chacha20Poly1305Seal(&f.key, f.nonce(f.packetCounter), plaintext, aad)
+	f.nextPacket()
+	return ciphertext
+}
+
+// Decrypt authenticates and decrypts the ciphertext, a failure
+// means the session can not continue since the nonces diverged
+func (f *FSChaCha20Poly1305) Decrypt(ciphertext, aad []byte) ([]byte, error) {
+	plaintext, err := chacha20Poly1305Open(&f.key, f.nonce(f.packetCounter), ciphertext, aad)
+	if err != nil {
+		return nil, err
+	}
+	f.nextPacket()
+	return plaintext, nil
+}
+
+func (f *FSChaCha20Poly1305) nextPacket() {
+	f.packetCounter++
+	if f.packetCounter != RekeyInterval {
+		return
+	}
+
+	// the new key comes from a keystream block with a nonce no packet uses
+	var key [KeySize]byte
+	chacha20XOR(&f.key, f.nonce(0xFFFFFFFF), 0, key[:], key[:])
+	f.key = key
+	f.packetCounter = 0
+	f.rekeyCounter++
+}
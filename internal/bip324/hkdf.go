@@ -0,0 +1,23 @@
+package bip324
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// hkdfExtract and hkdfExpand implement HKDF-SHA256
+// check: https://www.rfc-editor.org/rfc/rfc5869
+func hkdfExtract(salt, ikm []byte) []byte {
+	return hkdf.Extract(sha256.New, ikm, salt)
+}
+
+func hkdfExpand(prk, info []byte, length int) []byte {
+	okm := make([]byte, length)
+	// the output is only short when length is over 255 hashes
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, info), okm); err != nil {
+		panic(err)
+	}
+	return okm
+}
@@ -0,0 +1,99 @@
+package bip324
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// commandSize is the size of the command in the long message type encoding
+const commandSize = 12
+
+var ErrUnknownMessageType = errors.New("unknown message type")
+
+// shortIDs replaces the 12 bytes command by a single byte for the most
+// common messages, the 0 id means the command follows in full
+// check: https://github.com/bitcoin/bips/blob/master/bip-0324.mediawiki#v2-bitcoin-p2p-message-structure
+var shortIDs = []string{
+	1:  "addr",
+	2:  "block",
+	3:  "blocktxn",
+	4:  "cmpctblock",
+	5:  "feefilter",
+	6:  "filteradd",
+	7:  "filterclear",
+	8:  "filterload",
+	9:  "getblocks",
+	10: "getblocktxn",
+	11: "getdata",
+	12: "getheaders",
+	13: "headers",
+	14: "inv",
+	15: "mempool",
+	16: "merkleblock",
+	17: "notfound",
+	18: "ping",
+	19: "pong",
+	20: "sendcmpct",
+	21: "tx",
+	22: "getcfilters",
+	23: "cfilter",
+	24: "getcfheaders",
+	25: "cfheaders",
+	26: "getcfcheckpt",
+	27: "cfcheckpt",
+	28: "addrv2",
+}
+
+var shortIDByCommand = func() map[string]byte {
+	ids := make(map[string]byte, len(shortIDs))
+	for id, command := range shortIDs {
+		if command != "" {
+			ids[command] = byte(id)
+		}
+	}
+	return ids
+}()
+
+// EncodeContents builds the packet contents, the message type followed by the payload
+func EncodeContents(command string, payload []byte) ([]byte, error) {
+	if id, ok := shortIDByCommand[command]; ok {
+		return append([]byte{id}, payload...), nil
+	}
+
+	if len(command) == 0 || len(command) > commandSize {
+		return nil, fmt.Errorf("invalid command %q", command)
+	}
+
+	contents := make([]byte, 1+commandSize, 1+commandSize+len(payload))
+	copy(contents[1:], command)
+	return append(contents, payload...), nil
+}
+
+// DecodeContents splits the packet contents into the command and the payload
+func DecodeContents(contents []byte) (command string, payload []byte, err error) {
+	if len(contents) == 0 {
+		return "", nil, fmt.Errorf("%w: empty contents", ErrUnknownMessageType)
+	}
+
+	id := contents[0]
+	if id != 0 {
+		if int(id) >= len(shortIDs) {
+			return "", nil, fmt.Errorf("%w: short id %d", ErrUnknownMessageType, id)
+		}
+		return shortIDs[id], contents[1:], nil
+	}
+
+	if len(contents) < 1+commandSize {
+		return "", nil, fmt.Errorf("%w: truncated command", ErrUnknownMessageType)
+	}
+
+	// the command is padded with zeros, which can not appear before the end
+	raw := contents[1 : 1+commandSize]
+	command = string(bytes.TrimRight(raw, "\x00"))
+	if len(command) == 0 || bytes.IndexByte([]byte(command), 0) != -1 {
+		return "", nil, fmt.Errorf("%w: malformed command %q", ErrUnknownMessageType, raw)
+	}
+
+	return command, contents[1+commandSize:], nil
+}
@@ -0,0 +1,47 @@
+package bip324_test
+
+import (
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/bip324"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShortMessageType(t *testing.T) {
+	contents, err := bip324.EncodeContents("ping", []byte{1, 2, 3})
+	require.NoError(t, err)
+	require.Equal(t, []byte{18, 1, 2, 3}, contents)
+
+	command, payload, err := bip324.DecodeContents(contents)
+	require.NoError(t, err)
+	require.Equal(t, "ping", command)
+	require.Equal(t, []byte{1, 2, 3}, payload)
+}
+
+func TestLongMessageType(t *testing.T) {
+	// version and verack have no short id
+	contents, err := bip324.EncodeContents("verack", nil)
+	require.NoError(t, err)
+	require.Equal(t, append([]byte{0}, "verack\x00\x00\x00\x00\x00\x00"...), contents)
+
+	command, payload, err := bip324.DecodeContents(contents)
+	require.NoError(t, err)
+	require.Equal(t, "verack", command)
+	require.Empty(t, payload)
+}
+
+func TestDecodeInvalidMessageType(t *testing.T) {
+	cases := map[string][]byte{
+		"empty":            {},
+		"unknown short id": {29},
+		"truncated":        append([]byte{0}, "ver"...),
+		"zero in command":  append([]byte{0}, "ver\x00ack\x00\x00\x00\x00\x00"...),
+	}
+
+	for name, contents := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := bip324.DecodeContents(contents)
+			require.ErrorIs(t, err, bip324.ErrUnknownMessageType)
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package bip324
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+var ErrInvalidPrivateKey = errors.New("invalid private key")
+
+// the generator of the secp256k1 curve y^2 = x^3 + 7
+// check: https://www.secg.org/sec2-v2.pdf
+var curveGx, curveGy = fieldFromHex("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"),
+	fieldFromHex("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8")
+
+// curveB3 is 3 * b, used by the complete addition formulas
+const curveB3 = 21
+
+func fieldFromHex(s string) secp256k1.FieldVal {
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		panic(fmt.Sprintf("invalid hex constant %s", s))
+	}
+
+	var f secp256k1.FieldVal
+	f.SetByteSlice(raw)
+	return f
+}
+
+// the field operations of the secret dependent arithmetic, they are all
+// constant time and return normalized values so the magnitudes never grow
+func fieldAdd(a, b *secp256k1.FieldVal) secp256k1.FieldVal {
+	var r secp256k1.FieldVal
+	r.Add2(a, b).Normalize()
+	return r
+}
+
+func fieldSub(a, b *secp256k1.FieldVal) secp256k1.FieldVal {
+	var r secp256k1.FieldVal
+	r.NegateVal(b, 1).Add(a).Normalize()
+	return r
+}
+
+func fieldMul(a, b *secp256k1.FieldVal) secp256k1.FieldVal {
+	var r secp256k1.FieldVal
+	r.Mul2(a, b).Normalize()
+	return r
+}
+
+func fieldMulInt(a *secp256k1.FieldVal, v uint8) secp256k1.FieldVal {
+	r := *a
+	r.MulInt(v).Normalize()
+	return r
+}
+
+// fieldSwap swaps a and b when swap is 1 and leaves them when it is 0,
+// in constant time, both values must be normalized
+func fieldSwap(a, b *secp256k1.FieldVal, swap uint32) {
+	mask := byte(-swap)
+	aBytes, bBytes := a.Bytes(), b.Bytes()
+	for i := range aBytes {
+		diff := mask & (aBytes[i] ^ bBytes[i])
+		aBytes[i] ^= diff
+		bBytes[i] ^= diff
+	}
+	a.SetBytes(aBytes)
+	b.SetBytes(bBytes)
+}
+
+// projectivePoint represents the affine point (x/z, y/z), the infinity is (0, 1, 0)
+type projectivePoint struct {
+	x, y, z secp256k1.FieldVal
+}
+
+func infinity() projectivePoint {
+	var p projectivePoint
+	p.y.SetInt(1)
+	return p
+}
+
+// add uses the complete formulas for a = 0 (algorithm 7), they have no
+// exceptional case so doubling and the infinity need no branch
+// check: https://eprint.iacr.org/2015/1060.pdf
+func (p *projectivePoint) add(q *projectivePoint) projectivePoint {
+	t0 := fieldMul(&p.x, &q.x)
+	t1 := fieldMul(&p.y, &q.y)
+	t2 := fieldMul(&p.z, &q.z)
+
+	a, b := fieldAdd(&p.x, &p.y), fieldAdd(&q.x, &q.y)
+	t3 := fieldMul(&a, &b)
+	sum := fieldAdd(&t0, &t1)
+	t3 = fieldSub(&t3, &sum)
+
+	a, b = fieldAdd(&p.y, &p.z), fieldAdd(&q.y, &q.z)
+	t4 := fieldMul(&a, &b)
+	sum = fieldAdd(&t1, &t2)
+	t4 = fieldSub(&t4, &sum)
+
+	a, b = fieldAdd(&p.x, &p.z), fieldAdd(&q.x, &q.z)
+	y3 := fieldMul(&a, &b)
+	sum = fieldAdd(&t0, &t2)
+	y3 = fieldSub(&y3, &sum)
+
+	x3 := fieldAdd(&t0, &t0)
+	t0 = fieldAdd(&x3, &t0)
+	t2 = fieldMulInt(&t2, curveB3)
+	z3 := fieldAdd(&t1, &t2)
+	t1 = fieldSub(&t1, &t2)
+	y3 = fieldMulInt(&y3, curveB3)
+
+	x3 = fieldMul(&t4, &y3)
+	t2 = fieldMul(&t3, &t1)
+	x3 = fieldSub(&t2, &x3)
+	y3 = fieldMul(&y3, &t0)
+	t1 = fieldMul(&t1, &z3)
+	y3 = fieldAdd(&t1, &y3)
+	t0 = fieldMul(&t0, &t3)
+	z3 = fieldMul(&z3, &t4)
+	z3 = fieldAdd(&z3, &t0)
+	return projectivePoint{x: x3, y: y3, z: z3}
+}
+
+func (p *projectivePoint) swap(q *projectivePoint, swap uint32) {
+	fieldSwap(&p.x, &q.x, swap)
+	fieldSwap(&p.y, &q.y, swap)
+	fieldSwap(&p.z, &q.z, swap)
+}
+
+// affineX returns the x coordinate of the point, the bool is false for the infinity
+func (p *projectivePoint) affineX() ([32]byte, bool) {
+	if p.z.IsZero() {
+		return [32]byte{}, false
+	}
+
+	var zInv secp256k1.FieldVal
+	zInv.Set(&p.z).Inverse()
+	x := fieldMul(&p.x, &zInv)
+	return *x.Bytes(), true
+}
+
+// scalarMult multiplies the point by the big endian scalar with a montgomery
+// ladder, every bit takes the same additions and swaps so the time taken
+// does not depend on the scalar
+func scalarMult(k *[32]byte, x, y *secp256k1.FieldVal) projectivePoint {
+	r0, r1 := infinity(), projectivePoint{x: *x, y: *y}
+	r1.z.SetInt(1)
+
+	for i := 255; i >= 0; i-- {
+		bit := uint32(k[31-i/8]>>(i%8)) & 1
+
+		r0.swap(&r1, bit)
+		r1 = r0.add(&r1)
+		r0 = r0.add(&r0)
+		r0.swap(&r1, bit)
+	}
+	return r0
+}
+
+// PrivateKey is a secp256k1 scalar in [1, n-1]
+type PrivateKey struct {
+	d [32]byte
+}
+
+// GeneratePrivateKey returns a random private key
+func GeneratePrivateKey() (*PrivateKey, error) {
+	for {
+		var raw [32]byte
+		if _, err := rand.Read(raw[:]); err != nil {
+			return nil, fmt.Errorf("while generating private key: %w", err)
+		}
+
+		key, err := PrivateKeyFromBytes(raw[:])
+		if err == nil {
+			return key, nil
+		}
+	}
+}
+
+// PrivateKeyFromBytes parses a 32 bytes big endian private key
+func PrivateKeyFromBytes(raw []byte) (*PrivateKey, error) {
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("%w: must have 32 bytes, got %d", ErrInvalidPrivateKey, len(raw))
+	}
+
+	var d secp256k1.ModNScalar
+	if overflow := d.SetByteSlice(raw); overflow || d.IsZero() {
+		return nil, fmt.Errorf("%w: out of range", ErrInvalidPrivateKey)
+	}
+	return &PrivateKey{d: d.Bytes()}, nil
+}
+
+// PublicKeyX returns the x coordinate of the public key d*G
+func (k *PrivateKey) PublicKeyX() [32]byte {
+	public := scalarMult(&k.d, &curveGx, &curveGy)
+	// d is in [1, n-1] so d*G is never the infinity
+	x, _ := public.affineX()
+	return x
+}
+
+// ecdhX multiplies the point with the x coordinate by the private key and
+// returns the resulting x coordinate, either y gives the same x
+func (k *PrivateKey) ecdhX(x [32]byte) ([32]byte, error) {
+	var fx, fy secp256k1.FieldVal
+	if overflow := fx.SetBytes(&x); overflow != 0 {
+		return [32]byte{}, errors.New("x is not a field element")
+	}
+	if !secp256k1.DecompressY(&fx, false, &fy) {
+		return [32]byte{}, errors.New("x is not on the curve")
+	}
+	fy.Normalize()
+
+	shared := scalarMult(&k.d, &fx, &fy)
+	sharedX, ok := shared.affineX()
+	if !ok {
+		return [32]byte{}, errors.New("shared point is the infinity")
+	}
+	return sharedX, nil
+}
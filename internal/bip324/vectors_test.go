@@ -0,0 +1,138 @@
+package bip324_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/bip324"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+// readVectors reads a csv of the BIP324 test vectors, every row is
+// returned as a map from the column name to the value
+// check: https://github.com/bitcoin/bips/tree/master/bip-0324
+func readVectors(t *testing.T, name string) []map[string]string {
+	t.Helper()
+
+	file, err := os.Open(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	require.NoError(t, err)
+	require.NotEmpty(t, records)
+
+	header := records[0]
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(header))
+		for idx, column := range header {
+			row[column] = record[idx]
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func hex32(t *testing.T, s string) [32]byte {
+	t.Helper()
+	var out [32]byte
+	require.Len(t, s, 64)
+	copy(out[:], mustDecodeHex(t, s))
+	return out
+}
+
+func hexEllSwift(t *testing.T, s string) [bip324.EllSwiftSize]byte {
+	t.Helper()
+	var out [bip324.EllSwiftSize]byte
+	require.Len(t, s, 2*bip324.EllSwiftSize)
+	copy(out[:], mustDecodeHex(t, s))
+	return out
+}
+
+func TestEllSwiftDecodeVectors(t *testing.T) {
+	for idx, row := range readVectors(t, "ellswift_decode_test_vectors.csv") {
+		x := bip324.EllSwiftDecode(hexEllSwift(t, row["ellswift"]))
+		require.Equal(t, row["x"], hex.EncodeToString(x[:]), "row %d: %s", idx, row["comment"])
+	}
+}
+
+func TestXSwiftECInvVectors(t *testing.T) {
+	for idx, row := range readVectors(t, "xswiftec_inv_test_vectors.csv") {
+		u, x := hex32(t, row["u"]), hex32(t, row["x"])
+
+		for branch := 0; branch < 8; branch++ {
+			expected := row["case"+strconv.Itoa(branch)+"_t"]
+
+			tValue, ok := bip324.XSwiftECInv(x, u, branch)
+			if expected == "" {
+				require.False(t, ok, "row %d case %d: %s", idx, branch, row["comment"])
+				continue
+			}
+
+			require.True(t, ok, "row %d case %d: %s", idx, branch, row["comment"])
+			require.Equal(t, expected, hex.EncodeToString(tValue[:]), "row %d case %d: %s", idx, branch, row["comment"])
+
+			// every solution decodes back to x
+			require.Equal(t, x, bip324.XSwiftEC(u, tValue), "row %d case %d", idx, branch)
+		}
+	}
+}
+
+// TestPacketEncodingVectors covers the ECDH, the key derivation and the packet
+// encryption, packets before in_idx are encrypted so the ciphers go through
+// their rekeys before the checked packet
+func TestPacketEncodingVectors(t *testing.T) {
+	for idx, row := range readVectors(t, "packet_encoding_test_vectors.csv") {
+		key, err := bip324.PrivateKeyFromBytes(mustDecodeHex(t, row["in_priv_ours"]))
+		require.NoError(t, err)
+
+		ours, theirs := hexEllSwift(t, row["in_ellswift_ours"]), hexEllSwift(t, row["in_ellswift_theirs"])
+		initiator := row["in_initiating"] == "1"
+
+		require.Equal(t, hex32(t, row["mid_x_ours"]), key.PublicKeyX(), "row %d", idx)
+		require.Equal(t, hex32(t, row["mid_x_ours"]), bip324.EllSwiftDecode(ours), "row %d", idx)
+		require.Equal(t, hex32(t, row["mid_x_theirs"]), bip324.EllSwiftDecode(theirs), "row %d", idx)
+
+		secret, err := key.EllSwiftECDH(ours, theirs, initiator)
+		require.NoError(t, err)
+		require.Equal(t, hex32(t, row["mid_shared_secret"]), secret, "row %d", idx)
+
+		cipher := bip324.NewCipherWithKey(key, ours)
+		require.NoError(t, cipher.Initialize(theirs, initiator, messages.MagicMain))
+
+		sendGarbageTerminator, recvGarbageTerminator := cipher.SendGarbageTerminator(), cipher.ReceiveGarbageTerminator()
+		require.Equal(t, row["mid_send_garbage_terminator"], hex.EncodeToString(sendGarbageTerminator[:]), "row %d", idx)
+		require.Equal(t, row["mid_recv_garbage_terminator"], hex.EncodeToString(recvGarbageTerminator[:]), "row %d", idx)
+
+		sessionID := cipher.SessionID()
+		require.Equal(t, row["out_session_id"], hex.EncodeToString(sessionID[:]), "row %d", idx)
+
+		packets, err := strconv.Atoi(row["in_idx"])
+		require.NoError(t, err)
+		for i := 0; i < packets; i++ {
+			_, err := cipher.Encrypt(nil, nil, false)
+			require.NoError(t, err)
+		}
+
+		multiply, err := strconv.Atoi(row["in_multiply"])
+		require.NoError(t, err)
+		contents := bytes.Repeat(mustDecodeHex(t, row["in_contents"]), multiply)
+
+		packet, err := cipher.Encrypt(contents, mustDecodeHex(t, row["in_aad"]), row["in_ignore"] == "1")
+		require.NoError(t, err)
+
+		if expected := row["out_ciphertext"]; expected != "" {
+			require.Equal(t, expected, hex.EncodeToString(packet), "row %d", idx)
+		}
+		if suffix := row["out_ciphertext_endswith"]; suffix != "" {
+			require.Equal(t, suffix, hex.EncodeToString(packet[len(packet)-len(suffix)/2:]), "row %d", idx)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package blockfilter
+
+import (
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+// parameters of the basic filter, they give a false positive rate of 1/784931
+const (
+	BasicP uint8  = 19
+	BasicM uint64 = 784931
+)
+
+// opReturn starts the provably unspendable outputs, which are left out of the filter
+const opReturn = 0x6a
+
+// BasicKey is the filter key of the block, the first bytes of its hash
+func BasicKey(blockHash messages.Hash) [KeySize]byte {
+	var key [KeySize]byte
+	copy(key[:], blockHash[:KeySize])
+	return key
+}
+
+// NewBasicFilter builds the basic filter of the block, which has the scripts
+// of every output created in the block and of every output it spends, the
+// latter are not in the block so prevScripts gives them
+// check: https://github.com/bitcoin/bips/blob/master/bip-0158.mediawiki#basic-filter-type
+func NewBasicFilter(block *messages.Block, prevScripts [][]byte) (*Filter, error) {
+	var items [][]byte
+	for _, tx := range block.Transactions {
+		for _, out := range tx.TxOut {
+			if len(out.PkScript) == 0 || out.PkScript[0] == opReturn {
+				continue
+			}
+			items = append(items, out.PkScript)
+		}
+	}
+
+	for _, script := range prevScripts {
+		if len(script) > 0 {
+			items = append(items, script)
+		}
+	}
+
+	return NewFilter(BasicP, BasicM, BasicKey(block.BlockHash()), items)
+}
+
+// BasicFilterFromBytes returns the basic filter of the block sent in cfilter
+func BasicFilterFromBytes(blockHash messages.Hash, data []byte) (*Filter, error) {
+	return FromBytes(BasicP, BasicM, BasicKey(blockHash), data)
+}
+
+// FilterHeader chains the filter hash to the previous filter header, the
+// one before the genesis filter is zero
+func FilterHeader(filterHash, prevHeader messages.Hash) messages.Hash {
+	return messages.DoubleSHA256(append(filterHash[:], prevHeader[:]...))
+}
@@ -0,0 +1,236 @@
+package blockfilter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/hashes"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+// KeySize is the size of the SipHash key of a filter
+const KeySize = 16
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// Filter is a Golomb-coded set, a compact probabilistic set where
+// items are hashed into [0, N*M), sorted and their differences are
+// Golomb-Rice coded with P bits of remainder. False positives
+// happen with a 1/M probability, there are no false negatives
+// check: https://github.com/bitcoin/bips/blob/master/bip-0158.mediawiki#golomb-coded-sets
+type Filter struct {
+	p   uint8
+	m   uint64
+	key [KeySize]byte
+
+	n uint32
+	// data is the serialized filter, the count followed by the coded set
+	data []byte
+}
+
+// NewFilter builds the filter of the items, duplicated items are kept once
+func NewFilter(p uint8, m uint64, key [KeySize]byte, items [][]byte) (*Filter, error) {
+	unique := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		unique[string(item)] = struct{}{}
+	}
+
+	if uint64(len(unique)) > uint64(^uint32(0)) {
+		return nil, fmt.Errorf("%w: %d items", ErrInvalidFilter, len(unique))
+	}
+
+	f := &Filter{p: p, m: m, key: key, n: uint32(len(unique))}
+
+	values := make([]uint64, 0, len(unique))
+	for item := range unique {
+		values = append(values, f.hashToRange([]byte(item)))
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	w := &bitWriter{buf: bytes.NewBuffer(codec.EncodeToVarint(uint64(f.n)))}
+	var last uint64
+	for _, value := range values {
+		delta := value - last
+		last = value
+
+		// the quotient in unary followed by the remainder
+		for q := delta >> p; q > 0; q-- {
+			w.writeBit(1)
+		}
+		w.writeBit(0)
+		w.writeBits(delta, p)
+	}
+
+	f.data = w.flush()
+	return f, nil
+}
+
+// FromBytes returns the filter serialized in data, as sent in cfilter
+func FromBytes(p uint8, m uint64, key [KeySize]byte, data []byte) (*Filter, error) {
+	n, err := codec.DecodeFromVarint(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: while decoding items count: %s", ErrInvalidFilter, err)
+	}
+
+	if n > uint64(^uint32(0)) {
+		return nil, fmt.Errorf("%w: %d items", ErrInvalidFilter, n)
+	}
+
+	return &Filter{p: p, m: m, key: key, n: uint32(n), data: data}, nil
+}
+
+// N is the number of items in the filter
+func (f *Filter) N() uint32 {
+	return f.n
+}
+
+// Bytes is the serialized filter
+func (f *Filter) Bytes() []byte {
+	return f.data
+}
+
+// Hash is the filter hash committed by the filter headers
+func (f *Filter) Hash() messages.Hash {
+	return messages.DoubleSHA256(f.data)
+}
+
+// Match tells if the item might be in the filter
+func (f *Filter) Match(item []byte) (bool, error) {
+	return f.MatchAny([][]byte{item})
+}
+
+// MatchAny tells if any of the items might be in the filter,
+// the filter is decoded only once whatever the number of items
+func (f *Filter) MatchAny(items [][]byte) (bool, error) {
+	if f.n == 0 || len(items) == 0 {
+		return false, nil
+	}
+
+	targets := make([]uint64, len(items))
+	for idx, item := range items {
+		targets[idx] = f.hashToRange(item)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i] < targets[j] })
+
+	r, err := f.reader()
+	if err != nil {
+		return false, err
+	}
+
+	var value uint64
+	for idx := uint32(0); idx < f.n; idx++ {
+		delta, err := r.readDelta(f.p)
+		if err != nil {
+			return false, fmt.Errorf("%w: while decoding item %d: %s", ErrInvalidFilter, idx, err)
+		}
+		value += delta
+
+		// both lists are sorted, so targets below the value are not in the filter
+		for len(targets) > 0 && targets[0] < value {
+			targets = targets[1:]
+		}
+		if len(targets) == 0 {
+			return false, nil
+		}
+		if targets[0] == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// hashToRange maps the item uniformly into [0, N*M)
+func (f *Filter) hashToRange(item []byte) uint64 {
+	k0 := binary.LittleEndian.Uint64(f.key[0:8])
+	k1 := binary.LittleEndian.Uint64(f.key[8:16])
+
+	// (hash * F) >> 64 avoids the bias and the cost of a modulo
+	hi, _ := bits.Mul64(hashes.SipHash24(k0, k1, item), uint64(f.n)*f.m)
+	return hi
+}
+
+// reader returns a bit reader positioned after the items count
+func (f *Filter) reader() (*bitReader, error) {
+	count := bytes.NewReader(f.data)
+	if _, err := codec.DecodeFromVarint(count); err != nil {
+		return nil, fmt.Errorf("%w: while decoding items count: %s", ErrInvalidFilter, err)
+	}
+	return &bitReader{data: f.data[len(f.data)-count.Len():]}, nil
+}
+
+// bitWriter appends bits, the most significant bit of each byte first
+type bitWriter struct {
+	buf     *bytes.Buffer
+	current byte
+	used    uint8
+}
+
+func (w *bitWriter) writeBit(bit uint64) {
+	w.current |= byte(bit&1) << (7 - w.used)
+	w.used++
+	if w.used == 8 {
+		w.buf.WriteByte(w.current)
+		w.current, w.used = 0, 0
+	}
+}
+
+// writeBits writes the lowest count bits of value, the most significant first
+func (w *bitWriter) writeBits(value uint64, count uint8) {
+	for idx := int(count) - 1; idx >= 0; idx-- {
+		w.writeBit(value >> idx)
+	}
+}
+
+// flush writes the last partial byte, padded with zeros
+func (w *bitWriter) flush() []byte {
+	if w.used > 0 {
+		w.buf.WriteByte(w.current)
+		w.current, w.used = 0, 0
+	}
+	return w.buf.Bytes()
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) readBit() (uint64, error) {
+	if r.pos >= len(r.data)*8 {
+		return 0, errors.New("unexpected end of filter")
+	}
+
+	bit := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+	r.pos++
+	return uint64(bit), nil
+}
+
+// readDelta reads a Golomb-Rice coded value
+func (r *bitReader) readDelta(p uint8) (uint64, error) {
+	var quotient uint64
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if bit == 0 {
+			break
+		}
+		quotient++
+	}
+
+	var remainder uint64
+	for idx := uint8(0); idx < p; idx++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		remainder = remainder<<1 | bit
+	}
+	return quotient<<p | remainder, nil
+}
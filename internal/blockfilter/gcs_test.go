@@ -0,0 +1,220 @@
+package blockfilter_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/blockfilter"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/chaincfg"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+// the coinbase of every genesis block
+const genesisCoinbaseHex = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff" +
+	"4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e20" +
+	"6272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a010000" +
+	"00434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f3" +
+	"5504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+func genesisBlock(t *testing.T, params *chaincfg.Params) *messages.Block {
+	enc, err := hex.DecodeString(genesisCoinbaseHex)
+	require.NoError(t, err)
+
+	coinbase := new(messages.Tx)
+	require.NoError(t, coinbase.Decode(bytes.NewReader(enc)))
+	return &messages.Block{Header: params.GenesisHeader, Transactions: []*messages.Tx{coinbase}}
+}
+
+func TestBasicFilterVector(t *testing.T) {
+	// the testnet3 genesis block from the BIP158 test vectors
+	block := genesisBlock(t, &chaincfg.TestNet3)
+
+	filter, err := blockfilter.NewBasicFilter(block, nil)
+	require.NoError(t, err)
+	require.Equal(t, "019dfca8", hex.EncodeToString(filter.Bytes()))
+
+	header := blockfilter.FilterHeader(filter.Hash(), messages.Hash{})
+	require.Equal(t, "21584579b7eb08997773e5aeff3a7f932700042d0ed2a6129012b7d7ae81b750", header.String())
+}
+
+func TestFilterMatch(t *testing.T) {
+	key := [blockfilter.KeySize]byte{1, 2, 3}
+	items := [][]byte{[]byte("alice"), []byte("bob"), []byte("carol"), []byte("bob")}
+
+	filter, err := blockfilter.NewFilter(blockfilter.BasicP, blockfilter.BasicM, key, items)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), filter.N())
+
+	for _, item := range items {
+		match, err := filter.Match(item)
+		require.NoError(t, err)
+		require.True(t, match, string(item))
+	}
+
+	match, err := filter.Match([]byte("mallory"))
+	require.NoError(t, err)
+	require.False(t, match)
+
+	match, err = filter.MatchAny([][]byte{[]byte("mallory"), []byte("trent"), []byte("carol")})
+	require.NoError(t, err)
+	require.True(t, match)
+
+	match, err = filter.MatchAny([][]byte{[]byte("mallory"), []byte("trent")})
+	require.NoError(t, err)
+	require.False(t, match)
+
+	// the same items under another key give another filter
+	other, err := blockfilter.NewFilter(blockfilter.BasicP, blockfilter.BasicM, [blockfilter.KeySize]byte{9}, items)
+	require.NoError(t, err)
+	require.NotEqual(t, filter.Bytes(), other.Bytes())
+
+	decoded, err := blockfilter.FromBytes(blockfilter.BasicP, blockfilter.BasicM, key, filter.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, filter.N(), decoded.N())
+	require.Equal(t, filter.Hash(), decoded.Hash())
+
+	match, err = decoded.Match([]byte("alice"))
+	require.NoError(t, err)
+	require.True(t, match)
+}
+
+func TestEmptyFilter(t *testing.T) {
+	filter, err := blockfilter.NewFilter(blockfilter.BasicP, blockfilter.BasicM, [blockfilter.KeySize]byte{}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x00}, filter.Bytes())
+
+	match, err := filter.Match([]byte("anything"))
+	require.NoError(t, err)
+	require.False(t, match)
+}
+
+func TestTruncatedFilter(t *testing.T) {
+	key := [blockfilter.KeySize]byte{1}
+	items := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+
+	filter, err := blockfilter.NewFilter(blockfilter.BasicP, blockfilter.BasicM, key, items)
+	require.NoError(t, err)
+
+	truncated, err := blockfilter.FromBytes(blockfilter.BasicP, blockfilter.BasicM, key, filter.Bytes()[:3])
+	require.NoError(t, err)
+
+	_, err = truncated.Match([]byte("not there"))
+	require.ErrorIs(t, err, blockfilter.ErrInvalidFilter)
+
+	_, err = blockfilter.FromBytes(blockfilter.BasicP, blockfilter.BasicM, key, nil)
+	require.ErrorIs(t, err, blockfilter.ErrInvalidFilter)
+}
+
+func TestBasicFilterItems(t *testing.T) {
+	block := genesisBlock(t, &chaincfg.MainNet)
+	block.Transactions = append(block.Transactions, &messages.Tx{
+		Version: 1,
+		TxIn:    []messages.TxIn{{SignatureScript: []byte{}}},
+		TxOut: []messages.TxOut{
+			{Value: 1, PkScript: []byte{0x00, 0x14, 0xaa}},
+			{Value: 0, PkScript: []byte{0x6a, 0x04, 0xde, 0xad}},
+			{Value: 0, PkScript: []byte{}},
+		},
+	})
+
+	spent := []byte{0x76, 0xa9, 0x14, 0xbb}
+	filter, err := blockfilter.NewBasicFilter(block, [][]byte{spent, {}})
+	require.NoError(t, err)
+
+	// the coinbase output, the segwit output and the spent script
+	require.Equal(t, uint32(3), filter.N())
+
+	cases := []struct {
+		script []byte
+		match  bool
+	}{
+		{script: block.Transactions[0].TxOut[0].PkScript, match: true},
+		{script: []byte{0x00, 0x14, 0xaa}, match: true},
+		{script: spent, match: true},
+		{script: []byte{0x6a, 0x04, 0xde, 0xad}, match: false},
+	}
+
+	for _, tt := range cases {
+		match, err := filter.Match(tt.script)
+		require.NoError(t, err)
+		require.Equal(t, tt.match, match, "%x", tt.script)
+	}
+}
+
+// blocks modelled on the BIP158 vectors that spend outputs or have empty and
+// op_return outputs, the filters and headers come from btcutil's gcs builder
+// check: https://github.com/bitcoin/bips/blob/master/bip-0158/testnet-19.json
+func TestBasicFilterSpendVectors(t *testing.T) {
+	const header = "000000207f6a4d1c2b8e0e4f3a5b0d8cead0f4d8e1460a0f2b4cd9b78a5dd9b8a3d8b5f6" +
+		"000000000000000000000000000000000000000000000000000000000000000000096e88ffff7f200700000002"
+
+	vectors := []struct {
+		name        string
+		txs         string
+		prevScripts []string
+		filter      string
+		header      string
+	}{
+		{
+			name: "spends a p2pkh and a p2wpkh output",
+			txs: "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff020101ffffffff" +
+				"0200f2052a01000000160014751e76e8199196d454941c45d1b3a323f1433bd600f2052a01000000266a24aa21a9ed" +
+				"e2f61c3f71d1defd3fa999dfa36953755c690689799962b48bebd836974e8cf900000000" +
+				"02000000027f6a4d1c2b8e0e4f3a5b0d8cead0f4d8e1460a0f2b4cd9b78a5dd9b8a3d8b5f6000000000151ffffffff" +
+				"7f6a4d1c2b8e0e4f3a5b0d8cead0f4d8e1460a0f2b4cd9b78a5dd9b8a3d8b5f6010000000151ffffffff02e803000000" +
+				"00000017a914f18b8b6c9c4cf3d2bbf4da6c8f2a6f0b6d1c7b2e87d0070000000000001976a9143ebc40e411ed3c76f8" +
+				"6711507ab952300890397288ac00000000",
+			prevScripts: []string{"76a9143ebc40e411ed3c76f86711507ab952300890397288ac", "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+			filter:      "038359a63bf4c26b6a",
+			header:      "afafe8f92bf30b1153df782c0b9ed64eccbb2949e048586c2d43df4fb2afb432",
+		},
+		{
+			name: "empty and op_return outputs",
+			txs: "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff020102ffffffff" +
+				"0200f2052a010000000000f2052a01000000266a24aa21a9ede2f61c3f71d1defd3fa999dfa36953755c690689799962b4" +
+				"8bebd836974e8cf900000000" +
+				"02000000017f6a4d1c2b8e0e4f3a5b0d8cead0f4d8e1460a0f2b4cd9b78a5dd9b8a3d8b5f6020000000151ffffffff03" +
+				"e80300000000000000d0070000000000000d6a0b68656c6c6f20776f726c64b80b00000000000017a914f18b8b6c9c4c" +
+				"f3d2bbf4da6c8f2a6f0b6d1c7b2e8700000000",
+			prevScripts: []string{"a914f18b8b6c9c4cf3d2bbf4da6c8f2a6f0b6d1c7b2e87"},
+			filter:      "01861cf0",
+			header:      "a22347745754fa928a0ee2a00c649fac534c449e24857835813dffa58bda1214",
+		},
+		{
+			name: "spends an empty output script",
+			txs: "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff020103ffffffff" +
+				"0100f2052a01000000266a24aa21a9ede2f61c3f71d1defd3fa999dfa36953755c690689799962b48bebd836974e8cf9" +
+				"00000000" +
+				"02000000017f6a4d1c2b8e0e4f3a5b0d8cead0f4d8e1460a0f2b4cd9b78a5dd9b8a3d8b5f6030000000151ffffffff01" +
+				"e803000000000000266a24aa21a9ede2f61c3f71d1defd3fa999dfa36953755c690689799962b48bebd836974e8cf9" +
+				"00000000",
+			prevScripts: []string{""},
+			filter:      "00",
+			header:      "eb4754925f0836cf93c417cb190a06499b4b556fba7409a8d1ba2effbed32d34",
+		},
+	}
+
+	prevHeader := messages.Hash{}
+	for _, tt := range vectors {
+		enc, err := hex.DecodeString(header + tt.txs)
+		require.NoError(t, err, tt.name)
+
+		block := new(messages.Block)
+		require.NoError(t, block.Decode(bytes.NewReader(enc)), tt.name)
+
+		prevScripts := make([][]byte, len(tt.prevScripts))
+		for idx, script := range tt.prevScripts {
+			prevScripts[idx], err = hex.DecodeString(script)
+			require.NoError(t, err, tt.name)
+		}
+
+		filter, err := blockfilter.NewBasicFilter(block, prevScripts)
+		require.NoError(t, err, tt.name)
+		require.Equal(t, tt.filter, hex.EncodeToString(filter.Bytes()), tt.name)
+
+		prevHeader = blockfilter.FilterHeader(filter.Hash(), prevHeader)
+		require.Equal(t, tt.header, prevHeader.String(), tt.name)
+	}
+}
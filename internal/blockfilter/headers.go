@@ -0,0 +1,237 @@
+package blockfilter
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+var (
+	ErrUnknownBlock          = errors.New("block is not in the best chain")
+	ErrUnsupportedFilterType = errors.New("unsupported filter type")
+	ErrFilterHeadersGap      = errors.New("filter headers do not connect")
+	ErrFilterHeaderMismatch  = errors.New("filter header does not match")
+	ErrFilterMismatch        = errors.New("filter does not match its header")
+)
+
+// Blocks gives the block headers of the best chain, e.g a *headerchain.Chain
+type Blocks interface {
+	Tip() (messages.Hash, int32)
+	Header(hash messages.Hash) (messages.BlockHeader, int32, bool)
+	HeaderAt(height int32) (messages.BlockHeader, bool)
+}
+
+// HeaderChain keeps the basic filter header of every block of the best
+// chain, a filter is only trusted once it matches its header. Peers can
+// lie about the headers, so checkpoints (usually taken from another peer)
+// make conflicting headers fail instead of silently being used
+type HeaderChain struct {
+	blocks Blocks
+
+	mu sync.Mutex
+	// headers[h] is the filter header of the block blockHashes[h]
+	headers     []messages.Hash
+	blockHashes []messages.Hash
+	// checkpoints[i] is the filter header at (i+1)*CFCheckptInterval
+	checkpoints []messages.Hash
+}
+
+// NewHeaderChain returns a chain without filter headers following the blocks
+func NewHeaderChain(blocks Blocks) *HeaderChain {
+	return &HeaderChain{blocks: blocks}
+}
+
+// Tip returns the height of the last filter header known, -1 if none
+func (c *HeaderChain) Tip() int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dropStale()
+	return int32(len(c.headers)) - 1
+}
+
+// Header returns the filter header of the block at the height
+func (c *HeaderChain) Header(height int32) (messages.Hash, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dropStale()
+	if height < 0 || int(height) >= len(c.headers) {
+		return messages.Hash{}, false
+	}
+	return c.headers[height], true
+}
+
+// SetCheckpoints keeps the checkpoints, they must agree with the known headers
+func (c *HeaderChain) SetCheckpoints(checkpt *messages.CFCheckpt) error {
+	if checkpt.FilterType != messages.FilterTypeBasic {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFilterType, checkpt.FilterType)
+	}
+
+	stop, err := c.height(checkpt.StopHash)
+	if err != nil {
+		return err
+	}
+
+	if expected := int(stop) / messages.CFCheckptInterval; len(checkpt.FilterHeaders) != expected {
+		return fmt.Errorf("%w: %d checkpoints up to height %d, expected %d",
+			ErrFilterHeaderMismatch, len(checkpt.FilterHeaders), stop, expected)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dropStale()
+	for idx, header := range checkpt.FilterHeaders {
+		height := (idx + 1) * messages.CFCheckptInterval
+		if height < len(c.headers) && c.headers[height] != header {
+			return fmt.Errorf("%w: checkpoint at height %d", ErrFilterHeaderMismatch, height)
+		}
+	}
+
+	c.checkpoints = checkpt.FilterHeaders
+	return nil
+}
+
+// AddHeaders rebuilds the filter headers of cfheaders, which must connect
+// to the known ones, and checks them against the known headers and checkpoints
+func (c *HeaderChain) AddHeaders(cfheaders *messages.CFHeaders) error {
+	if cfheaders.FilterType != messages.FilterTypeBasic {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFilterType, cfheaders.FilterType)
+	}
+
+	stop, err := c.height(cfheaders.StopHash)
+	if err != nil {
+		return err
+	}
+
+	start := int(stop) - len(cfheaders.FilterHashes) + 1
+	if start < 0 {
+		return fmt.Errorf("%w: %d filter hashes up to height %d", ErrFilterHeadersGap, len(cfheaders.FilterHashes), stop)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dropStale()
+	if start > len(c.headers) {
+		return fmt.Errorf("%w: starting at height %d, the next one is %d", ErrFilterHeadersGap, start, len(c.headers))
+	}
+
+	if prev := c.headerBefore(start); prev != cfheaders.PrevFilterHeader {
+		return fmt.Errorf("%w: previous header %s, expected %s", ErrFilterHeaderMismatch, cfheaders.PrevFilterHeader, prev)
+	}
+
+	headers := make([]messages.Hash, len(cfheaders.FilterHashes))
+	prev := cfheaders.PrevFilterHeader
+	for idx, filterHash := range cfheaders.FilterHashes {
+		headers[idx] = FilterHeader(filterHash, prev)
+		prev = headers[idx]
+
+		height := start + idx
+		if height < len(c.headers) && c.headers[height] != headers[idx] {
+			return fmt.Errorf("%w: at height %d", ErrFilterHeaderMismatch, height)
+		}
+
+		if checkpoint, ok := c.checkpoint(height); ok && checkpoint != headers[idx] {
+			return fmt.Errorf("%w: checkpoint at height %d", ErrFilterHeaderMismatch, height)
+		}
+	}
+
+	for idx := len(c.headers) - start; idx < len(headers); idx++ {
+		block, ok := c.blocks.HeaderAt(int32(start + idx))
+		if !ok {
+			return fmt.Errorf("%w: at height %d", ErrUnknownBlock, start+idx)
+		}
+
+		c.headers = append(c.headers, headers[idx])
+		c.blockHashes = append(c.blockHashes, block.BlockHash())
+	}
+	return nil
+}
+
+// CheckFilter parses the filter of cfilter once it matches its filter
+// header, the height of the block is returned with it
+func (c *HeaderChain) CheckFilter(cfilter *messages.CFilter) (*Filter, int32, error) {
+	if cfilter.FilterType != messages.FilterTypeBasic {
+		return nil, 0, fmt.Errorf("%w: %s", ErrUnsupportedFilterType, cfilter.FilterType)
+	}
+
+	height, err := c.height(cfilter.BlockHash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dropStale()
+	if int(height) >= len(c.headers) {
+		return nil, 0, fmt.Errorf("%w: no filter header at height %d", ErrFilterMismatch, height)
+	}
+
+	filterHash := messages.DoubleSHA256(cfilter.Filter)
+	if FilterHeader(filterHash, c.headerBefore(int(height))) != c.headers[height] {
+		return nil, 0, fmt.Errorf("%w: block %s at height %d", ErrFilterMismatch, cfilter.BlockHash, height)
+	}
+
+	filter, err := BasicFilterFromBytes(cfilter.BlockHash, cfilter.Filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	return filter, height, nil
+}
+
+// height returns the height of the block, which must be in the best chain
+func (c *HeaderChain) height(hash messages.Hash) (int32, error) {
+	_, height, ok := c.blocks.Header(hash)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownBlock, hash)
+	}
+
+	if best, ok := c.blocks.HeaderAt(height); !ok || best.BlockHash() != hash {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownBlock, hash)
+	}
+	return height, nil
+}
+
+// headerBefore is the filter header before the height, zero for the genesis
+func (c *HeaderChain) headerBefore(height int) messages.Hash {
+	if height == 0 {
+		return messages.Hash{}
+	}
+	return c.headers[height-1]
+}
+
+func (c *HeaderChain) checkpoint(height int) (messages.Hash, bool) {
+	idx := height/messages.CFCheckptInterval - 1
+	if height%messages.CFCheckptInterval != 0 || idx < 0 || idx >= len(c.checkpoints) {
+		return messages.Hash{}, false
+	}
+	return c.checkpoints[idx], true
+}
+
+// dropStale forgets the filter headers of blocks a reorg took out of the
+// best chain, along with the checkpoints after the fork
+func (c *HeaderChain) dropStale() {
+	valid := len(c.headers)
+	for valid > 0 {
+		block, ok := c.blocks.HeaderAt(int32(valid - 1))
+		if ok && block.BlockHash() == c.blockHashes[valid-1] {
+			break
+		}
+		valid--
+	}
+
+	if valid == len(c.headers) {
+		return
+	}
+
+	c.headers = c.headers[:valid]
+	c.blockHashes = c.blockHashes[:valid]
+	if keep := max(valid-1, 0) / messages.CFCheckptInterval; keep < len(c.checkpoints) {
+		c.checkpoints = c.checkpoints[:keep]
+	}
+}
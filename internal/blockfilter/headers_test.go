@@ -0,0 +1,238 @@
+package blockfilter_test
+
+import (
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/blockfilter"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBlocks is a best chain of headers, proof of work is not needed here
+type fakeBlocks struct {
+	headers []messages.BlockHeader
+	heights map[messages.Hash]int32
+}
+
+// newFakeBlocks returns a chain of size blocks, the salt tells apart branches
+func newFakeBlocks(size int, salt uint32) *fakeBlocks {
+	blocks := &fakeBlocks{heights: make(map[messages.Hash]int32)}
+	blocks.extend(size, salt)
+	return blocks
+}
+
+func (f *fakeBlocks) extend(size int, salt uint32) {
+	for idx := 0; idx < size; idx++ {
+		header := messages.BlockHeader{Version: 1, Nonce: uint32(len(f.headers)), Bits: salt}
+		if len(f.headers) > 0 {
+			header.PrevBlock = f.headers[len(f.headers)-1].BlockHash()
+		}
+		f.headers = append(f.headers, header)
+		f.heights[header.BlockHash()] = int32(len(f.headers) - 1)
+	}
+}
+
+// reorg replaces the blocks after the height by size blocks of another branch
+func (f *fakeBlocks) reorg(height int, size int, salt uint32) {
+	for _, header := range f.headers[height+1:] {
+		delete(f.heights, header.BlockHash())
+	}
+	f.headers = f.headers[:height+1]
+	f.extend(size, salt)
+}
+
+func (f *fakeBlocks) Tip() (messages.Hash, int32) {
+	return f.headers[len(f.headers)-1].BlockHash(), int32(len(f.headers) - 1)
+}
+
+func (f *fakeBlocks) Header(hash messages.Hash) (messages.BlockHeader, int32, bool) {
+	height, ok := f.heights[hash]
+	if !ok {
+		return messages.BlockHeader{}, 0, false
+	}
+	return f.headers[height], height, true
+}
+
+func (f *fakeBlocks) HeaderAt(height int32) (messages.BlockHeader, bool) {
+	if height < 0 || int(height) >= len(f.headers) {
+		return messages.BlockHeader{}, false
+	}
+	return f.headers[height], true
+}
+
+// fullNode serves the filters of the blocks, as a peer would
+type fullNode struct {
+	blocks  *fakeBlocks
+	filters [][]byte
+	headers []messages.Hash
+}
+
+func newFullNode(t *testing.T, blocks *fakeBlocks) *fullNode {
+	node := &fullNode{blocks: blocks}
+
+	var prev messages.Hash
+	for height, header := range blocks.headers {
+		block := &messages.Block{Header: header, Transactions: []*messages.Tx{{
+			Version: 1,
+			TxOut:   []messages.TxOut{{Value: 1, PkScript: scriptAt(height)}},
+		}}}
+
+		filter, err := blockfilter.NewBasicFilter(block, nil)
+		require.NoError(t, err)
+
+		prev = blockfilter.FilterHeader(filter.Hash(), prev)
+		node.filters = append(node.filters, filter.Bytes())
+		node.headers = append(node.headers, prev)
+	}
+	return node
+}
+
+// scriptAt is the single output script of the block at the height
+func scriptAt(height int) []byte {
+	return []byte{0x51, byte(height), byte(height >> 8)}
+}
+
+func (n *fullNode) height(hash messages.Hash) int {
+	_, height, ok := n.blocks.Header(hash)
+	if !ok {
+		panic("unknown block")
+	}
+	return int(height)
+}
+
+func (n *fullNode) cfheaders(start, stop int) *messages.CFHeaders {
+	cfheaders := &messages.CFHeaders{StopHash: n.blocks.headers[stop].BlockHash()}
+	if start > 0 {
+		cfheaders.PrevFilterHeader = n.headers[start-1]
+	}
+	for height := start; height <= stop; height++ {
+		cfheaders.FilterHashes = append(cfheaders.FilterHashes, messages.DoubleSHA256(n.filters[height]))
+	}
+	return cfheaders
+}
+
+func (n *fullNode) cfcheckpt(stop int) *messages.CFCheckpt {
+	checkpt := &messages.CFCheckpt{StopHash: n.blocks.headers[stop].BlockHash()}
+	for height := messages.CFCheckptInterval; height <= stop; height += messages.CFCheckptInterval {
+		checkpt.FilterHeaders = append(checkpt.FilterHeaders, n.headers[height])
+	}
+	return checkpt
+}
+
+func (n *fullNode) cfilter(height int) *messages.CFilter {
+	return &messages.CFilter{BlockHash: n.blocks.headers[height].BlockHash(), Filter: n.filters[height]}
+}
+
+func TestHeaderChainAddHeaders(t *testing.T) {
+	blocks := newFakeBlocks(2500, 0)
+	node := newFullNode(t, blocks)
+	chain := blockfilter.NewHeaderChain(blocks)
+	require.Equal(t, int32(-1), chain.Tip())
+
+	require.NoError(t, chain.SetCheckpoints(node.cfcheckpt(2499)))
+
+	// headers after the tip do not connect
+	require.ErrorIs(t, chain.AddHeaders(node.cfheaders(1, 10)), blockfilter.ErrFilterHeadersGap)
+
+	require.NoError(t, chain.AddHeaders(node.cfheaders(0, 1999)))
+	require.Equal(t, int32(1999), chain.Tip())
+
+	// overlapping headers are fine as long as they agree
+	require.NoError(t, chain.AddHeaders(node.cfheaders(1500, 2499)))
+	require.Equal(t, int32(2499), chain.Tip())
+
+	for _, height := range []int32{0, 1000, 2499} {
+		header, ok := chain.Header(height)
+		require.True(t, ok)
+		require.Equal(t, node.headers[height], header)
+	}
+
+	_, ok := chain.Header(2500)
+	require.False(t, ok)
+}
+
+func TestHeaderChainRejectsConflicts(t *testing.T) {
+	blocks := newFakeBlocks(1200, 0)
+	node := newFullNode(t, blocks)
+
+	// a previous header that is not ours
+	chain := blockfilter.NewHeaderChain(blocks)
+	require.NoError(t, chain.AddHeaders(node.cfheaders(0, 99)))
+
+	cfheaders := node.cfheaders(100, 199)
+	cfheaders.PrevFilterHeader = messages.Hash{1}
+	require.ErrorIs(t, chain.AddHeaders(cfheaders), blockfilter.ErrFilterHeaderMismatch)
+
+	// a filter hash disagreeing with a known header
+	cfheaders = node.cfheaders(50, 150)
+	cfheaders.FilterHashes[10] = messages.Hash{2}
+	require.ErrorIs(t, chain.AddHeaders(cfheaders), blockfilter.ErrFilterHeaderMismatch)
+
+	// a filter hash disagreeing with the checkpoint at 1000
+	cfheaders = node.cfheaders(100, 1199)
+	cfheaders.FilterHashes[900] = messages.Hash{3}
+	chain = blockfilter.NewHeaderChain(blocks)
+	require.NoError(t, chain.SetCheckpoints(node.cfcheckpt(1199)))
+	require.NoError(t, chain.AddHeaders(node.cfheaders(0, 99)))
+	require.ErrorIs(t, chain.AddHeaders(cfheaders), blockfilter.ErrFilterHeaderMismatch)
+
+	// checkpoints disagreeing with the known headers
+	checkpt := node.cfcheckpt(1199)
+	checkpt.FilterHeaders[0] = messages.Hash{4}
+	chain = blockfilter.NewHeaderChain(blocks)
+	require.NoError(t, chain.AddHeaders(node.cfheaders(0, 1199)))
+	require.ErrorIs(t, chain.SetCheckpoints(checkpt), blockfilter.ErrFilterHeaderMismatch)
+
+	// blocks out of the best chain
+	cfheaders = node.cfheaders(0, 10)
+	cfheaders.StopHash = messages.Hash{5}
+	require.ErrorIs(t, chain.AddHeaders(cfheaders), blockfilter.ErrUnknownBlock)
+}
+
+func TestHeaderChainCheckFilter(t *testing.T) {
+	blocks := newFakeBlocks(10, 0)
+	node := newFullNode(t, blocks)
+	chain := blockfilter.NewHeaderChain(blocks)
+
+	_, _, err := chain.CheckFilter(node.cfilter(3))
+	require.ErrorIs(t, err, blockfilter.ErrFilterMismatch)
+
+	require.NoError(t, chain.AddHeaders(node.cfheaders(0, 9)))
+
+	filter, height, err := chain.CheckFilter(node.cfilter(3))
+	require.NoError(t, err)
+	require.Equal(t, int32(3), height)
+
+	match, err := filter.Match(scriptAt(3))
+	require.NoError(t, err)
+	require.True(t, match)
+
+	// the filter of another block
+	cfilter := node.cfilter(3)
+	cfilter.Filter = node.filters[4]
+	_, _, err = chain.CheckFilter(cfilter)
+	require.ErrorIs(t, err, blockfilter.ErrFilterMismatch)
+
+	cfilter.FilterType = 1
+	_, _, err = chain.CheckFilter(cfilter)
+	require.ErrorIs(t, err, blockfilter.ErrUnsupportedFilterType)
+}
+
+func TestHeaderChainReorg(t *testing.T) {
+	blocks := newFakeBlocks(20, 0)
+	node := newFullNode(t, blocks)
+	chain := blockfilter.NewHeaderChain(blocks)
+	require.NoError(t, chain.AddHeaders(node.cfheaders(0, 19)))
+
+	// the last 5 blocks are replaced by another branch
+	blocks.reorg(14, 8, 1)
+	require.Equal(t, int32(14), chain.Tip())
+
+	node = newFullNode(t, blocks)
+	require.NoError(t, chain.AddHeaders(node.cfheaders(15, 22)))
+	require.Equal(t, int32(22), chain.Tip())
+
+	header, ok := chain.Header(22)
+	require.True(t, ok)
+	require.Equal(t, node.headers[22], header)
+}
@@ -0,0 +1,291 @@
+package blockfilter
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+var ErrUnexpectedFilter = errors.New("unexpected filter message")
+
+// Sender sends messages to a peer, e.g a *network.Stream
+type Sender interface {
+	SendMessage(command string, payload codec.Encodeable) error
+}
+
+// FilterHandler receives the filters downloaded, in height order
+type FilterHandler func(blockHash messages.Hash, height int32, filter *Filter)
+
+// Syncer downloads the filter headers of a peer up to the block tip,
+// starting with its checkpoints, and then the filters of the blocks
+// from the scan height, which are handed over once checked
+type Syncer struct {
+	filters  *HeaderChain
+	blocks   Blocks
+	peer     Sender
+	onFilter FilterHandler
+
+	// the requests in flight, only one of each at a time
+	checkpointsPending bool
+	headersPending     bool
+	// checkpointsTo is the height of the last checkpoints received
+	checkpointsTo int32
+	// nextFilter is the next filter to be received, -1 when not scanning,
+	// the filters up to filtersTo, whose block is filtersStop, were asked
+	nextFilter  int32
+	filtersTo   int32
+	filtersStop messages.Hash
+	// scanned[i] is the block of the filter handed over at scanFrom+i, a
+	// reorg taking them out of the best chain rewinds the scan to the fork
+	scanFrom int32
+	scanned  []messages.Hash
+}
+
+// NewSyncer syncs the filter headers of the blocks from the peer, the
+// filters from the height scanFrom onwards are given to onFilter, a
+// negative scanFrom only syncs the headers
+func NewSyncer(filters *HeaderChain, peer Sender, scanFrom int32, onFilter FilterHandler) *Syncer {
+	if onFilter == nil {
+		scanFrom = -1
+	}
+
+	return &Syncer{
+		filters:       filters,
+		blocks:        filters.blocks,
+		peer:          peer,
+		onFilter:      onFilter,
+		checkpointsTo: -1,
+		nextFilter:    scanFrom,
+		filtersTo:     scanFrom - 1,
+		scanFrom:      scanFrom,
+	}
+}
+
+// Start asks the peer for its checkpoints up to our block tip,
+// the filter headers are then asked up to the block tip
+func (s *Syncer) Start() error {
+	return s.Sync()
+}
+
+// Sync asks what is missing to follow the block tip, it should be called
+// whenever the block tip changes. Whenever the tip passes a checkpoint
+// the checkpoints are asked again before any other filter header
+func (s *Syncer) Sync() error {
+	if s.checkpointsPending {
+		return nil
+	}
+
+	_, tip := s.blocks.Tip()
+	if s.checkpointsTo < 0 || tip/messages.CFCheckptInterval > s.checkpointsTo/messages.CFCheckptInterval {
+		return s.requestCheckpoints()
+	}
+
+	if err := s.requestHeaders(); err != nil {
+		return err
+	}
+
+	s.rewind()
+	return s.requestFilters()
+}
+
+// HandleMessage handles the filter messages, it returns true if the
+// message was one of them. An error means the peer sent invalid
+// filters or headers and should be disconnected
+func (s *Syncer) HandleMessage(msg *messages.Message) (bool, error) {
+	switch payload := msg.Payload.(type) {
+	case *messages.CFCheckpt:
+		return true, s.handleCheckpoints(payload)
+	case *messages.CFHeaders:
+		return true, s.handleHeaders(payload)
+	case *messages.CFilter:
+		return true, s.handleFilter(payload)
+	default:
+		return false, nil
+	}
+}
+
+func (s *Syncer) handleCheckpoints(checkpt *messages.CFCheckpt) error {
+	if !s.checkpointsPending {
+		return fmt.Errorf("%w: checkpoints were not requested", ErrUnexpectedFilter)
+	}
+	s.checkpointsPending = false
+
+	err := s.filters.SetCheckpoints(checkpt)
+	if errors.Is(err, ErrUnknownBlock) {
+		// a reorg happened since the request, ask again from the new tip
+		return s.Sync()
+	}
+	if err != nil {
+		return err
+	}
+
+	_, height, _ := s.blocks.Header(checkpt.StopHash)
+	s.checkpointsTo = height
+	return s.Sync()
+}
+
+func (s *Syncer) handleHeaders(cfheaders *messages.CFHeaders) error {
+	if !s.headersPending {
+		return fmt.Errorf("%w: filter headers were not requested", ErrUnexpectedFilter)
+	}
+	s.headersPending = false
+
+	err := s.filters.AddHeaders(cfheaders)
+	if errors.Is(err, ErrUnknownBlock) || errors.Is(err, ErrFilterHeadersGap) {
+		// a reorg happened since the request, ask again from the new tip
+		return s.Sync()
+	}
+	if err != nil {
+		return err
+	}
+	return s.Sync()
+}
+
+func (s *Syncer) handleFilter(cfilter *messages.CFilter) error {
+	if s.nextFilter < 0 {
+		return fmt.Errorf("%w: block %s was not requested", ErrUnexpectedFilter, cfilter.BlockHash)
+	}
+
+	filter, height, err := s.filters.CheckFilter(cfilter)
+	if errors.Is(err, ErrUnknownBlock) {
+		// asked before a reorg, the new blocks are asked by the next sync
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// the blocks of the filters already handed over may be gone
+	if s.rewind() {
+		if err := s.requestFilters(); err != nil {
+			return err
+		}
+	}
+
+	// filters asked again after a reorg that were already received
+	if height < s.nextFilter {
+		return nil
+	}
+
+	if height != s.nextFilter || height > s.filtersTo {
+		return fmt.Errorf("%w: got height %d, expected %d", ErrUnexpectedFilter, height, s.nextFilter)
+	}
+
+	s.nextFilter++
+	s.scanned = append(s.scanned, cfilter.BlockHash)
+	s.onFilter(cfilter.BlockHash, height, filter)
+
+	if s.nextFilter > s.filtersTo {
+		return s.requestFilters()
+	}
+	return nil
+}
+
+// rewind moves the scan back to the fork when a reorg took the blocks of
+// filters already handed over out of the best chain, so the filters of the
+// new branch are asked and handed over too, it returns true if it did
+func (s *Syncer) rewind() bool {
+	valid := len(s.scanned)
+	for valid > 0 {
+		block, ok := s.blocks.HeaderAt(s.scanFrom + int32(valid-1))
+		if ok && block.BlockHash() == s.scanned[valid-1] {
+			break
+		}
+		valid--
+	}
+
+	if valid == len(s.scanned) {
+		return false
+	}
+
+	s.scanned = s.scanned[:valid]
+	s.nextFilter = s.scanFrom + int32(valid)
+	s.filtersTo = s.nextFilter - 1
+	s.filtersStop = messages.Hash{}
+	return true
+}
+
+func (s *Syncer) requestCheckpoints() error {
+	tip, _ := s.blocks.Tip()
+	err := s.peer.SendMessage(messages.CmdGetCFCheckpt, &messages.GetCFCheckpt{
+		FilterType: messages.FilterTypeBasic,
+		StopHash:   tip,
+	})
+	if err != nil {
+		return fmt.Errorf("while requesting filter checkpoints: %w", err)
+	}
+
+	s.checkpointsPending = true
+	return nil
+}
+
+// requestHeaders asks the next filter headers up to the block tip
+func (s *Syncer) requestHeaders() error {
+	if s.headersPending {
+		return nil
+	}
+
+	_, blockTip := s.blocks.Tip()
+	start := s.filters.Tip() + 1
+	if start > blockTip {
+		return nil
+	}
+
+	stop := min(blockTip, start+messages.MaxCFHeadersResults-1)
+	stopHeader, ok := s.blocks.HeaderAt(stop)
+	if !ok {
+		return nil
+	}
+
+	err := s.peer.SendMessage(messages.CmdGetCFHeaders, &messages.GetCFHeaders{
+		FilterType:  messages.FilterTypeBasic,
+		StartHeight: uint32(start),
+		StopHash:    stopHeader.BlockHash(),
+	})
+	if err != nil {
+		return fmt.Errorf("while requesting filter headers: %w", err)
+	}
+
+	s.headersPending = true
+	return nil
+}
+
+// requestFilters asks the next filters whose header is known
+func (s *Syncer) requestFilters() error {
+	if s.nextFilter < 0 {
+		return nil
+	}
+
+	// a request in flight is only waited for while its blocks are in the best chain
+	if s.nextFilter <= s.filtersTo {
+		if stop, ok := s.blocks.HeaderAt(s.filtersTo); ok && stop.BlockHash() == s.filtersStop {
+			return nil
+		}
+	}
+
+	start := s.nextFilter
+	stop := min(s.filters.Tip(), start+messages.MaxGetCFiltersSize-1)
+	if start > stop {
+		return nil
+	}
+
+	stopHeader, ok := s.blocks.HeaderAt(stop)
+	if !ok {
+		return nil
+	}
+
+	err := s.peer.SendMessage(messages.CmdGetCFilters, &messages.GetCFilters{
+		FilterType:  messages.FilterTypeBasic,
+		StartHeight: uint32(start),
+		StopHash:    stopHeader.BlockHash(),
+	})
+	if err != nil {
+		return fmt.Errorf("while requesting filters: %w", err)
+	}
+
+	s.filtersTo = stop
+	s.filtersStop = stopHeader.BlockHash()
+	return nil
+}
@@ -0,0 +1,209 @@
+package blockfilter_test
+
+import (
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/blockfilter"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePeer answers every request the syncer sends with the full node
+type fakePeer struct {
+	node    *fullNode
+	pending []*messages.Message
+	sent    []string
+}
+
+func (p *fakePeer) SendMessage(command string, payload codec.Encodeable) error {
+	p.sent = append(p.sent, command)
+
+	answer := func(command string, payload codec.Encodeable) {
+		p.pending = append(p.pending, messages.NewMainMessage([]byte(command), payload))
+	}
+
+	switch request := payload.(type) {
+	case *messages.GetCFCheckpt:
+		answer(messages.CmdCFCheckpt, p.node.cfcheckpt(p.node.height(request.StopHash)))
+	case *messages.GetCFHeaders:
+		answer(messages.CmdCFHeaders, p.node.cfheaders(int(request.StartHeight), p.node.height(request.StopHash)))
+	case *messages.GetCFilters:
+		for height := int(request.StartHeight); height <= p.node.height(request.StopHash); height++ {
+			answer(messages.CmdCFilter, p.node.cfilter(height))
+		}
+	}
+	return nil
+}
+
+// deliver gives the syncer the answers until there are none left
+func (p *fakePeer) deliver(t *testing.T, syncer *blockfilter.Syncer) {
+	for len(p.pending) > 0 {
+		msg := p.pending[0]
+		p.pending = p.pending[1:]
+
+		handled, err := syncer.HandleMessage(msg)
+		require.NoError(t, err)
+		require.True(t, handled)
+	}
+}
+
+func count(commands []string, command string) int {
+	total := 0
+	for _, c := range commands {
+		if c == command {
+			total++
+		}
+	}
+	return total
+}
+
+func TestSyncerHeadersAndFilters(t *testing.T) {
+	blocks := newFakeBlocks(2600, 0)
+	peer := &fakePeer{node: newFullNode(t, blocks)}
+	chain := blockfilter.NewHeaderChain(blocks)
+
+	var scanned []int32
+	matches := 0
+	syncer := blockfilter.NewSyncer(chain, peer, 1500, func(hash messages.Hash, height int32, filter *blockfilter.Filter) {
+		require.Equal(t, blocks.headers[height].BlockHash(), hash)
+		scanned = append(scanned, height)
+
+		match, err := filter.Match(scriptAt(2222))
+		require.NoError(t, err)
+		if match {
+			matches++
+		}
+	})
+
+	require.NoError(t, syncer.Start())
+	peer.deliver(t, syncer)
+
+	require.Equal(t, int32(2599), chain.Tip())
+	require.Equal(t, 1, count(peer.sent, messages.CmdGetCFCheckpt))
+	require.Equal(t, 2, count(peer.sent, messages.CmdGetCFHeaders))
+	require.Equal(t, 2, count(peer.sent, messages.CmdGetCFilters))
+
+	require.Len(t, scanned, 1100)
+	for idx, height := range scanned {
+		require.Equal(t, int32(1500+idx), height)
+	}
+	require.Equal(t, 1, matches)
+
+	// new blocks are synced once the tip moves
+	blocks.extend(3, 0)
+	peer.node = newFullNode(t, blocks)
+	require.NoError(t, syncer.Sync())
+	peer.deliver(t, syncer)
+
+	require.Equal(t, int32(2602), chain.Tip())
+	require.Len(t, scanned, 1103)
+}
+
+func TestSyncerRescansAfterReorg(t *testing.T) {
+	blocks := newFakeBlocks(30, 0)
+	peer := &fakePeer{node: newFullNode(t, blocks)}
+	chain := blockfilter.NewHeaderChain(blocks)
+
+	scanned := make(map[int32]messages.Hash)
+	var heights []int32
+	syncer := blockfilter.NewSyncer(chain, peer, 10, func(hash messages.Hash, height int32, filter *blockfilter.Filter) {
+		require.Equal(t, blocks.headers[height].BlockHash(), hash)
+		scanned[height] = hash
+		heights = append(heights, height)
+	})
+
+	require.NoError(t, syncer.Start())
+	peer.deliver(t, syncer)
+	require.Len(t, heights, 20)
+
+	// the blocks after 19 are replaced by a longer branch
+	blocks.reorg(19, 15, 1)
+	peer.node = newFullNode(t, blocks)
+	require.NoError(t, syncer.Sync())
+	peer.deliver(t, syncer)
+
+	require.Len(t, heights, 35)
+	for idx, height := range heights[20:] {
+		require.Equal(t, int32(20+idx), height)
+	}
+	for height := int32(10); height < 35; height++ {
+		require.Equal(t, blocks.headers[height].BlockHash(), scanned[height], "height %d", height)
+	}
+}
+
+func TestSyncerHeadersOnly(t *testing.T) {
+	blocks := newFakeBlocks(10, 0)
+	peer := &fakePeer{node: newFullNode(t, blocks)}
+	chain := blockfilter.NewHeaderChain(blocks)
+
+	syncer := blockfilter.NewSyncer(chain, peer, -1, nil)
+	require.NoError(t, syncer.Start())
+	peer.deliver(t, syncer)
+
+	require.Equal(t, int32(9), chain.Tip())
+	require.Zero(t, count(peer.sent, messages.CmdGetCFilters))
+
+	// filters that were not asked
+	_, err := syncer.HandleMessage(messages.NewMainMessage([]byte(messages.CmdCFilter), peer.node.cfilter(3)))
+	require.ErrorIs(t, err, blockfilter.ErrUnexpectedFilter)
+}
+
+func TestSyncerRejectsBadFilter(t *testing.T) {
+	blocks := newFakeBlocks(10, 0)
+	peer := &fakePeer{node: newFullNode(t, blocks)}
+	chain := blockfilter.NewHeaderChain(blocks)
+
+	syncer := blockfilter.NewSyncer(chain, peer, 0, func(messages.Hash, int32, *blockfilter.Filter) {})
+	require.NoError(t, syncer.Start())
+
+	// the peer lies about the first filter
+	for len(peer.pending) > 0 {
+		msg := peer.pending[0]
+		peer.pending = peer.pending[1:]
+
+		if cfilter, ok := msg.Payload.(*messages.CFilter); ok {
+			cfilter.Filter = []byte{0x00}
+			_, err := syncer.HandleMessage(msg)
+			require.ErrorIs(t, err, blockfilter.ErrFilterMismatch)
+			return
+		}
+
+		_, err := syncer.HandleMessage(msg)
+		require.NoError(t, err)
+	}
+	t.Fatal("no filter was requested")
+}
+
+func TestSyncerRefreshesCheckpoints(t *testing.T) {
+	blocks := newFakeBlocks(990, 0)
+	peer := &fakePeer{node: newFullNode(t, blocks)}
+	chain := blockfilter.NewHeaderChain(blocks)
+
+	syncer := blockfilter.NewSyncer(chain, peer, -1, nil)
+	require.NoError(t, syncer.Start())
+	peer.deliver(t, syncer)
+	require.Equal(t, 1, count(peer.sent, messages.CmdGetCFCheckpt))
+
+	// the tip does not pass a checkpoint yet
+	blocks.extend(5, 0)
+	peer.node = newFullNode(t, blocks)
+	require.NoError(t, syncer.Sync())
+	peer.deliver(t, syncer)
+	require.Equal(t, 1, count(peer.sent, messages.CmdGetCFCheckpt))
+
+	blocks.extend(20, 0)
+	peer.node = newFullNode(t, blocks)
+	require.NoError(t, syncer.Sync())
+	peer.deliver(t, syncer)
+	require.Equal(t, 2, count(peer.sent, messages.CmdGetCFCheckpt))
+	require.Equal(t, int32(1014), chain.Tip())
+
+	// checkpoints that were not asked
+	_, err := syncer.HandleMessage(messages.NewMainMessage([]byte(messages.CmdCFCheckpt), peer.node.cfcheckpt(1014)))
+	require.ErrorIs(t, err, blockfilter.ErrUnexpectedFilter)
+
+	// neither were these filter headers
+	_, err = syncer.HandleMessage(messages.NewMainMessage([]byte(messages.CmdCFHeaders), peer.node.cfheaders(1000, 1014)))
+	require.ErrorIs(t, err, blockfilter.ErrUnexpectedFilter)
+}
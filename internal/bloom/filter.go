@@ -0,0 +1,208 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/hashes"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+// hashSeedStep spreads the seeds of the hash functions, the seed
+// of the nth function is n*hashSeedStep + tweak
+const hashSeedStep = 0xfba4c795
+
+// script opcodes needed to find data pushes and outputs paying to public keys
+const (
+	opPushData1     = 0x4c
+	opPushData2     = 0x4d
+	opPushData4     = 0x4e
+	op1             = 0x51
+	op16            = 0x60
+	opCheckSig      = 0xac
+	opCheckMultiSig = 0xae
+)
+
+// Filter is a BIP37 bloom filter, loaded into a peer so it only relays
+// the transactions matching it. Elements are hashed by MurmurHash3 into
+// bits of the filter, false positives hide which elements we care about
+// check: https://github.com/bitcoin/bips/blob/master/bip-0037.mediawiki
+type Filter struct {
+	mu        sync.Mutex
+	data      []byte
+	hashFuncs uint32
+	tweak     uint32
+	flags     messages.BloomUpdate
+}
+
+// NewFilter returns an empty filter sized to hold the elements with
+// the false positive rate, within the limits peers accept
+func NewFilter(elements uint32, fpRate float64, tweak uint32, flags messages.BloomUpdate) *Filter {
+	elements = max(elements, 1)
+
+	bits := -1 / (math.Ln2 * math.Ln2) * float64(elements) * math.Log(fpRate)
+	size := uint32(min(max(bits, 8), messages.MaxBloomFilterSize*8)) / 8
+	hashFuncs := uint32(float64(size*8) / float64(elements) * math.Ln2)
+
+	return &Filter{
+		data:      make([]byte, size),
+		hashFuncs: min(max(hashFuncs, 1), messages.MaxBloomHashFuncs),
+		tweak:     tweak,
+		flags:     flags,
+	}
+}
+
+// Add inserts the element into the filter
+func (f *Filter) Add(data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.add(data)
+}
+
+// AddOutPoint inserts the outpoint, so transactions spending it match
+func (f *Filter) AddOutPoint(outpoint messages.OutPoint) {
+	f.Add(outPointBytes(outpoint))
+}
+
+// Contains tells if the element might be in the filter
+func (f *Filter) Contains(data []byte) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.contains(data)
+}
+
+// MatchTxAndUpdate tells if the transaction matches the filter, the
+// same way the peer does: its txid, a data push of its output scripts,
+// an outpoint it spends or a data push of its input scripts. Matching
+// outputs are added to the filter as the update flags say, so the
+// transactions spending them match as well
+func (f *Filter) MatchTxAndUpdate(tx *messages.Tx) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	txid := tx.TxHash()
+	matched := f.contains(txid[:])
+
+	for idx, out := range tx.TxOut {
+		for _, push := range dataPushes(out.PkScript) {
+			if !f.contains(push) {
+				continue
+			}
+
+			matched = true
+			if f.flags == messages.BloomUpdateAll ||
+				(f.flags == messages.BloomUpdateP2PubKeyOnly && paysToPubKey(out.PkScript)) {
+				f.add(outPointBytes(messages.OutPoint{Hash: txid, Index: uint32(idx)}))
+			}
+			break
+		}
+	}
+
+	if matched {
+		return true
+	}
+
+	for _, in := range tx.TxIn {
+		if f.contains(outPointBytes(in.PreviousOutPoint)) {
+			return true
+		}
+
+		for _, push := range dataPushes(in.SignatureScript) {
+			if f.contains(push) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FilterLoad is the filterload message loading the filter into a peer
+func (f *Filter) FilterLoad() *messages.FilterLoad {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return &messages.FilterLoad{
+		Filter:    append([]byte(nil), f.data...),
+		HashFuncs: f.hashFuncs,
+		Tweak:     f.tweak,
+		Flags:     f.flags,
+	}
+}
+
+func (f *Filter) add(data []byte) {
+	for n := uint32(0); n < f.hashFuncs; n++ {
+		bit := f.bit(n, data)
+		f.data[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+func (f *Filter) contains(data []byte) bool {
+	for n := uint32(0); n < f.hashFuncs; n++ {
+		bit := f.bit(n, data)
+		if f.data[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bit is the index of the bit set by the nth hash function
+func (f *Filter) bit(n uint32, data []byte) uint32 {
+	return hashes.MurmurHash3(n*hashSeedStep+f.tweak, data) % uint32(len(f.data)*8)
+}
+
+func outPointBytes(outpoint messages.OutPoint) []byte {
+	return binary.LittleEndian.AppendUint32(outpoint.Hash[:], outpoint.Index)
+}
+
+// dataPushes returns the data pushed by the script, a malformed
+// push ends the script as it does when the script is evaluated
+func dataPushes(script []byte) [][]byte {
+	var pushes [][]byte
+	for len(script) > 0 {
+		op := script[0]
+		script = script[1:]
+
+		var size int
+		switch {
+		case op < opPushData1:
+			size = int(op)
+		case op == opPushData1 && len(script) >= 1:
+			size, script = int(script[0]), script[1:]
+		case op == opPushData2 && len(script) >= 2:
+			size, script = int(binary.LittleEndian.Uint16(script)), script[2:]
+		case op == opPushData4 && len(script) >= 4:
+			size, script = int(binary.LittleEndian.Uint32(script)), script[4:]
+		case op >= opPushData1 && op <= opPushData4:
+			return pushes
+		default:
+			continue
+		}
+
+		if size > len(script) {
+			return pushes
+		}
+
+		if size > 0 {
+			pushes = append(pushes, script[:size])
+		}
+		script = script[size:]
+	}
+	return pushes
+}
+
+// paysToPubKey tells if the script pays to a public key (<pubkey> OP_CHECKSIG)
+// or to a bare multisig (OP_m <pubkeys> OP_n OP_CHECKMULTISIG)
+func paysToPubKey(script []byte) bool {
+	size := len(script)
+	if (size == 35 || size == 67) && int(script[0]) == size-2 && script[size-1] == opCheckSig {
+		return true
+	}
+
+	return size > 3 && script[size-1] == opCheckMultiSig &&
+		script[0] >= op1 && script[0] <= op16 &&
+		script[size-2] >= op1 && script[size-2] <= op16
+}
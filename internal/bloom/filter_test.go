@@ -0,0 +1,108 @@
+package bloom_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/bloom"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	decoded, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return decoded
+}
+
+// vectors of the bitcoin core bloom filter tests
+func TestFilterVectors(t *testing.T) {
+	cases := []struct {
+		tweak    uint32
+		expected string
+	}{
+		{tweak: 0, expected: "03614e9b050000000000000001"},
+		{tweak: 2147483649, expected: "03ce4299050000000100008001"},
+	}
+
+	for _, tt := range cases {
+		filter := bloom.NewFilter(3, 0.01, tt.tweak, messages.BloomUpdateAll)
+
+		filter.Add(decodeHex(t, "99108ad8ed9bb6274d3980bab5a85c048f0950c8"))
+		require.True(t, filter.Contains(decodeHex(t, "99108ad8ed9bb6274d3980bab5a85c048f0950c8")))
+		require.False(t, filter.Contains(decodeHex(t, "19108ad8ed9bb6274d3980bab5a85c048f0950c8")))
+
+		filter.Add(decodeHex(t, "b5a2c786d9ef4658287ced5914b37a1b4aa32eee"))
+		require.True(t, filter.Contains(decodeHex(t, "b5a2c786d9ef4658287ced5914b37a1b4aa32eee")))
+
+		filter.Add(decodeHex(t, "b9300670b4c5366e95b2699e8b18bc75e5f729c5"))
+		require.True(t, filter.Contains(decodeHex(t, "b9300670b4c5366e95b2699e8b18bc75e5f729c5")))
+
+		enc, err := filter.FilterLoad().Encode()
+		require.NoError(t, err)
+		require.Equal(t, tt.expected, hex.EncodeToString(enc))
+	}
+}
+
+func TestFilterLimits(t *testing.T) {
+	load := bloom.NewFilter(1_000_000, 0.000001, 0, messages.BloomUpdateNone).FilterLoad()
+	require.Len(t, load.Filter, messages.MaxBloomFilterSize)
+	require.LessOrEqual(t, load.HashFuncs, uint32(messages.MaxBloomHashFuncs))
+
+	load = bloom.NewFilter(0, 1, 0, messages.BloomUpdateNone).FilterLoad()
+	require.Len(t, load.Filter, 1)
+	require.NotZero(t, load.HashFuncs)
+}
+
+func TestMatchTxAndUpdate(t *testing.T) {
+	pubKeyHash := decodeHex(t, "99108ad8ed9bb6274d3980bab5a85c048f0950c8")
+	pubKey := decodeHex(t, "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")
+
+	// OP_DUP OP_HASH160 <pubkey hash> OP_EQUALVERIFY OP_CHECKSIG
+	p2pkh := append(append([]byte{0x76, 0xa9, 0x14}, pubKeyHash...), 0x88, 0xac)
+	// <pubkey> OP_CHECKSIG
+	p2pk := append(append([]byte{0x21}, pubKey...), 0xac)
+
+	funding := &messages.Tx{
+		Version: 1,
+		TxIn:    []messages.TxIn{{PreviousOutPoint: messages.OutPoint{Index: 0xffffffff}, SignatureScript: []byte{0x51}}},
+		TxOut:   []messages.TxOut{{Value: 1, PkScript: []byte{0x51}}, {Value: 2, PkScript: p2pkh}, {Value: 3, PkScript: p2pk}},
+	}
+
+	spending := func(index uint32) *messages.Tx {
+		return &messages.Tx{
+			Version: 1,
+			TxIn:    []messages.TxIn{{PreviousOutPoint: messages.OutPoint{Hash: funding.TxHash(), Index: index}, SignatureScript: []byte{}}},
+			TxOut:   []messages.TxOut{{Value: 1, PkScript: []byte{0x51}}},
+		}
+	}
+
+	filter := bloom.NewFilter(10, 0.000001, 0, messages.BloomUpdateAll)
+	require.False(t, filter.MatchTxAndUpdate(funding))
+
+	filter.Add(pubKeyHash)
+	require.True(t, filter.MatchTxAndUpdate(funding))
+	require.True(t, filter.MatchTxAndUpdate(spending(1)))
+	require.False(t, filter.MatchTxAndUpdate(spending(0)))
+
+	// only outputs paying to a public key are followed
+	filter = bloom.NewFilter(10, 0.000001, 0, messages.BloomUpdateP2PubKeyOnly)
+	filter.Add(pubKeyHash)
+	filter.Add(pubKey)
+	require.True(t, filter.MatchTxAndUpdate(funding))
+	require.False(t, filter.MatchTxAndUpdate(spending(1)))
+	require.True(t, filter.MatchTxAndUpdate(spending(2)))
+
+	// the txid and the data pushed by inputs match too
+	filter = bloom.NewFilter(10, 0.000001, 0, messages.BloomUpdateNone)
+	txid := funding.TxHash()
+	filter.Add(txid[:])
+	require.True(t, filter.MatchTxAndUpdate(funding))
+	require.False(t, filter.MatchTxAndUpdate(spending(1)))
+
+	signed := spending(0)
+	signed.TxIn[0].SignatureScript = append([]byte{0x21}, pubKey...)
+	filter = bloom.NewFilter(10, 0.000001, 0, messages.BloomUpdateNone)
+	filter.Add(pubKey)
+	require.True(t, filter.MatchTxAndUpdate(signed))
+}
@@ -0,0 +1,245 @@
+package chaincfg
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+var ErrUnknownNetwork = errors.New("unknown network")
+
+// every network aims a block every TargetSpacing, the difficulty is
+// adjusted every RetargetInterval blocks, which should take TargetTimespan
+const (
+	TargetTimespan   = 14 * 24 * time.Hour
+	TargetSpacing    = 10 * time.Minute
+	RetargetInterval = int32(TargetTimespan / TargetSpacing)
+)
+
+// genesisMerkleRoot is the merkle root of the genesis coinbase
+// shared by every network but testnet4
+var genesisMerkleRoot = mustHash("4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b")
+
+func mustHash(s string) messages.Hash {
+	h, err := messages.NewHashFromString(s)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+func mustBig(s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic(fmt.Sprintf("invalid hex constant %s", s))
+	}
+	return v
+}
+
+// Checkpoint is a block known to be in the best chain
+type Checkpoint struct {
+	Height int32
+	Hash   messages.Hash
+}
+
+// Params bundles what identifies each bitcoin network
+// check: https://github.com/bitcoin/bitcoin/blob/master/src/kernel/chainparams.cpp
+type Params struct {
+	Name        string
+	Magic       messages.Magic
+	DefaultPort uint16
+	DNSSeeds    []string
+	// GenesisHash is the hex encoded hash of the genesis block, in
+	// the same (reversed) byte order used by block explorers
+	GenesisHash string
+	// GenesisHeader is the first header of the chain, its hash is GenesisHash
+	GenesisHeader messages.BlockHeader
+
+	// PowLimit is the easiest target a block can have
+	PowLimit *big.Int
+	// AllowMinDifficultyBlocks allows a block to use the PowLimit once
+	// the previous block is older than twice the TargetSpacing (testnets)
+	AllowMinDifficultyBlocks bool
+	// NoRetargeting keeps the difficulty of the genesis (regtest)
+	NoRetargeting bool
+	// EnforceBIP94 enables the testnet4 timewarp fix, retargets start
+	// from the first block of the period instead of the last one
+	EnforceBIP94 bool
+	// Checkpoints, sorted by height, are the blocks the chain must have, forks
+	// below the last one known are rejected so low work headers can not be
+	// used to fill our memory and disk
+	Checkpoints []Checkpoint
+}
+
+var MainNet = Params{
+	Name:        "mainnet",
+	Magic:       messages.MagicMain,
+	DefaultPort: 8333,
+	DNSSeeds: []string{
+		"seed.bitcoin.sipa.be",
+		"dnsseed.bluematt.me",
+		"dnsseed.bitcoin.dashjr-list-of-p2p-nodes.us",
+		"seed.bitcoinstats.com",
+		"seed.bitcoin.jonasschnelli.ch",
+		"seed.btc.petertodd.net",
+		"seed.bitcoin.sprovoost.nl",
+		"dnsseed.emzy.de",
+		"seed.bitcoin.wiz.biz",
+	},
+	GenesisHash: "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+	GenesisHeader: messages.BlockHeader{
+		Version:    1,
+		MerkleRoot: genesisMerkleRoot,
+		Timestamp:  1231006505,
+		Bits:       0x1d00ffff,
+		Nonce:      2083236893,
+	},
+	PowLimit: mustBig("00000000ffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+	// the checkpoints of btcd
+	// check: https://github.com/btcsuite/btcd/blob/master/chaincfg/params.go
+	Checkpoints: []Checkpoint{
+		{Height: 11111, Hash: mustHash("0000000069e244f73d78e8fd29ba2fd2ed618bd6fa2ee92559f542fdb26e7c1d")},
+		{Height: 33333, Hash: mustHash("000000002dd5588a74784eaa7ab0507a18ad16a236e7b1ce69f00d7ddfb5d0a6")},
+		{Height: 74000, Hash: mustHash("0000000000573993a3c9e41ce34471c079dcf5f52a0e824a81e7f953b8661a20")},
+		{Height: 105000, Hash: mustHash("00000000000291ce28027faea320c8d2b054b2e0fe44a773f3eefb151d6bdc97")},
+		{Height: 134444, Hash: mustHash("00000000000005b12ffd4cd315cd34ffd4a594f430ac814c91184a0d42d2b0fe")},
+		{Height: 168000, Hash: mustHash("000000000000099e61ea72015e79632f216fe6cb33d7899acb35b75c8303b763")},
+		{Height: 193000, Hash: mustHash("000000000000059f452a5f7340de6682a977387c17010ff6e6c3bd83ca8b1317")},
+		{Height: 210000, Hash: mustHash("000000000000048b95347e83192f69cf0366076336c639f9b7228e9ba171342e")},
+		{Height: 216116, Hash: mustHash("00000000000001b4f4b433e81ee46494af945cf96014816a4e2370f11b23df4e")},
+		{Height: 225430, Hash: mustHash("00000000000001c108384350f74090433e7fcf79a606b8e797f065b130575932")},
+		{Height: 250000, Hash: mustHash("000000000000003887df1f29024b06fc2200b55f8af8f35453d7be294df2d214")},
+		{Height: 267300, Hash: mustHash("000000000000000a83fbd660e918f218bf37edd92b748ad940483c7c116179ac")},
+		{Height: 279000, Hash: mustHash("0000000000000001ae8c72a0b0c301f67e3afca10e819efa9041e458e9bd7e40")},
+		{Height: 300255, Hash: mustHash("0000000000000000162804527c6e9b9f0563a280525f9d08c12041def0a0f3b2")},
+		{Height: 319400, Hash: mustHash("000000000000000021c6052e9becade189495d1c539aa37c58917305fd15f13b")},
+		{Height: 343185, Hash: mustHash("0000000000000000072b8bf361d01a6ba7d445dd024203fafc78768ed4368554")},
+		{Height: 352940, Hash: mustHash("000000000000000010755df42dba556bb72be6a32f3ce0b6941ce4430152c9ff")},
+		{Height: 382320, Hash: mustHash("00000000000000000a8dc6ed5b133d0eb2fd6af56203e4159789b092defd8ab2")},
+		{Height: 400000, Hash: mustHash("000000000000000004ec466ce4732fe6f1ed1cddc2ed4b328fff5224276e3f6f")},
+		{Height: 430000, Hash: mustHash("000000000000000001868b2bb3a285f3cc6b33ea234eb70facf4dcdf22186b87")},
+		{Height: 460000, Hash: mustHash("000000000000000000ef751bbce8e744ad303c47ece06c8d863e4d417efc258c")},
+		{Height: 490000, Hash: mustHash("000000000000000000de069137b17b8d5a3dfbd5b145b2dcfb203f15d0c4de90")},
+		{Height: 520000, Hash: mustHash("0000000000000000000d26984c0229c9f6962dc74db0a6d525f2f1640396f69c")},
+		{Height: 550000, Hash: mustHash("000000000000000000223b7a2298fb1c6c75fb0efc28a4c56853ff4112ec6bc9")},
+		{Height: 560000, Hash: mustHash("0000000000000000002c7b276daf6efb2b6aa68e2ce3be67ef925b3264ae7122")},
+	},
+}
+
+var TestNet3 = Params{
+	Name:        "testnet3",
+	Magic:       messages.MagicTestNet3,
+	DefaultPort: 18333,
+	DNSSeeds: []string{
+		"testnet-seed.bitcoin.jonasschnelli.ch",
+		"seed.tbtc.petertodd.net",
+		"seed.testnet.bitcoin.sprovoost.nl",
+		"testnet-seed.bluematt.me",
+	},
+	GenesisHash: "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943",
+	GenesisHeader: messages.BlockHeader{
+		Version:    1,
+		MerkleRoot: genesisMerkleRoot,
+		Timestamp:  1296688602,
+		Bits:       0x1d00ffff,
+		Nonce:      414098458,
+	},
+	PowLimit:                 mustBig("00000000ffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+	AllowMinDifficultyBlocks: true,
+	Checkpoints: []Checkpoint{
+		{Height: 546, Hash: mustHash("000000002a936ca763904c3c35fce2f3556c559c0214345d31b1bcebf76acb70")},
+		{Height: 100000, Hash: mustHash("00000000009e2958c15ff9290d571bf9459e93b19765c6801ddeccadbb160a1e")},
+		{Height: 200000, Hash: mustHash("0000000000287bffd321963ef05feab753ebe274e1d78b2fd4e2bfe9ad3aa6f2")},
+		{Height: 300001, Hash: mustHash("0000000000004829474748f3d1bc8fcf893c88be255e6d7f571c548aff57abf4")},
+		{Height: 400002, Hash: mustHash("0000000005e2c73b8ecb82ae2dbc2e8274614ebad7172b53528aba7501f5a089")},
+		{Height: 500011, Hash: mustHash("00000000000929f63977fbac92ff570a9bd9e7715401ee96f2848f7b07750b02")},
+		{Height: 600002, Hash: mustHash("000000000001f471389afd6ee94dcace5ccc44adc18e8bff402443f034b07240")},
+		{Height: 700000, Hash: mustHash("000000000000406178b12a4dea3b27e13b3c4fe4510994fd667d7c1e6a3f4dc1")},
+		{Height: 800010, Hash: mustHash("000000000017ed35296433190b6829db01e657d80631d43f5983fa403bfdb4c1")},
+		{Height: 900000, Hash: mustHash("0000000000356f8d8924556e765b7a94aaebc6b5c8685dcfa2b1ee8b41acd89b")},
+		{Height: 1000007, Hash: mustHash("00000000001ccb893d8a1f25b70ad173ce955e5f50124261bbbc50379a612ddf")},
+		{Height: 1100007, Hash: mustHash("00000000000abc7b2cd18768ab3dee20857326a818d1946ed6796f42d66dd1e8")},
+		{Height: 1200007, Hash: mustHash("00000000000004f2dc41845771909db57e04191714ed8c963f7e56713a7b6cea")},
+		{Height: 1300007, Hash: mustHash("0000000072eab69d54df75107c052b26b0395b44f77578184293bf1bb1dbd9fa")},
+	},
+}
+
+var TestNet4 = Params{
+	Name:        "testnet4",
+	Magic:       messages.MagicTestNet4,
+	DefaultPort: 48333,
+	DNSSeeds: []string{
+		"seed.testnet4.bitcoin.sprovoost.nl",
+		"seed.testnet4.wiz.biz",
+	},
+	GenesisHash: "00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043",
+	GenesisHeader: messages.BlockHeader{
+		Version:    1,
+		MerkleRoot: mustHash("7aa0a7ae1e223414cb807e40cd57e667b718e42aaf9306db9102fe28912b7b4e"),
+		Timestamp:  1714777860,
+		Bits:       0x1d00ffff,
+		Nonce:      393743547,
+	},
+	PowLimit:                 mustBig("00000000ffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+	AllowMinDifficultyBlocks: true,
+	EnforceBIP94:             true,
+}
+
+var SigNet = Params{
+	Name:        "signet",
+	Magic:       messages.MagicSignet,
+	DefaultPort: 38333,
+	DNSSeeds: []string{
+		"seed.signet.bitcoin.sprovoost.nl",
+	},
+	GenesisHash: "00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6",
+	GenesisHeader: messages.BlockHeader{
+		Version:    1,
+		MerkleRoot: genesisMerkleRoot,
+		Timestamp:  1598918400,
+		Bits:       0x1e0377ae,
+		Nonce:      52613770,
+	},
+	PowLimit: mustBig("00000377ae000000000000000000000000000000000000000000000000000000"),
+}
+
+// RegTest is a local network, there are no DNS seeds for it
+var RegTest = Params{
+	Name:        "regtest",
+	Magic:       messages.MagicTestNetRegTest,
+	DefaultPort: 18444,
+	GenesisHash: "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206",
+	GenesisHeader: messages.BlockHeader{
+		Version:    1,
+		MerkleRoot: genesisMerkleRoot,
+		Timestamp:  1296688602,
+		Bits:       0x207fffff,
+		Nonce:      2,
+	},
+	PowLimit:                 mustBig("7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+	AllowMinDifficultyBlocks: true,
+	NoRetargeting:            true,
+}
+
+// Networks lists every supported network
+var Networks = []*Params{&MainNet, &TestNet3, &TestNet4, &SigNet, &RegTest}
+
+// ByName returns the network params given its name (e.g mainnet, regtest)
+func ByName(name string) (*Params, error) {
+	for _, params := range Networks {
+		if params.Name == strings.ToLower(strings.TrimSpace(name)) {
+			return params, nil
+		}
+	}
+
+	names := make([]string, len(Networks))
+	for idx, params := range Networks {
+		names[idx] = params.Name
+	}
+
+	return nil, fmt.Errorf("%w: %q, expected one of: %s", ErrUnknownNetwork, name, strings.Join(names, ", "))
+}
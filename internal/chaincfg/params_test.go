@@ -0,0 +1,52 @@
+package chaincfg_test
+
+import (
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/chaincfg"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestByName(t *testing.T) {
+	params, err := chaincfg.ByName("regtest")
+	require.NoError(t, err)
+	require.Equal(t, messages.MagicTestNetRegTest, params.Magic)
+	require.Equal(t, uint16(18444), params.DefaultPort)
+	require.Empty(t, params.DNSSeeds)
+
+	params, err = chaincfg.ByName(" TestNet4 ")
+	require.NoError(t, err)
+	require.Equal(t, messages.MagicTestNet4, params.Magic)
+
+	_, err = chaincfg.ByName("litecoin")
+	require.ErrorIs(t, err, chaincfg.ErrUnknownNetwork)
+}
+
+func TestNetworksAreDistinct(t *testing.T) {
+	magics := make(map[messages.Magic]struct{})
+	ports := make(map[uint16]struct{})
+
+	for _, params := range chaincfg.Networks {
+		magics[params.Magic] = struct{}{}
+		ports[params.DefaultPort] = struct{}{}
+	}
+
+	require.Len(t, magics, len(chaincfg.Networks))
+	require.Len(t, ports, len(chaincfg.Networks))
+}
+
+func TestGenesisHeaders(t *testing.T) {
+	for _, params := range chaincfg.Networks {
+		hash := params.GenesisHeader.BlockHash()
+		require.Equal(t, params.GenesisHash, hash.String(), params.Name)
+	}
+}
+
+func TestCheckpointsAreSorted(t *testing.T) {
+	for _, params := range chaincfg.Networks {
+		for idx := 1; idx < len(params.Checkpoints); idx++ {
+			require.Less(t, params.Checkpoints[idx-1].Height, params.Checkpoints[idx].Height, params.Name)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package compactblock
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+var (
+	ErrShortIDCollision = errors.New("compact block has duplicated short ids")
+	ErrMissingTxs       = errors.New("compact block is missing transactions")
+	ErrUnexpectedTxs    = errors.New("blocktxn does not match the missing transactions")
+)
+
+// TxPool gives the transactions we already have, the ones
+// a compact block refers to are taken from it
+type TxPool interface {
+	Transactions() []*messages.Tx
+}
+
+// Reconstructor rebuilds a block from its compact block, the prefilled
+// transactions and the ones found in the pool by their short id, the
+// remaining ones are asked through getblocktxn
+// check: https://github.com/bitcoin/bips/blob/master/bip-0152.mediawiki
+type Reconstructor struct {
+	header messages.BlockHeader
+	hash   messages.Hash
+	txs    []*messages.Tx
+
+	prefilled int
+	fromPool  int
+	requested int
+}
+
+// NewReconstructor places the prefilled transactions and the ones of the
+// pool matching a short id, an error means the compact block can not be
+// used at all and the full block should be asked instead
+func NewReconstructor(cmpct *messages.CmpctBlock, pool TxPool) (*Reconstructor, error) {
+	count := cmpct.TxCount()
+	if count == 0 {
+		return nil, fmt.Errorf("%w: no transactions", messages.ErrInvalidCompactBlock)
+	}
+
+	r := &Reconstructor{
+		header:    cmpct.Header,
+		hash:      cmpct.BlockHash(),
+		txs:       make([]*messages.Tx, count),
+		prefilled: len(cmpct.Prefilled),
+	}
+
+	for _, prefilled := range cmpct.Prefilled {
+		if int(prefilled.Index) >= count || prefilled.Tx == nil || r.txs[prefilled.Index] != nil {
+			return nil, fmt.Errorf("%w: prefilled index %d of %d transactions", messages.ErrInvalidCompactBlock, prefilled.Index, count)
+		}
+		r.txs[prefilled.Index] = prefilled.Tx
+	}
+
+	// the short ids fill the slots left by the prefilled transactions, in order
+	slots := make(map[uint64]int, len(cmpct.ShortIDs))
+	slot := 0
+	for _, id := range cmpct.ShortIDs {
+		for r.txs[slot] != nil {
+			slot++
+		}
+
+		if _, ok := slots[id]; ok {
+			return nil, fmt.Errorf("%w: %012x", ErrShortIDCollision, id)
+		}
+		slots[id] = slot
+		slot++
+	}
+
+	// two pool transactions with the same short id leave the slot to be requested
+	collided := make(map[int]bool)
+	if pool != nil {
+		key := cmpct.ShortIDKey()
+		for _, tx := range pool.Transactions() {
+			slot, ok := slots[key.ShortID(tx.WitnessHash())]
+			if !ok || collided[slot] {
+				continue
+			}
+
+			if r.txs[slot] != nil {
+				if r.txs[slot].WitnessHash() != tx.WitnessHash() {
+					r.txs[slot] = nil
+					collided[slot] = true
+					r.fromPool--
+				}
+				continue
+			}
+
+			r.txs[slot] = tx
+			r.fromPool++
+		}
+	}
+
+	return r, nil
+}
+
+// BlockHash is the hash of the block being rebuilt
+func (r *Reconstructor) BlockHash() messages.Hash {
+	return r.hash
+}
+
+// Missing returns the indexes of the transactions still missing
+func (r *Reconstructor) Missing() []uint32 {
+	var missing []uint32
+	for idx, tx := range r.txs {
+		if tx == nil {
+			missing = append(missing, uint32(idx))
+		}
+	}
+	return missing
+}
+
+// Request returns the getblocktxn asking the missing transactions,
+// it returns nil when the block can already be rebuilt
+func (r *Reconstructor) Request() *messages.GetBlockTxn {
+	missing := r.Missing()
+	if len(missing) == 0 {
+		return nil
+	}
+	return &messages.GetBlockTxn{BlockHash: r.hash, Indexes: missing}
+}
+
+// Fill places the transactions answering our getblocktxn
+func (r *Reconstructor) Fill(txn *messages.BlockTxn) error {
+	if txn.BlockHash != r.hash {
+		return fmt.Errorf("%w: for block %s, expected %s", ErrUnexpectedTxs, txn.BlockHash, r.hash)
+	}
+
+	missing := r.Missing()
+	if len(txn.Transactions) != len(missing) {
+		return fmt.Errorf("%w: got %d transactions, %d are missing", ErrUnexpectedTxs, len(txn.Transactions), len(missing))
+	}
+
+	for idx, slot := range missing {
+		r.txs[slot] = txn.Transactions[idx]
+	}
+	r.requested += len(missing)
+	return nil
+}
+
+// Block returns the rebuilt block once no transaction is missing, a bad
+// merkle root means a pool transaction matched the wrong short id, the
+// full block should then be asked instead
+func (r *Reconstructor) Block() (*messages.Block, error) {
+	if missing := r.Missing(); len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %d of %d", ErrMissingTxs, len(missing), len(r.txs))
+	}
+
+	block := &messages.Block{Header: r.header, Transactions: r.txs}
+	if err := block.CheckMerkleRoot(); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// Stats tells where the transactions of the block came from
+func (r *Reconstructor) Stats() (prefilled, fromPool, requested int) {
+	return r.prefilled, r.fromPool, r.requested
+}
@@ -0,0 +1,120 @@
+package compactblock_test
+
+import (
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/compactblock"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+type pool []*messages.Tx
+
+func (p pool) Transactions() []*messages.Tx {
+	return p
+}
+
+func testTx(lockTime uint32) *messages.Tx {
+	return &messages.Tx{
+		Version: 2,
+		TxIn: []messages.TxIn{{
+			PreviousOutPoint: messages.OutPoint{Hash: messages.Hash{byte(lockTime)}},
+			SignatureScript:  []byte{},
+			Sequence:         0xffffffff,
+			Witness:          [][]byte{{byte(lockTime)}},
+		}},
+		TxOut:    []messages.TxOut{{Value: 1000, PkScript: []byte{0x51}}},
+		LockTime: lockTime,
+	}
+}
+
+func testBlock(size int) *messages.Block {
+	block := new(messages.Block)
+	for idx := 0; idx < size; idx++ {
+		block.Transactions = append(block.Transactions, testTx(uint32(idx)))
+	}
+	block.Header.MerkleRoot, _ = block.MerkleRoot()
+	return block
+}
+
+func TestReconstructFromPool(t *testing.T) {
+	block := testBlock(5)
+	cmpct := messages.NewCmpctBlock(block, 42)
+
+	// the pool has every transaction plus unrelated ones
+	r, err := compactblock.NewReconstructor(cmpct, pool{testTx(100), block.Transactions[3], block.Transactions[1],
+		block.Transactions[4], block.Transactions[2], testTx(200)})
+	require.NoError(t, err)
+	require.Empty(t, r.Missing())
+	require.Nil(t, r.Request())
+
+	rebuilt, err := r.Block()
+	require.NoError(t, err)
+	require.Equal(t, block, rebuilt)
+
+	prefilled, fromPool, requested := r.Stats()
+	require.Equal(t, []int{1, 4, 0}, []int{prefilled, fromPool, requested})
+}
+
+func TestReconstructRequestsMissing(t *testing.T) {
+	block := testBlock(6)
+	cmpct := messages.NewCmpctBlock(block, 7)
+
+	r, err := compactblock.NewReconstructor(cmpct, pool{block.Transactions[2], block.Transactions[5]})
+	require.NoError(t, err)
+
+	_, err = r.Block()
+	require.ErrorIs(t, err, compactblock.ErrMissingTxs)
+
+	request := r.Request()
+	require.Equal(t, &messages.GetBlockTxn{BlockHash: block.BlockHash(), Indexes: []uint32{1, 3, 4}}, request)
+
+	// the answer must have exactly the missing transactions
+	err = r.Fill(&messages.BlockTxn{BlockHash: block.BlockHash(), Transactions: block.Transactions[1:2]})
+	require.ErrorIs(t, err, compactblock.ErrUnexpectedTxs)
+
+	err = r.Fill(&messages.BlockTxn{BlockHash: messages.Hash{1}, Transactions: block.Transactions[1:4]})
+	require.ErrorIs(t, err, compactblock.ErrUnexpectedTxs)
+
+	txs := []*messages.Tx{block.Transactions[1], block.Transactions[3], block.Transactions[4]}
+	require.NoError(t, r.Fill(&messages.BlockTxn{BlockHash: block.BlockHash(), Transactions: txs}))
+
+	rebuilt, err := r.Block()
+	require.NoError(t, err)
+	require.Equal(t, block, rebuilt)
+
+	prefilled, fromPool, requested := r.Stats()
+	require.Equal(t, []int{1, 2, 3}, []int{prefilled, fromPool, requested})
+}
+
+func TestReconstructWrongTransactions(t *testing.T) {
+	block := testBlock(3)
+	cmpct := messages.NewCmpctBlock(block, 1)
+
+	r, err := compactblock.NewReconstructor(cmpct, nil)
+	require.NoError(t, err)
+	require.Equal(t, []uint32{1, 2}, r.Missing())
+
+	// transactions that are not the ones of the block give another merkle root
+	require.NoError(t, r.Fill(&messages.BlockTxn{BlockHash: block.BlockHash(), Transactions: []*messages.Tx{testTx(8), testTx(9)}}))
+
+	_, err = r.Block()
+	require.ErrorIs(t, err, messages.ErrBadMerkleRoot)
+}
+
+func TestReconstructInvalidCompactBlock(t *testing.T) {
+	block := testBlock(3)
+
+	cmpct := messages.NewCmpctBlock(block, 1)
+	cmpct.ShortIDs[1] = cmpct.ShortIDs[0]
+	_, err := compactblock.NewReconstructor(cmpct, nil)
+	require.ErrorIs(t, err, compactblock.ErrShortIDCollision)
+
+	cmpct = messages.NewCmpctBlock(block, 1)
+	cmpct.Prefilled[0].Index = 3
+	_, err = compactblock.NewReconstructor(cmpct, nil)
+	require.ErrorIs(t, err, messages.ErrInvalidCompactBlock)
+
+	_, err = compactblock.NewReconstructor(&messages.CmpctBlock{}, nil)
+	require.ErrorIs(t, err, messages.ErrInvalidCompactBlock)
+}
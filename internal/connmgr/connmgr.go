@@ -0,0 +1,427 @@
+package connmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/addrman"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/handshake"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+)
+
+const (
+	DefaultTarget        = 8
+	DefaultBaseBackoff   = time.Second
+	DefaultMaxBackoff    = 5 * time.Minute
+	DefaultRetryInterval = 5 * time.Second
+	DefaultPingInterval  = 2 * time.Minute
+	DefaultPingTimeout   = 20 * time.Minute
+
+	// how many candidates are drawn from the address source
+	// every time a slot is filled before giving up for a while
+	maxSelectTries = 100
+	eventsBuffer   = 64
+)
+
+var (
+	ErrUnknownPeer     = errors.New("unknown peer")
+	ErrMissingServices = errors.New("peer does not advertise the required services")
+)
+
+type EventType uint8
+
+const (
+	// EventConnected is emitted once the handshake with the peer is established
+	EventConnected EventType = iota
+	// EventDisconnected is emitted when an established connection ends
+	EventDisconnected
+	// EventHandshakeFailed is emitted when the peer could not be dialed
+	// or the handshake with it could not be established
+	EventHandshakeFailed
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventConnected:
+		return "connected"
+	case EventDisconnected:
+		return "disconnected"
+	case EventHandshakeFailed:
+		return "handshake failed"
+	default:
+		return "undefined"
+	}
+}
+
+// Event reports a change in one of the outbound connections,
+// PeerInfo is only set once the handshake is established
+type Event struct {
+	Type     EventType
+	Addr     string
+	PeerInfo *handshake.PeerInfo
+	Err      error
+}
+
+func (e Event) String() string {
+	if e.Err != nil {
+		return fmt.Sprintf("[%s] %s: %s", e.Type.String(), e.Addr, e.Err.Error())
+	}
+	return fmt.Sprintf("[%s] %s", e.Type.String(), e.Addr)
+}
+
+// AddressSource provides the candidates to connect to and is told about
+// the outcome of every attempt, *addrman.AddrMan satisfies it
+type AddressSource interface {
+	Select(newOnly bool) (addrman.Address, bool)
+	Attempt(addr string) error
+	Good(addr string, services uint64) error
+}
+
+type DialFunc func(ctx context.Context, addr string) (*network.Stream, error)
+
+// Peer is an established outbound connection
+type Peer struct {
+	Addr   string
+	Info   *handshake.PeerInfo
+	Stream *network.Stream
+}
+
+type Config struct {
+	// Target is the number of outbound peers kept connected
+	Target int
+	// Addresses provides the peers to connect to
+	Addresses AddressSource
+	// Dial opens the connection to the peer address (host:port)
+	Dial DialFunc
+	// Version builds the version message we send to the peer
+	Version func(addr string) *messages.Version
+	// HandshakeTimeout is the time to establish the handshake with each peer
+	HandshakeTimeout time.Duration
+	// Nonces tracks our handshakes so we never connect to ourselves
+	Nonces *handshake.Nonces
+	// SendHeaders and FeeFilter are negotiated with every peer
+	// after the handshake, see handshake.Config
+	SendHeaders bool
+	FeeFilter   int64
+	// Services the peers must advertise, e.g messages.NodeBloom, peers without
+	// them are dropped after the handshake and candidates known to lack them
+	// are not dialed. Zero accepts every peer
+	Services uint64
+	// Serve handles the peer after the handshake, the connection is closed and
+	// replaced once it returns, an error means the peer misbehaved. When nil
+	// the peer is only kept alive with pings
+	Serve func(ctx context.Context, peer *Peer) error
+	// BaseBackoff is the wait before retrying an address that failed once,
+	// it doubles with every consecutive failure up to MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// RetryInterval is the wait before looking for new candidates
+	// when the address source has none that can be dialed
+	RetryInterval time.Duration
+}
+
+// Manager keeps Target outbound peers connected, a peer that disconnects
+// is replaced by another one from the address source. To avoid having
+// every connection controlled by the same entity, at most one peer per
+// network group (the /16 for IPv4) is connected at a time
+type Manager struct {
+	cfg    Config
+	events chan Event
+
+	mu    sync.Mutex
+	peers map[string]*Peer
+
+	// the fields below are only used by the Run goroutine
+	active   map[string]struct{}
+	groups   map[string]struct{}
+	failures map[string]int
+	retryAt  map[string]time.Time
+}
+
+func New(cfg Config) *Manager {
+	if cfg.Target <= 0 {
+		cfg.Target = DefaultTarget
+	}
+
+	if cfg.BaseBackoff == 0 {
+		cfg.BaseBackoff = DefaultBaseBackoff
+	}
+
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+
+	if cfg.RetryInterval == 0 {
+		cfg.RetryInterval = DefaultRetryInterval
+	}
+
+	if cfg.Nonces == nil {
+		cfg.Nonces = handshake.NewNonces()
+	}
+
+	if cfg.Serve == nil {
+		cfg.Serve = keepAlive
+	}
+
+	return &Manager{
+		cfg:      cfg,
+		events:   make(chan Event, eventsBuffer),
+		peers:    make(map[string]*Peer),
+		active:   make(map[string]struct{}),
+		groups:   make(map[string]struct{}),
+		failures: make(map[string]int),
+		retryAt:  make(map[string]time.Time),
+	}
+}
+
+// Events returns the channel where connection events are published,
+// it is closed once Run returns. Events are dropped after the context
+// given to Run is done, so a late reader never blocks the shutdown
+func (m *Manager) Events() <-chan Event {
+	return m.events
+}
+
+// Peers returns the peers currently connected
+func (m *Manager) Peers() []*Peer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	peers := make([]*Peer, 0, len(m.peers))
+	for _, peer := range m.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// Disconnect closes the connection with the peer, e.g when it misbehaves,
+// the peer is retried only after its backoff and a replacement is dialed
+func (m *Manager) Disconnect(addr string) error {
+	m.mu.Lock()
+	peer, ok := m.peers[addr]
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownPeer, addr)
+	}
+	return peer.Stream.Close()
+}
+
+type connResult struct {
+	addr        string
+	established bool
+	err         error
+}
+
+// Run keeps the outbound connections until the context is done,
+// then every connection is closed and the context error returned
+func (m *Manager) Run(ctx context.Context) error {
+	defer close(m.events)
+
+	var wg sync.WaitGroup
+	results := make(chan connResult)
+
+	retry := time.NewTimer(0)
+	defer retry.Stop()
+
+	for {
+		m.fill(ctx, &wg, results)
+
+		select {
+		case <-ctx.Done():
+			m.mu.Lock()
+			for _, peer := range m.peers {
+				peer.Stream.Close()
+			}
+			m.mu.Unlock()
+
+			// the results channel is drained so no connection goroutine blocks
+			go func() {
+				for range results {
+				}
+			}()
+			wg.Wait()
+			close(results)
+			return ctx.Err()
+		case result := <-results:
+			m.release(result)
+		case <-retry.C:
+		}
+
+		// while slots are missing we look for candidates again after a while
+		if len(m.active) < m.cfg.Target {
+			if !retry.Stop() {
+				select {
+				case <-retry.C:
+				default:
+				}
+			}
+			retry.Reset(m.cfg.RetryInterval)
+		}
+	}
+}
+
+// fill starts connections until the target is reached
+// or there is no candidate that can be dialed right now
+func (m *Manager) fill(ctx context.Context, wg *sync.WaitGroup, results chan<- connResult) {
+	for len(m.active) < m.cfg.Target && ctx.Err() == nil {
+		addr, group, ok := m.candidate()
+		if !ok {
+			return
+		}
+
+		m.active[addr] = struct{}{}
+		m.groups[group] = struct{}{}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- m.connect(ctx, addr)
+		}()
+	}
+}
+
+func (m *Manager) candidate() (addr, group string, ok bool) {
+	now := time.Now()
+	for i := 0; i < maxSelectTries; i++ {
+		candidate, ok := m.cfg.Addresses.Select(false)
+		if !ok {
+			return "", "", false
+		}
+
+		addr, group := candidate.String(), addrman.Group(candidate.Host)
+		if _, ok := m.active[addr]; ok {
+			continue
+		}
+
+		if _, ok := m.groups[group]; ok {
+			continue
+		}
+
+		if now.Before(m.retryAt[addr]) {
+			continue
+		}
+
+		// addresses whose services are not known yet are dialed to find out
+		if candidate.Services != 0 && !hasServices(candidate.Services, m.cfg.Services) {
+			continue
+		}
+
+		return addr, group, true
+	}
+
+	return "", "", false
+}
+
+// release frees the slot of a finished connection, addresses that failed
+// or misbehaved wait an exponential backoff before being dialed again
+func (m *Manager) release(result connResult) {
+	delete(m.active, result.addr)
+
+	host, _, err := net.SplitHostPort(result.addr)
+	if err == nil {
+		delete(m.groups, addrman.Group(host))
+	}
+
+	if result.established && result.err == nil {
+		delete(m.failures, result.addr)
+		m.retryAt[result.addr] = time.Now().Add(m.cfg.BaseBackoff)
+		return
+	}
+
+	m.failures[result.addr]++
+	m.retryAt[result.addr] = time.Now().Add(m.backoff(m.failures[result.addr]))
+}
+
+func (m *Manager) backoff(failures int) time.Duration {
+	backoff := m.cfg.BaseBackoff
+	for i := 1; i < failures && backoff < m.cfg.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, m.cfg.MaxBackoff)
+}
+
+func (m *Manager) connect(ctx context.Context, addr string) connResult {
+	m.cfg.Addresses.Attempt(addr)
+
+	stream, err := m.cfg.Dial(ctx, addr)
+	if err != nil {
+		m.emit(ctx, Event{Type: EventHandshakeFailed, Addr: addr, Err: err})
+		return connResult{addr: addr, err: err}
+	}
+
+	peerInfo, err := handshake.Outbound(stream, handshake.Config{
+		Version:     m.cfg.Version(addr),
+		Timeout:     m.cfg.HandshakeTimeout,
+		Nonces:      m.cfg.Nonces,
+		SendHeaders: m.cfg.SendHeaders,
+		FeeFilter:   m.cfg.FeeFilter,
+	})
+	if err != nil {
+		stream.Close()
+		m.emit(ctx, Event{Type: EventHandshakeFailed, Addr: addr, Err: err})
+		return connResult{addr: addr, err: err}
+	}
+
+	m.cfg.Addresses.Good(addr, peerInfo.Services)
+
+	if !hasServices(peerInfo.Services, m.cfg.Services) {
+		stream.Close()
+		err = fmt.Errorf("%w: 0x%x lacks 0x%x", ErrMissingServices, peerInfo.Services, m.cfg.Services)
+		m.emit(ctx, Event{Type: EventHandshakeFailed, Addr: addr, PeerInfo: peerInfo, Err: err})
+		return connResult{addr: addr, err: err}
+	}
+
+	peer := &Peer{Addr: addr, Info: peerInfo, Stream: stream}
+	m.mu.Lock()
+	m.peers[addr] = peer
+	m.mu.Unlock()
+
+	m.emit(ctx, Event{Type: EventConnected, Addr: addr, PeerInfo: peerInfo})
+	err = m.cfg.Serve(ctx, peer)
+	stream.Close()
+
+	m.mu.Lock()
+	delete(m.peers, addr)
+	m.mu.Unlock()
+
+	m.emit(ctx, Event{Type: EventDisconnected, Addr: addr, PeerInfo: peerInfo, Err: err})
+	return connResult{addr: addr, established: true, err: err}
+}
+
+func hasServices(services, required uint64) bool {
+	return services&required == required
+}
+
+func (m *Manager) emit(ctx context.Context, event Event) {
+	select {
+	case m.events <- event:
+	case <-ctx.Done():
+	}
+}
+
+// keepAlive is the default Serve, it answers and sends pings
+// until the connection ends, every other message is ignored
+func keepAlive(ctx context.Context, peer *Peer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	keepAlive := network.NewKeepAlive(peer.Stream, DefaultPingInterval, DefaultPingTimeout)
+	go keepAlive.Run(ctx)
+
+	for {
+		msg, err := peer.Stream.ReadMessage()
+		if err != nil {
+			// the connection ending is not the peer misbehaving
+			return nil
+		}
+
+		if _, err := keepAlive.HandleMessage(msg); err != nil {
+			return err
+		}
+	}
+}
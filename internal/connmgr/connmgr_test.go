@@ -0,0 +1,273 @@
+package connmgr_test
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/addrman"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/connmgr"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/handshake"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+	"github.com/stretchr/testify/require"
+)
+
+func testVersion(string) *messages.Version {
+	return messages.NewVersion(
+		messages.WithNumber(70015),
+		messages.WithServices(messages.NodeNetwork),
+		messages.WithAddrRecv("127.0.0.1", 8333, 1),
+		messages.WithAddrFrom("0.0.0.0", 8080, 1),
+		messages.WithNonce(rand.Uint64()),
+		messages.WithUserAgent("/connmgr-test/"),
+	)
+}
+
+// fakeSource is an in-memory address source, the address
+// manager refuses loopback addresses so it can not be used here
+type fakeSource struct {
+	mu    sync.Mutex
+	addrs []addrman.Address
+	good  map[string]uint64
+}
+
+func (f *fakeSource) Select(bool) (addrman.Address, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.addrs) == 0 {
+		return addrman.Address{}, false
+	}
+	return f.addrs[rand.Intn(len(f.addrs))], true
+}
+
+func (f *fakeSource) Attempt(string) error { return nil }
+
+func (f *fakeSource) Good(addr string, services uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.good == nil {
+		f.good = make(map[string]uint64)
+	}
+	f.good[addr] = services
+	return nil
+}
+
+// listen starts a node answering handshakes on ip, linux routes the whole
+// 127.0.0.0/8 to loopback so every test node can live in its own /16
+func listen(t *testing.T, ip string) (addrman.Address, net.Listener) {
+	lst, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { lst.Close() })
+
+	go func() {
+		for {
+			conn, err := lst.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				stream := network.NewStream(conn)
+				defer stream.Close()
+
+				_, err := handshake.Inbound(stream, handshake.Config{Version: testVersion(""), Timeout: time.Second})
+				if err != nil {
+					return
+				}
+
+				for {
+					if _, err := stream.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	addrPort := netip.MustParseAddrPort(lst.Addr().String())
+	return addrman.Address{Host: addrPort.Addr().String(), Port: addrPort.Port()}, lst
+}
+
+func tcpDial(ctx context.Context, addr string) (*network.Stream, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return network.NewStream(conn), nil
+}
+
+func newManager(source connmgr.AddressSource, target int) *connmgr.Manager {
+	return connmgr.New(connmgr.Config{
+		Target:           target,
+		Addresses:        source,
+		Dial:             tcpDial,
+		Version:          testVersion,
+		HandshakeTimeout: time.Second,
+		BaseBackoff:      time.Minute,
+		RetryInterval:    50 * time.Millisecond,
+	})
+}
+
+func nextEvent(t *testing.T, events <-chan connmgr.Event) connmgr.Event {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting connection event")
+		return connmgr.Event{}
+	}
+}
+
+func run(t *testing.T, manager *connmgr.Manager) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- manager.Run(ctx) }()
+
+	t.Cleanup(func() {
+		cancel()
+		require.ErrorIs(t, <-done, context.Canceled)
+	})
+}
+
+func TestManagerReachesTarget(t *testing.T) {
+	nodeA, _ := listen(t, "127.1.0.1")
+	nodeB, _ := listen(t, "127.2.0.1")
+	nodeC, _ := listen(t, "127.3.0.1")
+
+	source := &fakeSource{addrs: []addrman.Address{nodeA, nodeB, nodeC}}
+	manager := newManager(source, 2)
+	run(t, manager)
+
+	connected := make(map[string]struct{})
+	for len(connected) < 2 {
+		event := nextEvent(t, manager.Events())
+		require.Equal(t, connmgr.EventConnected, event.Type)
+		require.Equal(t, "/connmgr-test/", event.PeerInfo.UserAgent)
+		connected[event.Addr] = struct{}{}
+	}
+
+	require.Len(t, manager.Peers(), 2)
+
+	source.mu.Lock()
+	require.Len(t, source.good, 2)
+	source.mu.Unlock()
+}
+
+func TestManagerReplacesDisconnectedPeer(t *testing.T) {
+	nodeA, _ := listen(t, "127.1.0.1")
+	nodeB, _ := listen(t, "127.2.0.1")
+
+	source := &fakeSource{addrs: []addrman.Address{nodeA, nodeB}}
+	manager := newManager(source, 1)
+	run(t, manager)
+
+	first := nextEvent(t, manager.Events())
+	require.Equal(t, connmgr.EventConnected, first.Type)
+
+	require.NoError(t, manager.Disconnect(first.Addr))
+	require.ErrorIs(t, manager.Disconnect("1.2.3.4:8333"), connmgr.ErrUnknownPeer)
+
+	disconnected := nextEvent(t, manager.Events())
+	require.Equal(t, connmgr.EventDisconnected, disconnected.Type)
+	require.Equal(t, first.Addr, disconnected.Addr)
+
+	// the disconnected peer waits its backoff, so the other one is dialed
+	replacement := nextEvent(t, manager.Events())
+	require.Equal(t, connmgr.EventConnected, replacement.Type)
+	require.NotEqual(t, first.Addr, replacement.Addr)
+}
+
+func TestManagerKeepsOnePeerPerGroup(t *testing.T) {
+	nodeA, _ := listen(t, "127.1.0.1")
+	nodeB, _ := listen(t, "127.1.0.2")
+
+	source := &fakeSource{addrs: []addrman.Address{nodeA, nodeB}}
+	manager := newManager(source, 2)
+	run(t, manager)
+
+	require.Equal(t, connmgr.EventConnected, nextEvent(t, manager.Events()).Type)
+
+	select {
+	case event := <-manager.Events():
+		require.FailNow(t, "unexpected event", event.String())
+	case <-time.After(300 * time.Millisecond):
+	}
+	require.Len(t, manager.Peers(), 1)
+}
+
+func TestManagerBacksOffFailingPeers(t *testing.T) {
+	dead, lst := listen(t, "127.1.0.1")
+	lst.Close()
+
+	source := &fakeSource{addrs: []addrman.Address{dead}}
+	manager := newManager(source, 1)
+	run(t, manager)
+
+	event := nextEvent(t, manager.Events())
+	require.Equal(t, connmgr.EventHandshakeFailed, event.Type)
+	require.Error(t, event.Err)
+
+	// the only candidate is waiting its backoff, so it is not dialed again
+	select {
+	case event := <-manager.Events():
+		require.FailNow(t, "unexpected event", event.String())
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestManagerServeErrorDisconnects(t *testing.T) {
+	node, _ := listen(t, "127.1.0.1")
+	misbehaved := errors.New("misbehaved")
+
+	manager := connmgr.New(connmgr.Config{
+		Target:           1,
+		Addresses:        &fakeSource{addrs: []addrman.Address{node}},
+		Dial:             tcpDial,
+		Version:          testVersion,
+		HandshakeTimeout: time.Second,
+		Serve: func(context.Context, *connmgr.Peer) error {
+			return misbehaved
+		},
+	})
+	run(t, manager)
+
+	require.Equal(t, connmgr.EventConnected, nextEvent(t, manager.Events()).Type)
+
+	event := nextEvent(t, manager.Events())
+	require.Equal(t, connmgr.EventDisconnected, event.Type)
+	require.ErrorIs(t, event.Err, misbehaved)
+}
+
+func TestManagerRequiresServices(t *testing.T) {
+	node, _ := listen(t, "127.1.0.1")
+	source := &fakeSource{addrs: []addrman.Address{node}}
+
+	manager := connmgr.New(connmgr.Config{
+		Target:           1,
+		Addresses:        source,
+		Dial:             tcpDial,
+		Version:          testVersion,
+		HandshakeTimeout: time.Second,
+		Services:         messages.NodeBloom,
+	})
+	run(t, manager)
+
+	// the test nodes only advertise NodeNetwork
+	event := nextEvent(t, manager.Events())
+	require.Equal(t, connmgr.EventHandshakeFailed, event.Type)
+	require.ErrorIs(t, event.Err, connmgr.ErrMissingServices)
+	require.Empty(t, manager.Peers())
+
+	source.mu.Lock()
+	require.Equal(t, messages.NodeNetwork, source.good[node.String()])
+	source.mu.Unlock()
+}
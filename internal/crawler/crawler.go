@@ -0,0 +1,284 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/handshake"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+)
+
+const (
+	DefaultConcurrency = 16
+	DefaultDialTimeout = 10 * time.Second
+	DefaultAddrTimeout = 30 * time.Second
+)
+
+// Result is the outcome of visiting a single peer
+type Result struct {
+	Addr        string `json:"addr"`
+	Reachable   bool   `json:"reachable"`
+	Error       string `json:"error,omitempty"`
+	Version     uint32 `json:"version,omitempty"`
+	Services    uint64 `json:"services,omitempty"`
+	UserAgent   string `json:"user_agent,omitempty"`
+	StartHeight uint32 `json:"start_height,omitempty"`
+	Relay       bool   `json:"relay,omitempty"`
+	// Learned is how many addresses the peer sent us
+	Learned int `json:"learned"`
+
+	// Addrs are the addresses learned from the peer, not part of the output
+	Addrs []string `json:"-"`
+}
+
+type DialFunc func(ctx context.Context, addr string) (*network.Stream, error)
+
+type Config struct {
+	// Dial opens the connection to the peer address (host:port)
+	Dial DialFunc
+	// Version builds the version message we send to the peer
+	Version func(addr string) *messages.Version
+	// Concurrency is the maximum number of peers visited at the same time
+	Concurrency int
+	// MaxPeers stops the crawl after visiting this many peers, zero means no limit
+	MaxPeers int
+	// HandshakeTimeout is the time to establish the handshake with each peer
+	HandshakeTimeout time.Duration
+	// AddrTimeout is the time we wait for the peer to answer our getaddr
+	AddrTimeout time.Duration
+	// AllowUnroutable enqueues learned addresses that are not publicly
+	// routable (e.g loopback and private networks), useful for local networks
+	AllowUnroutable bool
+	// AllowOnion enqueues tor v3 addresses learned through addrv2,
+	// Dial must then reach them through a proxy
+	AllowOnion bool
+}
+
+// Crawler walks the network starting from seed peers, every reachable
+// peer is asked (getaddr) for the addresses it knows, which are then visited
+type Crawler struct {
+	cfg    Config
+	nonces *handshake.Nonces
+}
+
+func New(cfg Config) *Crawler {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = DefaultConcurrency
+	}
+
+	if cfg.AddrTimeout == 0 {
+		cfg.AddrTimeout = DefaultAddrTimeout
+	}
+
+	return &Crawler{cfg: cfg, nonces: handshake.NewNonces()}
+}
+
+// Run crawls the network until there is no address left to visit, MaxPeers
+// is reached or the context is done, it returns the result of every visited peer
+func (c *Crawler) Run(ctx context.Context, seeds []string) []Result {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	visited := make(map[string]struct{})
+	pending := make([]string, 0, len(seeds))
+	enqueue := func(addrs []string) {
+		for _, addr := range addrs {
+			if _, ok := visited[addr]; ok {
+				continue
+			}
+			visited[addr] = struct{}{}
+			pending = append(pending, addr)
+		}
+	}
+	enqueue(seeds)
+
+	jobs := make(chan string)
+	results := make(chan Result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for addr := range jobs {
+				results <- c.visit(ctx, addr)
+			}
+		}()
+	}
+
+	var (
+		crawled    []Result
+		active     int
+		dispatched int
+	)
+
+	for {
+		canDispatch := len(pending) > 0 && ctx.Err() == nil &&
+			(c.cfg.MaxPeers == 0 || dispatched < c.cfg.MaxPeers)
+
+		if !canDispatch && active == 0 {
+			break
+		}
+
+		// a nil channel blocks forever, so nothing is
+		// dispatched while there is no address to visit
+		var next chan string
+		var addr string
+		if canDispatch {
+			next, addr = jobs, pending[0]
+		}
+
+		select {
+		case next <- addr:
+			pending = pending[1:]
+			active++
+			dispatched++
+		case result := <-results:
+			active--
+			crawled = append(crawled, result)
+			enqueue(result.Addrs)
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+	return crawled
+}
+
+func (c *Crawler) visit(ctx context.Context, addr string) Result {
+	result := Result{Addr: addr}
+
+	stream, err := c.cfg.Dial(ctx, addr)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer stream.Close()
+
+	peerInfo, err := handshake.Outbound(stream, handshake.Config{
+		Version: c.cfg.Version(addr),
+		Timeout: c.cfg.HandshakeTimeout,
+		Nonces:  c.nonces,
+	})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Reachable = true
+	result.Version = peerInfo.Version
+	result.Services = peerInfo.Services
+	result.UserAgent = peerInfo.UserAgent
+	result.StartHeight = peerInfo.StartHeight
+	result.Relay = peerInfo.Relay
+
+	result.Addrs, err = c.requestAddrs(stream)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.Learned = len(result.Addrs)
+
+	return result
+}
+
+// requestAddrs sends a getaddr and collects the addresses the peer
+// sends back, peers usually announce their own address right after the
+// handshake, so we wait until an addr message with more than one address
+func (c *Crawler) requestAddrs(stream *network.Stream) ([]string, error) {
+	err := stream.SendMessage(messages.CmdGetAddr, messages.EmptyPayload{})
+	if err != nil {
+		return nil, fmt.Errorf("while sending getaddr: %w", err)
+	}
+
+	err = stream.SetDeadline(time.Now().Add(c.cfg.AddrTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("while setting getaddr deadline: %w", err)
+	}
+
+	var learned []string
+	for {
+		msg, err := stream.ReadMessage()
+		if err != nil {
+			// peers that do not answer getaddr are not an error
+			if network.IsTimeout(err) {
+				return learned, nil
+			}
+			return learned, fmt.Errorf("while waiting addresses: %w", err)
+		}
+
+		var (
+			addrs    []string
+			received int
+		)
+
+		switch payload := msg.Payload.(type) {
+		case *messages.Ping:
+			err = stream.SendMessage(messages.CmdPong, &messages.Pong{Nonce: payload.Nonce})
+			if err != nil {
+				return learned, fmt.Errorf("while answering ping: %w", err)
+			}
+			continue
+		case *messages.Addr:
+			received = len(payload.Addresses)
+			for idx := range payload.Addresses {
+				addrs = c.appendDialable(addrs, payload.Addresses[idx].NetworkAddress)
+			}
+		case *messages.AddrV2:
+			received = len(payload.Addresses)
+			for idx := range payload.Addresses {
+				entry := &payload.Addresses[idx]
+				if netaddr, ok := entry.NetworkAddress(); ok {
+					addrs = c.appendDialable(addrs, netaddr.NetworkAddress)
+				} else if c.cfg.AllowOnion && entry.NetworkID == messages.NetTorV3 && entry.Port != 0 {
+					addrs = append(addrs, net.JoinHostPort(entry.Host(), strconv.Itoa(int(entry.Port))))
+				}
+			}
+		default:
+			continue
+		}
+
+		learned = append(learned, addrs...)
+		if received > 1 {
+			return learned, nil
+		}
+	}
+}
+
+func (c *Crawler) appendDialable(addrs []string, netaddr messages.NetworkAddress) []string {
+	if !netaddr.IsValid() || netaddr.Port == 0 {
+		return addrs
+	}
+
+	if !c.cfg.AllowUnroutable && !netaddr.IsRoutable() {
+		return addrs
+	}
+
+	return append(addrs, netip.AddrPortFrom(netaddr.IpV6V4, netaddr.Port).String())
+}
+
+// TCPDialer returns a DialFunc dialing plain tcp connections,
+// the streams use the given magic to frame messages
+func TCPDialer(magic messages.Magic, timeout time.Duration) DialFunc {
+	return Dialer(magic, timeout, &network.Router{})
+}
+
+// Dialer returns a DialFunc opening the connections through via,
+// e.g a SOCKS5 proxy, the streams use the given magic to frame messages
+func Dialer(magic messages.Magic, timeout time.Duration, via network.ContextDialer) DialFunc {
+	if timeout == 0 {
+		timeout = DefaultDialTimeout
+	}
+
+	return func(ctx context.Context, addr string) (*network.Stream, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		return network.DialVia(ctx, via, addr, network.WithMagic(magic))
+	}
+}
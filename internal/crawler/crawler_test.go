@@ -0,0 +1,237 @@
+package crawler_test
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/crawler"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/handshake"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+	"github.com/stretchr/testify/require"
+)
+
+func testVersion(userAgent string) *messages.Version {
+	return messages.NewVersion(
+		messages.WithNumber(70015),
+		messages.WithServices(messages.NodeNetwork),
+		messages.WithAddrRecv("127.0.0.1", 8333, 1),
+		messages.WithAddrFrom("0.0.0.0", 8080, 1),
+		messages.WithNonce(uint64(time.Now().UnixNano())),
+		messages.WithUserAgent(userAgent),
+		messages.WithStartHeight(800_000),
+	)
+}
+
+// fakeNode accepts connections, handshakes and answers getaddr with its
+// known addresses, it first announces itself like bitcoin core does
+type fakeNode struct {
+	lst   net.Listener
+	known []string
+	// knownV2 is sent through addrv2 instead of known when set
+	knownV2 []messages.AddrV2Entry
+}
+
+func newFakeNode(t *testing.T) *fakeNode {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { lst.Close() })
+	return &fakeNode{lst: lst}
+}
+
+func (f *fakeNode) addr() string {
+	return f.lst.Addr().String()
+}
+
+func (f *fakeNode) serve(t *testing.T, userAgent string) {
+	go func() {
+		for {
+			conn, err := f.lst.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				stream := network.NewStream(conn)
+				defer stream.Close()
+
+				_, err := handshake.Inbound(stream, handshake.Config{
+					Version: testVersion(userAgent),
+					Timeout: time.Second,
+				})
+				if err != nil {
+					return
+				}
+
+				for {
+					msg, err := stream.ReadMessage()
+					if err != nil {
+						return
+					}
+
+					if string(msg.Command) != messages.CmdGetAddr {
+						continue
+					}
+
+					self := &messages.Addr{Addresses: []messages.TimestampedNetworkAddress{toNetAddr(t, f.addr())}}
+					if err := stream.SendMessage(messages.CmdAddr, self); err != nil {
+						return
+					}
+
+					if len(f.knownV2) > 0 {
+						if err := stream.SendMessage(messages.CmdAddrV2, &messages.AddrV2{Addresses: f.knownV2}); err != nil {
+							return
+						}
+						continue
+					}
+
+					known := &messages.Addr{}
+					for _, addr := range f.known {
+						known.Addresses = append(known.Addresses, toNetAddr(t, addr))
+					}
+					if err := stream.SendMessage(messages.CmdAddr, known); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+}
+
+func toNetAddr(t *testing.T, addr string) messages.TimestampedNetworkAddress {
+	addrPort, err := netip.ParseAddrPort(addr)
+	require.NoError(t, err)
+
+	return messages.TimestampedNetworkAddress{
+		Time: uint32(time.Now().Unix()),
+		NetworkAddress: messages.NetworkAddress{
+			Services: messages.NodeNetwork,
+			IpV6V4:   addrPort.Addr(),
+			Port:     addrPort.Port(),
+		},
+	}
+}
+
+func TestCrawlerWalksTheNetwork(t *testing.T) {
+	seed, nodeB, nodeC := newFakeNode(t), newFakeNode(t), newFakeNode(t)
+
+	// an address nobody listens on
+	dead := newFakeNode(t)
+	deadAddr := dead.addr()
+	dead.lst.Close()
+
+	seed.known = []string{nodeB.addr(), deadAddr}
+	nodeB.known = []string{nodeC.addr(), seed.addr()}
+	nodeC.known = []string{seed.addr(), nodeB.addr()}
+
+	seed.serve(t, "/seed/")
+	nodeB.serve(t, "/node-b/")
+	nodeC.serve(t, "/node-c/")
+
+	crawl := crawler.New(crawler.Config{
+		Dial:             crawler.TCPDialer(messages.MagicMain, time.Second),
+		Version:          func(string) *messages.Version { return testVersion("/crawler/") },
+		Concurrency:      2,
+		HandshakeTimeout: time.Second,
+		AddrTimeout:      time.Second,
+		AllowUnroutable:  true,
+	})
+
+	results := crawl.Run(context.Background(), []string{seed.addr()})
+	require.Len(t, results, 4)
+
+	sort.Slice(results, func(i, j int) bool { return results[i].UserAgent < results[j].UserAgent })
+
+	// the unreachable peer has no user agent so it comes first
+	require.Equal(t, deadAddr, results[0].Addr)
+	require.False(t, results[0].Reachable)
+	require.NotEmpty(t, results[0].Error)
+
+	expected := []string{"/node-b/", "/node-c/", "/seed/"}
+	for idx, userAgent := range expected {
+		result := results[idx+1]
+		require.True(t, result.Reachable)
+		require.Empty(t, result.Error)
+		require.Equal(t, userAgent, result.UserAgent)
+		require.Equal(t, uint32(70015), result.Version)
+		require.Equal(t, uint32(800_000), result.StartHeight)
+		require.Equal(t, 3, result.Learned)
+	}
+}
+
+func TestCrawlerRespectsMaxPeers(t *testing.T) {
+	seed, nodeB := newFakeNode(t), newFakeNode(t)
+	seed.known = []string{nodeB.addr()}
+	seed.serve(t, "/seed/")
+	nodeB.serve(t, "/node-b/")
+
+	crawl := crawler.New(crawler.Config{
+		Dial:             crawler.TCPDialer(messages.MagicMain, time.Second),
+		Version:          func(string) *messages.Version { return testVersion("/crawler/") },
+		MaxPeers:         1,
+		HandshakeTimeout: time.Second,
+		AddrTimeout:      200 * time.Millisecond,
+		AllowUnroutable:  true,
+	})
+
+	results := crawl.Run(context.Background(), []string{seed.addr()})
+	require.Len(t, results, 1)
+	require.Equal(t, "/seed/", results[0].UserAgent)
+}
+
+func TestCrawlerSkipsUnroutableAddresses(t *testing.T) {
+	seed, nodeB := newFakeNode(t), newFakeNode(t)
+	seed.known = []string{nodeB.addr()}
+	seed.serve(t, "/seed/")
+	nodeB.serve(t, "/node-b/")
+
+	crawl := crawler.New(crawler.Config{
+		Dial:             crawler.TCPDialer(messages.MagicMain, time.Second),
+		Version:          func(string) *messages.Version { return testVersion("/crawler/") },
+		HandshakeTimeout: time.Second,
+		AddrTimeout:      200 * time.Millisecond,
+	})
+
+	// loopback addresses learned from the seed are not visited, the
+	// seed only knows one address so the crawler waits the addr timeout
+	results := crawl.Run(context.Background(), []string{seed.addr()})
+	require.Len(t, results, 1)
+	require.Zero(t, results[0].Learned)
+}
+
+func TestCrawlerEnqueuesOnionAddresses(t *testing.T) {
+	pubkey, err := hex.DecodeString("79bcc625184b05194975c28b66b66b0469f7f6556fb1ac3189a79b40dda32f1f")
+	require.NoError(t, err)
+
+	seed := newFakeNode(t)
+	seed.knownV2 = []messages.AddrV2Entry{
+		{Time: uint32(time.Now().Unix()), NetworkID: messages.NetTorV3, Addr: pubkey, Port: 8333},
+		{Time: uint32(time.Now().Unix()), NetworkID: messages.NetTorV3, Addr: pubkey, Port: 0},
+	}
+	seed.serve(t, "/seed/")
+
+	crawl := crawler.New(crawler.Config{
+		Dial:             crawler.TCPDialer(messages.MagicMain, time.Second),
+		Version:          func(string) *messages.Version { return testVersion("/crawler/") },
+		HandshakeTimeout: time.Second,
+		AddrTimeout:      time.Second,
+		AllowUnroutable:  true,
+		AllowOnion:       true,
+	})
+
+	results := crawl.Run(context.Background(), []string{seed.addr()})
+	require.Len(t, results, 2)
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Addr < results[j].Addr })
+
+	// the onion address is visited but, without a proxy, it is not reachable
+	onion := results[1]
+	require.Equal(t, "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion:8333", onion.Addr)
+	require.False(t, onion.Reachable)
+	require.Contains(t, onion.Error, network.ErrOnionRequiresProxy.Error())
+}
@@ -0,0 +1,142 @@
+package dnsseed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/chaincfg"
+)
+
+var ErrNoAddresses = errors.New("no address resolved from dns seeds")
+
+// Resolver resolves a hostname into its addresses, *net.Resolver
+// satisfies it, tests can use an in-memory stand-in
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Seeder bootstraps the peer discovery by resolving the
+// DNS seeds of a network into dialable addresses
+type Seeder struct {
+	params   *chaincfg.Params
+	resolver Resolver
+}
+
+// New returns a seeder for the network, if resolver is nil the system resolver is used
+func New(params *chaincfg.Params, resolver Resolver) *Seeder {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &Seeder{params: params, resolver: resolver}
+}
+
+// ServiceBitsHost returns the seed subdomain that only answers with peers
+// advertising the given services, e.g x9.seed.bitcoin.sipa.be for NODE_NETWORK
+// and NODE_WITNESS, if services is zero the seed itself is returned
+func ServiceBitsHost(seed string, services uint64) string {
+	if services == 0 {
+		return seed
+	}
+	return fmt.Sprintf("x%x.%s", services, seed)
+}
+
+// Resolve queries every DNS seed of the network concurrently, when services is
+// not zero the service bit filtered subdomain is tried first, falling back to the
+// plain seed since not every seed supports filtering. The addresses are
+// returned shuffled, with duplicates removed, using the network default port
+func (s *Seeder) Resolve(ctx context.Context, services uint64) ([]netip.AddrPort, error) {
+	if len(s.params.DNSSeeds) == 0 {
+		return nil, fmt.Errorf("%w: %s has no dns seeds", ErrNoAddresses, s.params.Name)
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		seen     = make(map[netip.Addr]struct{})
+		resolved []netip.AddrPort
+		errs     []error
+	)
+
+	for _, seed := range s.params.DNSSeeds {
+		wg.Add(1)
+		go func(seed string) {
+			defer wg.Done()
+
+			addrs, err := s.lookupSeed(ctx, seed, services)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+
+			for _, addr := range addrs {
+				if _, ok := seen[addr]; ok {
+					continue
+				}
+				seen[addr] = struct{}{}
+				resolved = append(resolved, netip.AddrPortFrom(addr, s.params.DefaultPort))
+			}
+		}(seed)
+	}
+	wg.Wait()
+
+	if len(resolved) == 0 {
+		return nil, fmt.Errorf("%w: %w", ErrNoAddresses, errors.Join(errs...))
+	}
+
+	rand.Shuffle(len(resolved), func(i, j int) {
+		resolved[i], resolved[j] = resolved[j], resolved[i]
+	})
+
+	return resolved, nil
+}
+
+// Hosts returns the DNS seeds as dialable host:port addresses, meant to be
+// dialed through a proxy resolving them, so the seeds are not looked up
+// with the local resolver. The plain seeds are used, without the service
+// bits subdomain, since there is no falling back when it is not supported
+func (s *Seeder) Hosts() []string {
+	hosts := make([]string, 0, len(s.params.DNSSeeds))
+	for _, seed := range s.params.DNSSeeds {
+		hosts = append(hosts, net.JoinHostPort(seed, strconv.Itoa(int(s.params.DefaultPort))))
+	}
+	return hosts
+}
+
+func (s *Seeder) lookupSeed(ctx context.Context, seed string, services uint64) ([]netip.Addr, error) {
+	if services != 0 {
+		addrs, err := s.lookup(ctx, ServiceBitsHost(seed, services))
+		if err == nil && len(addrs) > 0 {
+			return addrs, nil
+		}
+	}
+
+	return s.lookup(ctx, seed)
+}
+
+func (s *Seeder) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	hosts, err := s.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("while resolving %s: %w", host, err)
+	}
+
+	addrs := make([]netip.Addr, 0, len(hosts))
+	for _, host := range hosts {
+		addr, err := netip.ParseAddr(host)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr.Unmap())
+	}
+
+	return addrs, nil
+}
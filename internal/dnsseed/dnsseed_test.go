@@ -0,0 +1,86 @@
+package dnsseed_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sort"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/chaincfg"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/dnsseed"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+// localResolver is a stand-in for the system resolver
+type localResolver map[string][]string
+
+func (l localResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	addrs, ok := l[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return addrs, nil
+}
+
+var testParams = &chaincfg.Params{
+	Name:        "testnet",
+	DefaultPort: 18333,
+	DNSSeeds:    []string{"seed.one.test", "seed.two.test", "seed.dead.test"},
+}
+
+func TestResolveWithServiceBits(t *testing.T) {
+	resolver := localResolver{
+		// seed.one supports service bits filtering
+		"x9.seed.one.test": {"1.1.1.1", "2.2.2.2"},
+		"seed.one.test":    {"9.9.9.9"},
+		// seed.two does not, so its plain records are used
+		"seed.two.test": {"2.2.2.2", "2001:db8::1", "not-an-ip"},
+	}
+
+	seeder := dnsseed.New(testParams, resolver)
+	addrs, err := seeder.Resolve(context.Background(), messages.NodeNetwork|messages.NodeWitness)
+	require.NoError(t, err)
+
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Addr().Less(addrs[j].Addr()) })
+	require.Equal(t, []netip.AddrPort{
+		netip.MustParseAddrPort("1.1.1.1:18333"),
+		netip.MustParseAddrPort("2.2.2.2:18333"),
+		netip.MustParseAddrPort("[2001:db8::1]:18333"),
+	}, addrs)
+}
+
+func TestResolveWithoutServiceBits(t *testing.T) {
+	resolver := localResolver{
+		"x9.seed.one.test": {"1.1.1.1"},
+		"seed.one.test":    {"9.9.9.9"},
+	}
+
+	seeder := dnsseed.New(testParams, resolver)
+	addrs, err := seeder.Resolve(context.Background(), 0)
+	require.NoError(t, err)
+	require.Equal(t, []netip.AddrPort{netip.MustParseAddrPort("9.9.9.9:18333")}, addrs)
+}
+
+func TestResolveFailures(t *testing.T) {
+	seeder := dnsseed.New(testParams, localResolver{})
+	_, err := seeder.Resolve(context.Background(), 0)
+	require.ErrorIs(t, err, dnsseed.ErrNoAddresses)
+
+	seeder = dnsseed.New(&chaincfg.RegTest, localResolver{})
+	_, err = seeder.Resolve(context.Background(), 0)
+	require.ErrorIs(t, err, dnsseed.ErrNoAddresses)
+}
+
+func TestHosts(t *testing.T) {
+	// nothing is resolved, the hosts are left to the proxy
+	seeder := dnsseed.New(testParams, localResolver{})
+	require.Equal(t, []string{"seed.one.test:18333", "seed.two.test:18333", "seed.dead.test:18333"}, seeder.Hosts())
+}
+
+func TestServiceBitsHost(t *testing.T) {
+	require.Equal(t, "x9.seed.bitcoin.sipa.be", dnsseed.ServiceBitsHost("seed.bitcoin.sipa.be", 9))
+	require.Equal(t, "xd.seed.bitcoin.sipa.be", dnsseed.ServiceBitsHost("seed.bitcoin.sipa.be", 13))
+	require.Equal(t, "seed.bitcoin.sipa.be", dnsseed.ServiceBitsHost("seed.bitcoin.sipa.be", 0))
+}
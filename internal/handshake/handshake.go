@@ -0,0 +1,343 @@
+package handshake
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+)
+
+var (
+	ErrUnexpectedMessage = errors.New("unexpected message before handshake")
+	ErrDuplicatedMessage = errors.New("duplicated handshake message")
+	ErrHandshakeTimeout  = errors.New("handshake timeout")
+)
+
+const DefaultTimeout = time.Minute
+
+type State int
+
+const (
+	AwaitingVersion State = iota
+	AwaitingVerAck
+	Established
+)
+
+func (s State) String() string {
+	switch s {
+	case AwaitingVersion:
+		return "AwaitingVersion"
+	case AwaitingVerAck:
+		return "AwaitingVerAck"
+	case Established:
+		return "Established"
+	default:
+		return "undefined"
+	}
+}
+
+// PeerInfo is what was negotiated with the remote peer during the handshake
+type PeerInfo struct {
+	Addr        net.Addr
+	Inbound     bool
+	Version     uint32
+	Services    uint64
+	UserAgent   string
+	StartHeight uint32
+	Relay       bool
+
+	// SendAddrV2 is true when the remote asked (BIP155) to
+	// receive addresses through addrv2 instead of addr
+	SendAddrV2 bool
+	// WtxidRelay is true when both sides sent wtxidrelay (BIP339), transactions
+	// are then announced and requested by their wtxid
+	WtxidRelay bool
+
+	// sendHeaders and feeFilter are negotiated after the handshake, the
+	// remote can send them at any time so they are set by HandleMessage
+	sendHeaders atomic.Bool
+	feeFilter   atomic.Int64
+}
+
+func (p *PeerInfo) String() string {
+	return fmt.Sprintf("[addr=%s] [inbound=%v] [version=%d] [services=%d] [user-agent=%s] [start-height=%d] [relay=%v] [addrv2=%v] [wtxidrelay=%v]",
+		p.Addr, p.Inbound, p.Version, p.Services, p.UserAgent, p.StartHeight, p.Relay, p.SendAddrV2, p.WtxidRelay)
+}
+
+// SendHeaders tells if the remote asked (BIP130) new blocks
+// to be announced through headers instead of inv
+func (p *PeerInfo) SendHeaders() bool {
+	return p.sendHeaders.Load()
+}
+
+// FeeFilter is the minimum fee rate, in satoshis per kilobyte, of the
+// transactions the remote wants to be announced (BIP133), zero if none
+func (p *PeerInfo) FeeFilter() int64 {
+	return p.feeFilter.Load()
+}
+
+// HandleMessage keeps the negotiation messages the remote sends once
+// the handshake is established, it returns true if the message was one of
+// them. Messages only allowed during the handshake result in an error
+func (p *PeerInfo) HandleMessage(msg *messages.Message) (bool, error) {
+	switch string(msg.Command) {
+	case messages.CmdSendHeaders:
+		p.sendHeaders.Store(true)
+	case messages.CmdFeeFilter:
+		feeFilter, ok := msg.Payload.(*messages.FeeFilter)
+		if !ok {
+			return false, fmt.Errorf("%w: %s without fee rate", ErrUnexpectedMessage, messages.CmdFeeFilter)
+		}
+		p.feeFilter.Store(feeFilter.FeeRate)
+	case messages.CmdVersion, messages.CmdVerAck:
+		return false, fmt.Errorf("%w: %s", ErrDuplicatedMessage, string(msg.Command))
+	case messages.CmdSendAddrV2, messages.CmdWtxidRelay:
+		return false, fmt.Errorf("%w: %s after the handshake", ErrUnexpectedMessage, string(msg.Command))
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// Machine tracks the handshake messages received from the remote, the version
+// and verack can arrive in any order for outbound connections, since we are the
+// first to send our version, while inbound connections must receive the version first.
+// Feature negotiation messages (e.g sendaddrv2, wtxidrelay) are only accepted before
+// the verack, the ones sent after it (e.g sendheaders) are kept by PeerInfo.HandleMessage
+// check: https://en.bitcoin.it/wiki/Version_Handshake
+type Machine struct {
+	inbound       bool
+	remoteVersion *messages.Version
+	verackRecv    bool
+	sendAddrV2    bool
+	wtxidRelay    bool
+}
+
+func NewMachine(inbound bool) *Machine {
+	return &Machine{inbound: inbound}
+}
+
+func (m *Machine) State() State {
+	switch {
+	case m.remoteVersion == nil:
+		return AwaitingVersion
+	case !m.verackRecv:
+		return AwaitingVerAck
+	default:
+		return Established
+	}
+}
+
+// Handle moves the machine forward, any message that
+// is not legal in the current state results in an error
+func (m *Machine) Handle(msg *messages.Message) error {
+	if m.State() == Established {
+		return fmt.Errorf("%w: handshake already established, got: %s",
+			ErrDuplicatedMessage, string(msg.Command))
+	}
+
+	if payload, ok := msg.Payload.(*messages.Version); ok {
+		if m.remoteVersion != nil {
+			return fmt.Errorf("%w: %s", ErrDuplicatedMessage, messages.CmdVersion)
+		}
+		m.remoteVersion = payload
+		return nil
+	}
+
+	switch string(msg.Command) {
+	case messages.CmdVerAck:
+		if m.verackRecv {
+			return fmt.Errorf("%w: %s", ErrDuplicatedMessage, messages.CmdVerAck)
+		}
+
+		// an inbound remote can't acknowledge a version we did not send
+		if m.inbound && m.remoteVersion == nil {
+			return fmt.Errorf("%w: %s while %s", ErrUnexpectedMessage, messages.CmdVerAck, m.State())
+		}
+		m.verackRecv = true
+	case messages.CmdSendAddrV2:
+		// BIP155: sent after the version and before the verack
+		if m.remoteVersion == nil {
+			return fmt.Errorf("%w: %s while %s", ErrUnexpectedMessage, messages.CmdSendAddrV2, m.State())
+		}
+		m.sendAddrV2 = true
+	case messages.CmdWtxidRelay:
+		// BIP339: sent after the version and before the verack
+		if m.remoteVersion == nil {
+			return fmt.Errorf("%w: %s while %s", ErrUnexpectedMessage, messages.CmdWtxidRelay, m.State())
+		}
+		m.wtxidRelay = true
+	default:
+		return fmt.Errorf("%w: %s while %s", ErrUnexpectedMessage, string(msg.Command), m.State())
+	}
+
+	return nil
+}
+
+// PeerInfo returns the negotiated information, it is only available after
+// receiving the remote's version. WtxidRelay tells if the remote sent wtxidrelay,
+// it is only negotiated if we sent it too
+func (m *Machine) PeerInfo() *PeerInfo {
+	if m.remoteVersion == nil {
+		return nil
+	}
+
+	return &PeerInfo{
+		Inbound:     m.inbound,
+		Version:     m.remoteVersion.Number,
+		Services:    m.remoteVersion.Services,
+		UserAgent:   m.remoteVersion.UserAgent,
+		StartHeight: m.remoteVersion.StartHeight,
+		Relay:       m.remoteVersion.Relay,
+		SendAddrV2:  m.sendAddrV2,
+		WtxidRelay:  m.wtxidRelay,
+	}
+}
+
+type Config struct {
+	// Version is the version message we send to the remote
+	Version *messages.Version
+	// Timeout is the maximum time to establish the handshake,
+	// if zero the DefaultTimeout is used
+	Timeout time.Duration
+	// Nonces, when set, tracks the nonces of outbound handshakes
+	// so inbound connections from ourselves are dropped
+	Nonces *Nonces
+	// SendHeaders asks the remote (BIP130) to announce new
+	// blocks through headers instead of inv
+	SendHeaders bool
+	// FeeFilter, when positive, asks the remote (BIP133) to not announce
+	// transactions paying less than it, in satoshis per kilobyte
+	FeeFilter int64
+}
+
+// Outbound performs the handshake as the connection initiator, we send our
+// version and wait for the remote's version and verack, in any order
+func Outbound(stream *network.Stream, cfg Config) (*PeerInfo, error) {
+	return run(stream, cfg, false)
+}
+
+// Inbound performs the handshake as the connection receiver, we wait the
+// remote's version, answer with our version and verack and wait for the remote's verack
+func Inbound(stream *network.Stream, cfg Config) (*PeerInfo, error) {
+	return run(stream, cfg, true)
+}
+
+func run(stream *network.Stream, cfg Config, inbound bool) (*PeerInfo, error) {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	err := stream.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, fmt.Errorf("while setting handshake deadline: %w", err)
+	}
+
+	peerInfo, err := establish(stream, cfg, inbound)
+	if err != nil {
+		if network.IsTimeout(err) {
+			return nil, fmt.Errorf("%w: %s", ErrHandshakeTimeout, err.Error())
+		}
+		return nil, err
+	}
+
+	// clear the deadline, from now on the connection is long lived
+	err = stream.SetDeadline(time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("while clearing handshake deadline: %w", err)
+	}
+
+	return peerInfo, nil
+}
+
+func establish(stream *network.Stream, cfg Config, inbound bool) (*PeerInfo, error) {
+	if !inbound {
+		if cfg.Nonces != nil {
+			cfg.Nonces.Add(cfg.Version.Nonce)
+			defer cfg.Nonces.Remove(cfg.Version.Nonce)
+		}
+
+		err := stream.SendMessage(messages.CmdVersion, cfg.Version)
+		if err != nil {
+			return nil, fmt.Errorf("while sending our version: %w", err)
+		}
+	}
+
+	var sentWtxidRelay bool
+	machine := NewMachine(inbound)
+	for machine.State() != Established {
+		msg, err := stream.ReadMessage()
+		if err != nil {
+			return nil, fmt.Errorf("while waiting %s: %w", machine.State(), err)
+		}
+
+		err = machine.Handle(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		remoteVersion, ok := msg.Payload.(*messages.Version)
+		if !ok {
+			continue
+		}
+
+		if inbound && cfg.Nonces != nil && cfg.Nonces.Contains(remoteVersion.Nonce) {
+			return nil, fmt.Errorf("%w: version nonce %d is ours", ErrSelfConnection, remoteVersion.Nonce)
+		}
+
+		if inbound {
+			err = stream.SendMessage(messages.CmdVersion, cfg.Version)
+			if err != nil {
+				return nil, fmt.Errorf("while sending our version: %w", err)
+			}
+		}
+
+		// BIP339 and BIP155 negotiation happen between the version and the verack
+		if min(cfg.Version.Number, remoteVersion.Number) >= messages.WtxidRelayMinVersion {
+			err = stream.SendMessage(messages.CmdWtxidRelay, messages.EmptyPayload{})
+			if err != nil {
+				return nil, fmt.Errorf("while sending wtxidrelay: %w", err)
+			}
+			sentWtxidRelay = true
+		}
+
+		if min(cfg.Version.Number, remoteVersion.Number) >= messages.AddrV2MinVersion {
+			err = stream.SendMessage(messages.CmdSendAddrV2, messages.EmptyPayload{})
+			if err != nil {
+				return nil, fmt.Errorf("while sending sendaddrv2: %w", err)
+			}
+		}
+
+		// we should send a verack since we received the remote's version
+		err = stream.SendMessage(messages.CmdVerAck, messages.EmptyPayload{})
+		if err != nil {
+			return nil, fmt.Errorf("while sending verack: %w", err)
+		}
+	}
+
+	peerInfo := machine.PeerInfo()
+	peerInfo.Addr = stream.RemoteAddr()
+	peerInfo.WtxidRelay = peerInfo.WtxidRelay && sentWtxidRelay
+
+	// BIP130 and BIP133 negotiation happen once the handshake is established
+	if cfg.SendHeaders && peerInfo.Version >= messages.SendHeadersMinVersion {
+		err := stream.SendMessage(messages.CmdSendHeaders, messages.EmptyPayload{})
+		if err != nil {
+			return nil, fmt.Errorf("while sending sendheaders: %w", err)
+		}
+	}
+
+	if cfg.FeeFilter > 0 && peerInfo.Version >= messages.FeeFilterMinVersion {
+		err := stream.SendMessage(messages.CmdFeeFilter, &messages.FeeFilter{FeeRate: cfg.FeeFilter})
+		if err != nil {
+			return nil, fmt.Errorf("while sending feefilter: %w", err)
+		}
+	}
+
+	return peerInfo, nil
+}
@@ -0,0 +1,353 @@
+package handshake_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/handshake"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+	"github.com/stretchr/testify/require"
+)
+
+func testVersion(userAgent string, nonce uint64) *messages.Version {
+	return testVersionNumber(60002, userAgent, nonce)
+}
+
+func testVersionNumber(number uint32, userAgent string, nonce uint64) *messages.Version {
+	return messages.NewVersion(
+		messages.WithNumber(number),
+		messages.WithServices(messages.NodeNetwork),
+		messages.WithAddrRecv("127.0.0.1", 8333, 1),
+		messages.WithAddrFrom("0.0.0.0", 8080, 1),
+		messages.WithNonce(nonce),
+		messages.WithUserAgent(userAgent),
+		messages.WithStartHeight(100),
+	)
+}
+
+// pipe returns both sides of a loopback tcp connection, net.Pipe is not used
+// since its writes are unbuffered and both sides write at the same time
+func pipe(t *testing.T) (*network.Stream, *network.Stream) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lst.Close()
+
+	local, err := net.Dial("tcp", lst.Addr().String())
+	require.NoError(t, err)
+
+	remote, err := lst.Accept()
+	require.NoError(t, err)
+
+	localStream, remoteStream := network.NewStream(local), network.NewStream(remote)
+	t.Cleanup(func() {
+		localStream.Close()
+		remoteStream.Close()
+	})
+	return localStream, remoteStream
+}
+
+func TestOutboundAndInboundHandshake(t *testing.T) {
+	local, remote := pipe(t)
+
+	type result struct {
+		info *handshake.PeerInfo
+		err  error
+	}
+
+	inboundResult := make(chan result, 1)
+	go func() {
+		info, err := handshake.Inbound(remote, handshake.Config{
+			Version: testVersion("/inbound/", 2),
+			Timeout: time.Second,
+		})
+		inboundResult <- result{info, err}
+	}()
+
+	outboundInfo, err := handshake.Outbound(local, handshake.Config{
+		Version: testVersion("/outbound/", 1),
+		Timeout: time.Second,
+	})
+	require.NoError(t, err)
+	require.False(t, outboundInfo.Inbound)
+	require.Equal(t, "/inbound/", outboundInfo.UserAgent)
+	require.Equal(t, uint32(60002), outboundInfo.Version)
+	require.Equal(t, uint32(100), outboundInfo.StartHeight)
+
+	res := <-inboundResult
+	require.NoError(t, res.err)
+	require.True(t, res.info.Inbound)
+	require.Equal(t, "/outbound/", res.info.UserAgent)
+}
+
+func TestAddrV2Negotiation(t *testing.T) {
+	cases := map[string]struct {
+		inboundVersion  uint32
+		outboundVersion uint32
+		negotiated      bool
+	}{
+		"both_support_addrv2": {
+			inboundVersion:  messages.AddrV2MinVersion,
+			outboundVersion: messages.AddrV2MinVersion,
+			negotiated:      true,
+		},
+		"inbound_is_older": {
+			inboundVersion:  70015,
+			outboundVersion: messages.AddrV2MinVersion,
+		},
+		"outbound_is_older": {
+			inboundVersion:  messages.AddrV2MinVersion,
+			outboundVersion: 60002,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			local, remote := pipe(t)
+
+			inboundInfo := make(chan *handshake.PeerInfo, 1)
+			go func() {
+				info, err := handshake.Inbound(remote, handshake.Config{
+					Version: testVersionNumber(tt.inboundVersion, "/inbound/", 2),
+					Timeout: time.Second,
+				})
+				require.NoError(t, err)
+				inboundInfo <- info
+			}()
+
+			outboundInfo, err := handshake.Outbound(local, handshake.Config{
+				Version: testVersionNumber(tt.outboundVersion, "/outbound/", 1),
+				Timeout: time.Second,
+			})
+			require.NoError(t, err)
+			require.Equal(t, tt.negotiated, outboundInfo.SendAddrV2)
+			require.Equal(t, tt.negotiated, (<-inboundInfo).SendAddrV2)
+		})
+	}
+}
+
+func TestRelayNegotiation(t *testing.T) {
+	cases := map[string]struct {
+		inboundVersion  uint32
+		outboundVersion uint32
+		wtxidRelay      bool
+		sendHeaders     bool
+		feeFilter       int64
+	}{
+		"both_support_wtxidrelay": {
+			inboundVersion:  messages.WtxidRelayMinVersion,
+			outboundVersion: messages.WtxidRelayMinVersion,
+			wtxidRelay:      true,
+			sendHeaders:     true,
+			feeFilter:       1000,
+		},
+		"inbound_is_older": {
+			inboundVersion:  messages.FeeFilterMinVersion,
+			outboundVersion: messages.WtxidRelayMinVersion,
+			sendHeaders:     true,
+			feeFilter:       1000,
+		},
+		"outbound_is_older": {
+			inboundVersion:  messages.WtxidRelayMinVersion,
+			outboundVersion: messages.SendHeadersMinVersion,
+			sendHeaders:     true,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			local, remote := pipe(t)
+
+			// the inbound side asks for both, the outbound side
+			// reads what it asked once the handshake is established
+			inboundInfo := make(chan *handshake.PeerInfo, 1)
+			go func() {
+				info, err := handshake.Inbound(remote, handshake.Config{
+					Version:     testVersionNumber(tt.inboundVersion, "/inbound/", 2),
+					Timeout:     time.Second,
+					SendHeaders: true,
+					FeeFilter:   1000,
+				})
+				require.NoError(t, err)
+				inboundInfo <- info
+			}()
+
+			outboundInfo, err := handshake.Outbound(local, handshake.Config{
+				Version: testVersionNumber(tt.outboundVersion, "/outbound/", 1),
+				Timeout: time.Second,
+			})
+			require.NoError(t, err)
+			require.Equal(t, tt.wtxidRelay, outboundInfo.WtxidRelay)
+			require.Equal(t, tt.wtxidRelay, (<-inboundInfo).WtxidRelay)
+
+			expected := 0
+			if tt.sendHeaders {
+				expected++
+			}
+			if tt.feeFilter > 0 {
+				expected++
+			}
+
+			require.NoError(t, local.SetDeadline(time.Now().Add(time.Second)))
+			for idx := 0; idx < expected; idx++ {
+				msg, err := local.ReadMessage()
+				require.NoError(t, err)
+
+				handled, err := outboundInfo.HandleMessage(msg)
+				require.NoError(t, err)
+				require.True(t, handled)
+			}
+
+			require.Equal(t, tt.sendHeaders, outboundInfo.SendHeaders())
+			require.Equal(t, tt.feeFilter, outboundInfo.FeeFilter())
+		})
+	}
+}
+
+func TestPeerInfoHandleMessage(t *testing.T) {
+	info := new(handshake.PeerInfo)
+	require.False(t, info.SendHeaders())
+	require.Zero(t, info.FeeFilter())
+
+	handled, err := info.HandleMessage(messages.NewMainMessage([]byte(messages.CmdSendHeaders), messages.EmptyPayload{}))
+	require.NoError(t, err)
+	require.True(t, handled)
+	require.True(t, info.SendHeaders())
+
+	handled, err = info.HandleMessage(messages.NewMainMessage([]byte(messages.CmdFeeFilter), &messages.FeeFilter{FeeRate: 2000}))
+	require.NoError(t, err)
+	require.True(t, handled)
+	require.Equal(t, int64(2000), info.FeeFilter())
+
+	handled, err = info.HandleMessage(messages.NewMainMessage([]byte(messages.CmdPing), &messages.Ping{Nonce: 1}))
+	require.NoError(t, err)
+	require.False(t, handled)
+
+	_, err = info.HandleMessage(messages.NewMainMessage([]byte(messages.CmdWtxidRelay), messages.EmptyPayload{}))
+	require.ErrorIs(t, err, handshake.ErrUnexpectedMessage)
+
+	_, err = info.HandleMessage(messages.NewMainMessage([]byte(messages.CmdVerAck), messages.EmptyPayload{}))
+	require.ErrorIs(t, err, handshake.ErrDuplicatedMessage)
+}
+
+func TestOutboundAcceptsVerAckBeforeVersion(t *testing.T) {
+	local, remote := pipe(t)
+
+	go func() {
+		if _, err := remote.ReadMessage(); err != nil {
+			return
+		}
+		_ = remote.SendMessage(messages.CmdVerAck, messages.EmptyPayload{})
+		_ = remote.SendMessage(messages.CmdVersion, testVersion("/remote/", 2))
+		// drains our verack
+		_, _ = remote.ReadMessage()
+	}()
+
+	info, err := handshake.Outbound(local, handshake.Config{
+		Version: testVersion("/local/", 1),
+		Timeout: time.Second,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "/remote/", info.UserAgent)
+}
+
+func TestHandshakeRejectsUnexpectedMessages(t *testing.T) {
+	local, remote := pipe(t)
+
+	go func() {
+		if _, err := remote.ReadMessage(); err != nil {
+			return
+		}
+		_ = remote.SendMessage(messages.CmdPing, &messages.Ping{Nonce: 1})
+	}()
+
+	_, err := handshake.Outbound(local, handshake.Config{
+		Version: testVersion("/local/", 1),
+		Timeout: time.Second,
+	})
+	require.ErrorIs(t, err, handshake.ErrUnexpectedMessage)
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	local, remote := pipe(t)
+
+	// the remote reads our version and then stays silent
+	go func() { _, _ = remote.ReadMessage() }()
+
+	_, err := handshake.Outbound(local, handshake.Config{
+		Version: testVersion("/local/", 1),
+		Timeout: 50 * time.Millisecond,
+	})
+	require.ErrorIs(t, err, handshake.ErrHandshakeTimeout)
+}
+
+func TestSelfConnectionIsDropped(t *testing.T) {
+	local, remote := pipe(t)
+	nonces := handshake.NewNonces()
+
+	inboundErr := make(chan error, 1)
+	go func() {
+		_, err := handshake.Inbound(remote, handshake.Config{
+			Version: testVersion("/ours/", 7),
+			Timeout: time.Second,
+			Nonces:  nonces,
+		})
+		// simulates the listener dropping the connection
+		remote.Close()
+		inboundErr <- err
+	}()
+
+	_, err := handshake.Outbound(local, handshake.Config{
+		Version: testVersion("/ours/", 7),
+		Timeout: time.Second,
+		Nonces:  nonces,
+	})
+	require.Error(t, err)
+	require.ErrorIs(t, <-inboundErr, handshake.ErrSelfConnection)
+
+	// once the outbound handshake is done its nonce is forgotten
+	require.False(t, nonces.Contains(7))
+}
+
+func TestMachine(t *testing.T) {
+	version := messages.NewMainMessage([]byte(messages.CmdVersion), testVersion("/remote/", 1))
+	verack := messages.NewMainMessage([]byte(messages.CmdVerAck), messages.EmptyPayload{})
+
+	inbound := handshake.NewMachine(true)
+	require.Equal(t, handshake.AwaitingVersion, inbound.State())
+	require.ErrorIs(t, inbound.Handle(verack), handshake.ErrUnexpectedMessage)
+	require.NoError(t, inbound.Handle(version))
+	require.Equal(t, handshake.AwaitingVerAck, inbound.State())
+	require.ErrorIs(t, inbound.Handle(version), handshake.ErrDuplicatedMessage)
+	require.NoError(t, inbound.Handle(verack))
+	require.Equal(t, handshake.Established, inbound.State())
+	require.ErrorIs(t, inbound.Handle(verack), handshake.ErrDuplicatedMessage)
+
+	sendAddrV2 := messages.NewMainMessage([]byte(messages.CmdSendAddrV2), messages.EmptyPayload{})
+	negotiation := handshake.NewMachine(true)
+	require.ErrorIs(t, negotiation.Handle(sendAddrV2), handshake.ErrUnexpectedMessage)
+	require.NoError(t, negotiation.Handle(version))
+	require.NoError(t, negotiation.Handle(sendAddrV2))
+	require.NoError(t, negotiation.Handle(verack))
+	require.True(t, negotiation.PeerInfo().SendAddrV2)
+	require.Error(t, negotiation.Handle(sendAddrV2))
+
+	wtxidRelay := messages.NewMainMessage([]byte(messages.CmdWtxidRelay), messages.EmptyPayload{})
+	negotiation = handshake.NewMachine(true)
+	require.ErrorIs(t, negotiation.Handle(wtxidRelay), handshake.ErrUnexpectedMessage)
+	require.NoError(t, negotiation.Handle(version))
+	require.NoError(t, negotiation.Handle(wtxidRelay))
+	require.NoError(t, negotiation.Handle(verack))
+	require.True(t, negotiation.PeerInfo().WtxidRelay)
+	require.Error(t, negotiation.Handle(wtxidRelay))
+
+	outbound := handshake.NewMachine(false)
+	require.Nil(t, outbound.PeerInfo())
+	require.NoError(t, outbound.Handle(verack))
+	require.Equal(t, handshake.AwaitingVersion, outbound.State())
+	require.ErrorIs(t, outbound.Handle(verack), handshake.ErrDuplicatedMessage)
+	require.NoError(t, outbound.Handle(version))
+	require.Equal(t, handshake.Established, outbound.State())
+	require.Equal(t, "/remote/", outbound.PeerInfo().UserAgent)
+}
@@ -0,0 +1,39 @@
+package handshake
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrSelfConnection = errors.New("connected to self")
+
+// Nonces remembers the nonces of our outbound version messages while their
+// handshake is in progress, an inbound version carrying one of them means we
+// are connected to ourselves, the same check bitcoin core does
+type Nonces struct {
+	mu     sync.Mutex
+	nonces map[uint64]struct{}
+}
+
+func NewNonces() *Nonces {
+	return &Nonces{nonces: make(map[uint64]struct{})}
+}
+
+func (n *Nonces) Add(nonce uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nonces[nonce] = struct{}{}
+}
+
+func (n *Nonces) Remove(nonce uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.nonces, nonce)
+}
+
+func (n *Nonces) Contains(nonce uint64) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, ok := n.nonces[nonce]
+	return ok
+}
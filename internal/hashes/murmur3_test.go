@@ -0,0 +1,39 @@
+package hashes_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/hashes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMurmurHash3(t *testing.T) {
+	// the vectors bitcoin core checks its implementation against
+	cases := []struct {
+		hash uint32
+		seed uint32
+		data string
+	}{
+		{hash: 0x00000000, seed: 0x00000000, data: ""},
+		{hash: 0x6a396f08, seed: 0xfba4c795, data: ""},
+		{hash: 0x81f16f39, seed: 0xffffffff, data: ""},
+		{hash: 0x514e28b7, seed: 0x00000000, data: "00"},
+		{hash: 0xea3f0b17, seed: 0xfba4c795, data: "00"},
+		{hash: 0xfd6cf10d, seed: 0x00000000, data: "ff"},
+		{hash: 0x16c6b7ab, seed: 0x00000000, data: "0011"},
+		{hash: 0x8eb51c3d, seed: 0x00000000, data: "001122"},
+		{hash: 0xb4471bf8, seed: 0x00000000, data: "00112233"},
+		{hash: 0xe2301fa8, seed: 0x00000000, data: "0011223344"},
+		{hash: 0xfc2e4a15, seed: 0x00000000, data: "001122334455"},
+		{hash: 0xb074502c, seed: 0x00000000, data: "00112233445566"},
+		{hash: 0x8034d2a0, seed: 0x00000000, data: "0011223344556677"},
+		{hash: 0xb4698def, seed: 0x00000000, data: "001122334455667788"},
+	}
+
+	for _, tt := range cases {
+		data, err := hex.DecodeString(tt.data)
+		require.NoError(t, err)
+		require.Equal(t, tt.hash, hashes.MurmurHash3(tt.seed, data), "seed 0x%08x data %q", tt.seed, tt.data)
+	}
+}
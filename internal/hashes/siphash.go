@@ -0,0 +1,59 @@
+package hashes
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// SipHash24 returns the SipHash-2-4 of the data keyed by k0 and k1,
+// bitcoin uses it for the short transaction ids of compact blocks
+// check: https://www.aumasson.jp/siphash/siphash.pdf
+func SipHash24(k0, k1 uint64, data []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	compress := func(m uint64) {
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+
+	size := len(data)
+	for len(data) >= 8 {
+		compress(binary.LittleEndian.Uint64(data))
+		data = data[8:]
+	}
+
+	// the last block has the leftover bytes and the length in its top byte
+	var last [8]byte
+	copy(last[:], data)
+	last[7] = byte(size)
+	compress(binary.LittleEndian.Uint64(last[:]))
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
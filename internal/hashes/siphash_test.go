@@ -0,0 +1,33 @@
+package hashes_test
+
+import (
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/hashes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSipHash24(t *testing.T) {
+	// the reference vectors use the key 00 01 .. 0f and the
+	// message 00 01 .. (n-1) for every length n below 64
+	const k0, k1 = 0x0706050403020100, 0x0f0e0d0c0b0a0908
+
+	message := make([]byte, 64)
+	for idx := range message {
+		message[idx] = byte(idx)
+	}
+
+	cases := []struct {
+		size int
+		hash uint64
+	}{
+		{size: 0, hash: 0x726fdb47dd0e0e31},
+		{size: 1, hash: 0x74f839c593dc67fd},
+		{size: 8, hash: 0x93f5f5799a932462},
+		{size: 15, hash: 0xa129ca6149be45e5},
+	}
+
+	for _, tt := range cases {
+		require.Equal(t, tt.hash, hashes.SipHash24(k0, k1, message[:tt.size]), "size %d", tt.size)
+	}
+}
@@ -0,0 +1,344 @@
+package headerchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/chaincfg"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+const (
+	// the timestamp must be above the median of the last medianTimeBlocks
+	medianTimeBlocks = 11
+	// MaxFutureBlockTime is how far in the future a header timestamp can be
+	MaxFutureBlockTime = 2 * time.Hour
+	// bip94MaxTimewarp is how much the first block of a period can go back in time
+	bip94MaxTimewarp = 600
+)
+
+var (
+	ErrOrphanHeader  = errors.New("header does not connect to the chain")
+	ErrBadDifficulty = errors.New("unexpected difficulty bits")
+	ErrTimeTooOld    = errors.New("header timestamp too old")
+	ErrTimeTooNew    = errors.New("header timestamp too far in the future")
+	// ErrCheckpointMismatch and ErrForkBeforeCheckpoint keep the headers
+	// on the checkpointed chain, forks below them would be cheap to mine
+	ErrCheckpointMismatch   = errors.New("header does not match the checkpoint")
+	ErrForkBeforeCheckpoint = errors.New("header forks before the last checkpoint")
+)
+
+// node is a validated header, the cumulative work
+// decides which branch is the best chain
+type node struct {
+	header messages.BlockHeader
+	hash   messages.Hash
+	parent *node
+	height int32
+	work   *big.Int
+	// stored is true once the header is in the store
+	stored bool
+}
+
+// Chain keeps every valid header it was given, following the branch with
+// the most work, headers are validated against the consensus rules that
+// do not need the block transactions (proof of work, difficulty and time)
+type Chain struct {
+	params *chaincfg.Params
+	now    func() time.Time
+
+	mu    sync.RWMutex
+	nodes map[messages.Hash]*node
+	// best is the main chain indexed by height, best[len-1] is the tip
+	best []*node
+
+	// store, if any, receives the headers once they are in the best chain,
+	// low work forks are kept in memory only, unstored has the ones to write
+	store    *os.File
+	unstored []*node
+}
+
+// New returns a chain with only the network genesis header
+func New(params *chaincfg.Params) *Chain {
+	genesis := &node{
+		header: params.GenesisHeader,
+		hash:   params.GenesisHeader.BlockHash(),
+		work:   CalcWork(params.GenesisHeader.Bits),
+		stored: true,
+	}
+
+	return &Chain{
+		params: params,
+		now:    time.Now,
+		nodes:  map[messages.Hash]*node{genesis.hash: genesis},
+		best:   []*node{genesis},
+	}
+}
+
+// Tip returns the hash and height of the best chain tip
+func (c *Chain) Tip() (messages.Hash, int32) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	tip := c.best[len(c.best)-1]
+	return tip.hash, tip.height
+}
+
+// Header returns a known header, from any branch, and its height
+func (c *Chain) Header(hash messages.Hash) (messages.BlockHeader, int32, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	n, ok := c.nodes[hash]
+	if !ok {
+		return messages.BlockHeader{}, 0, false
+	}
+	return n.header, n.height, true
+}
+
+// HeaderAt returns the header of the best chain at the height
+func (c *Chain) HeaderAt(height int32) (messages.BlockHeader, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if height < 0 || int(height) >= len(c.best) {
+		return messages.BlockHeader{}, false
+	}
+	return c.best[height].header, true
+}
+
+// Locator lists hashes of the best chain from the tip to the genesis,
+// the first 10 are consecutive and then the gap doubles at each step
+// check: https://en.bitcoin.it/wiki/Protocol_documentation#getblocks
+func (c *Chain) Locator() []messages.Hash {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var locator []messages.Hash
+	step := 1
+	for height := len(c.best) - 1; height > 0; height -= step {
+		locator = append(locator, c.best[height].hash)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+
+	return append(locator, c.best[0].hash)
+}
+
+// Add validates and connects the headers in order, known headers are
+// skipped. It stops at the first invalid header returning how many
+// headers were added before it, the best chain is updated as needed
+func (c *Chain) Add(headers ...messages.BlockHeader) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var (
+		added []*node
+		err   error
+	)
+
+	for idx := range headers {
+		var n *node
+		n, err = c.connect(&headers[idx])
+		if err != nil {
+			break
+		}
+
+		if n != nil {
+			added = append(added, n)
+		}
+	}
+
+	if storeErr := c.persist(); storeErr != nil {
+		return len(added), storeErr
+	}
+
+	return len(added), err
+}
+
+// connect validates the header and links it to its parent,
+// it returns nil without error if the header is already known
+func (c *Chain) connect(header *messages.BlockHeader) (*node, error) {
+	hash := header.BlockHash()
+	if _, ok := c.nodes[hash]; ok {
+		return nil, nil
+	}
+
+	parent, ok := c.nodes[header.PrevBlock]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has unknown parent %s", ErrOrphanHeader, hash, header.PrevBlock)
+	}
+
+	if err := CheckProofOfWork(header, c.params.PowLimit); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkCheckpoints(hash, parent.height+1); err != nil {
+		return nil, fmt.Errorf("header %s: %w", hash, err)
+	}
+
+	if err := c.checkContext(header, parent); err != nil {
+		return nil, fmt.Errorf("header %s: %w", hash, err)
+	}
+
+	n := &node{
+		header: *header,
+		hash:   hash,
+		parent: parent,
+		height: parent.height + 1,
+		work:   new(big.Int).Add(parent.work, CalcWork(header.Bits)),
+	}
+	c.nodes[hash] = n
+
+	// ties keep the tip we saw first
+	if n.work.Cmp(c.best[len(c.best)-1].work) > 0 {
+		c.setTip(n)
+	}
+	return n, nil
+}
+
+// checkContext checks the rules that depend on the previous headers
+func (c *Chain) checkContext(header *messages.BlockHeader, parent *node) error {
+	if expected := c.nextRequiredBits(parent, header); header.Bits != expected {
+		return fmt.Errorf("%w: got 0x%08x, expected 0x%08x", ErrBadDifficulty, header.Bits, expected)
+	}
+
+	if mtp := c.medianTimePast(parent); int64(header.Timestamp) <= mtp {
+		return fmt.Errorf("%w: %d is not after the median time past %d", ErrTimeTooOld, header.Timestamp, mtp)
+	}
+
+	// the timewarp attack lowers the difficulty moving the period start back in time
+	height := parent.height + 1
+	if c.params.EnforceBIP94 && height%chaincfg.RetargetInterval == 0 &&
+		int64(header.Timestamp) < int64(parent.header.Timestamp)-bip94MaxTimewarp {
+		return fmt.Errorf("%w: %d is more than %ds before the previous block", ErrTimeTooOld, header.Timestamp, bip94MaxTimewarp)
+	}
+
+	if limit := c.now().Add(MaxFutureBlockTime); header.Time().After(limit) {
+		return fmt.Errorf("%w: %s is after %s", ErrTimeTooNew, header.Time().UTC(), limit.UTC())
+	}
+
+	return nil
+}
+
+// checkCheckpoints checks the header at a checkpoint height is the
+// checkpoint and that it does not fork below the last checkpoint we have
+// check: https://github.com/bitcoin/bitcoin/blob/v0.20.0/src/validation.cpp#L3528
+func (c *Chain) checkCheckpoints(hash messages.Hash, height int32) error {
+	checkpoints := c.params.Checkpoints
+	for idx := len(checkpoints) - 1; idx >= 0; idx-- {
+		checkpoint := checkpoints[idx]
+		if checkpoint.Height == height && checkpoint.Hash != hash {
+			return fmt.Errorf("%w: expected %s at height %d", ErrCheckpointMismatch, checkpoint.Hash, height)
+		}
+
+		// known headers were skipped, so a new one below the last checkpoint is a fork
+		if _, ok := c.nodes[checkpoint.Hash]; ok {
+			if height < checkpoint.Height {
+				return fmt.Errorf("%w: height %d is below the checkpoint at %d", ErrForkBeforeCheckpoint, height, checkpoint.Height)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// medianTimePast is the median timestamp of the last blocks up to n
+func (c *Chain) medianTimePast(n *node) int64 {
+	timestamps := make([]int64, 0, medianTimeBlocks)
+	for ; n != nil && len(timestamps) < medianTimeBlocks; n = n.parent {
+		timestamps = append(timestamps, int64(n.header.Timestamp))
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
+
+// nextRequiredBits returns the difficulty the child of last must have
+// check: https://github.com/bitcoin/bitcoin/blob/master/src/pow.cpp
+func (c *Chain) nextRequiredBits(last *node, header *messages.BlockHeader) uint32 {
+	powLimitBits := BigToCompact(c.params.PowLimit)
+	height := last.height + 1
+
+	if height%chaincfg.RetargetInterval != 0 {
+		if !c.params.AllowMinDifficultyBlocks {
+			return last.header.Bits
+		}
+
+		// a block 20 minutes late on testnet can be mined at the minimum difficulty
+		spacing := int64(chaincfg.TargetSpacing / time.Second)
+		if int64(header.Timestamp) > int64(last.header.Timestamp)+2*spacing {
+			return powLimitBits
+		}
+
+		// otherwise it uses the last difficulty not given by the rule above
+		n := last
+		for n.parent != nil && n.height%chaincfg.RetargetInterval != 0 && n.header.Bits == powLimitBits {
+			n = n.parent
+		}
+		return n.header.Bits
+	}
+
+	if c.params.NoRetargeting {
+		return last.header.Bits
+	}
+
+	first := c.ancestor(last, height-chaincfg.RetargetInterval)
+	timespan := int64(last.header.Timestamp) - int64(first.header.Timestamp)
+
+	// the adjustment is limited to a factor of 4 in both directions
+	targetTimespan := int64(chaincfg.TargetTimespan / time.Second)
+	timespan = max(timespan, targetTimespan/4)
+	timespan = min(timespan, targetTimespan*4)
+
+	bits := last.header.Bits
+	if c.params.EnforceBIP94 {
+		// the last block might be one of the min difficulty blocks
+		bits = first.header.Bits
+	}
+
+	target := CompactToBig(bits)
+	target.Mul(target, big.NewInt(timespan))
+	target.Div(target, big.NewInt(targetTimespan))
+
+	if target.Cmp(c.params.PowLimit) > 0 {
+		target.Set(c.params.PowLimit)
+	}
+	return BigToCompact(target)
+}
+
+// ancestor returns the node at the height in the branch of n
+func (c *Chain) ancestor(n *node, height int32) *node {
+	for n.height > height {
+		// once in the best chain the ancestor is found by height
+		if int(n.height) < len(c.best) && c.best[n.height] == n {
+			return c.best[height]
+		}
+		n = n.parent
+	}
+	return n
+}
+
+// setTip makes n the best chain tip, replacing the branch
+// from the fork point if n is not a child of the current tip
+func (c *Chain) setTip(n *node) {
+	var branch []*node
+	for fork := n; int(fork.height) >= len(c.best) || c.best[fork.height] != fork; fork = fork.parent {
+		branch = append(branch, fork)
+	}
+
+	forkHeight := n.height - int32(len(branch))
+	c.best = c.best[:forkHeight+1]
+	for idx := len(branch) - 1; idx >= 0; idx-- {
+		c.best = append(c.best, branch[idx])
+		if !branch[idx].stored {
+			c.unstored = append(c.unstored, branch[idx])
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package headerchain_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/chaincfg"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/headerchain"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+const regtestBits = 0x207fffff
+
+// mine finds a nonce so the header child of parent meets the bits
+func mine(parent messages.BlockHeader, timestamp, bits uint32) messages.BlockHeader {
+	header := messages.BlockHeader{
+		Version:   4,
+		PrevBlock: parent.BlockHash(),
+		Timestamp: timestamp,
+		Bits:      bits,
+	}
+
+	target := headerchain.CompactToBig(bits)
+	for headerchain.HashToBig(header.BlockHash()).Cmp(target) > 0 {
+		header.Nonce++
+	}
+	return header
+}
+
+// extend mines n headers after parent, one every spacing seconds
+func extend(parent messages.BlockHeader, n int, spacing, bits uint32) []messages.BlockHeader {
+	headers := make([]messages.BlockHeader, n)
+	for idx := range headers {
+		headers[idx] = mine(parent, parent.Timestamp+spacing, bits)
+		parent = headers[idx]
+	}
+	return headers
+}
+
+func requireTip(t *testing.T, chain *headerchain.Chain, header messages.BlockHeader, height int32) {
+	t.Helper()
+
+	hash, tipHeight := chain.Tip()
+	require.Equal(t, header.BlockHash(), hash)
+	require.Equal(t, height, tipHeight)
+}
+
+func TestChainAddsMainnetHeader(t *testing.T) {
+	raw, err := hex.DecodeString("010000006fe28c0ab6f1b372c1a6a246ae63f74f931e8365e15a089c68d6190000000000" +
+		"982051fd1e4ba744bbbe680e1fee14677ba1a3c3540bf7b1cdb606e857233e0e61bc6649ffff001d01e36299")
+	require.NoError(t, err)
+
+	var block1 messages.BlockHeader
+	require.NoError(t, block1.Decode(bytes.NewReader(raw)))
+
+	chain := headerchain.New(&chaincfg.MainNet)
+	added, err := chain.Add(block1)
+	require.NoError(t, err)
+	require.Equal(t, 1, added)
+	requireTip(t, chain, block1, 1)
+
+	// known headers are skipped
+	added, err = chain.Add(block1)
+	require.NoError(t, err)
+	require.Zero(t, added)
+}
+
+func TestChainFollowsMostWork(t *testing.T) {
+	genesis := chaincfg.RegTest.GenesisHeader
+	chain := headerchain.New(&chaincfg.RegTest)
+
+	main := extend(genesis, 5, 600, regtestBits)
+	added, err := chain.Add(main...)
+	require.NoError(t, err)
+	require.Equal(t, 5, added)
+	requireTip(t, chain, main[4], 5)
+
+	// a branch with the same work does not replace the tip
+	fork := extend(main[1], 3, 601, regtestBits)
+	_, err = chain.Add(fork...)
+	require.NoError(t, err)
+	requireTip(t, chain, main[4], 5)
+
+	// once it has more work the chain reorgs to it
+	longer := extend(fork[2], 1, 601, regtestBits)
+	_, err = chain.Add(longer...)
+	require.NoError(t, err)
+	requireTip(t, chain, longer[0], 6)
+
+	header, ok := chain.HeaderAt(3)
+	require.True(t, ok)
+	require.Equal(t, fork[0], header)
+
+	// the replaced headers are still known
+	_, height, ok := chain.Header(main[4].BlockHash())
+	require.True(t, ok)
+	require.Equal(t, int32(5), height)
+}
+
+func TestChainRejectsInvalidHeaders(t *testing.T) {
+	genesis := chaincfg.RegTest.GenesisHeader
+	chain := headerchain.New(&chaincfg.RegTest)
+
+	headers := extend(genesis, 11, 600, regtestBits)
+	_, err := chain.Add(headers...)
+	require.NoError(t, err)
+	tip := headers[10]
+
+	orphan := mine(mine(tip, tip.Timestamp+600, regtestBits), tip.Timestamp+1200, regtestBits)
+	_, err = chain.Add(orphan)
+	require.ErrorIs(t, err, headerchain.ErrOrphanHeader)
+
+	badPow := mine(tip, tip.Timestamp+600, regtestBits)
+	for headerchain.CheckProofOfWork(&badPow, chaincfg.RegTest.PowLimit) == nil {
+		badPow.Nonce++
+	}
+	_, err = chain.Add(badPow)
+	require.ErrorIs(t, err, headerchain.ErrBadProofOfWork)
+
+	// regtest never retargets, so easier bits are not expected
+	_, err = chain.Add(mine(tip, tip.Timestamp+600, 0x1f7fffff))
+	require.ErrorIs(t, err, headerchain.ErrBadDifficulty)
+
+	// the median of the last 11 timestamps is headers[5]
+	_, err = chain.Add(mine(tip, headers[5].Timestamp, regtestBits))
+	require.ErrorIs(t, err, headerchain.ErrTimeTooOld)
+	_, err = chain.Add(mine(tip, headers[5].Timestamp+1, regtestBits))
+	require.NoError(t, err)
+
+	future := uint32(time.Now().Add(headerchain.MaxFutureBlockTime + time.Hour).Unix())
+	_, err = chain.Add(mine(tip, future, regtestBits))
+	require.ErrorIs(t, err, headerchain.ErrTimeTooNew)
+}
+
+func TestChainStopsAtFirstInvalidHeader(t *testing.T) {
+	genesis := chaincfg.RegTest.GenesisHeader
+	chain := headerchain.New(&chaincfg.RegTest)
+
+	headers := extend(genesis, 3, 600, regtestBits)
+	headers[2].PrevBlock = messages.Hash{1}
+
+	added, err := chain.Add(headers...)
+	require.ErrorIs(t, err, headerchain.ErrOrphanHeader)
+	require.Equal(t, 2, added)
+	requireTip(t, chain, headers[1], 2)
+}
+
+// retargetParams is regtest with the mainnet difficulty rules
+func retargetParams() *chaincfg.Params {
+	params := chaincfg.RegTest
+	params.NoRetargeting = false
+	params.AllowMinDifficultyBlocks = false
+	return &params
+}
+
+func TestChainRetarget(t *testing.T) {
+	params := retargetParams()
+	chain := headerchain.New(params)
+
+	// blocks twice as fast as expected double the difficulty
+	headers := extend(params.GenesisHeader, int(chaincfg.RetargetInterval)-1, 300, regtestBits)
+	_, err := chain.Add(headers...)
+	require.NoError(t, err)
+	last := headers[len(headers)-1]
+
+	_, err = chain.Add(mine(last, last.Timestamp+300, regtestBits))
+	require.ErrorIs(t, err, headerchain.ErrBadDifficulty)
+
+	// the period timespan goes from the genesis to the last block
+	timespan := int64(last.Timestamp - params.GenesisHeader.Timestamp)
+	target := headerchain.CompactToBig(regtestBits)
+	target.Mul(target, big.NewInt(timespan))
+	target.Div(target, big.NewInt(int64(chaincfg.TargetTimespan/time.Second)))
+	expected := headerchain.BigToCompact(target)
+
+	retargeted := mine(last, last.Timestamp+300, expected)
+	_, err = chain.Add(retargeted)
+	require.NoError(t, err)
+	requireTip(t, chain, retargeted, chaincfg.RetargetInterval)
+}
+
+func TestChainMinDifficultyBlocks(t *testing.T) {
+	params := retargetParams()
+	params.AllowMinDifficultyBlocks = true
+
+	const bits = 0x2000ffff
+	params.GenesisHeader.Bits = bits
+	chain := headerchain.New(params)
+
+	headers := extend(params.GenesisHeader, 2, 600, bits)
+	_, err := chain.Add(headers...)
+	require.NoError(t, err)
+
+	// a block more than 20 minutes late may use the minimum difficulty
+	late := mine(headers[1], headers[1].Timestamp+1201, regtestBits)
+	_, err = chain.Add(late)
+	require.NoError(t, err)
+
+	// the next ones go back to the last regular difficulty
+	_, err = chain.Add(mine(late, late.Timestamp+600, regtestBits))
+	require.ErrorIs(t, err, headerchain.ErrBadDifficulty)
+
+	_, err = chain.Add(mine(late, late.Timestamp+600, bits))
+	require.NoError(t, err)
+}
+
+func TestChainLocator(t *testing.T) {
+	genesis := chaincfg.RegTest.GenesisHeader
+	chain := headerchain.New(&chaincfg.RegTest)
+
+	headers := extend(genesis, 30, 600, regtestBits)
+	_, err := chain.Add(headers...)
+	require.NoError(t, err)
+
+	locator := chain.Locator()
+	require.Equal(t, headers[29].BlockHash(), locator[0])
+	require.Equal(t, genesis.BlockHash(), locator[len(locator)-1])
+
+	// 10 consecutive hashes from height 30, then steps of 2, 4 and 8
+	heights := []int32{30, 29, 28, 27, 26, 25, 24, 23, 22, 21, 19, 15, 7}
+	require.Len(t, locator, len(heights)+1)
+	for idx, height := range heights {
+		header, ok := chain.HeaderAt(height)
+		require.True(t, ok)
+		require.Equal(t, header.BlockHash(), locator[idx])
+	}
+}
+
+func TestChainCheckpoints(t *testing.T) {
+	genesis := chaincfg.RegTest.GenesisHeader
+	main := extend(genesis, 5, 600, regtestBits)
+
+	params := chaincfg.RegTest
+	params.Checkpoints = []chaincfg.Checkpoint{{Height: 3, Hash: main[2].BlockHash()}}
+	chain := headerchain.New(&params)
+
+	// a different header at the checkpoint height is rejected
+	other := extend(genesis, 3, 601, regtestBits)
+	added, err := chain.Add(other...)
+	require.ErrorIs(t, err, headerchain.ErrCheckpointMismatch)
+	require.Equal(t, 2, added)
+
+	_, err = chain.Add(main...)
+	require.NoError(t, err)
+	requireTip(t, chain, main[4], 5)
+
+	// once the checkpoint is known no fork can start below it, even with more work
+	fork := extend(main[0], 6, 601, regtestBits)
+	added, err = chain.Add(fork...)
+	require.ErrorIs(t, err, headerchain.ErrForkBeforeCheckpoint)
+	require.Zero(t, added)
+	requireTip(t, chain, main[4], 5)
+
+	// forks above it are still followed
+	fork = extend(main[2], 3, 601, regtestBits)
+	_, err = chain.Add(fork...)
+	require.NoError(t, err)
+	requireTip(t, chain, fork[2], 6)
+}
@@ -0,0 +1,105 @@
+package headerchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+var ErrBadProofOfWork = errors.New("bad proof of work")
+
+// oneLsh256 is 2^256, used to compute the work of a target
+var oneLsh256 = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// CompactToBig expands the compact (nBits) representation of a target,
+// a 3 bytes mantissa, with a sign bit, and a 1 byte base 256 exponent
+// check: https://developer.bitcoin.org/reference/block_chain.html#target-nbits
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := int64(compact & 0x007fffff)
+	negative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var target *big.Int
+	if exponent <= 3 {
+		target = big.NewInt(mantissa >> (8 * (3 - exponent)))
+	} else {
+		target = new(big.Int).Lsh(big.NewInt(mantissa), 8*(exponent-3))
+	}
+
+	if negative {
+		target.Neg(target)
+	}
+	return target
+}
+
+// BigToCompact is the inverse of CompactToBig, precision is lost
+// since only the 3 most significant bytes are kept
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() == 0 {
+		return 0
+	}
+
+	abs := new(big.Int).Abs(target)
+	exponent := uint(len(abs.Bytes()))
+
+	var mantissa uint32
+	if exponent <= 3 {
+		mantissa = uint32(abs.Uint64()) << (8 * (3 - exponent))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(abs, 8*(exponent-3)).Uint64())
+	}
+
+	// the mantissa would be read as negative, so it moves a byte to the exponent
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if target.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
+
+// HashToBig interprets the hash as a little endian number
+func HashToBig(hash messages.Hash) *big.Int {
+	reversed := hash
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+	return new(big.Int).SetBytes(reversed[:])
+}
+
+// CalcWork returns the expected number of hashes to find a block with
+// the target, 2^256 / (target + 1), the chain with more work is the best one
+func CalcWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	return new(big.Int).Div(oneLsh256, new(big.Int).Add(target, big.NewInt(1)))
+}
+
+// CheckProofOfWork checks the header hash is below the target it
+// claims, and that the target is not easier than the network limit
+func CheckProofOfWork(header *messages.BlockHeader, powLimit *big.Int) error {
+	target := CompactToBig(header.Bits)
+	if target.Sign() <= 0 {
+		return fmt.Errorf("%w: target from bits 0x%08x is not positive", ErrBadProofOfWork, header.Bits)
+	}
+
+	if target.Cmp(powLimit) > 0 {
+		return fmt.Errorf("%w: target from bits 0x%08x is above the limit", ErrBadProofOfWork, header.Bits)
+	}
+
+	hash := header.BlockHash()
+	if HashToBig(hash).Cmp(target) > 0 {
+		return fmt.Errorf("%w: hash %s is above the target from bits 0x%08x", ErrBadProofOfWork, hash, header.Bits)
+	}
+
+	return nil
+}
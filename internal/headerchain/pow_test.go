@@ -0,0 +1,42 @@
+package headerchain_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/chaincfg"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/headerchain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompact(t *testing.T) {
+	target := headerchain.CompactToBig(0x1d00ffff)
+	require.Equal(t, "ffff0000000000000000000000000000000000000000000000000000", target.Text(16))
+	require.Equal(t, uint32(0x1d00ffff), headerchain.BigToCompact(target))
+
+	// a mantissa with the sign bit set moves a byte to the exponent
+	require.Equal(t, uint32(0x02008000), headerchain.BigToCompact(big.NewInt(0x80)))
+	require.Equal(t, int64(0x80), headerchain.CompactToBig(0x02008000).Int64())
+
+	require.Equal(t, uint32(0x207fffff), headerchain.BigToCompact(chaincfg.RegTest.PowLimit))
+	require.Equal(t, uint32(0x1d00ffff), headerchain.BigToCompact(chaincfg.MainNet.PowLimit))
+	require.Equal(t, -1, headerchain.CompactToBig(0x04923456).Sign())
+}
+
+func TestCalcWork(t *testing.T) {
+	// the genesis work, 2^256 / (0xffff * 2^208 + 1)
+	require.Equal(t, "100010001", headerchain.CalcWork(0x1d00ffff).Text(16))
+	require.Zero(t, headerchain.CalcWork(0).Sign())
+}
+
+func TestCheckProofOfWork(t *testing.T) {
+	genesis := chaincfg.MainNet.GenesisHeader
+	require.NoError(t, headerchain.CheckProofOfWork(&genesis, chaincfg.MainNet.PowLimit))
+
+	genesis.Nonce++
+	require.ErrorIs(t, headerchain.CheckProofOfWork(&genesis, chaincfg.MainNet.PowLimit), headerchain.ErrBadProofOfWork)
+
+	// the regtest genesis is far easier than the mainnet limit
+	regtest := chaincfg.RegTest.GenesisHeader
+	require.ErrorIs(t, headerchain.CheckProofOfWork(&regtest, chaincfg.MainNet.PowLimit), headerchain.ErrBadProofOfWork)
+}
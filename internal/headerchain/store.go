@@ -0,0 +1,118 @@
+package headerchain
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/chaincfg"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+// Open loads the headers kept in the file and keeps appending the new
+// ones to it. Headers are only ever appended, parents before children,
+// so the file is the raw 80 bytes headers one after the other and a
+// crash can at most leave an incomplete last header, which is dropped
+func Open(path string, params *chaincfg.Params) (*Chain, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("while opening headers file: %w", err)
+	}
+
+	chain := New(params)
+	valid, err := chain.replay(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("while loading %s: %w", path, err)
+	}
+
+	if err := file.Truncate(valid); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("while truncating headers file: %w", err)
+	}
+
+	if _, err := file.Seek(valid, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("while seeking headers file: %w", err)
+	}
+
+	chain.store = file
+	return chain, nil
+}
+
+// replay adds every whole header in the reader, returning where they end
+func (c *Chain) replay(r io.Reader) (int64, error) {
+	reader := bufio.NewReader(r)
+	enc := make([]byte, messages.BlockHeaderSize)
+
+	var valid int64
+	for {
+		_, err := io.ReadFull(reader, enc)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return valid, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		var header messages.BlockHeader
+		if err := header.Decode(bytes.NewReader(enc)); err != nil {
+			return 0, err
+		}
+
+		if _, err := c.Add(header); err != nil {
+			return 0, fmt.Errorf("header at offset %d: %w", valid, err)
+		}
+		// forks in the file may not be in the best chain anymore
+		c.nodes[header.BlockHash()].stored = true
+		valid += messages.BlockHeaderSize
+	}
+}
+
+// persist appends the headers that joined the best chain to the store, if
+// there is one, they come parent first since a branch only joins the best
+// chain after its ancestors. Forks with less work are never written, so
+// they can not grow the file, and the ones replayed are already stored
+func (c *Chain) persist() error {
+	nodes := c.unstored
+	c.unstored = nil
+	for _, n := range nodes {
+		n.stored = true
+	}
+
+	if c.store == nil || len(nodes) == 0 {
+		return nil
+	}
+
+	buf := make([]byte, 0, len(nodes)*messages.BlockHeaderSize)
+	for _, n := range nodes {
+		enc, err := n.header.Encode()
+		if err != nil {
+			return err
+		}
+		buf = append(buf, enc...)
+	}
+
+	if _, err := c.store.Write(buf); err != nil {
+		return fmt.Errorf("while storing headers: %w", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the headers file, if there is one
+func (c *Chain) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.store == nil {
+		return nil
+	}
+
+	syncErr := c.store.Sync()
+	closeErr := c.store.Close()
+	c.store = nil
+	return errors.Join(syncErr, closeErr)
+}
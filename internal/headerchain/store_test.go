@@ -0,0 +1,118 @@
+package headerchain_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/chaincfg"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/headerchain"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenKeepsHeaders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "headers.dat")
+
+	chain, err := headerchain.Open(path, &chaincfg.RegTest)
+	require.NoError(t, err)
+	requireTip(t, chain, chaincfg.RegTest.GenesisHeader, 0)
+
+	main := extend(chaincfg.RegTest.GenesisHeader, 4, 600, regtestBits)
+	fork := extend(main[0], 5, 601, regtestBits)
+
+	_, err = chain.Add(main...)
+	require.NoError(t, err)
+	_, err = chain.Add(fork...)
+	require.NoError(t, err)
+	require.NoError(t, chain.Close())
+
+	// both branches were stored
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, int64(9*messages.BlockHeaderSize), stat.Size())
+
+	reopened, err := headerchain.Open(path, &chaincfg.RegTest)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	requireTip(t, reopened, fork[4], 6)
+	_, _, ok := reopened.Header(main[3].BlockHash())
+	require.True(t, ok)
+}
+
+func TestOpenOnlyKeepsTheBestChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "headers.dat")
+
+	chain, err := headerchain.Open(path, &chaincfg.RegTest)
+	require.NoError(t, err)
+
+	main := extend(chaincfg.RegTest.GenesisHeader, 4, 600, regtestBits)
+	fork := extend(main[0], 2, 601, regtestBits)
+
+	_, err = chain.Add(main...)
+	require.NoError(t, err)
+	added, err := chain.Add(fork...)
+	require.NoError(t, err)
+	require.Equal(t, 2, added)
+
+	// the fork has less work, it is not written
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, int64(4*messages.BlockHeaderSize), stat.Size())
+
+	// until it becomes the best chain
+	longer := extend(fork[1], 3, 601, regtestBits)
+	_, err = chain.Add(longer...)
+	require.NoError(t, err)
+	require.NoError(t, chain.Close())
+
+	stat, err = os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, int64(9*messages.BlockHeaderSize), stat.Size())
+
+	reopened, err := headerchain.Open(path, &chaincfg.RegTest)
+	require.NoError(t, err)
+	defer reopened.Close()
+	requireTip(t, reopened, longer[2], 6)
+}
+
+func TestOpenDropsIncompleteHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "headers.dat")
+
+	headers := extend(chaincfg.RegTest.GenesisHeader, 2, 600, regtestBits)
+	var raw []byte
+	for idx := range headers {
+		enc, err := headers[idx].Encode()
+		require.NoError(t, err)
+		raw = append(raw, enc...)
+	}
+
+	// as if we crashed while writing the second header
+	require.NoError(t, os.WriteFile(path, raw[:messages.BlockHeaderSize+10], 0o644))
+
+	chain, err := headerchain.Open(path, &chaincfg.RegTest)
+	require.NoError(t, err)
+	requireTip(t, chain, headers[0], 1)
+
+	_, err = chain.Add(headers[1])
+	require.NoError(t, err)
+	require.NoError(t, chain.Close())
+
+	stored, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, raw, stored)
+}
+
+func TestOpenRejectsOtherNetworks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "headers.dat")
+
+	chain, err := headerchain.Open(path, &chaincfg.RegTest)
+	require.NoError(t, err)
+	_, err = chain.Add(extend(chaincfg.RegTest.GenesisHeader, 1, 600, regtestBits)...)
+	require.NoError(t, err)
+	require.NoError(t, chain.Close())
+
+	_, err = headerchain.Open(path, &chaincfg.MainNet)
+	require.ErrorIs(t, err, headerchain.ErrOrphanHeader)
+}
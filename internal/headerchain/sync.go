@@ -0,0 +1,109 @@
+package headerchain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+// MaxUnconnectingHeaders is how many headers messages in a row that do not
+// connect a peer can send before being disconnected, the same as bitcoin core
+const MaxUnconnectingHeaders = 10
+
+var ErrTooManyUnconnecting = errors.New("too many headers messages that do not connect")
+
+// Sender sends messages to a peer, e.g a *network.Stream
+type Sender interface {
+	SendMessage(command string, payload codec.Encodeable) error
+}
+
+// Syncer downloads the headers of a peer into the chain, it asks for
+// the headers after our tip until the peer has no more of them, and
+// asks again whenever the peer announces headers that do not connect
+type Syncer struct {
+	chain   *Chain
+	peer    Sender
+	version uint32
+	// unconnecting counts the headers messages in a row that did not connect
+	unconnecting int
+}
+
+// NewSyncer syncs the chain from the peer, version is our protocol version
+func NewSyncer(chain *Chain, peer Sender, version uint32) *Syncer {
+	return &Syncer{chain: chain, peer: peer, version: version}
+}
+
+// Start asks the peer for the headers after our tip, messages received
+// from the peer should then be given to HandleMessage
+func (s *Syncer) Start() error {
+	return s.requestHeaders()
+}
+
+// HandleMessage adds the headers the peer sent to the chain, it
+// returns true if the message was a headers message. An error
+// means the peer sent invalid headers and should be disconnected.
+// Unknown blocks announced through inv are asked as headers, but
+// since the inv might announce more, false is returned for it
+func (s *Syncer) HandleMessage(msg *messages.Message) (bool, error) {
+	switch payload := msg.Payload.(type) {
+	case *messages.Headers:
+		return true, s.handleHeaders(payload)
+	case *messages.Inv:
+		return false, s.handleInv(payload)
+	default:
+		return false, nil
+	}
+}
+
+func (s *Syncer) handleInv(payload *messages.Inv) error {
+	for _, inv := range payload.Inventory {
+		if !inv.Type.IsBlock() {
+			continue
+		}
+
+		if _, _, known := s.chain.Header(inv.Hash); !known {
+			return s.requestHeaders()
+		}
+	}
+	return nil
+}
+
+func (s *Syncer) handleHeaders(payload *messages.Headers) error {
+	if len(payload.Headers) == 0 {
+		return nil
+	}
+
+	_, err := s.chain.Add(payload.Headers...)
+	if errors.Is(err, ErrOrphanHeader) {
+		s.unconnecting++
+		if s.unconnecting > MaxUnconnectingHeaders {
+			return fmt.Errorf("%w: %d in a row", ErrTooManyUnconnecting, s.unconnecting)
+		}
+
+		// a new block announced while we are behind, catch up first
+		return s.requestHeaders()
+	}
+	if err != nil {
+		return err
+	}
+	s.unconnecting = 0
+
+	// a full message means the peer has more headers
+	if len(payload.Headers) == messages.MaxHeadersResults {
+		return s.requestHeaders()
+	}
+	return nil
+}
+
+func (s *Syncer) requestHeaders() error {
+	err := s.peer.SendMessage(messages.CmdGetHeaders, &messages.GetHeaders{
+		Version: s.version,
+		Locator: s.chain.Locator(),
+	})
+	if err != nil {
+		return fmt.Errorf("while requesting headers: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package headerchain_test
+
+import (
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/chaincfg"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/headerchain"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePeer records the messages sent to it
+type fakePeer struct {
+	sent []*messages.GetHeaders
+}
+
+func (f *fakePeer) SendMessage(command string, payload codec.Encodeable) error {
+	if getHeaders, ok := payload.(*messages.GetHeaders); ok && command == messages.CmdGetHeaders {
+		f.sent = append(f.sent, getHeaders)
+	}
+	return nil
+}
+
+func headersMessage(headers []messages.BlockHeader) *messages.Message {
+	return messages.NewMessage(messages.MagicTestNetRegTest, []byte(messages.CmdHeaders), &messages.Headers{Headers: headers})
+}
+
+func TestSyncerRequestsUntilCaughtUp(t *testing.T) {
+	chain := headerchain.New(&chaincfg.RegTest)
+	peer := &fakePeer{}
+	syncer := headerchain.NewSyncer(chain, peer, 70016)
+
+	require.NoError(t, syncer.Start())
+	require.Len(t, peer.sent, 1)
+	require.Equal(t, []messages.Hash{chaincfg.RegTest.GenesisHeader.BlockHash()}, peer.sent[0].Locator)
+	require.Equal(t, uint32(70016), peer.sent[0].Version)
+
+	headers := extend(chaincfg.RegTest.GenesisHeader, messages.MaxHeadersResults+10, 600, regtestBits)
+
+	// a full message means there are more headers to ask for
+	handled, err := syncer.HandleMessage(headersMessage(headers[:messages.MaxHeadersResults]))
+	require.True(t, handled)
+	require.NoError(t, err)
+	require.Len(t, peer.sent, 2)
+	require.Equal(t, headers[messages.MaxHeadersResults-1].BlockHash(), peer.sent[1].Locator[0])
+
+	handled, err = syncer.HandleMessage(headersMessage(headers[messages.MaxHeadersResults:]))
+	require.True(t, handled)
+	require.NoError(t, err)
+	require.Len(t, peer.sent, 2)
+	requireTip(t, chain, headers[len(headers)-1], int32(len(headers)))
+}
+
+func TestSyncerCatchesUpOnAnnouncements(t *testing.T) {
+	chain := headerchain.New(&chaincfg.RegTest)
+	peer := &fakePeer{}
+	syncer := headerchain.NewSyncer(chain, peer, 70016)
+
+	// the announced header does not connect to our tip yet
+	headers := extend(chaincfg.RegTest.GenesisHeader, 3, 600, regtestBits)
+	handled, err := syncer.HandleMessage(headersMessage(headers[2:]))
+	require.True(t, handled)
+	require.NoError(t, err)
+	require.Len(t, peer.sent, 1)
+}
+
+func TestSyncerDropsPeersSendingUnconnectingHeaders(t *testing.T) {
+	chain := headerchain.New(&chaincfg.RegTest)
+	peer := &fakePeer{}
+	syncer := headerchain.NewSyncer(chain, peer, 70016)
+
+	headers := extend(chaincfg.RegTest.GenesisHeader, 3, 600, regtestBits)
+	for i := 0; i < headerchain.MaxUnconnectingHeaders; i++ {
+		_, err := syncer.HandleMessage(headersMessage(headers[2:]))
+		require.NoError(t, err)
+	}
+
+	// headers that connect reset the count
+	_, err := syncer.HandleMessage(headersMessage(headers[:1]))
+	require.NoError(t, err)
+	for i := 0; i < headerchain.MaxUnconnectingHeaders; i++ {
+		_, err := syncer.HandleMessage(headersMessage(headers[2:]))
+		require.NoError(t, err)
+	}
+
+	_, err = syncer.HandleMessage(headersMessage(headers[2:]))
+	require.ErrorIs(t, err, headerchain.ErrTooManyUnconnecting)
+	require.Len(t, peer.sent, 2*headerchain.MaxUnconnectingHeaders)
+}
+
+func TestSyncerRejectsInvalidHeaders(t *testing.T) {
+	chain := headerchain.New(&chaincfg.RegTest)
+	syncer := headerchain.NewSyncer(chain, &fakePeer{}, 70016)
+
+	invalid := mine(chaincfg.RegTest.GenesisHeader, chaincfg.RegTest.GenesisHeader.Timestamp+600, 0x1f7fffff)
+	handled, err := syncer.HandleMessage(headersMessage([]messages.BlockHeader{invalid}))
+	require.True(t, handled)
+	require.ErrorIs(t, err, headerchain.ErrBadDifficulty)
+
+	handled, err = syncer.HandleMessage(messages.NewMessage(messages.MagicTestNetRegTest, []byte(messages.CmdPing), &messages.Ping{}))
+	require.False(t, handled)
+	require.NoError(t, err)
+}
+
+func TestSyncerRequestsAnnouncedBlocks(t *testing.T) {
+	chain := headerchain.New(&chaincfg.RegTest)
+	peer := &fakePeer{}
+	syncer := headerchain.NewSyncer(chain, peer, 70016)
+
+	genesis := chaincfg.RegTest.GenesisHeader.BlockHash()
+	known := messages.NewMessage(messages.MagicTestNetRegTest, []byte(messages.CmdInv), &messages.Inv{
+		Inventory: []messages.InvVect{{Type: messages.InvBlock, Hash: genesis}, {Type: messages.InvTx, Hash: messages.Hash{1}}},
+	})
+
+	// the inv is left for the other handlers
+	handled, err := syncer.HandleMessage(known)
+	require.False(t, handled)
+	require.NoError(t, err)
+	require.Empty(t, peer.sent)
+
+	unknown := messages.NewMessage(messages.MagicTestNetRegTest, []byte(messages.CmdInv), &messages.Inv{
+		Inventory: []messages.InvVect{{Type: messages.InvBlock, Hash: messages.Hash{2}}},
+	})
+	handled, err = syncer.HandleMessage(unknown)
+	require.False(t, handled)
+	require.NoError(t, err)
+	require.Len(t, peer.sent, 1)
+}
@@ -0,0 +1,101 @@
+package inventory
+
+import (
+	"sync"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+// DefaultTrackerSize is how many entries a tracker remembers, the
+// same as the inventory announced per peer bitcoin core remembers
+const DefaultTrackerSize = messages.MaxInvEntries
+
+// Tracker remembers the inventory known by a peer, either because the
+// peer announced it or because we sent it, so the same entry is neither
+// processed twice nor announced back. Once full the oldest entries are forgotten
+type Tracker struct {
+	mu    sync.Mutex
+	known map[messages.InvVect]struct{}
+	// order is a ring of the known entries, next is the oldest one
+	order []messages.InvVect
+	next  int
+}
+
+// NewTracker returns a tracker remembering at most size entries
+func NewTracker(size int) *Tracker {
+	if size <= 0 {
+		size = DefaultTrackerSize
+	}
+
+	return &Tracker{
+		known: make(map[messages.InvVect]struct{}, size),
+		order: make([]messages.InvVect, 0, size),
+	}
+}
+
+// key drops the witness flag, the same transaction or block
+// is the same entry whatever serialization is asked for
+func key(inv messages.InvVect) messages.InvVect {
+	inv.Type &^= messages.InvWitnessFlag
+	return inv
+}
+
+// Add marks the entries as known by the peer
+func (t *Tracker) Add(inventory ...messages.InvVect) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, inv := range inventory {
+		t.add(key(inv))
+	}
+}
+
+// Has reports whether the peer knows the entry
+func (t *Tracker) Has(inv messages.InvVect) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.known[key(inv)]
+	return ok
+}
+
+// Filter returns the entries the peer did not know, in the same order
+// and without repetitions, and marks all of them as known
+func (t *Tracker) Filter(inventory []messages.InvVect) []messages.InvVect {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var unknown []messages.InvVect
+	for _, inv := range inventory {
+		if _, ok := t.known[key(inv)]; ok {
+			continue
+		}
+
+		t.add(key(inv))
+		unknown = append(unknown, inv)
+	}
+	return unknown
+}
+
+// Len returns how many entries are remembered
+func (t *Tracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.known)
+}
+
+// add must be called holding mu
+func (t *Tracker) add(inv messages.InvVect) {
+	if _, ok := t.known[inv]; ok {
+		return
+	}
+
+	if len(t.order) < cap(t.order) {
+		t.order = append(t.order, inv)
+	} else {
+		delete(t.known, t.order[t.next])
+		t.order[t.next] = inv
+		t.next = (t.next + 1) % len(t.order)
+	}
+	t.known[inv] = struct{}{}
+}
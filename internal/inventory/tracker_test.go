@@ -0,0 +1,54 @@
+package inventory_test
+
+import (
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/inventory"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func tx(n byte) messages.InvVect {
+	return messages.InvVect{Type: messages.InvTx, Hash: messages.Hash{n}}
+}
+
+func TestTrackerDeduplicates(t *testing.T) {
+	tracker := inventory.NewTracker(10)
+
+	unknown := tracker.Filter([]messages.InvVect{tx(1), tx(2), tx(1)})
+	require.Equal(t, []messages.InvVect{tx(1), tx(2)}, unknown)
+
+	// announcing them again gives nothing new
+	require.Empty(t, tracker.Filter([]messages.InvVect{tx(2), tx(1)}))
+	require.Equal(t, []messages.InvVect{tx(3)}, tracker.Filter([]messages.InvVect{tx(1), tx(3)}))
+	require.Equal(t, 3, tracker.Len())
+}
+
+func TestTrackerIgnoresWitnessFlag(t *testing.T) {
+	tracker := inventory.NewTracker(10)
+	tracker.Add(tx(1))
+
+	witness := messages.InvVect{Type: messages.InvWitnessTx, Hash: messages.Hash{1}}
+	require.True(t, tracker.Has(witness))
+
+	// a block with the same hash is another entry
+	block := messages.InvVect{Type: messages.InvBlock, Hash: messages.Hash{1}}
+	require.False(t, tracker.Has(block))
+}
+
+func TestTrackerForgetsOldest(t *testing.T) {
+	tracker := inventory.NewTracker(3)
+	tracker.Add(tx(1), tx(2), tx(3))
+	tracker.Add(tx(4))
+
+	require.Equal(t, 3, tracker.Len())
+	require.False(t, tracker.Has(tx(1)))
+	require.True(t, tracker.Has(tx(2)))
+	require.True(t, tracker.Has(tx(4)))
+
+	tracker.Add(tx(5), tx(6))
+	require.False(t, tracker.Has(tx(2)))
+	require.False(t, tracker.Has(tx(3)))
+	require.True(t, tracker.Has(tx(4)))
+	require.True(t, tracker.Has(tx(6)))
+}
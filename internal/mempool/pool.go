@@ -0,0 +1,637 @@
+package mempool
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+const (
+	// DefaultMaxSize is the most bytes of transactions kept, the same as
+	// the bitcoin core -maxmempool default
+	DefaultMaxSize = 300_000_000
+	// DefaultMaxAge is how long a transaction is kept without being
+	// confirmed, the same as the bitcoin core -mempoolexpiry default
+	DefaultMaxAge = 14 * 24 * time.Hour
+	// DefaultRequestTimeout is the wait for an asked transaction before
+	// asking it to another peer that announced it
+	DefaultRequestTimeout = time.Minute
+	// DefaultMaxPeerAnnouncements is the most transactions a peer can have
+	// announced without us having them, the same as bitcoin core
+	DefaultMaxPeerAnnouncements = 5000
+	// DefaultMaxPeerRequests is the most transactions asked to a
+	// peer at the same time, the same as bitcoin core
+	DefaultMaxPeerRequests = 100
+)
+
+var (
+	ErrAlreadyKnown = errors.New("transaction already in the pool")
+	ErrTxTooLarge   = errors.New("transaction does not fit in the pool")
+)
+
+type Config struct {
+	// MaxSize is the most bytes of transactions kept, the oldest
+	// ones (along with their descendants) are evicted to make room
+	MaxSize int
+	// MaxAge is how long a transaction is kept without being confirmed
+	MaxAge time.Duration
+	// RequestTimeout is the wait for an asked transaction
+	RequestTimeout time.Duration
+	// MaxPeerAnnouncements is the most pending announcements kept per
+	// peer, the ones over it are dropped so a peer can not flood us
+	MaxPeerAnnouncements int
+	// MaxPeerRequests is the most requests in flight per peer, the
+	// transactions over it are asked once the peer answers the others
+	MaxPeerRequests int
+	// Now returns the current time, time.Now when nil
+	Now func() time.Time
+}
+
+// Entry is a transaction of the pool
+type Entry struct {
+	Tx    *messages.Tx
+	TxID  messages.Hash
+	WTxID messages.Hash
+	// Size is the serialized size, witness included, and VSize the
+	// virtual size (the weight over 4) fee rates are computed with
+	Size  int
+	VSize int
+	// Fee is only known when every output spent is in the pool, the
+	// pool has no utxo set so it can not tell the value of the others
+	Fee      int64
+	FeeKnown bool
+	Added    time.Time
+	// From is the peer the transaction was received from
+	From string
+
+	announcers map[string]struct{}
+	element    *list.Element
+}
+
+// FeeRate is the fee rate in satoshis per kilobyte, the unit of feefilter
+func (e *Entry) FeeRate() (int64, bool) {
+	if !e.FeeKnown {
+		return 0, false
+	}
+	return e.Fee * 1000 / int64(e.VSize), true
+}
+
+func (e *Entry) String() string {
+	fee := "unknown"
+	if feeRate, ok := e.FeeRate(); ok {
+		fee = fmt.Sprintf("%d sat/kvB", feeRate)
+	}
+	return fmt.Sprintf("[txid=%s] [vsize=%d] [fee=%s] [from=%s]", e.TxID, e.VSize, fee, e.From)
+}
+
+// pending is an announced transaction we do not have yet
+type pending struct {
+	hash messages.Hash
+	// requestType asks it by wtxid or by txid with its witness
+	requestType messages.InvType
+	announcers  map[string]struct{}
+	// requestedFrom is the peer asked for it, empty when no
+	// request is in flight, e.g the peer did not find it
+	requestedFrom string
+	// updatedAt is when it was first announced, last requested or last
+	// timed out. A request timing out moves on to the other announcers,
+	// it is dropped when none of them is asked within the request timeout
+	updatedAt time.Time
+	element   *list.Element
+}
+
+// peerState limits what a single peer makes us keep
+type peerState struct {
+	// announced has the pending transactions the peer announced
+	announced map[messages.Hash]struct{}
+	// inFlight is how many of them were asked to the peer
+	inFlight int
+}
+
+// Pool keeps the unconfirmed transactions announced by the peers, the
+// peers already validated them so the pool only tracks them: each one is
+// asked to a single announcer at a time, the next one being asked when it
+// does not come, and kept until it is confirmed, replaced by a conflicting
+// one, too old or evicted for room
+type Pool struct {
+	cfg Config
+
+	mu      sync.Mutex
+	byTxID  map[messages.Hash]*Entry
+	byWTxID map[messages.Hash]*Entry
+	// spends maps every outpoint spent in the pool to its spender
+	spends map[messages.OutPoint]*Entry
+	// order has the entries from the oldest to the newest
+	order *list.List
+	size  int
+
+	// pending is indexed by the announced hash, a txid or a wtxid, and
+	// pendingOrder has them from the least to the most recently updated
+	pending      map[messages.Hash]*pending
+	pendingOrder *list.List
+	peers        map[string]*peerState
+}
+
+func New(cfg Config) *Pool {
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = DefaultMaxSize
+	}
+
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = DefaultMaxAge
+	}
+
+	if cfg.RequestTimeout == 0 {
+		cfg.RequestTimeout = DefaultRequestTimeout
+	}
+
+	if cfg.MaxPeerAnnouncements <= 0 {
+		cfg.MaxPeerAnnouncements = DefaultMaxPeerAnnouncements
+	}
+
+	if cfg.MaxPeerRequests <= 0 {
+		cfg.MaxPeerRequests = DefaultMaxPeerRequests
+	}
+
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	return &Pool{
+		cfg:          cfg,
+		byTxID:       make(map[messages.Hash]*Entry),
+		byWTxID:      make(map[messages.Hash]*Entry),
+		spends:       make(map[messages.OutPoint]*Entry),
+		order:        list.New(),
+		pending:      make(map[messages.Hash]*pending),
+		pendingOrder: list.New(),
+		peers:        make(map[string]*peerState),
+	}
+}
+
+// Announced keeps which peer announced each transaction of the inv
+// and returns the getdata entries the peer should be asked for, the
+// ones we neither have nor already asked another peer for. Announcements
+// over the peer limit are dropped and requests over the in flight
+// limit wait for NextRequests
+func (p *Pool) Announced(peer string, inv []messages.InvVect) []messages.InvVect {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire()
+	now := p.cfg.Now()
+	state := p.peer(peer)
+	defer p.forgetIdle(peer)
+
+	var request []messages.InvVect
+	for _, entry := range inv {
+		if !entry.Type.IsTx() {
+			continue
+		}
+
+		if known, ok := p.lookup(entry.Hash); ok {
+			known.announcers[peer] = struct{}{}
+			continue
+		}
+
+		announced, ok := p.pending[entry.Hash]
+		if _, seen := state.announced[entry.Hash]; !seen {
+			if len(state.announced) >= p.cfg.MaxPeerAnnouncements {
+				continue
+			}
+
+			if !ok {
+				// transactions announced by txid are asked with their witness
+				announced = &pending{
+					hash:        entry.Hash,
+					requestType: messages.InvWitnessTx,
+					announcers:  make(map[string]struct{}),
+					updatedAt:   now,
+				}
+				if entry.Type == messages.InvWTx {
+					announced.requestType = messages.InvWTx
+				}
+				announced.element = p.pendingOrder.PushBack(announced)
+				p.pending[entry.Hash] = announced
+			}
+
+			announced.announcers[peer] = struct{}{}
+			state.announced[entry.Hash] = struct{}{}
+		}
+
+		if announced.requestedFrom != "" || !p.request(announced, peer, now) {
+			continue
+		}
+		request = append(request, messages.InvVect{Type: announced.requestType, Hash: announced.hash})
+	}
+	return request
+}
+
+// NextRequests returns the getdata entries of the transactions the
+// peer announced that are not asked to anyone, as many as the peer in
+// flight limit allows, it should be called once the peer answers requests
+func (p *Pool) NextRequests(peer string) []messages.InvVect {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire()
+	state, ok := p.peers[peer]
+	if !ok {
+		return nil
+	}
+
+	now := p.cfg.Now()
+
+	var request []messages.InvVect
+	for hash := range state.announced {
+		if state.inFlight >= p.cfg.MaxPeerRequests {
+			break
+		}
+
+		announced := p.pending[hash]
+		if announced.requestedFrom != "" || !p.request(announced, peer, now) {
+			continue
+		}
+		request = append(request, messages.InvVect{Type: announced.requestType, Hash: hash})
+	}
+	return request
+}
+
+// NotFound forgets the requests the peer could not answer, the
+// transactions are asked again once another peer announces them
+func (p *Pool) NotFound(peer string, inv []messages.InvVect) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, entry := range inv {
+		announced, ok := p.pending[entry.Hash]
+		if !ok || announced.requestedFrom != peer {
+			continue
+		}
+
+		state := p.peers[peer]
+		state.inFlight--
+		announced.requestedFrom = ""
+
+		delete(announced.announcers, peer)
+		delete(state.announced, entry.Hash)
+		if len(announced.announcers) == 0 {
+			p.removePending(announced)
+		}
+	}
+	p.forgetIdle(peer)
+}
+
+// Add keeps the transaction the peer sent, transactions spending the same
+// outputs are replaced since the peer relaying it already checked the
+// replacement rules. Entries too old or over the size limit are evicted
+func (p *Pool) Add(peer string, tx *messages.Tx) (*Entry, error) {
+	txid, wtxid := tx.TxHash(), tx.WitnessHash()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if known, ok := p.byTxID[txid]; ok {
+		known.announcers[peer] = struct{}{}
+		return nil, fmt.Errorf("%w: %s", ErrAlreadyKnown, txid)
+	}
+
+	enc, err := tx.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("while encoding transaction: %w", err)
+	}
+
+	if len(enc) > p.cfg.MaxSize {
+		return nil, fmt.Errorf("%w: %d bytes, limit is %d", ErrTxTooLarge, len(enc), p.cfg.MaxSize)
+	}
+
+	weight := len(tx.EncodeNoWitness())*3 + len(enc)
+	entry := &Entry{
+		Tx:         tx,
+		TxID:       txid,
+		WTxID:      wtxid,
+		Size:       len(enc),
+		VSize:      (weight + 3) / 4,
+		Added:      p.cfg.Now(),
+		From:       peer,
+		announcers: map[string]struct{}{peer: {}},
+	}
+	entry.Fee, entry.FeeKnown = p.fee(tx)
+
+	for _, hash := range []messages.Hash{txid, wtxid} {
+		if announced, ok := p.pending[hash]; ok {
+			for announcer := range announced.announcers {
+				entry.announcers[announcer] = struct{}{}
+			}
+			p.removePending(announced)
+		}
+	}
+
+	for idx := range tx.TxIn {
+		if conflict, ok := p.spends[tx.TxIn[idx].PreviousOutPoint]; ok {
+			p.removeWithDescendants(conflict)
+		}
+	}
+
+	p.byTxID[txid] = entry
+	p.byWTxID[wtxid] = entry
+	for idx := range tx.TxIn {
+		p.spends[tx.TxIn[idx].PreviousOutPoint] = entry
+	}
+	entry.element = p.order.PushBack(entry)
+	p.size += entry.Size
+
+	p.expire()
+	for p.size > p.cfg.MaxSize {
+		p.removeWithDescendants(p.order.Front().Value.(*Entry))
+	}
+
+	// it descends from an evicted entry
+	if _, ok := p.byTxID[txid]; !ok {
+		return nil, fmt.Errorf("%w: its ancestors were evicted", ErrTxTooLarge)
+	}
+	return entry, nil
+}
+
+// RemoveBlock removes the transactions confirmed by the block and the
+// ones conflicting with them, along with their descendants
+func (p *Pool) RemoveBlock(block *messages.Block) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	removed := 0
+	for _, tx := range block.Transactions {
+		if confirmed, ok := p.byTxID[tx.TxHash()]; ok {
+			// the descendants are still valid, they spend a confirmed output now
+			p.remove(confirmed)
+			removed++
+		}
+
+		for idx := range tx.TxIn {
+			if conflict, ok := p.spends[tx.TxIn[idx].PreviousOutPoint]; ok {
+				removed += p.removeWithDescendants(conflict)
+			}
+		}
+	}
+	return removed
+}
+
+// Expire removes the entries older than the max age and the announcements
+// whose request timed out, it should be called periodically so the
+// announcements of quiet peers do not stay forever
+func (p *Pool) Expire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire()
+}
+
+// Get returns the entry of the transaction, by txid or wtxid
+func (p *Pool) Get(hash messages.Hash) (*Entry, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.lookup(hash)
+}
+
+// Has tells if the transaction, by txid or wtxid, is in the pool
+func (p *Pool) Has(hash messages.Hash) bool {
+	_, ok := p.Get(hash)
+	return ok
+}
+
+// Len returns how many transactions are in the pool and their size in bytes
+func (p *Pool) Len() (count int, size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.byTxID), p.size
+}
+
+// Entries returns the entries from the oldest to the newest
+func (p *Pool) Entries() []*Entry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entries := make([]*Entry, 0, len(p.byTxID))
+	for element := p.order.Front(); element != nil; element = element.Next() {
+		entries = append(entries, element.Value.(*Entry))
+	}
+	return entries
+}
+
+// Transactions returns every transaction of the pool, so
+// compact blocks can be rebuilt from it
+func (p *Pool) Transactions() []*messages.Tx {
+	entries := p.Entries()
+
+	txs := make([]*messages.Tx, len(entries))
+	for idx, entry := range entries {
+		txs[idx] = entry.Tx
+	}
+	return txs
+}
+
+// AnnouncedBy returns the peers that announced the transaction, by txid or wtxid
+func (p *Pool) AnnouncedBy(hash messages.Hash) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var announcers map[string]struct{}
+	if entry, ok := p.lookup(hash); ok {
+		announcers = entry.announcers
+	} else if announced, ok := p.pending[hash]; ok {
+		announcers = announced.announcers
+	}
+
+	peers := make([]string, 0, len(announcers))
+	for peer := range announcers {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// Inventory is the answer to the mempool message of a peer (BIP35), the
+// transactions paying at least the peer feefilter are announced, by wtxid
+// if the peer negotiated wtxidrelay. Transactions whose fee is unknown
+// are only announced to peers without a feefilter
+// check: https://github.com/bitcoin/bips/blob/master/bip-0035.mediawiki
+func (p *Pool) Inventory(feeFilter int64, wtxidRelay bool) []messages.InvVect {
+	var inv []messages.InvVect
+	for _, entry := range p.Entries() {
+		if feeFilter > 0 {
+			if feeRate, ok := entry.FeeRate(); !ok || feeRate < feeFilter {
+				continue
+			}
+		}
+
+		if wtxidRelay {
+			inv = append(inv, messages.InvVect{Type: messages.InvWTx, Hash: entry.WTxID})
+		} else {
+			inv = append(inv, messages.InvVect{Type: messages.InvTx, Hash: entry.TxID})
+		}
+	}
+	return inv
+}
+
+func (p *Pool) lookup(hash messages.Hash) (*Entry, bool) {
+	if entry, ok := p.byTxID[hash]; ok {
+		return entry, true
+	}
+
+	entry, ok := p.byWTxID[hash]
+	return entry, ok
+}
+
+// fee sums the inputs, which must all be outputs of transactions in the pool
+func (p *Pool) fee(tx *messages.Tx) (int64, bool) {
+	var in, out int64
+	for idx := range tx.TxIn {
+		outpoint := tx.TxIn[idx].PreviousOutPoint
+
+		parent, ok := p.byTxID[outpoint.Hash]
+		if !ok || int(outpoint.Index) >= len(parent.Tx.TxOut) {
+			return 0, false
+		}
+		in += parent.Tx.TxOut[outpoint.Index].Value
+	}
+
+	for idx := range tx.TxOut {
+		out += tx.TxOut[idx].Value
+	}
+	return in - out, in >= out
+}
+
+func (p *Pool) expire() {
+	now := p.cfg.Now()
+	for element := p.order.Front(); element != nil; element = p.order.Front() {
+		entry := element.Value.(*Entry)
+		if now.Sub(entry.Added) < p.cfg.MaxAge {
+			break
+		}
+		p.removeWithDescendants(entry)
+	}
+
+	var timedOut []*pending
+	for element := p.pendingOrder.Front(); element != nil; element = element.Next() {
+		announced := element.Value.(*pending)
+		if now.Sub(announced.updatedAt) < p.cfg.RequestTimeout {
+			break
+		}
+		timedOut = append(timedOut, announced)
+	}
+
+	for _, announced := range timedOut {
+		if announced.requestedFrom == "" {
+			p.removePending(announced)
+			continue
+		}
+		p.dropRequest(announced, now)
+	}
+}
+
+// dropRequest gives up on the peer asked for the transaction, which is
+// not asked to it again, the other announcers are asked by NextRequests
+func (p *Pool) dropRequest(announced *pending, now time.Time) {
+	peer := announced.requestedFrom
+	state := p.peers[peer]
+	state.inFlight--
+	announced.requestedFrom = ""
+
+	delete(announced.announcers, peer)
+	delete(state.announced, announced.hash)
+	p.forgetIdle(peer)
+
+	if len(announced.announcers) == 0 {
+		p.removePending(announced)
+		return
+	}
+
+	announced.updatedAt = now
+	p.pendingOrder.MoveToBack(announced.element)
+}
+
+// peer returns the state of the peer, creating it if needed
+func (p *Pool) peer(peer string) *peerState {
+	state, ok := p.peers[peer]
+	if !ok {
+		state = &peerState{announced: make(map[messages.Hash]struct{})}
+		p.peers[peer] = state
+	}
+	return state
+}
+
+// forgetIdle drops the state of a peer with nothing pending
+func (p *Pool) forgetIdle(peer string) {
+	if state, ok := p.peers[peer]; ok && len(state.announced) == 0 && state.inFlight == 0 {
+		delete(p.peers, peer)
+	}
+}
+
+// request asks the pending transaction to the peer, unless
+// the peer already has as many requests in flight as allowed
+func (p *Pool) request(announced *pending, peer string, now time.Time) bool {
+	state := p.peer(peer)
+	if state.inFlight >= p.cfg.MaxPeerRequests {
+		return false
+	}
+
+	state.inFlight++
+	announced.requestedFrom = peer
+	announced.updatedAt = now
+	p.pendingOrder.MoveToBack(announced.element)
+	return true
+}
+
+// removePending forgets the announcement, releasing what it took from the peers limits
+func (p *Pool) removePending(announced *pending) {
+	delete(p.pending, announced.hash)
+	p.pendingOrder.Remove(announced.element)
+
+	// the peer asked is always one of the announcers
+	if announced.requestedFrom != "" {
+		p.peers[announced.requestedFrom].inFlight--
+	}
+
+	for peer := range announced.announcers {
+		delete(p.peers[peer].announced, announced.hash)
+		p.forgetIdle(peer)
+	}
+}
+
+// removeWithDescendants removes the entry and every entry spending its
+// outputs, directly or not, returning how many were removed
+func (p *Pool) removeWithDescendants(entry *Entry) int {
+	if _, ok := p.byTxID[entry.TxID]; !ok {
+		return 0
+	}
+	p.remove(entry)
+
+	removed := 1
+	for idx := range entry.Tx.TxOut {
+		child, ok := p.spends[messages.OutPoint{Hash: entry.TxID, Index: uint32(idx)}]
+		if ok {
+			removed += p.removeWithDescendants(child)
+		}
+	}
+	return removed
+}
+
+func (p *Pool) remove(entry *Entry) {
+	delete(p.byTxID, entry.TxID)
+	delete(p.byWTxID, entry.WTxID)
+	for idx := range entry.Tx.TxIn {
+		if spender := p.spends[entry.Tx.TxIn[idx].PreviousOutPoint]; spender == entry {
+			delete(p.spends, entry.Tx.TxIn[idx].PreviousOutPoint)
+		}
+	}
+
+	p.order.Remove(entry.element)
+	p.size -= entry.Size
+}
@@ -0,0 +1,254 @@
+package mempool_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/mempool"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+// clock is a fake time source the tests move forward
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+// spend builds a transaction spending the outpoints, the lock
+// time keeps transactions with the same outpoints different
+func spend(lockTime uint32, values []int64, outpoints ...messages.OutPoint) *messages.Tx {
+	tx := &messages.Tx{Version: 2, LockTime: lockTime}
+	for _, outpoint := range outpoints {
+		tx.TxIn = append(tx.TxIn, messages.TxIn{PreviousOutPoint: outpoint, SignatureScript: []byte{}})
+	}
+
+	for _, value := range values {
+		tx.TxOut = append(tx.TxOut, messages.TxOut{Value: value, PkScript: []byte{0x51}})
+	}
+	return tx
+}
+
+func TestPoolAnnouncements(t *testing.T) {
+	pool := mempool.New(mempool.Config{})
+	tx := spend(0, []int64{1000}, messages.OutPoint{Hash: messages.Hash{1}})
+	txid := tx.TxHash()
+
+	inv := []messages.InvVect{{Type: messages.InvTx, Hash: txid}, {Type: messages.InvBlock, Hash: messages.Hash{2}}}
+
+	// only the first announcer is asked, with the witness
+	require.Equal(t, []messages.InvVect{{Type: messages.InvWitnessTx, Hash: txid}}, pool.Announced("a", inv))
+	require.Empty(t, pool.Announced("b", inv))
+	require.Equal(t, []string{"a", "b"}, pool.AnnouncedBy(txid))
+
+	// a peer that can not find it lets the next announcer be asked
+	pool.NotFound("a", []messages.InvVect{{Type: messages.InvWitnessTx, Hash: txid}})
+	require.Equal(t, []messages.InvVect{{Type: messages.InvWitnessTx, Hash: txid}}, pool.Announced("b", inv))
+
+	entry, err := pool.Add("b", tx)
+	require.NoError(t, err)
+	require.Equal(t, "b", entry.From)
+	require.Empty(t, pool.Announced("c", inv))
+	require.Equal(t, []string{"b", "c"}, pool.AnnouncedBy(txid))
+
+	_, err = pool.Add("a", tx)
+	require.ErrorIs(t, err, mempool.ErrAlreadyKnown)
+
+	// announcements by wtxid are asked by wtxid
+	other := spend(1, []int64{1000}, messages.OutPoint{Hash: messages.Hash{3}})
+	wtxInv := []messages.InvVect{{Type: messages.InvWTx, Hash: other.WitnessHash()}}
+	require.Equal(t, wtxInv, pool.Announced("a", wtxInv))
+	require.Empty(t, pool.Announced("a", []messages.InvVect{{Type: messages.InvWTx, Hash: tx.WitnessHash()}}))
+}
+
+func TestPoolRequestTimeout(t *testing.T) {
+	clk := &clock{now: time.Unix(1700000000, 0)}
+	pool := mempool.New(mempool.Config{RequestTimeout: time.Minute, Now: clk.Now})
+
+	inv := []messages.InvVect{{Type: messages.InvTx, Hash: messages.Hash{1}}}
+	require.Len(t, pool.Announced("a", inv), 1)
+	require.Empty(t, pool.Announced("b", inv))
+
+	clk.now = clk.now.Add(time.Minute)
+	require.Len(t, pool.Announced("b", inv), 1)
+}
+
+func TestPoolRequestTimeoutAsksTheOtherAnnouncers(t *testing.T) {
+	clk := &clock{now: time.Unix(1700000000, 0)}
+	pool := mempool.New(mempool.Config{RequestTimeout: time.Minute, Now: clk.Now})
+
+	inv := []messages.InvVect{{Type: messages.InvTx, Hash: messages.Hash{1}}}
+	request := []messages.InvVect{{Type: messages.InvWitnessTx, Hash: messages.Hash{1}}}
+	require.Equal(t, request, pool.Announced("a", inv))
+	require.Empty(t, pool.Announced("b", inv))
+	require.Empty(t, pool.Announced("c", inv))
+
+	// the peer that did not answer is not asked again, the others are
+	clk.now = clk.now.Add(time.Minute)
+	pool.Expire()
+	require.Equal(t, []string{"b", "c"}, pool.AnnouncedBy(inv[0].Hash))
+	require.Empty(t, pool.NextRequests("a"))
+	require.Equal(t, request, pool.NextRequests("b"))
+	require.Empty(t, pool.NextRequests("c"))
+
+	clk.now = clk.now.Add(time.Minute)
+	pool.Expire()
+	require.Equal(t, []string{"c"}, pool.AnnouncedBy(inv[0].Hash))
+	require.Equal(t, request, pool.NextRequests("c"))
+
+	// nobody is left to ask
+	clk.now = clk.now.Add(time.Minute)
+	pool.Expire()
+	require.Empty(t, pool.AnnouncedBy(inv[0].Hash))
+	require.Equal(t, request, pool.Announced("a", inv))
+}
+
+func TestPoolPeerLimits(t *testing.T) {
+	clk := &clock{now: time.Unix(1700000000, 0)}
+	pool := mempool.New(mempool.Config{
+		RequestTimeout:       time.Minute,
+		MaxPeerAnnouncements: 3,
+		MaxPeerRequests:      2,
+		Now:                  clk.Now,
+	})
+
+	var inv []messages.InvVect
+	for idx := byte(1); idx <= 5; idx++ {
+		inv = append(inv, messages.InvVect{Type: messages.InvWTx, Hash: messages.Hash{idx}})
+	}
+
+	// only two are asked, the third waits and the others are dropped
+	require.Equal(t, inv[:2], pool.Announced("a", inv))
+	require.Equal(t, []string{"a"}, pool.AnnouncedBy(inv[2].Hash))
+	require.Empty(t, pool.AnnouncedBy(inv[3].Hash))
+	require.Empty(t, pool.NextRequests("a"))
+
+	// the limits are per peer
+	require.Equal(t, inv[2:4], pool.Announced("b", inv[2:]))
+
+	// an answered request lets the waiting one be asked
+	pool.NotFound("a", inv[:1])
+	require.Empty(t, pool.NextRequests("a"))
+	pool.NotFound("b", inv[2:3])
+	require.Equal(t, inv[2:3], pool.NextRequests("a"))
+
+	// expired announcements release the limits
+	clk.now = clk.now.Add(time.Minute)
+	pool.Expire()
+	for _, entry := range inv {
+		require.Empty(t, pool.AnnouncedBy(entry.Hash))
+	}
+	require.Equal(t, inv[3:5], pool.Announced("a", inv[3:]))
+}
+
+func TestPoolFees(t *testing.T) {
+	pool := mempool.New(mempool.Config{})
+
+	parent := spend(0, []int64{10_000, 5_000}, messages.OutPoint{Hash: messages.Hash{1}})
+	entry, err := pool.Add("a", parent)
+	require.NoError(t, err)
+	require.False(t, entry.FeeKnown)
+
+	child := spend(0, []int64{14_000}, messages.OutPoint{Hash: parent.TxHash(), Index: 0}, messages.OutPoint{Hash: parent.TxHash(), Index: 1})
+	entry, err = pool.Add("a", child)
+	require.NoError(t, err)
+	require.True(t, entry.FeeKnown)
+	require.Equal(t, int64(1000), entry.Fee)
+
+	feeRate, ok := entry.FeeRate()
+	require.True(t, ok)
+	require.Equal(t, int64(1000)*1000/int64(entry.VSize), feeRate)
+
+	// only the child fee is known, it is the only one paying the feefilter
+	require.Len(t, pool.Inventory(0, false), 2)
+	require.Equal(t, []messages.InvVect{{Type: messages.InvTx, Hash: child.TxHash()}}, pool.Inventory(feeRate, false))
+	require.Equal(t, []messages.InvVect{{Type: messages.InvWTx, Hash: child.WitnessHash()}}, pool.Inventory(feeRate, true))
+	require.Empty(t, pool.Inventory(feeRate+1, false))
+}
+
+func TestPoolReplacesConflicts(t *testing.T) {
+	pool := mempool.New(mempool.Config{})
+
+	outpoint := messages.OutPoint{Hash: messages.Hash{1}}
+	original := spend(0, []int64{1000}, outpoint)
+	_, err := pool.Add("a", original)
+	require.NoError(t, err)
+
+	child := spend(0, []int64{900}, messages.OutPoint{Hash: original.TxHash()})
+	_, err = pool.Add("a", child)
+	require.NoError(t, err)
+
+	// the replacement takes the original out along with its child
+	replacement := spend(1, []int64{500}, outpoint)
+	_, err = pool.Add("b", replacement)
+	require.NoError(t, err)
+
+	require.False(t, pool.Has(original.TxHash()))
+	require.False(t, pool.Has(child.TxHash()))
+	require.True(t, pool.Has(replacement.TxHash()))
+
+	count, _ := pool.Len()
+	require.Equal(t, 1, count)
+}
+
+func TestPoolRemoveBlock(t *testing.T) {
+	pool := mempool.New(mempool.Config{})
+
+	confirmed := spend(0, []int64{1000}, messages.OutPoint{Hash: messages.Hash{1}})
+	child := spend(0, []int64{900}, messages.OutPoint{Hash: confirmed.TxHash()})
+	conflicting := spend(0, []int64{1000}, messages.OutPoint{Hash: messages.Hash{2}})
+	for _, tx := range []*messages.Tx{confirmed, child, conflicting} {
+		_, err := pool.Add("a", tx)
+		require.NoError(t, err)
+	}
+
+	block := &messages.Block{Transactions: []*messages.Tx{
+		spend(0, []int64{50}),
+		confirmed,
+		spend(1, []int64{1000}, messages.OutPoint{Hash: messages.Hash{2}}),
+	}}
+	require.Equal(t, 2, pool.RemoveBlock(block))
+
+	// the child spends a confirmed output now, it is still valid
+	require.Equal(t, []*messages.Tx{child}, pool.Transactions())
+}
+
+func TestPoolEviction(t *testing.T) {
+	clk := &clock{now: time.Unix(1700000000, 0)}
+
+	txs := make([]*messages.Tx, 4)
+	for idx := range txs {
+		txs[idx] = spend(uint32(idx), []int64{1000}, messages.OutPoint{Hash: messages.Hash{byte(idx)}})
+	}
+
+	enc, err := txs[0].Encode()
+	require.NoError(t, err)
+
+	// room for three transactions
+	pool := mempool.New(mempool.Config{MaxSize: 3 * len(enc), MaxAge: time.Hour, Now: clk.Now})
+	for _, tx := range txs {
+		_, err := pool.Add("a", tx)
+		require.NoError(t, err)
+		clk.now = clk.now.Add(time.Minute)
+	}
+
+	count, size := pool.Len()
+	require.Equal(t, 3, count)
+	require.Equal(t, 3*len(enc), size)
+	require.False(t, pool.Has(txs[0].TxHash()))
+
+	entries := pool.Entries()
+	require.Equal(t, txs[1].TxHash(), entries[0].TxID)
+	require.Equal(t, txs[3].TxHash(), entries[2].TxID)
+
+	// entries older than the max age are expired
+	clk.now = entries[1].Added.Add(time.Hour)
+	pool.Expire()
+	require.Equal(t, []*messages.Tx{txs[3]}, pool.Transactions())
+
+	_, err = pool.Add("a", spend(9, make([]int64, 1000), messages.OutPoint{}))
+	require.ErrorIs(t, err, mempool.ErrTxTooLarge)
+}
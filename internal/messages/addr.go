@@ -0,0 +1,103 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+)
+
+// MaxAddrEntries is the maximum number of addresses in a single addr message
+const MaxAddrEntries = 1000
+
+var ErrTooManyAddresses = errors.New("too many addresses")
+
+var _ codec.Encodeable = (*TimestampedNetworkAddress)(nil)
+var _ codec.Encodeable = (*Addr)(nil)
+
+// TimestampedNetworkAddress is the network address as it appears in the addr
+// message, the time is a unix timestamp in seconds encoded as an uint32, which
+// is enough to represent dates until 2106 without data loss
+type TimestampedNetworkAddress struct {
+	Time uint32
+	NetworkAddress
+}
+
+func (t *TimestampedNetworkAddress) String() string {
+	return fmt.Sprintf("[time=%s] %s", time.Unix(int64(t.Time), 0).UTC().Format(time.RFC3339), t.NetworkAddress.String())
+}
+
+func (t *TimestampedNetworkAddress) Encode() ([]byte, error) {
+	encodedTime := make([]byte, 4)
+	binary.LittleEndian.PutUint32(encodedTime, t.Time)
+
+	encodedAddr, err := t.NetworkAddress.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(encodedTime, encodedAddr...), nil
+}
+
+func (t *TimestampedNetworkAddress) Decode(r io.Reader) error {
+	enc := make([]byte, 4)
+	_, err := io.ReadFull(r, enc)
+	if err != nil {
+		return fmt.Errorf("reading time: %w", err)
+	}
+	t.Time = binary.LittleEndian.Uint32(enc)
+
+	return t.NetworkAddress.Decode(r)
+}
+
+// Addr announces known active peers, it is sent in response to getaddr
+// check: https://en.bitcoin.it/wiki/Protocol_documentation#addr
+type Addr struct {
+	Addresses []TimestampedNetworkAddress
+}
+
+func (a *Addr) String() string {
+	return fmt.Sprintf("[count=%d]", len(a.Addresses))
+}
+
+func (a *Addr) Encode() ([]byte, error) {
+	if len(a.Addresses) > MaxAddrEntries {
+		return nil, fmt.Errorf("%w: %d, limit is %d", ErrTooManyAddresses, len(a.Addresses), MaxAddrEntries)
+	}
+
+	encoded := bytes.NewBuffer(codec.EncodeToVarint(uint64(len(a.Addresses))))
+	for idx := range a.Addresses {
+		encodedAddr, err := a.Addresses[idx].Encode()
+		if err != nil {
+			return nil, fmt.Errorf("while encoding address %d: %w", idx, err)
+		}
+		encoded.Write(encodedAddr)
+	}
+
+	return encoded.Bytes(), nil
+}
+
+func (a *Addr) Decode(r io.Reader) error {
+	count, err := codec.DecodeFromVarint(r)
+	if err != nil {
+		return fmt.Errorf("while decoding addresses count: %w", err)
+	}
+
+	if count > MaxAddrEntries {
+		return fmt.Errorf("%w: %d, limit is %d", ErrTooManyAddresses, count, MaxAddrEntries)
+	}
+
+	a.Addresses = make([]TimestampedNetworkAddress, count)
+	for idx := range a.Addresses {
+		err = a.Addresses[idx].Decode(r)
+		if err != nil {
+			return fmt.Errorf("while decoding address %d: %w", idx, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package messages_test
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddrEncoding(t *testing.T) {
+	// taken from an example at: https://en.bitcoin.it/wiki/Protocol_documentation#addr
+	testEncoded := []byte{
+		0x01,                   // 1 address in this message
+		0xE2, 0x15, 0x10, 0x4D, // Mon Dec 20 21:50:10 EST 2010
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // NODE_NETWORK service
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xFF, 0xFF, 0x0A, 0x00, 0x00, 0x01, // ip addr
+		0x20, 0x8D, // port
+	}
+
+	addr := &messages.Addr{}
+	err := addr.Decode(bytes.NewReader(testEncoded))
+	require.NoError(t, err)
+
+	expectedAddr := &messages.Addr{
+		Addresses: []messages.TimestampedNetworkAddress{
+			{
+				Time: 1292899810,
+				NetworkAddress: messages.NetworkAddress{
+					Services: messages.NodeNetwork,
+					IpV6V4:   netip.MustParseAddr("10.0.0.1"),
+					Port:     8333,
+				},
+			},
+		},
+	}
+	require.Equal(t, expectedAddr, addr)
+
+	encoded, err := expectedAddr.Encode()
+	require.NoError(t, err)
+	require.Equal(t, testEncoded, encoded)
+}
+
+func TestAddrLimit(t *testing.T) {
+	addr := &messages.Addr{
+		Addresses: make([]messages.TimestampedNetworkAddress, messages.MaxAddrEntries+1),
+	}
+
+	_, err := addr.Encode()
+	require.ErrorIs(t, err, messages.ErrTooManyAddresses)
+
+	// the count is validated before reading any address
+	encodedCount := codec.EncodeToVarint(messages.MaxAddrEntries + 1)
+	err = addr.Decode(bytes.NewReader(encodedCount))
+	require.ErrorIs(t, err, messages.ErrTooManyAddresses)
+}
@@ -0,0 +1,299 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+	"golang.org/x/crypto/sha3"
+)
+
+// AddrV2MinVersion is the minimum protocol version we send sendaddrv2 to,
+// BIP155 allows it for every version, but as a courtesy (same as bitcoin core)
+// we do not send it to older peers that might not know the message
+const AddrV2MinVersion = 70016
+
+// MaxAddrV2Size is the biggest address, in bytes, allowed by BIP155
+const MaxAddrV2Size = 512
+
+var ErrInvalidAddrV2 = errors.New("invalid addrv2 address")
+
+// NetworkID identifies the network of an addrv2 address
+// check: https://github.com/bitcoin/bips/blob/master/bip-0155.mediawiki
+type NetworkID uint8
+
+const (
+	NetIPv4  NetworkID = 1
+	NetIPv6  NetworkID = 2
+	NetTorV2 NetworkID = 3
+	NetTorV3 NetworkID = 4
+	NetI2P   NetworkID = 5
+	NetCJDNS NetworkID = 6
+)
+
+func (n NetworkID) String() string {
+	switch n {
+	case NetIPv4:
+		return "IPv4"
+	case NetIPv6:
+		return "IPv6"
+	case NetTorV2:
+		return "TorV2"
+	case NetTorV3:
+		return "TorV3"
+	case NetI2P:
+		return "I2P"
+	case NetCJDNS:
+		return "CJDNS"
+	default:
+		return "undefined"
+	}
+}
+
+// addressSize returns the size each known network address must have
+func (n NetworkID) addressSize() (int, bool) {
+	switch n {
+	case NetIPv4:
+		return 4, true
+	case NetIPv6, NetCJDNS:
+		return 16, true
+	case NetTorV2:
+		return 10, true
+	case NetTorV3, NetI2P:
+		return 32, true
+	default:
+		return 0, false
+	}
+}
+
+var _ codec.Encodeable = (*AddrV2Entry)(nil)
+var _ codec.Encodeable = (*AddrV2)(nil)
+
+// onionBase32 is the lowercase, unpadded, base32 used by tor and i2p hostnames
+var onionBase32 = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// AddrV2Entry is an address as announced in the addrv2 message, unlike
+// the NetworkAddress the address length depends on the network
+type AddrV2Entry struct {
+	Time      uint32
+	Services  uint64
+	NetworkID NetworkID
+	Addr      []byte
+	Port      uint16
+}
+
+func (a *AddrV2Entry) String() string {
+	return fmt.Sprintf("[time=%s] [services=%08b] [network=%s] [host=%s] [port=%d]",
+		time.Unix(int64(a.Time), 0).UTC().Format(time.RFC3339), a.Services, a.NetworkID.String(), a.Host(), a.Port)
+}
+
+// IsKnown reports whether the entry belongs to a network we know how
+// to handle, BIP155 says unknown networks should be ignored
+func (a *AddrV2Entry) IsKnown() bool {
+	_, ok := a.NetworkID.addressSize()
+	return ok
+}
+
+// IP returns the address as a netip.Addr for the IP based networks (IPv4, IPv6 and CJDNS)
+func (a *AddrV2Entry) IP() (netip.Addr, bool) {
+	switch a.NetworkID {
+	case NetIPv4, NetIPv6, NetCJDNS:
+		return netip.AddrFromSlice(a.Addr)
+	default:
+		return netip.Addr{}, false
+	}
+}
+
+// Host returns the address in a form that can be dialed, an IP
+// for the IP based networks or a hostname for tor and i2p
+func (a *AddrV2Entry) Host() string {
+	switch a.NetworkID {
+	case NetIPv4, NetIPv6, NetCJDNS:
+		ip, ok := a.IP()
+		if !ok {
+			return ""
+		}
+		return ip.String()
+	case NetTorV2:
+		return onionBase32.EncodeToString(a.Addr) + ".onion"
+	case NetTorV3:
+		return torV3Host(a.Addr)
+	case NetI2P:
+		return onionBase32.EncodeToString(a.Addr) + ".b32.i2p"
+	default:
+		return fmt.Sprintf("0x%x", a.Addr)
+	}
+}
+
+// torV3Host builds the onion hostname from the service public key
+// check: https://spec.torproject.org/rend-spec/encoding-onion-addresses.html
+func torV3Host(pubkey []byte) string {
+	const version = 0x03
+
+	checksumInput := bytes.Join([][]byte{[]byte(".onion checksum"), pubkey, {version}}, nil)
+	checksum := sha3.Sum256(checksumInput)
+
+	address := bytes.Join([][]byte{pubkey, checksum[:2], {version}}, nil)
+	return onionBase32.EncodeToString(address) + ".onion"
+}
+
+// NetworkAddress converts IPv4 and IPv6 entries to the format used by
+// the addr and version messages, other networks can not be represented there
+func (a *AddrV2Entry) NetworkAddress() (*TimestampedNetworkAddress, bool) {
+	if a.NetworkID != NetIPv4 && a.NetworkID != NetIPv6 {
+		return nil, false
+	}
+
+	ip, ok := a.IP()
+	if !ok {
+		return nil, false
+	}
+
+	return &TimestampedNetworkAddress{
+		Time: a.Time,
+		NetworkAddress: NetworkAddress{
+			Services: a.Services,
+			IpV6V4:   ip,
+			Port:     a.Port,
+		},
+	}, true
+}
+
+func (a *AddrV2Entry) validate() error {
+	if len(a.Addr) > MaxAddrV2Size {
+		return fmt.Errorf("%w: %d bytes, limit is %d", ErrInvalidAddrV2, len(a.Addr), MaxAddrV2Size)
+	}
+
+	expectedSize, ok := a.NetworkID.addressSize()
+	if ok && len(a.Addr) != expectedSize {
+		return fmt.Errorf("%w: %s address must have %d bytes, got: %d",
+			ErrInvalidAddrV2, a.NetworkID.String(), expectedSize, len(a.Addr))
+	}
+
+	return nil
+}
+
+func (a *AddrV2Entry) Encode() ([]byte, error) {
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+
+	encodedTime := make([]byte, 4)
+	binary.LittleEndian.PutUint32(encodedTime, a.Time)
+
+	encodedPort := make([]byte, 2)
+	binary.BigEndian.PutUint16(encodedPort, a.Port)
+
+	return bytes.Join([][]byte{
+		encodedTime,
+		codec.EncodeToVarint(a.Services),
+		{byte(a.NetworkID)},
+		codec.EncodeToVarint(uint64(len(a.Addr))),
+		a.Addr,
+		encodedPort,
+	}, nil), nil
+}
+
+func (a *AddrV2Entry) Decode(r io.Reader) (err error) {
+	enc := make([]byte, 4)
+	_, err = io.ReadFull(r, enc)
+	if err != nil {
+		return fmt.Errorf("reading time: %w", err)
+	}
+	a.Time = binary.LittleEndian.Uint32(enc)
+
+	a.Services, err = codec.DecodeFromVarint(r)
+	if err != nil {
+		return fmt.Errorf("reading services: %w", err)
+	}
+
+	enc = make([]byte, 1)
+	_, err = io.ReadFull(r, enc)
+	if err != nil {
+		return fmt.Errorf("reading network id: %w", err)
+	}
+	a.NetworkID = NetworkID(enc[0])
+
+	addrLen, err := codec.DecodeFromVarint(r)
+	if err != nil {
+		return fmt.Errorf("reading address length: %w", err)
+	}
+
+	// the length is validated before allocating the address
+	if addrLen > MaxAddrV2Size {
+		return fmt.Errorf("%w: %d bytes, limit is %d", ErrInvalidAddrV2, addrLen, MaxAddrV2Size)
+	}
+
+	a.Addr = make([]byte, addrLen)
+	_, err = io.ReadFull(r, a.Addr)
+	if err != nil {
+		return fmt.Errorf("reading address: %w", err)
+	}
+
+	if err := a.validate(); err != nil {
+		return err
+	}
+
+	enc = make([]byte, 2)
+	_, err = io.ReadFull(r, enc)
+	if err != nil {
+		return fmt.Errorf("reading port: %w", err)
+	}
+	a.Port = binary.BigEndian.Uint16(enc)
+
+	return nil
+}
+
+// AddrV2 announces known peers using the BIP155 format, it is only
+// sent to peers that signaled support through sendaddrv2
+type AddrV2 struct {
+	Addresses []AddrV2Entry
+}
+
+func (a *AddrV2) String() string {
+	return fmt.Sprintf("[count=%d]", len(a.Addresses))
+}
+
+func (a *AddrV2) Encode() ([]byte, error) {
+	if len(a.Addresses) > MaxAddrEntries {
+		return nil, fmt.Errorf("%w: %d, limit is %d", ErrTooManyAddresses, len(a.Addresses), MaxAddrEntries)
+	}
+
+	encoded := bytes.NewBuffer(codec.EncodeToVarint(uint64(len(a.Addresses))))
+	for idx := range a.Addresses {
+		encodedAddr, err := a.Addresses[idx].Encode()
+		if err != nil {
+			return nil, fmt.Errorf("while encoding address %d: %w", idx, err)
+		}
+		encoded.Write(encodedAddr)
+	}
+
+	return encoded.Bytes(), nil
+}
+
+func (a *AddrV2) Decode(r io.Reader) error {
+	count, err := codec.DecodeFromVarint(r)
+	if err != nil {
+		return fmt.Errorf("while decoding addresses count: %w", err)
+	}
+
+	if count > MaxAddrEntries {
+		return fmt.Errorf("%w: %d, limit is %d", ErrTooManyAddresses, count, MaxAddrEntries)
+	}
+
+	a.Addresses = make([]AddrV2Entry, count)
+	for idx := range a.Addresses {
+		err = a.Addresses[idx].Decode(r)
+		if err != nil {
+			return fmt.Errorf("while decoding address %d: %w", idx, err)
+		}
+	}
+
+	return nil
+}
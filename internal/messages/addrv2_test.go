@@ -0,0 +1,125 @@
+package messages_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/netip"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddrV2EntryHosts(t *testing.T) {
+	// network id, address and expected host, taken from bitcoin core net_tests
+	cases := []struct {
+		networkID messages.NetworkID
+		addr      string
+		host      string
+	}{
+		{
+			networkID: messages.NetIPv4,
+			addr:      "01020304",
+			host:      "1.2.3.4",
+		},
+		{
+			networkID: messages.NetIPv6,
+			addr:      "0102030405060708090a0b0c0d0e0f10",
+			host:      "102:304:506:708:90a:b0c:d0e:f10",
+		},
+		{
+			networkID: messages.NetTorV3,
+			addr:      "79bcc625184b05194975c28b66b66b0469f7f6556fb1ac3189a79b40dda32f1f",
+			host:      "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion",
+		},
+		{
+			networkID: messages.NetI2P,
+			addr:      "a2894dabaec08c0051a481a6dac88b64f98232ae42d4b6fd2fa81952dfe36a87",
+			host:      "ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7dnkdq.b32.i2p",
+		},
+		{
+			networkID: messages.NetCJDNS,
+			addr:      "fc000001000200030004000500060007",
+			host:      "fc00:1:2:3:4:5:6:7",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.networkID.String(), func(t *testing.T) {
+			addr, err := hex.DecodeString(tt.addr)
+			require.NoError(t, err)
+
+			entry := &messages.AddrV2Entry{
+				Time:      1610000000,
+				Services:  messages.NodeNetwork | messages.NodeWitness,
+				NetworkID: tt.networkID,
+				Addr:      addr,
+				Port:      8333,
+			}
+			require.True(t, entry.IsKnown())
+			require.Equal(t, tt.host, entry.Host())
+
+			encoded, err := entry.Encode()
+			require.NoError(t, err)
+
+			decoded := &messages.AddrV2Entry{}
+			require.NoError(t, decoded.Decode(bytes.NewReader(encoded)))
+			require.Equal(t, entry, decoded)
+		})
+	}
+}
+
+func TestAddrV2Encoding(t *testing.T) {
+	testEncoded := []byte{
+		0x02,                   // 2 addresses
+		0x00, 0xF1, 0xF6, 0x5F, // time
+		0xFD, 0x09, 0x04, // compact size services (NODE_NETWORK | NODE_WITNESS | NODE_NETWORK_LIMITED)
+		0x01, 0x04, 0x01, 0x02, 0x03, 0x04, // ipv4 1.2.3.4
+		0x20, 0x8D, // port
+		0x00, 0xF1, 0xF6, 0x5F, // time
+		0x00,                         // no services
+		0x07, 0x03, 0xAA, 0xBB, 0xCC, // unknown network, 3 bytes
+		0x00, 0x00, // port
+	}
+
+	addrv2 := &messages.AddrV2{}
+	require.NoError(t, addrv2.Decode(bytes.NewReader(testEncoded)))
+	require.Len(t, addrv2.Addresses, 2)
+
+	ipv4, ok := addrv2.Addresses[0].NetworkAddress()
+	require.True(t, ok)
+	require.Equal(t, &messages.TimestampedNetworkAddress{
+		Time: 1610019072,
+		NetworkAddress: messages.NetworkAddress{
+			Services: messages.NodeNetwork | messages.NodeWitness | messages.NodeNetworkLimited,
+			IpV6V4:   netip.MustParseAddr("1.2.3.4"),
+			Port:     8333,
+		},
+	}, ipv4)
+
+	// unknown networks are decoded but should be ignored
+	require.False(t, addrv2.Addresses[1].IsKnown())
+	_, ok = addrv2.Addresses[1].NetworkAddress()
+	require.False(t, ok)
+
+	encoded, err := addrv2.Encode()
+	require.NoError(t, err)
+	require.Equal(t, testEncoded, encoded)
+}
+
+func TestAddrV2Validation(t *testing.T) {
+	// an ipv4 address with 5 bytes
+	entry := &messages.AddrV2Entry{NetworkID: messages.NetIPv4, Addr: make([]byte, 5)}
+	_, err := entry.Encode()
+	require.ErrorIs(t, err, messages.ErrInvalidAddrV2)
+
+	encoded := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x04, 0x02, 0xAA, 0xBB, 0x00, 0x00}
+	err = entry.Decode(bytes.NewReader(encoded))
+	require.ErrorIs(t, err, messages.ErrInvalidAddrV2)
+
+	// addresses bigger than 512 bytes are rejected before being read
+	encoded = append([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x07}, codec.EncodeToVarint(messages.MaxAddrV2Size+1)...)
+	err = entry.Decode(bytes.NewReader(encoded))
+	require.ErrorIs(t, err, messages.ErrInvalidAddrV2)
+}
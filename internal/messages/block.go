@@ -0,0 +1,86 @@
+package messages
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+)
+
+var (
+	ErrBadMerkleRoot   = errors.New("merkle root does not match the header")
+	ErrMutatedBlock    = errors.New("block has duplicated transactions")
+	ErrBlockWithoutTxs = errors.New("block without transactions")
+)
+
+var _ codec.Encodeable = (*Block)(nil)
+
+// Block is a header followed by the transactions it commits to
+// check: https://en.bitcoin.it/wiki/Protocol_documentation#block
+type Block struct {
+	Header       BlockHeader
+	Transactions []*Tx
+}
+
+func (b *Block) String() string {
+	return fmt.Sprintf("%s [txs=%d]", b.Header.String(), len(b.Transactions))
+}
+
+// BlockHash is the hash of the block header
+func (b *Block) BlockHash() Hash {
+	return b.Header.BlockHash()
+}
+
+// MerkleRoot computes the root of the txids of the block transactions
+func (b *Block) MerkleRoot() (root Hash, mutated bool) {
+	hashes := make([]Hash, len(b.Transactions))
+	for idx, tx := range b.Transactions {
+		hashes[idx] = tx.TxHash()
+	}
+	return CalcMerkleRoot(hashes)
+}
+
+// CheckMerkleRoot checks the transactions are the ones committed by the header
+func (b *Block) CheckMerkleRoot() error {
+	if len(b.Transactions) == 0 {
+		return ErrBlockWithoutTxs
+	}
+
+	root, mutated := b.MerkleRoot()
+	if mutated {
+		return ErrMutatedBlock
+	}
+
+	if root != b.Header.MerkleRoot {
+		return fmt.Errorf("%w: header has %s, transactions give %s", ErrBadMerkleRoot, b.Header.MerkleRoot, root)
+	}
+	return nil
+}
+
+func (b *Block) Encode() ([]byte, error) {
+	header, err := b.Header.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	enc := bytes.NewBuffer(header)
+	enc.Write(codec.EncodeToVarint(uint64(len(b.Transactions))))
+	encodeTxs(enc, b.Transactions)
+	return enc.Bytes(), nil
+}
+
+func (b *Block) Decode(r io.Reader) error {
+	if err := b.Header.Decode(r); err != nil {
+		return err
+	}
+
+	count, err := readCount(r, MaxPayloadLength/minTxSize)
+	if err != nil {
+		return fmt.Errorf("while decoding transactions count: %w", err)
+	}
+
+	b.Transactions, err = decodeTxs(r, count)
+	return err
+}
@@ -0,0 +1,72 @@
+package messages_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenesisBlock(t *testing.T) {
+	enc, err := hex.DecodeString(genesisHeaderHex + "01" + genesisCoinbaseHex)
+	require.NoError(t, err)
+
+	block := new(messages.Block)
+	require.NoError(t, block.Decode(bytes.NewReader(enc)))
+	require.Len(t, block.Transactions, 1)
+	require.NoError(t, block.CheckMerkleRoot())
+
+	hash := block.BlockHash()
+	require.Equal(t, "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f", hash.String())
+
+	reencoded, err := block.Encode()
+	require.NoError(t, err)
+	require.Equal(t, enc, reencoded)
+
+	block.Header.MerkleRoot = messages.Hash{}
+	require.ErrorIs(t, block.CheckMerkleRoot(), messages.ErrBadMerkleRoot)
+}
+
+func TestBlockWithWitnessTx(t *testing.T) {
+	enc, err := hex.DecodeString(genesisCoinbaseHex)
+	require.NoError(t, err)
+
+	coinbase := new(messages.Tx)
+	require.NoError(t, coinbase.Decode(bytes.NewReader(enc)))
+
+	block := &messages.Block{Transactions: []*messages.Tx{coinbase, segwitTx()}}
+	block.Header.MerkleRoot, _ = block.MerkleRoot()
+
+	raw, err := block.Encode()
+	require.NoError(t, err)
+
+	decoded := new(messages.Block)
+	require.NoError(t, decoded.Decode(bytes.NewReader(raw)))
+	require.Equal(t, block, decoded)
+	require.NoError(t, decoded.CheckMerkleRoot())
+
+	require.ErrorIs(t, new(messages.Block).CheckMerkleRoot(), messages.ErrBlockWithoutTxs)
+}
+
+func TestCalcMerkleRoot(t *testing.T) {
+	a, b, c := messages.Hash{1}, messages.Hash{2}, messages.Hash{3}
+	pair := func(l, r messages.Hash) messages.Hash {
+		return messages.DoubleSHA256(append(l[:], r[:]...))
+	}
+
+	root, mutated := messages.CalcMerkleRoot([]messages.Hash{a})
+	require.Equal(t, a, root)
+	require.False(t, mutated)
+
+	// the odd hash is paired with itself
+	root, mutated = messages.CalcMerkleRoot([]messages.Hash{a, b, c})
+	require.Equal(t, pair(pair(a, b), pair(c, c)), root)
+	require.False(t, mutated)
+
+	// duplicating the last transaction gives the same root, but it is detected
+	duplicated, mutated := messages.CalcMerkleRoot([]messages.Hash{a, b, c, c})
+	require.Equal(t, root, duplicated)
+	require.True(t, mutated)
+}
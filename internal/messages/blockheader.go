@@ -0,0 +1,121 @@
+package messages
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+)
+
+const (
+	HashSize = 32
+	// BlockHeaderSize is the size of an encoded block header
+	BlockHeaderSize = 80
+)
+
+var ErrInvalidHash = errors.New("invalid hash")
+
+// Hash is a double-SHA256 hash in the byte order it is sent on the
+// wire, which is the reverse of the order used by block explorers
+type Hash [HashSize]byte
+
+// String returns the hash in the (reversed) block explorer order
+func (h Hash) String() string {
+	reversed := h
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+	return hex.EncodeToString(reversed[:])
+}
+
+// NewHashFromString parses a hash in the block explorer order
+func NewHashFromString(s string) (Hash, error) {
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return Hash{}, fmt.Errorf("%w: %w", ErrInvalidHash, err)
+	}
+
+	if len(raw) != HashSize {
+		return Hash{}, fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidHash, HashSize, len(raw))
+	}
+
+	var h Hash
+	for i := range raw {
+		h[HashSize-1-i] = raw[i]
+	}
+	return h, nil
+}
+
+// DoubleSHA256 is the hash function used for blocks and transactions
+func DoubleSHA256(b []byte) Hash {
+	first := sha256.Sum256(b)
+	return sha256.Sum256(first[:])
+}
+
+func readHash(r io.Reader) (Hash, error) {
+	var h Hash
+	_, err := io.ReadFull(r, h[:])
+	return h, err
+}
+
+var _ codec.Encodeable = (*BlockHeader)(nil)
+
+// BlockHeader commits to the block transactions through the merkle root
+// and to the previous block, its hash must be below the target encoded in Bits
+// check: https://en.bitcoin.it/wiki/Protocol_documentation#Block_Headers
+type BlockHeader struct {
+	Version    int32
+	PrevBlock  Hash
+	MerkleRoot Hash
+	Timestamp  uint32
+	Bits       uint32
+	Nonce      uint32
+}
+
+func (b *BlockHeader) String() string {
+	return fmt.Sprintf("[hash=%s] [prev=%s] [time=%s] [bits=0x%08x]",
+		b.BlockHash(), b.PrevBlock, b.Time().UTC().Format(time.RFC3339), b.Bits)
+}
+
+// Time returns the header timestamp
+func (b *BlockHeader) Time() time.Time {
+	return time.Unix(int64(b.Timestamp), 0)
+}
+
+// BlockHash is the double-SHA256 of the encoded header
+func (b *BlockHeader) BlockHash() Hash {
+	enc, _ := b.Encode()
+	return DoubleSHA256(enc)
+}
+
+func (b *BlockHeader) Encode() ([]byte, error) {
+	enc := make([]byte, 0, BlockHeaderSize)
+	enc = binary.LittleEndian.AppendUint32(enc, uint32(b.Version))
+	enc = append(enc, b.PrevBlock[:]...)
+	enc = append(enc, b.MerkleRoot[:]...)
+	enc = binary.LittleEndian.AppendUint32(enc, b.Timestamp)
+	enc = binary.LittleEndian.AppendUint32(enc, b.Bits)
+	enc = binary.LittleEndian.AppendUint32(enc, b.Nonce)
+	return enc, nil
+}
+
+func (b *BlockHeader) Decode(r io.Reader) error {
+	enc := make([]byte, BlockHeaderSize)
+	_, err := io.ReadFull(r, enc)
+	if err != nil {
+		return fmt.Errorf("while reading block header: %w", err)
+	}
+
+	b.Version = int32(binary.LittleEndian.Uint32(enc[0:4]))
+	copy(b.PrevBlock[:], enc[4:36])
+	copy(b.MerkleRoot[:], enc[36:68])
+	b.Timestamp = binary.LittleEndian.Uint32(enc[68:72])
+	b.Bits = binary.LittleEndian.Uint32(enc[72:76])
+	b.Nonce = binary.LittleEndian.Uint32(enc[76:80])
+	return nil
+}
@@ -0,0 +1,56 @@
+package messages_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+// the mainnet genesis block header
+const genesisHeaderHex = "01000000" +
+	"0000000000000000000000000000000000000000000000000000000000000000" +
+	"3ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a" +
+	"29ab5f49ffff001d1dac2b7c"
+
+func TestBlockHeaderEncoding(t *testing.T) {
+	enc, err := hex.DecodeString(genesisHeaderHex)
+	require.NoError(t, err)
+
+	header := new(messages.BlockHeader)
+	require.NoError(t, header.Decode(bytes.NewReader(enc)))
+
+	require.Equal(t, int32(1), header.Version)
+	require.Equal(t, messages.Hash{}, header.PrevBlock)
+	require.Equal(t, "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b", header.MerkleRoot.String())
+	require.Equal(t, uint32(1231006505), header.Timestamp)
+	require.Equal(t, uint32(0x1d00ffff), header.Bits)
+	require.Equal(t, uint32(2083236893), header.Nonce)
+
+	hash := header.BlockHash()
+	require.Equal(t, "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f", hash.String())
+
+	reencoded, err := header.Encode()
+	require.NoError(t, err)
+	require.Equal(t, enc, reencoded)
+
+	err = header.Decode(bytes.NewReader(enc[:79]))
+	require.Error(t, err)
+}
+
+func TestHashFromString(t *testing.T) {
+	hash, err := messages.NewHashFromString("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f")
+	require.NoError(t, err)
+
+	// the wire order is reversed
+	require.Equal(t, byte(0x6f), hash[0])
+	require.Equal(t, byte(0x00), hash[31])
+
+	_, err = messages.NewHashFromString("00ff")
+	require.ErrorIs(t, err, messages.ErrInvalidHash)
+
+	_, err = messages.NewHashFromString("not hex")
+	require.ErrorIs(t, err, messages.ErrInvalidHash)
+}
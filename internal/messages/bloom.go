@@ -0,0 +1,147 @@
+package messages
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+)
+
+const (
+	// MaxBloomFilterSize is the maximum size in bytes of a bloom filter
+	MaxBloomFilterSize = 36000
+	// MaxBloomHashFuncs is the maximum number of hash functions of a bloom filter
+	MaxBloomHashFuncs = 50
+	// MaxFilterAddSize is the maximum size of an element added by filteradd
+	MaxFilterAddSize = 520
+)
+
+// BloomUpdate tells the remote how to update the filter when a
+// transaction output matches, so the transactions spending it match too
+type BloomUpdate uint8
+
+const (
+	// BloomUpdateNone never updates the filter
+	BloomUpdateNone BloomUpdate = 0
+	// BloomUpdateAll adds the outpoint of every matching output
+	BloomUpdateAll BloomUpdate = 1
+	// BloomUpdateP2PubKeyOnly adds the outpoint of matching outputs
+	// paying to a public key or a bare multisig
+	BloomUpdateP2PubKeyOnly BloomUpdate = 2
+)
+
+var ErrInvalidBloomFilter = errors.New("invalid bloom filter")
+
+var _ codec.Encodeable = (*FilterLoad)(nil)
+var _ codec.Encodeable = (*FilterAdd)(nil)
+
+func (b BloomUpdate) String() string {
+	switch b {
+	case BloomUpdateNone:
+		return "none"
+	case BloomUpdateAll:
+		return "all"
+	case BloomUpdateP2PubKeyOnly:
+		return "p2pubkey-only"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(b))
+	}
+}
+
+// FilterLoad sets the bloom filter of the connection, from then on
+// the remote only relays the transactions matching it and sends
+// merkleblock instead of block when filtered blocks are asked
+// check: https://github.com/bitcoin/bips/blob/master/bip-0037.mediawiki
+type FilterLoad struct {
+	Filter    []byte
+	HashFuncs uint32
+	Tweak     uint32
+	Flags     BloomUpdate
+}
+
+func (f *FilterLoad) String() string {
+	return fmt.Sprintf("[size=%d] [hashfuncs=%d] [tweak=%d] [flags=%s]", len(f.Filter), f.HashFuncs, f.Tweak, f.Flags)
+}
+
+func (f *FilterLoad) Encode() ([]byte, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+
+	enc := appendVarBytes(nil, f.Filter)
+	enc = binary.LittleEndian.AppendUint32(enc, f.HashFuncs)
+	enc = binary.LittleEndian.AppendUint32(enc, f.Tweak)
+	return append(enc, byte(f.Flags)), nil
+}
+
+func (f *FilterLoad) Decode(r io.Reader) (err error) {
+	size, err := codec.DecodeFromVarint(r)
+	if err != nil {
+		return fmt.Errorf("while decoding filter size: %w", err)
+	}
+
+	if size > MaxBloomFilterSize {
+		return fmt.Errorf("%w: %d bytes, limit is %d", ErrInvalidBloomFilter, size, MaxBloomFilterSize)
+	}
+
+	f.Filter = make([]byte, size)
+	if _, err := io.ReadFull(r, f.Filter); err != nil {
+		return fmt.Errorf("while reading filter: %w", err)
+	}
+
+	enc := make([]byte, 9)
+	if _, err := io.ReadFull(r, enc); err != nil {
+		return fmt.Errorf("while reading filter parameters: %w", err)
+	}
+
+	f.HashFuncs = binary.LittleEndian.Uint32(enc[0:4])
+	f.Tweak = binary.LittleEndian.Uint32(enc[4:8])
+	f.Flags = BloomUpdate(enc[8])
+	return f.validate()
+}
+
+func (f *FilterLoad) validate() error {
+	if len(f.Filter) > MaxBloomFilterSize {
+		return fmt.Errorf("%w: %d bytes, limit is %d", ErrInvalidBloomFilter, len(f.Filter), MaxBloomFilterSize)
+	}
+
+	if f.HashFuncs > MaxBloomHashFuncs {
+		return fmt.Errorf("%w: %d hash functions, limit is %d", ErrInvalidBloomFilter, f.HashFuncs, MaxBloomHashFuncs)
+	}
+	return nil
+}
+
+// FilterAdd adds an element to the loaded bloom filter
+type FilterAdd struct {
+	Data []byte
+}
+
+func (f *FilterAdd) String() string {
+	return fmt.Sprintf("[data=0x%x]", f.Data)
+}
+
+func (f *FilterAdd) Encode() ([]byte, error) {
+	if len(f.Data) > MaxFilterAddSize {
+		return nil, fmt.Errorf("%w: element of %d bytes, limit is %d", ErrInvalidBloomFilter, len(f.Data), MaxFilterAddSize)
+	}
+	return appendVarBytes(nil, f.Data), nil
+}
+
+func (f *FilterAdd) Decode(r io.Reader) error {
+	size, err := codec.DecodeFromVarint(r)
+	if err != nil {
+		return fmt.Errorf("while decoding element size: %w", err)
+	}
+
+	if size > MaxFilterAddSize {
+		return fmt.Errorf("%w: element of %d bytes, limit is %d", ErrInvalidBloomFilter, size, MaxFilterAddSize)
+	}
+
+	f.Data = make([]byte, size)
+	if _, err := io.ReadFull(r, f.Data); err != nil {
+		return fmt.Errorf("while reading element: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package messages_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterLoad(t *testing.T) {
+	// filter of the bitcoin core bloom filter tests
+	filter, err := hex.DecodeString("614e9b")
+	require.NoError(t, err)
+
+	payload := &messages.FilterLoad{Filter: filter, HashFuncs: 5, Tweak: 0, Flags: messages.BloomUpdateAll}
+	enc, err := payload.Encode()
+	require.NoError(t, err)
+	require.Equal(t, "03614e9b050000000000000001", hex.EncodeToString(enc))
+
+	decoded := new(messages.FilterLoad)
+	require.NoError(t, decoded.Decode(bytes.NewReader(enc)))
+	require.Equal(t, payload, decoded)
+}
+
+func TestFilterLoadLimits(t *testing.T) {
+	_, err := (&messages.FilterLoad{Filter: make([]byte, messages.MaxBloomFilterSize+1)}).Encode()
+	require.ErrorIs(t, err, messages.ErrInvalidBloomFilter)
+
+	_, err = (&messages.FilterLoad{Filter: []byte{0}, HashFuncs: messages.MaxBloomHashFuncs + 1}).Encode()
+	require.ErrorIs(t, err, messages.ErrInvalidBloomFilter)
+
+	// too many hash functions on the wire
+	enc, err := hex.DecodeString("0100" + "33000000" + "00000000" + "00")
+	require.NoError(t, err)
+	require.ErrorIs(t, new(messages.FilterLoad).Decode(bytes.NewReader(enc)), messages.ErrInvalidBloomFilter)
+}
+
+func TestFilterAdd(t *testing.T) {
+	payload := &messages.FilterAdd{Data: []byte{0xde, 0xad, 0xbe, 0xef}}
+	enc, err := payload.Encode()
+	require.NoError(t, err)
+
+	decoded := new(messages.FilterAdd)
+	require.NoError(t, decoded.Decode(bytes.NewReader(enc)))
+	require.Equal(t, payload, decoded)
+
+	_, err = (&messages.FilterAdd{Data: make([]byte, messages.MaxFilterAddSize+1)}).Encode()
+	require.ErrorIs(t, err, messages.ErrInvalidBloomFilter)
+}
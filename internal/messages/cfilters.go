@@ -0,0 +1,283 @@
+package messages
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+)
+
+// FilterType identifies the kind of compact block filter
+// check: https://github.com/bitcoin/bips/blob/master/bip-0157.mediawiki
+type FilterType uint8
+
+// FilterTypeBasic is the only filter type defined, see BIP158
+const FilterTypeBasic FilterType = 0
+
+const (
+	// MaxGetCFiltersSize is the maximum number of filters asked at once
+	MaxGetCFiltersSize = 1000
+	// MaxCFHeadersResults is the maximum number of filter hashes in a cfheaders
+	MaxCFHeadersResults = 2000
+	// CFCheckptInterval is the distance between the filter headers of a cfcheckpt
+	CFCheckptInterval = 1000
+)
+
+var ErrTooManyFilterHashes = errors.New("too many filter hashes")
+
+var _ codec.Encodeable = (*GetCFilters)(nil)
+var _ codec.Encodeable = (*CFilter)(nil)
+var _ codec.Encodeable = (*GetCFHeaders)(nil)
+var _ codec.Encodeable = (*CFHeaders)(nil)
+var _ codec.Encodeable = (*GetCFCheckpt)(nil)
+var _ codec.Encodeable = (*CFCheckpt)(nil)
+
+func (f FilterType) String() string {
+	if f == FilterTypeBasic {
+		return "basic"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(f))
+}
+
+func readFilterType(r io.Reader) (FilterType, error) {
+	enc := make([]byte, 1)
+	if _, err := io.ReadFull(r, enc); err != nil {
+		return 0, fmt.Errorf("while reading filter type: %w", err)
+	}
+	return FilterType(enc[0]), nil
+}
+
+// GetCFilters asks the filters of the blocks from the start
+// height up to the stop hash, answered by a cfilter per block
+type GetCFilters struct {
+	FilterType  FilterType
+	StartHeight uint32
+	StopHash    Hash
+}
+
+func (g *GetCFilters) String() string {
+	return filterRangeString(g.FilterType, g.StartHeight, g.StopHash)
+}
+
+func (g *GetCFilters) Encode() ([]byte, error) {
+	return encodeFilterRange(g.FilterType, g.StartHeight, g.StopHash), nil
+}
+
+func (g *GetCFilters) Decode(r io.Reader) error {
+	return decodeFilterRange(r, &g.FilterType, &g.StartHeight, &g.StopHash)
+}
+
+// CFilter is the filter of a block
+type CFilter struct {
+	FilterType FilterType
+	BlockHash  Hash
+	Filter     []byte
+}
+
+func (c *CFilter) String() string {
+	return fmt.Sprintf("[type=%s] [block=%s] [size=%d]", c.FilterType, c.BlockHash, len(c.Filter))
+}
+
+func (c *CFilter) Encode() ([]byte, error) {
+	enc := append([]byte{byte(c.FilterType)}, c.BlockHash[:]...)
+	return appendVarBytes(enc, c.Filter), nil
+}
+
+func (c *CFilter) Decode(r io.Reader) (err error) {
+	c.FilterType, err = readFilterType(r)
+	if err != nil {
+		return err
+	}
+
+	c.BlockHash, err = readHash(r)
+	if err != nil {
+		return fmt.Errorf("while reading block hash: %w", err)
+	}
+
+	c.Filter, err = readVarBytes(r)
+	if err != nil {
+		return fmt.Errorf("while reading filter: %w", err)
+	}
+	return nil
+}
+
+// GetCFHeaders asks the filter hashes of the blocks from the start height
+// up to the stop hash, along with the filter header before the start
+type GetCFHeaders struct {
+	FilterType  FilterType
+	StartHeight uint32
+	StopHash    Hash
+}
+
+func (g *GetCFHeaders) String() string {
+	return filterRangeString(g.FilterType, g.StartHeight, g.StopHash)
+}
+
+func (g *GetCFHeaders) Encode() ([]byte, error) {
+	return encodeFilterRange(g.FilterType, g.StartHeight, g.StopHash), nil
+}
+
+func (g *GetCFHeaders) Decode(r io.Reader) error {
+	return decodeFilterRange(r, &g.FilterType, &g.StartHeight, &g.StopHash)
+}
+
+// CFHeaders answers getcfheaders, the filter headers are rebuilt
+// chaining the filter hashes from the previous filter header
+type CFHeaders struct {
+	FilterType       FilterType
+	StopHash         Hash
+	PrevFilterHeader Hash
+	FilterHashes     []Hash
+}
+
+func (c *CFHeaders) String() string {
+	return fmt.Sprintf("[type=%s] [stop=%s] [count=%d]", c.FilterType, c.StopHash, len(c.FilterHashes))
+}
+
+func (c *CFHeaders) Encode() ([]byte, error) {
+	if len(c.FilterHashes) > MaxCFHeadersResults {
+		return nil, fmt.Errorf("%w: %d, limit is %d", ErrTooManyFilterHashes, len(c.FilterHashes), MaxCFHeadersResults)
+	}
+
+	enc := append([]byte{byte(c.FilterType)}, c.StopHash[:]...)
+	enc = append(enc, c.PrevFilterHeader[:]...)
+	return appendHashes(enc, c.FilterHashes), nil
+}
+
+func (c *CFHeaders) Decode(r io.Reader) (err error) {
+	c.FilterType, err = readFilterType(r)
+	if err != nil {
+		return err
+	}
+
+	c.StopHash, err = readHash(r)
+	if err != nil {
+		return fmt.Errorf("while reading stop hash: %w", err)
+	}
+
+	c.PrevFilterHeader, err = readHash(r)
+	if err != nil {
+		return fmt.Errorf("while reading previous filter header: %w", err)
+	}
+
+	c.FilterHashes, err = readHashes(r, MaxCFHeadersResults)
+	return err
+}
+
+// GetCFCheckpt asks the filter headers at every CFCheckptInterval
+// blocks up to the stop hash, to check the cfheaders of many peers agree
+type GetCFCheckpt struct {
+	FilterType FilterType
+	StopHash   Hash
+}
+
+func (g *GetCFCheckpt) String() string {
+	return fmt.Sprintf("[type=%s] [stop=%s]", g.FilterType, g.StopHash)
+}
+
+func (g *GetCFCheckpt) Encode() ([]byte, error) {
+	return append([]byte{byte(g.FilterType)}, g.StopHash[:]...), nil
+}
+
+func (g *GetCFCheckpt) Decode(r io.Reader) (err error) {
+	g.FilterType, err = readFilterType(r)
+	if err != nil {
+		return err
+	}
+
+	g.StopHash, err = readHash(r)
+	if err != nil {
+		return fmt.Errorf("while reading stop hash: %w", err)
+	}
+	return nil
+}
+
+// CFCheckpt answers getcfcheckpt, FilterHeaders[i] is the
+// filter header at the height (i+1)*CFCheckptInterval
+type CFCheckpt struct {
+	FilterType    FilterType
+	StopHash      Hash
+	FilterHeaders []Hash
+}
+
+func (c *CFCheckpt) String() string {
+	return fmt.Sprintf("[type=%s] [stop=%s] [count=%d]", c.FilterType, c.StopHash, len(c.FilterHeaders))
+}
+
+func (c *CFCheckpt) Encode() ([]byte, error) {
+	enc := append([]byte{byte(c.FilterType)}, c.StopHash[:]...)
+	return appendHashes(enc, c.FilterHeaders), nil
+}
+
+func (c *CFCheckpt) Decode(r io.Reader) (err error) {
+	c.FilterType, err = readFilterType(r)
+	if err != nil {
+		return err
+	}
+
+	c.StopHash, err = readHash(r)
+	if err != nil {
+		return fmt.Errorf("while reading stop hash: %w", err)
+	}
+
+	c.FilterHeaders, err = readHashes(r, MaxPayloadLength/HashSize)
+	return err
+}
+
+// getcfilters and getcfheaders ask the blocks from the start height up to the stop hash
+func filterRangeString(filterType FilterType, start uint32, stop Hash) string {
+	return fmt.Sprintf("[type=%s] [start=%d] [stop=%s]", filterType, start, stop)
+}
+
+func encodeFilterRange(filterType FilterType, start uint32, stop Hash) []byte {
+	enc := binary.LittleEndian.AppendUint32([]byte{byte(filterType)}, start)
+	return append(enc, stop[:]...)
+}
+
+func decodeFilterRange(r io.Reader, filterType *FilterType, start *uint32, stop *Hash) (err error) {
+	*filterType, err = readFilterType(r)
+	if err != nil {
+		return err
+	}
+
+	*start, err = readUint32(r)
+	if err != nil {
+		return fmt.Errorf("while reading start height: %w", err)
+	}
+
+	*stop, err = readHash(r)
+	if err != nil {
+		return fmt.Errorf("while reading stop hash: %w", err)
+	}
+	return nil
+}
+
+func appendHashes(enc []byte, hashes []Hash) []byte {
+	enc = append(enc, codec.EncodeToVarint(uint64(len(hashes)))...)
+	for _, hash := range hashes {
+		enc = append(enc, hash[:]...)
+	}
+	return enc
+}
+
+func readHashes(r io.Reader, max uint64) ([]Hash, error) {
+	count, err := codec.DecodeFromVarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("while decoding hashes count: %w", err)
+	}
+
+	if count > max {
+		return nil, fmt.Errorf("%w: %d, limit is %d", ErrTooManyFilterHashes, count, max)
+	}
+
+	hashes := make([]Hash, count)
+	for idx := range hashes {
+		hashes[idx], err = readHash(r)
+		if err != nil {
+			return nil, fmt.Errorf("while reading hash %d: %w", idx, err)
+		}
+	}
+	return hashes, nil
+}
@@ -0,0 +1,83 @@
+package messages_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCFiltersPayloads(t *testing.T) {
+	stop := messages.Hash{0xaa}
+
+	cases := []struct {
+		payload codec.Encodeable
+		empty   codec.Encodeable
+	}{
+		{
+			payload: &messages.GetCFilters{FilterType: messages.FilterTypeBasic, StartHeight: 700000, StopHash: stop},
+			empty:   new(messages.GetCFilters),
+		},
+		{
+			payload: &messages.CFilter{FilterType: messages.FilterTypeBasic, BlockHash: stop, Filter: []byte{0x01, 0x9d, 0xfc, 0xa8}},
+			empty:   new(messages.CFilter),
+		},
+		{
+			payload: &messages.GetCFHeaders{FilterType: messages.FilterTypeBasic, StartHeight: 1, StopHash: stop},
+			empty:   new(messages.GetCFHeaders),
+		},
+		{
+			payload: &messages.CFHeaders{
+				FilterType:       messages.FilterTypeBasic,
+				StopHash:         stop,
+				PrevFilterHeader: messages.Hash{0xbb},
+				FilterHashes:     []messages.Hash{{1}, {2}, {3}},
+			},
+			empty: new(messages.CFHeaders),
+		},
+		{
+			payload: &messages.GetCFCheckpt{FilterType: messages.FilterTypeBasic, StopHash: stop},
+			empty:   new(messages.GetCFCheckpt),
+		},
+		{
+			payload: &messages.CFCheckpt{FilterType: messages.FilterTypeBasic, StopHash: stop, FilterHeaders: []messages.Hash{{4}, {5}}},
+			empty:   new(messages.CFCheckpt),
+		},
+	}
+
+	for _, tt := range cases {
+		enc, err := tt.payload.Encode()
+		require.NoError(t, err)
+
+		require.NoError(t, tt.empty.Decode(bytes.NewReader(enc)))
+		require.Equal(t, tt.payload, tt.empty)
+	}
+}
+
+func TestGetCFiltersEncoding(t *testing.T) {
+	stop := messages.Hash{0xaa}
+	enc, err := (&messages.GetCFilters{FilterType: messages.FilterTypeBasic, StartHeight: 0x01020304, StopHash: stop}).Encode()
+	require.NoError(t, err)
+
+	// the filter type, the start height in little endian and the stop hash
+	require.Equal(t, append([]byte{0x00, 0x04, 0x03, 0x02, 0x01}, stop[:]...), enc)
+}
+
+func TestCFHeadersLimit(t *testing.T) {
+	payload := &messages.CFHeaders{FilterHashes: make([]messages.Hash, messages.MaxCFHeadersResults+1)}
+
+	_, err := payload.Encode()
+	require.ErrorIs(t, err, messages.ErrTooManyFilterHashes)
+
+	payload.FilterHashes = payload.FilterHashes[:messages.MaxCFHeadersResults]
+	enc, err := payload.Encode()
+	require.NoError(t, err)
+
+	// a count above the limit is refused before reading the hashes
+	enc[1+32+32] = 0xfd
+	enc = append(enc[:1+32+32+1], 0xd1, 0x07)
+	err = new(messages.CFHeaders).Decode(bytes.NewReader(enc))
+	require.ErrorIs(t, err, messages.ErrTooManyFilterHashes)
+}
@@ -0,0 +1,334 @@
+package messages
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/hashes"
+)
+
+const (
+	// CompactBlocksVersion is the compact blocks version we speak, version 2
+	// computes the short ids from the wtxid and carries the witnesses
+	CompactBlocksVersion = 2
+	// CompactBlocksMinVersion is the first protocol version knowing sendcmpct, peers
+	// between it and 70015 only speak version 1, which we do not use
+	CompactBlocksMinVersion = 70014
+	// ShortIDSize is the size of a short transaction id
+	ShortIDSize = 6
+	// transaction indexes of compact blocks must fit in 16 bits
+	maxCompactIndex = 0xffff
+	shortIDMask     = 1<<(8*ShortIDSize) - 1
+)
+
+var ErrInvalidCompactBlock = errors.New("invalid compact block")
+
+var _ codec.Encodeable = (*SendCmpct)(nil)
+var _ codec.Encodeable = (*CmpctBlock)(nil)
+var _ codec.Encodeable = (*GetBlockTxn)(nil)
+var _ codec.Encodeable = (*BlockTxn)(nil)
+
+// SendCmpct tells the remote we understand compact blocks of the version,
+// with Announce set the remote sends new blocks straight as cmpctblock
+// (high bandwidth mode) instead of announcing them first
+// check: https://github.com/bitcoin/bips/blob/master/bip-0152.mediawiki
+type SendCmpct struct {
+	Announce bool
+	Version  uint64
+}
+
+func (s *SendCmpct) String() string {
+	return fmt.Sprintf("[announce=%t] [version=%d]", s.Announce, s.Version)
+}
+
+func (s *SendCmpct) Encode() ([]byte, error) {
+	var announce byte
+	if s.Announce {
+		announce = 1
+	}
+	return binary.LittleEndian.AppendUint64([]byte{announce}, s.Version), nil
+}
+
+func (s *SendCmpct) Decode(r io.Reader) error {
+	enc := make([]byte, 9)
+	if _, err := io.ReadFull(r, enc); err != nil {
+		return fmt.Errorf("while reading sendcmpct: %w", err)
+	}
+
+	s.Announce = enc[0] != 0
+	s.Version = binary.LittleEndian.Uint64(enc[1:])
+	return nil
+}
+
+// PrefilledTx is a transaction sent in full within a compact block,
+// usually the coinbase since the remote can not have it
+type PrefilledTx struct {
+	// Index is the position of the transaction in the block
+	Index uint32
+	Tx    *Tx
+}
+
+// CmpctBlock carries a block as its header and the short ids of its
+// transactions, most of them should already be known by the remote
+type CmpctBlock struct {
+	Header    BlockHeader
+	Nonce     uint64
+	ShortIDs  []uint64
+	Prefilled []PrefilledTx
+}
+
+// NewCmpctBlock builds the compact block of the block, only the coinbase is prefilled
+func NewCmpctBlock(block *Block, nonce uint64) *CmpctBlock {
+	cmpct := &CmpctBlock{Header: block.Header, Nonce: nonce}
+	key := cmpct.ShortIDKey()
+	for idx, tx := range block.Transactions {
+		if idx == 0 {
+			cmpct.Prefilled = append(cmpct.Prefilled, PrefilledTx{Index: 0, Tx: tx})
+			continue
+		}
+		cmpct.ShortIDs = append(cmpct.ShortIDs, key.ShortID(tx.WitnessHash()))
+	}
+	return cmpct
+}
+
+func (c *CmpctBlock) String() string {
+	return fmt.Sprintf("%s [shortids=%d] [prefilled=%d]", c.Header.String(), len(c.ShortIDs), len(c.Prefilled))
+}
+
+// BlockHash is the hash of the block header
+func (c *CmpctBlock) BlockHash() Hash {
+	return c.Header.BlockHash()
+}
+
+// TxCount is the number of transactions in the block
+func (c *CmpctBlock) TxCount() int {
+	return len(c.ShortIDs) + len(c.Prefilled)
+}
+
+// ShortIDKey is the SipHash-2-4 key of the short ids of a compact block
+type ShortIDKey struct {
+	k0, k1 uint64
+}
+
+// ShortIDKey derives the short ids key from the header and the nonce, it
+// should be computed once per compact block and not once per transaction
+func (c *CmpctBlock) ShortIDKey() ShortIDKey {
+	header, _ := c.Header.Encode()
+	key := sha256.Sum256(binary.LittleEndian.AppendUint64(header, c.Nonce))
+
+	return ShortIDKey{
+		k0: binary.LittleEndian.Uint64(key[0:8]),
+		k1: binary.LittleEndian.Uint64(key[8:16]),
+	}
+}
+
+// ShortID returns the short id of the transaction hash (the wtxid in version 2)
+func (k ShortIDKey) ShortID(hash Hash) uint64 {
+	return hashes.SipHash24(k.k0, k.k1, hash[:]) & shortIDMask
+}
+
+// ShortID returns the short id of a single transaction hash, use
+// ShortIDKey when computing the short ids of many transactions
+func (c *CmpctBlock) ShortID(hash Hash) uint64 {
+	return c.ShortIDKey().ShortID(hash)
+}
+
+func (c *CmpctBlock) Encode() ([]byte, error) {
+	if c.TxCount() > maxCompactIndex {
+		return nil, fmt.Errorf("%w: %d transactions", ErrInvalidCompactBlock, c.TxCount())
+	}
+
+	header, err := c.Header.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	enc := bytes.NewBuffer(binary.LittleEndian.AppendUint64(header, c.Nonce))
+	enc.Write(codec.EncodeToVarint(uint64(len(c.ShortIDs))))
+	for _, id := range c.ShortIDs {
+		enc.Write(binary.LittleEndian.AppendUint64(nil, id)[:ShortIDSize])
+	}
+
+	indexes := make([]uint32, len(c.Prefilled))
+	for idx := range c.Prefilled {
+		indexes[idx] = c.Prefilled[idx].Index
+	}
+
+	diffs, err := differentialIndexes(indexes)
+	if err != nil {
+		return nil, err
+	}
+
+	enc.Write(codec.EncodeToVarint(uint64(len(c.Prefilled))))
+	for idx := range c.Prefilled {
+		enc.Write(codec.EncodeToVarint(diffs[idx]))
+		encodeTxs(enc, []*Tx{c.Prefilled[idx].Tx})
+	}
+	return enc.Bytes(), nil
+}
+
+func (c *CmpctBlock) Decode(r io.Reader) error {
+	if err := c.Header.Decode(r); err != nil {
+		return err
+	}
+
+	var err error
+	c.Nonce, err = readUint64(r)
+	if err != nil {
+		return fmt.Errorf("while reading nonce: %w", err)
+	}
+
+	count, err := readCount(r, maxCompactIndex)
+	if err != nil {
+		return fmt.Errorf("while decoding short ids count: %w", err)
+	}
+
+	c.ShortIDs = make([]uint64, count)
+	enc := make([]byte, 8)
+	for idx := range c.ShortIDs {
+		if _, err := io.ReadFull(r, enc[:ShortIDSize]); err != nil {
+			return fmt.Errorf("while reading short id %d: %w", idx, err)
+		}
+		c.ShortIDs[idx] = binary.LittleEndian.Uint64(enc)
+	}
+
+	count, err = readCount(r, maxCompactIndex)
+	if err != nil {
+		return fmt.Errorf("while decoding prefilled count: %w", err)
+	}
+
+	c.Prefilled = make([]PrefilledTx, count)
+	next := uint64(0)
+	for idx := range c.Prefilled {
+		diff, err := codec.DecodeFromVarint(r)
+		if err != nil {
+			return fmt.Errorf("while decoding prefilled %d index: %w", idx, err)
+		}
+
+		index := next + diff
+		if diff > maxCompactIndex || index > maxCompactIndex {
+			return fmt.Errorf("%w: prefilled index overflows 16 bits", ErrInvalidCompactBlock)
+		}
+
+		tx := new(Tx)
+		if err := tx.Decode(r); err != nil {
+			return fmt.Errorf("while decoding prefilled %d: %w", idx, err)
+		}
+
+		c.Prefilled[idx] = PrefilledTx{Index: uint32(index), Tx: tx}
+		next = index + 1
+	}
+
+	if c.TxCount() > maxCompactIndex {
+		return fmt.Errorf("%w: %d transactions", ErrInvalidCompactBlock, c.TxCount())
+	}
+	return nil
+}
+
+// GetBlockTxn asks for the transactions of a block at the indexes,
+// which are the ones missing to rebuild it from a compact block
+type GetBlockTxn struct {
+	BlockHash Hash
+	Indexes   []uint32
+}
+
+func (g *GetBlockTxn) String() string {
+	return fmt.Sprintf("[block=%s] [indexes=%d]", g.BlockHash, len(g.Indexes))
+}
+
+func (g *GetBlockTxn) Encode() ([]byte, error) {
+	diffs, err := differentialIndexes(g.Indexes)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := append([]byte{}, g.BlockHash[:]...)
+	enc = append(enc, codec.EncodeToVarint(uint64(len(diffs)))...)
+	for _, diff := range diffs {
+		enc = append(enc, codec.EncodeToVarint(diff)...)
+	}
+	return enc, nil
+}
+
+func (g *GetBlockTxn) Decode(r io.Reader) (err error) {
+	g.BlockHash, err = readHash(r)
+	if err != nil {
+		return fmt.Errorf("while reading block hash: %w", err)
+	}
+
+	count, err := readCount(r, maxCompactIndex+1)
+	if err != nil {
+		return fmt.Errorf("while decoding indexes count: %w", err)
+	}
+
+	g.Indexes = make([]uint32, count)
+	next := uint64(0)
+	for idx := range g.Indexes {
+		diff, err := codec.DecodeFromVarint(r)
+		if err != nil {
+			return fmt.Errorf("while decoding index %d: %w", idx, err)
+		}
+
+		index := next + diff
+		if diff > maxCompactIndex || index > maxCompactIndex {
+			return fmt.Errorf("%w: index overflows 16 bits", ErrInvalidCompactBlock)
+		}
+
+		g.Indexes[idx] = uint32(index)
+		next = index + 1
+	}
+	return nil
+}
+
+// BlockTxn answers getblocktxn with the transactions asked, in the same order
+type BlockTxn struct {
+	BlockHash    Hash
+	Transactions []*Tx
+}
+
+func (b *BlockTxn) String() string {
+	return fmt.Sprintf("[block=%s] [txs=%d]", b.BlockHash, len(b.Transactions))
+}
+
+func (b *BlockTxn) Encode() ([]byte, error) {
+	enc := bytes.NewBuffer(append([]byte{}, b.BlockHash[:]...))
+	enc.Write(codec.EncodeToVarint(uint64(len(b.Transactions))))
+	encodeTxs(enc, b.Transactions)
+	return enc.Bytes(), nil
+}
+
+func (b *BlockTxn) Decode(r io.Reader) (err error) {
+	b.BlockHash, err = readHash(r)
+	if err != nil {
+		return fmt.Errorf("while reading block hash: %w", err)
+	}
+
+	count, err := readCount(r, MaxPayloadLength/minTxSize)
+	if err != nil {
+		return fmt.Errorf("while decoding transactions count: %w", err)
+	}
+
+	b.Transactions, err = decodeTxs(r, count)
+	return err
+}
+
+// differentialIndexes encodes each index as its distance from the
+// index after the previous one, the indexes must be increasing
+func differentialIndexes(indexes []uint32) ([]uint64, error) {
+	diffs := make([]uint64, len(indexes))
+	next := uint64(0)
+	for idx, index := range indexes {
+		if uint64(index) < next || index > maxCompactIndex {
+			return nil, fmt.Errorf("%w: indexes must be increasing and fit in 16 bits", ErrInvalidCompactBlock)
+		}
+
+		diffs[idx] = uint64(index) - next
+		next = uint64(index) + 1
+	}
+	return diffs, nil
+}
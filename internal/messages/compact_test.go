@@ -0,0 +1,122 @@
+package messages_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendCmpct(t *testing.T) {
+	payload := &messages.SendCmpct{Announce: true, Version: messages.CompactBlocksVersion}
+
+	enc, err := payload.Encode()
+	require.NoError(t, err)
+	require.Equal(t, "010200000000000000", hex.EncodeToString(enc))
+
+	decoded := new(messages.SendCmpct)
+	require.NoError(t, decoded.Decode(bytes.NewReader(enc)))
+	require.Equal(t, payload, decoded)
+}
+
+func compactTestBlock(t *testing.T) *messages.Block {
+	enc, err := hex.DecodeString(genesisCoinbaseHex)
+	require.NoError(t, err)
+
+	coinbase := new(messages.Tx)
+	require.NoError(t, coinbase.Decode(bytes.NewReader(enc)))
+
+	other := segwitTx()
+	other.LockTime = 1
+
+	block := &messages.Block{Transactions: []*messages.Tx{coinbase, segwitTx(), other}}
+	block.Header.MerkleRoot, _ = block.MerkleRoot()
+	return block
+}
+
+func TestCmpctBlock(t *testing.T) {
+	block := compactTestBlock(t)
+	cmpct := messages.NewCmpctBlock(block, 0xdeadbeef)
+
+	require.Equal(t, 3, cmpct.TxCount())
+	require.Len(t, cmpct.Prefilled, 1)
+	require.Equal(t, block.Transactions[0], cmpct.Prefilled[0].Tx)
+	require.Len(t, cmpct.ShortIDs, 2)
+
+	for idx, id := range cmpct.ShortIDs {
+		require.Less(t, id, uint64(1)<<48)
+		require.Equal(t, cmpct.ShortID(block.Transactions[idx+1].WitnessHash()), id)
+	}
+	require.NotEqual(t, cmpct.ShortIDs[0], cmpct.ShortIDs[1])
+
+	// the short ids depend on the nonce
+	other := messages.NewCmpctBlock(block, 1)
+	require.NotEqual(t, cmpct.ShortIDs, other.ShortIDs)
+
+	enc, err := cmpct.Encode()
+	require.NoError(t, err)
+
+	decoded := new(messages.CmpctBlock)
+	require.NoError(t, decoded.Decode(bytes.NewReader(enc)))
+	require.Equal(t, cmpct, decoded)
+	require.Equal(t, block.BlockHash(), decoded.BlockHash())
+}
+
+func TestCmpctBlockPrefilledIndexes(t *testing.T) {
+	block := compactTestBlock(t)
+	cmpct := &messages.CmpctBlock{
+		Header:   block.Header,
+		ShortIDs: []uint64{1},
+		Prefilled: []messages.PrefilledTx{
+			{Index: 0, Tx: block.Transactions[0]},
+			{Index: 2, Tx: block.Transactions[2]},
+		},
+	}
+
+	enc, err := cmpct.Encode()
+	require.NoError(t, err)
+
+	decoded := new(messages.CmpctBlock)
+	require.NoError(t, decoded.Decode(bytes.NewReader(enc)))
+	require.Equal(t, uint32(0), decoded.Prefilled[0].Index)
+	require.Equal(t, uint32(2), decoded.Prefilled[1].Index)
+
+	// the indexes must be increasing
+	cmpct.Prefilled[0], cmpct.Prefilled[1] = cmpct.Prefilled[1], cmpct.Prefilled[0]
+	_, err = cmpct.Encode()
+	require.ErrorIs(t, err, messages.ErrInvalidCompactBlock)
+}
+
+func TestGetBlockTxn(t *testing.T) {
+	hash := messages.Hash{0xab}
+	payload := &messages.GetBlockTxn{BlockHash: hash, Indexes: []uint32{1, 2, 5}}
+
+	enc, err := payload.Encode()
+	require.NoError(t, err)
+
+	// the indexes are encoded as the gap from the previous one
+	require.Equal(t, append(hash[:], 0x03, 0x01, 0x00, 0x02), enc)
+
+	decoded := new(messages.GetBlockTxn)
+	require.NoError(t, decoded.Decode(bytes.NewReader(enc)))
+	require.Equal(t, payload, decoded)
+
+	// an index above 16 bits
+	overflow := append(append([]byte{}, hash[:]...), 0x02, 0xfd, 0xff, 0xff, 0x00)
+	err = new(messages.GetBlockTxn).Decode(bytes.NewReader(overflow))
+	require.ErrorIs(t, err, messages.ErrInvalidCompactBlock)
+}
+
+func TestBlockTxn(t *testing.T) {
+	block := compactTestBlock(t)
+	payload := &messages.BlockTxn{BlockHash: block.BlockHash(), Transactions: block.Transactions[1:]}
+
+	enc, err := payload.Encode()
+	require.NoError(t, err)
+
+	decoded := new(messages.BlockTxn)
+	require.NoError(t, decoded.Decode(bytes.NewReader(enc)))
+	require.Equal(t, payload, decoded)
+}
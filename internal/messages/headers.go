@@ -0,0 +1,147 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+)
+
+const (
+	// MaxHeadersResults is the maximum number of headers in a single headers message
+	MaxHeadersResults = 2000
+	// MaxLocatorHashes is the maximum number of hashes in a block locator
+	MaxLocatorHashes = 101
+)
+
+var (
+	ErrTooManyHeaders       = errors.New("too many headers")
+	ErrTooManyLocatorHashes = errors.New("too many locator hashes")
+	ErrHeaderWithTxs        = errors.New("header with transactions")
+)
+
+var _ codec.Encodeable = (*GetHeaders)(nil)
+var _ codec.Encodeable = (*Headers)(nil)
+
+// GetHeaders asks for the headers following the first locator hash the
+// remote knows, up to HashStop (zero meaning as many as possible). The
+// locator goes from our tip back to the genesis with growing gaps
+// check: https://en.bitcoin.it/wiki/Protocol_documentation#getheaders
+type GetHeaders struct {
+	Version  uint32
+	Locator  []Hash
+	HashStop Hash
+}
+
+func (g *GetHeaders) String() string {
+	if len(g.Locator) == 0 {
+		return fmt.Sprintf("[locator=0] [stop=%s]", g.HashStop)
+	}
+	return fmt.Sprintf("[locator=%d] [from=%s] [stop=%s]", len(g.Locator), g.Locator[0], g.HashStop)
+}
+
+func (g *GetHeaders) Encode() ([]byte, error) {
+	if len(g.Locator) > MaxLocatorHashes {
+		return nil, fmt.Errorf("%w: %d, limit is %d", ErrTooManyLocatorHashes, len(g.Locator), MaxLocatorHashes)
+	}
+
+	enc := binary.LittleEndian.AppendUint32(nil, g.Version)
+	enc = append(enc, codec.EncodeToVarint(uint64(len(g.Locator)))...)
+	for _, hash := range g.Locator {
+		enc = append(enc, hash[:]...)
+	}
+	return append(enc, g.HashStop[:]...), nil
+}
+
+func (g *GetHeaders) Decode(r io.Reader) error {
+	enc := make([]byte, 4)
+	_, err := io.ReadFull(r, enc)
+	if err != nil {
+		return fmt.Errorf("while reading version: %w", err)
+	}
+	g.Version = binary.LittleEndian.Uint32(enc)
+
+	count, err := codec.DecodeFromVarint(r)
+	if err != nil {
+		return fmt.Errorf("while decoding locator count: %w", err)
+	}
+
+	if count > MaxLocatorHashes {
+		return fmt.Errorf("%w: %d, limit is %d", ErrTooManyLocatorHashes, count, MaxLocatorHashes)
+	}
+
+	g.Locator = make([]Hash, count)
+	for idx := range g.Locator {
+		g.Locator[idx], err = readHash(r)
+		if err != nil {
+			return fmt.Errorf("while reading locator hash %d: %w", idx, err)
+		}
+	}
+
+	g.HashStop, err = readHash(r)
+	if err != nil {
+		return fmt.Errorf("while reading hash stop: %w", err)
+	}
+	return nil
+}
+
+// Headers answers getheaders, every header is followed by
+// a transaction count which is always zero
+// check: https://en.bitcoin.it/wiki/Protocol_documentation#headers
+type Headers struct {
+	Headers []BlockHeader
+}
+
+func (h *Headers) String() string {
+	return fmt.Sprintf("[count=%d]", len(h.Headers))
+}
+
+func (h *Headers) Encode() ([]byte, error) {
+	if len(h.Headers) > MaxHeadersResults {
+		return nil, fmt.Errorf("%w: %d, limit is %d", ErrTooManyHeaders, len(h.Headers), MaxHeadersResults)
+	}
+
+	encoded := bytes.NewBuffer(codec.EncodeToVarint(uint64(len(h.Headers))))
+	for idx := range h.Headers {
+		enc, err := h.Headers[idx].Encode()
+		if err != nil {
+			return nil, fmt.Errorf("while encoding header %d: %w", idx, err)
+		}
+		encoded.Write(enc)
+		encoded.WriteByte(0)
+	}
+
+	return encoded.Bytes(), nil
+}
+
+func (h *Headers) Decode(r io.Reader) error {
+	count, err := codec.DecodeFromVarint(r)
+	if err != nil {
+		return fmt.Errorf("while decoding headers count: %w", err)
+	}
+
+	if count > MaxHeadersResults {
+		return fmt.Errorf("%w: %d, limit is %d", ErrTooManyHeaders, count, MaxHeadersResults)
+	}
+
+	h.Headers = make([]BlockHeader, count)
+	for idx := range h.Headers {
+		err = h.Headers[idx].Decode(r)
+		if err != nil {
+			return fmt.Errorf("while decoding header %d: %w", idx, err)
+		}
+
+		txs, err := codec.DecodeFromVarint(r)
+		if err != nil {
+			return fmt.Errorf("while decoding header %d transaction count: %w", idx, err)
+		}
+		if txs != 0 {
+			return fmt.Errorf("%w: header %d has %d", ErrHeaderWithTxs, idx, txs)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package messages_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetHeadersEncoding(t *testing.T) {
+	genesis, err := messages.NewHashFromString("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f")
+	require.NoError(t, err)
+
+	getHeaders := &messages.GetHeaders{Version: 70016, Locator: []messages.Hash{genesis}}
+	enc, err := getHeaders.Encode()
+	require.NoError(t, err)
+	require.Len(t, enc, 4+1+32+32)
+
+	decoded := new(messages.GetHeaders)
+	require.NoError(t, decoded.Decode(bytes.NewReader(enc)))
+	require.Equal(t, getHeaders, decoded)
+
+	tooMany := &messages.GetHeaders{Locator: make([]messages.Hash, messages.MaxLocatorHashes+1)}
+	_, err = tooMany.Encode()
+	require.ErrorIs(t, err, messages.ErrTooManyLocatorHashes)
+}
+
+func TestHeadersEncoding(t *testing.T) {
+	raw, err := hex.DecodeString(genesisHeaderHex)
+	require.NoError(t, err)
+
+	var genesis messages.BlockHeader
+	require.NoError(t, genesis.Decode(bytes.NewReader(raw)))
+
+	headers := &messages.Headers{Headers: []messages.BlockHeader{genesis, genesis}}
+	enc, err := headers.Encode()
+	require.NoError(t, err)
+
+	// every header is followed by an empty transaction count
+	expected := append([]byte{2}, raw...)
+	expected = append(expected, 0)
+	expected = append(expected, raw...)
+	expected = append(expected, 0)
+	require.Equal(t, expected, enc)
+
+	decoded := new(messages.Headers)
+	require.NoError(t, decoded.Decode(bytes.NewReader(enc)))
+	require.Equal(t, headers, decoded)
+}
+
+func TestHeadersRejectsTransactions(t *testing.T) {
+	raw, err := hex.DecodeString(genesisHeaderHex)
+	require.NoError(t, err)
+
+	enc := append([]byte{1}, raw...)
+	enc = append(enc, 1)
+
+	err = new(messages.Headers).Decode(bytes.NewReader(enc))
+	require.ErrorIs(t, err, messages.ErrHeaderWithTxs)
+}
+
+func TestHeadersLimit(t *testing.T) {
+	enc := codec.EncodeToVarint(messages.MaxHeadersResults + 1)
+
+	err := new(messages.Headers).Decode(bytes.NewReader(enc))
+	require.ErrorIs(t, err, messages.ErrTooManyHeaders)
+}
@@ -0,0 +1,215 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+)
+
+// MaxInvEntries is the maximum number of entries in inv, getdata and notfound
+const MaxInvEntries = 50000
+
+var ErrTooManyInvEntries = errors.New("too many inventory entries")
+
+// InvType identifies what an inventory entry refers to
+// check: https://developer.bitcoin.org/reference/p2p_networking.html#data-messages
+type InvType uint32
+
+// InvWitnessFlag asks for the witness serialization (BIP144)
+const InvWitnessFlag InvType = 1 << 30
+
+const (
+	InvError         InvType = 0
+	InvTx            InvType = 1
+	InvBlock         InvType = 2
+	InvFilteredBlock InvType = 3
+	InvCmpctBlock    InvType = 4
+	// InvWTx announces a transaction by its wtxid (BIP339)
+	InvWTx InvType = 5
+
+	InvWitnessTx            = InvTx | InvWitnessFlag
+	InvWitnessBlock         = InvBlock | InvWitnessFlag
+	InvFilteredWitnessBlock = InvFilteredBlock | InvWitnessFlag
+)
+
+func (i InvType) String() string {
+	switch i {
+	case InvError:
+		return "error"
+	case InvTx:
+		return "tx"
+	case InvBlock:
+		return "block"
+	case InvFilteredBlock:
+		return "filtered_block"
+	case InvCmpctBlock:
+		return "cmpct_block"
+	case InvWTx:
+		return "wtx"
+	case InvWitnessTx:
+		return "witness_tx"
+	case InvWitnessBlock:
+		return "witness_block"
+	case InvFilteredWitnessBlock:
+		return "filtered_witness_block"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint32(i))
+	}
+}
+
+// IsTx reports whether the entry refers to a transaction, by txid or wtxid
+func (i InvType) IsTx() bool {
+	return i&^InvWitnessFlag == InvTx || i == InvWTx
+}
+
+// WantsWitness reports whether the entry asks for the witness serialization,
+// transactions asked by wtxid always have it
+// check: https://github.com/bitcoin/bips/blob/master/bip-0144.mediawiki
+func (i InvType) WantsWitness() bool {
+	return i&InvWitnessFlag != 0 || i == InvWTx
+}
+
+// IsBlock reports whether the entry refers to a block, in any of its forms
+func (i InvType) IsBlock() bool {
+	switch i &^ InvWitnessFlag {
+	case InvBlock, InvFilteredBlock, InvCmpctBlock:
+		return true
+	default:
+		return false
+	}
+}
+
+var _ codec.Encodeable = (*InvVect)(nil)
+
+// InvVect is an inventory entry, the hash is the txid, wtxid or block hash
+type InvVect struct {
+	Type InvType
+	Hash Hash
+}
+
+func (i *InvVect) String() string {
+	return fmt.Sprintf("[%s=%s]", i.Type, i.Hash)
+}
+
+func (i *InvVect) Encode() ([]byte, error) {
+	enc := binary.LittleEndian.AppendUint32(make([]byte, 0, 36), uint32(i.Type))
+	return append(enc, i.Hash[:]...), nil
+}
+
+func (i *InvVect) Decode(r io.Reader) error {
+	enc := make([]byte, 4)
+	_, err := io.ReadFull(r, enc)
+	if err != nil {
+		return fmt.Errorf("while reading inventory type: %w", err)
+	}
+	i.Type = InvType(binary.LittleEndian.Uint32(enc))
+
+	i.Hash, err = readHash(r)
+	if err != nil {
+		return fmt.Errorf("while reading inventory hash: %w", err)
+	}
+	return nil
+}
+
+func encodeInventory(inventory []InvVect) ([]byte, error) {
+	if len(inventory) > MaxInvEntries {
+		return nil, fmt.Errorf("%w: %d, limit is %d", ErrTooManyInvEntries, len(inventory), MaxInvEntries)
+	}
+
+	encoded := bytes.NewBuffer(codec.EncodeToVarint(uint64(len(inventory))))
+	for idx := range inventory {
+		enc, err := inventory[idx].Encode()
+		if err != nil {
+			return nil, fmt.Errorf("while encoding inventory %d: %w", idx, err)
+		}
+		encoded.Write(enc)
+	}
+
+	return encoded.Bytes(), nil
+}
+
+func decodeInventory(r io.Reader) ([]InvVect, error) {
+	count, err := codec.DecodeFromVarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("while decoding inventory count: %w", err)
+	}
+
+	if count > MaxInvEntries {
+		return nil, fmt.Errorf("%w: %d, limit is %d", ErrTooManyInvEntries, count, MaxInvEntries)
+	}
+
+	inventory := make([]InvVect, count)
+	for idx := range inventory {
+		err = inventory[idx].Decode(r)
+		if err != nil {
+			return nil, fmt.Errorf("while decoding inventory %d: %w", idx, err)
+		}
+	}
+
+	return inventory, nil
+}
+
+var _ codec.Encodeable = (*Inv)(nil)
+var _ codec.Encodeable = (*GetData)(nil)
+var _ codec.Encodeable = (*NotFound)(nil)
+
+// Inv announces transactions or blocks the remote can then ask with getdata
+// check: https://en.bitcoin.it/wiki/Protocol_documentation#inv
+type Inv struct {
+	Inventory []InvVect
+}
+
+func (i *Inv) String() string {
+	return fmt.Sprintf("[count=%d]", len(i.Inventory))
+}
+
+func (i *Inv) Encode() ([]byte, error) {
+	return encodeInventory(i.Inventory)
+}
+
+func (i *Inv) Decode(r io.Reader) (err error) {
+	i.Inventory, err = decodeInventory(r)
+	return err
+}
+
+// GetData asks for the announced transactions or blocks
+// check: https://en.bitcoin.it/wiki/Protocol_documentation#getdata
+type GetData struct {
+	Inventory []InvVect
+}
+
+func (g *GetData) String() string {
+	return fmt.Sprintf("[count=%d]", len(g.Inventory))
+}
+
+func (g *GetData) Encode() ([]byte, error) {
+	return encodeInventory(g.Inventory)
+}
+
+func (g *GetData) Decode(r io.Reader) (err error) {
+	g.Inventory, err = decodeInventory(r)
+	return err
+}
+
+// NotFound answers getdata for the entries the remote does not have
+// check: https://en.bitcoin.it/wiki/Protocol_documentation#notfound
+type NotFound struct {
+	Inventory []InvVect
+}
+
+func (n *NotFound) String() string {
+	return fmt.Sprintf("[count=%d]", len(n.Inventory))
+}
+
+func (n *NotFound) Encode() ([]byte, error) {
+	return encodeInventory(n.Inventory)
+}
+
+func (n *NotFound) Decode(r io.Reader) (err error) {
+	n.Inventory, err = decodeInventory(r)
+	return err
+}
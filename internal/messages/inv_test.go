@@ -0,0 +1,74 @@
+package messages_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvEncoding(t *testing.T) {
+	genesis, err := messages.NewHashFromString("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f")
+	require.NoError(t, err)
+
+	inv := &messages.Inv{Inventory: []messages.InvVect{{Type: messages.InvWitnessBlock, Hash: genesis}}}
+	enc, err := inv.Encode()
+	require.NoError(t, err)
+
+	expected, err := hex.DecodeString("01" + "02000040" + "6fe28c0ab6f1b372c1a6a246ae63f74f931e8365e15a089c68d6190000000000")
+	require.NoError(t, err)
+	require.Equal(t, expected, enc)
+
+	decoded := new(messages.Inv)
+	require.NoError(t, decoded.Decode(bytes.NewReader(enc)))
+	require.Equal(t, inv, decoded)
+
+	// getdata and notfound share the same encoding
+	getData := new(messages.GetData)
+	require.NoError(t, getData.Decode(bytes.NewReader(enc)))
+	require.Equal(t, inv.Inventory, getData.Inventory)
+
+	notFound := new(messages.NotFound)
+	require.NoError(t, notFound.Decode(bytes.NewReader(enc)))
+	require.Equal(t, inv.Inventory, notFound.Inventory)
+}
+
+func TestInvLimit(t *testing.T) {
+	inv := &messages.Inv{Inventory: make([]messages.InvVect, messages.MaxInvEntries+1)}
+	_, err := inv.Encode()
+	require.ErrorIs(t, err, messages.ErrTooManyInvEntries)
+
+	enc := codec.EncodeToVarint(messages.MaxInvEntries + 1)
+	err = new(messages.GetData).Decode(bytes.NewReader(enc))
+	require.ErrorIs(t, err, messages.ErrTooManyInvEntries)
+}
+
+func TestInvTypes(t *testing.T) {
+	require.Equal(t, messages.InvType(0x40000001), messages.InvWitnessTx)
+	require.Equal(t, messages.InvType(0x40000003), messages.InvFilteredWitnessBlock)
+
+	for _, txType := range []messages.InvType{messages.InvTx, messages.InvWitnessTx, messages.InvWTx} {
+		require.True(t, txType.IsTx(), txType.String())
+		require.False(t, txType.IsBlock(), txType.String())
+	}
+
+	blockTypes := []messages.InvType{
+		messages.InvBlock, messages.InvWitnessBlock, messages.InvFilteredBlock,
+		messages.InvFilteredWitnessBlock, messages.InvCmpctBlock,
+	}
+	for _, blockType := range blockTypes {
+		require.True(t, blockType.IsBlock(), blockType.String())
+		require.False(t, blockType.IsTx(), blockType.String())
+	}
+
+	require.True(t, messages.InvWitnessTx.WantsWitness())
+	require.True(t, messages.InvWTx.WantsWitness())
+	require.True(t, messages.InvWitnessBlock.WantsWitness())
+	require.False(t, messages.InvTx.WantsWitness())
+	require.False(t, messages.InvBlock.WantsWitness())
+
+	require.Equal(t, "unknown(9)", messages.InvType(9).String())
+}
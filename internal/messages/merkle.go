@@ -0,0 +1,42 @@
+package messages
+
+// CalcMerkleRoot computes the root of the merkle tree of the hashes, levels
+// with an odd number of hashes duplicate the last one. Mutated is true if
+// two identical hashes were paired, a block with such a tree has the same
+// root as a different (invalid) block, so it must be rejected (CVE-2012-2459)
+// check: https://github.com/bitcoin/bitcoin/blob/master/src/consensus/merkle.cpp
+func CalcMerkleRoot(hashes []Hash) (root Hash, mutated bool) {
+	if len(hashes) == 0 {
+		return Hash{}, false
+	}
+
+	level := make([]Hash, len(hashes))
+	copy(level, hashes)
+
+	for len(level) > 1 {
+		for idx := 0; idx+1 < len(level); idx += 2 {
+			if level[idx] == level[idx+1] {
+				mutated = true
+			}
+		}
+
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+
+		next := make([]Hash, len(level)/2)
+		for idx := range next {
+			next[idx] = hashPair(level[2*idx], level[2*idx+1])
+		}
+		level = next
+	}
+
+	return level[0], mutated
+}
+
+func hashPair(left, right Hash) Hash {
+	var buf [2 * HashSize]byte
+	copy(buf[:HashSize], left[:])
+	copy(buf[HashSize:], right[:])
+	return DoubleSHA256(buf[:])
+}
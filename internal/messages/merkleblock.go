@@ -0,0 +1,255 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+)
+
+// maxBlockTxs is the most transactions a block can have, the maximum
+// block weight over the weight of the smallest transaction
+const maxBlockTxs = 4_000_000 / 240
+
+var ErrBadPartialMerkleTree = errors.New("bad partial merkle tree")
+
+var _ codec.Encodeable = (*MerkleBlock)(nil)
+
+// MerkleBlock is a block header with a partial merkle tree proving the
+// transactions matching our bloom filter are in it. The tree is walked
+// depth first, a flag bit tells if a node is the parent of a match,
+// only the hashes of the matches and of the subtrees without a match are sent
+// check: https://github.com/bitcoin/bips/blob/master/bip-0037.mediawiki#partial-merkle-branch-format
+type MerkleBlock struct {
+	Header BlockHeader
+	// Transactions is the number of transactions in the block
+	Transactions uint32
+	Hashes       []Hash
+	// Flags are the bits of the walk, least significant bit first
+	Flags []byte
+}
+
+// NewMerkleBlock builds the merkle block proving the transactions
+// of the block whose matches entry is true
+func NewMerkleBlock(block *Block, matches []bool) *MerkleBlock {
+	tree := &partialTree{count: len(block.Transactions), matches: matches}
+	for _, tx := range block.Transactions {
+		tree.txids = append(tree.txids, tx.TxHash())
+	}
+
+	tree.build(tree.height(), 0)
+
+	flags := make([]byte, (len(tree.bits)+7)/8)
+	for idx, bit := range tree.bits {
+		if bit {
+			flags[idx/8] |= 1 << (idx % 8)
+		}
+	}
+
+	return &MerkleBlock{
+		Header:       block.Header,
+		Transactions: uint32(len(block.Transactions)),
+		Hashes:       tree.hashes,
+		Flags:        flags,
+	}
+}
+
+func (m *MerkleBlock) String() string {
+	return fmt.Sprintf("%s [txs=%d] [hashes=%d]", m.Header.String(), m.Transactions, len(m.Hashes))
+}
+
+// BlockHash is the hash of the block header
+func (m *MerkleBlock) BlockHash() Hash {
+	return m.Header.BlockHash()
+}
+
+// ExtractMatches walks the partial merkle tree, checking its root is the
+// header merkle root, and returns the matched txids and their index in the block
+func (m *MerkleBlock) ExtractMatches() (txids []Hash, indexes []uint32, err error) {
+	if m.Transactions == 0 {
+		return nil, nil, fmt.Errorf("%w: no transactions", ErrBadPartialMerkleTree)
+	}
+
+	if m.Transactions > maxBlockTxs {
+		return nil, nil, fmt.Errorf("%w: %d transactions", ErrBadPartialMerkleTree, m.Transactions)
+	}
+
+	// every hash needs at least a flag bit
+	if len(m.Hashes) > int(m.Transactions) || len(m.Hashes) > len(m.Flags)*8 {
+		return nil, nil, fmt.Errorf("%w: %d hashes for %d transactions", ErrBadPartialMerkleTree, len(m.Hashes), m.Transactions)
+	}
+
+	tree := &partialTree{count: int(m.Transactions), hashes: m.Hashes}
+	for idx := 0; idx < len(m.Flags)*8; idx++ {
+		tree.bits = append(tree.bits, m.Flags[idx/8]&(1<<(idx%8)) != 0)
+	}
+
+	root, err := tree.extract(tree.height(), 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// every hash must be used, and every flag byte but the padding bits
+	if tree.hashesUsed != len(tree.hashes) || (tree.bitsUsed+7)/8 != len(m.Flags) {
+		return nil, nil, fmt.Errorf("%w: not every hash or flag was used", ErrBadPartialMerkleTree)
+	}
+
+	if root != m.Header.MerkleRoot {
+		return nil, nil, fmt.Errorf("%w: header has %s, tree gives %s", ErrBadMerkleRoot, m.Header.MerkleRoot, root)
+	}
+	return tree.matchedTxids, tree.matchedIndexes, nil
+}
+
+func (m *MerkleBlock) Encode() ([]byte, error) {
+	header, err := m.Header.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	enc := binary.LittleEndian.AppendUint32(header, m.Transactions)
+	enc = appendHashes(enc, m.Hashes)
+	return appendVarBytes(enc, m.Flags), nil
+}
+
+func (m *MerkleBlock) Decode(r io.Reader) (err error) {
+	if err := m.Header.Decode(r); err != nil {
+		return err
+	}
+
+	m.Transactions, err = readUint32(r)
+	if err != nil {
+		return fmt.Errorf("while reading transactions count: %w", err)
+	}
+
+	count, err := codec.DecodeFromVarint(r)
+	if err != nil {
+		return fmt.Errorf("while decoding hashes count: %w", err)
+	}
+
+	if count > maxBlockTxs {
+		return fmt.Errorf("%w: %d hashes", ErrBadPartialMerkleTree, count)
+	}
+
+	m.Hashes = make([]Hash, count)
+	for idx := range m.Hashes {
+		m.Hashes[idx], err = readHash(r)
+		if err != nil {
+			return fmt.Errorf("while reading hash %d: %w", idx, err)
+		}
+	}
+
+	m.Flags, err = readVarBytes(r)
+	if err != nil {
+		return fmt.Errorf("while reading flags: %w", err)
+	}
+	return nil
+}
+
+// partialTree builds and walks partial merkle trees, nodes are
+// identified by their height (0 for the txids) and position
+// check: https://github.com/bitcoin/bitcoin/blob/master/src/merkleblock.cpp
+type partialTree struct {
+	count   int
+	txids   []Hash
+	matches []bool
+
+	bits   []bool
+	hashes []Hash
+
+	bitsUsed       int
+	hashesUsed     int
+	matchedTxids   []Hash
+	matchedIndexes []uint32
+}
+
+// width is the number of nodes at the height
+func (t *partialTree) width(height int) int {
+	return (t.count + (1 << height) - 1) >> height
+}
+
+// height is the height of the root
+func (t *partialTree) height() int {
+	height := 0
+	for t.width(height) > 1 {
+		height++
+	}
+	return height
+}
+
+// hash computes the hash of a node from the txids
+func (t *partialTree) hash(height, pos int) Hash {
+	if height == 0 {
+		return t.txids[pos]
+	}
+
+	left := t.hash(height-1, pos*2)
+	right := left
+	if pos*2+1 < t.width(height-1) {
+		right = t.hash(height-1, pos*2+1)
+	}
+	return hashPair(left, right)
+}
+
+func (t *partialTree) build(height, pos int) {
+	parentOfMatch := false
+	for idx := pos << height; idx < (pos+1)<<height && idx < t.count; idx++ {
+		parentOfMatch = parentOfMatch || (idx < len(t.matches) && t.matches[idx])
+	}
+	t.bits = append(t.bits, parentOfMatch)
+
+	if height == 0 || !parentOfMatch {
+		t.hashes = append(t.hashes, t.hash(height, pos))
+		return
+	}
+
+	t.build(height-1, pos*2)
+	if pos*2+1 < t.width(height-1) {
+		t.build(height-1, pos*2+1)
+	}
+}
+
+func (t *partialTree) extract(height, pos int) (Hash, error) {
+	if t.bitsUsed >= len(t.bits) {
+		return Hash{}, fmt.Errorf("%w: ran out of flag bits", ErrBadPartialMerkleTree)
+	}
+
+	parentOfMatch := t.bits[t.bitsUsed]
+	t.bitsUsed++
+
+	if height == 0 || !parentOfMatch {
+		if t.hashesUsed >= len(t.hashes) {
+			return Hash{}, fmt.Errorf("%w: ran out of hashes", ErrBadPartialMerkleTree)
+		}
+
+		hash := t.hashes[t.hashesUsed]
+		t.hashesUsed++
+
+		if height == 0 && parentOfMatch {
+			t.matchedTxids = append(t.matchedTxids, hash)
+			t.matchedIndexes = append(t.matchedIndexes, uint32(pos))
+		}
+		return hash, nil
+	}
+
+	left, err := t.extract(height-1, pos*2)
+	if err != nil {
+		return Hash{}, err
+	}
+
+	right := left
+	if pos*2+1 < t.width(height-1) {
+		right, err = t.extract(height-1, pos*2+1)
+		if err != nil {
+			return Hash{}, err
+		}
+
+		// identical children would let a different tree give the same root
+		if bytes.Equal(left[:], right[:]) {
+			return Hash{}, fmt.Errorf("%w: identical sibling hashes", ErrBadPartialMerkleTree)
+		}
+	}
+	return hashPair(left, right), nil
+}
@@ -0,0 +1,103 @@
+package messages_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func merkleTestBlock(txs int) *messages.Block {
+	block := new(messages.Block)
+	for idx := 0; idx < txs; idx++ {
+		tx := segwitTx()
+		tx.LockTime = uint32(idx)
+		block.Transactions = append(block.Transactions, tx)
+	}
+
+	block.Header.MerkleRoot, _ = block.MerkleRoot()
+	return block
+}
+
+func TestMerkleBlockExtractMatches(t *testing.T) {
+	for txs := 1; txs <= 9; txs++ {
+		block := merkleTestBlock(txs)
+
+		// no match, every odd transaction, the last one and all of them
+		patterns := [][]bool{make([]bool, txs), make([]bool, txs), make([]bool, txs), make([]bool, txs)}
+		for idx := 0; idx < txs; idx++ {
+			patterns[1][idx] = idx%2 == 1
+			patterns[3][idx] = true
+		}
+		patterns[2][txs-1] = true
+
+		for _, matches := range patterns {
+			var expectedTxids []messages.Hash
+			var expectedIndexes []uint32
+			for idx, match := range matches {
+				if match {
+					expectedTxids = append(expectedTxids, block.Transactions[idx].TxHash())
+					expectedIndexes = append(expectedIndexes, uint32(idx))
+				}
+			}
+
+			merkleBlock := messages.NewMerkleBlock(block, matches)
+			enc, err := merkleBlock.Encode()
+			require.NoError(t, err)
+
+			decoded := new(messages.MerkleBlock)
+			require.NoError(t, decoded.Decode(bytes.NewReader(enc)))
+			require.Equal(t, merkleBlock, decoded)
+
+			txids, indexes, err := decoded.ExtractMatches()
+			require.NoError(t, err)
+			require.Equal(t, expectedTxids, txids)
+			require.Equal(t, expectedIndexes, indexes)
+		}
+	}
+}
+
+func TestMerkleBlockRejectsBadTrees(t *testing.T) {
+	block := merkleTestBlock(5)
+	matches := []bool{false, true, false, false, true}
+
+	merkleBlock := messages.NewMerkleBlock(block, matches)
+	merkleBlock.Header.MerkleRoot = messages.Hash{}
+	_, _, err := merkleBlock.ExtractMatches()
+	require.ErrorIs(t, err, messages.ErrBadMerkleRoot)
+
+	merkleBlock = messages.NewMerkleBlock(block, matches)
+	merkleBlock.Hashes = append(merkleBlock.Hashes, messages.Hash{1})
+	_, _, err = merkleBlock.ExtractMatches()
+	require.ErrorIs(t, err, messages.ErrBadPartialMerkleTree)
+
+	merkleBlock = messages.NewMerkleBlock(block, matches)
+	merkleBlock.Hashes = merkleBlock.Hashes[:len(merkleBlock.Hashes)-1]
+	_, _, err = merkleBlock.ExtractMatches()
+	require.ErrorIs(t, err, messages.ErrBadPartialMerkleTree)
+
+	merkleBlock = messages.NewMerkleBlock(block, matches)
+	merkleBlock.Flags = append(merkleBlock.Flags, 0)
+	_, _, err = merkleBlock.ExtractMatches()
+	require.ErrorIs(t, err, messages.ErrBadPartialMerkleTree)
+
+	merkleBlock = messages.NewMerkleBlock(block, matches)
+	merkleBlock.Transactions = 0
+	_, _, err = merkleBlock.ExtractMatches()
+	require.ErrorIs(t, err, messages.ErrBadPartialMerkleTree)
+}
+
+func TestMerkleBlockRejectsDuplicatedSiblings(t *testing.T) {
+	// duplicating the last transaction keeps the merkle root, the partial
+	// tree must not accept it as the same block
+	block := merkleTestBlock(3)
+	mutated := &messages.Block{
+		Header:       block.Header,
+		Transactions: append(block.Transactions, block.Transactions[2]),
+	}
+
+	merkleBlock := messages.NewMerkleBlock(mutated, []bool{false, false, true, true})
+	_, _, err := merkleBlock.ExtractMatches()
+	require.ErrorIs(t, err, messages.ErrBadPartialMerkleTree)
+}
@@ -18,6 +18,8 @@ var (
 	ErrCommandBytesOverflow  = errors.New("message command bytes overflow")
 	ErrFailedToEncodePayload = errors.New("failed to encode payload")
 	ErrFailedToEncodeMessage = errors.New("failed to encode message")
+	ErrMagicMismatch         = errors.New("magic mismatch")
+	ErrPayloadTooLarge       = errors.New("payload too large")
 )
 
 var IPV6Default = []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xFF, 0xFF}
@@ -40,6 +42,7 @@ const (
 	MagicMain           Magic = 0xD9B4BEF9
 	MagicTestNetRegTest Magic = 0xDAB5BFFA
 	MagicTestNet3       Magic = 0x0709110B
+	MagicTestNet4       Magic = 0x283F161C
 	MagicSignet         Magic = 0x40CF030A
 	MagicNameCoin       Magic = 0xFEB4BEF9
 )
@@ -52,6 +55,8 @@ func (m Magic) String() string {
 		return "TestNetRegTest"
 	case MagicTestNet3:
 		return "MagicTestNet3"
+	case MagicTestNet4:
+		return "TestNet4"
 	case MagicSignet:
 		return "Signet"
 	case MagicNameCoin:
@@ -65,9 +70,14 @@ type Service uint64
 
 const MessageCapWithoutPayloadInBytes = 24
 
+// MaxPayloadLength is the biggest payload we accept from a remote
+// peer, it is the same limit used by bitcoin core (MAX_PROTOCOL_MESSAGE_LENGTH)
+const MaxPayloadLength = 4 * 1000 * 1000
+
 var _ codec.Encodeable = (*Message)(nil)
 var _ codec.Encodeable = (*NetworkAddress)(nil)
 var _ codec.Encodeable = (*Version)(nil)
+var _ codec.Encodeable = (*RawPayload)(nil)
 
 type EmptyPayload struct{}
 
@@ -83,20 +93,46 @@ func (EmptyPayload) Decode(_ io.Reader) error {
 	return nil
 }
 
+// RawPayload holds the payload bytes exactly as they were
+// received, it is useful when the payload type is not known
+// at the moment the message is read from the wire
+type RawPayload []byte
+
+func (r RawPayload) String() string {
+	return fmt.Sprintf("0x%x", []byte(r))
+}
+
+func (r RawPayload) Encode() ([]byte, error) {
+	return r, nil
+}
+
+func (r *RawPayload) Decode(rd io.Reader) error {
+	raw, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	*r = raw
+	return nil
+}
+
 type Message struct {
 	Magic   Magic
 	Command []byte
 	Payload codec.Encodeable
 }
 
-func NewMainMessage(command []byte, payload codec.Encodeable) *Message {
+func NewMessage(magic Magic, command []byte, payload codec.Encodeable) *Message {
 	return &Message{
-		Magic:   MagicMain,
+		Magic:   magic,
 		Command: command,
 		Payload: payload,
 	}
 }
 
+func NewMainMessage(command []byte, payload codec.Encodeable) *Message {
+	return NewMessage(MagicMain, command, payload)
+}
+
 func (m Message) String() string {
 	return fmt.Sprintf("[magic=%s] [command=%s] < %s >",
 		m.Magic.String(), string(m.Command), m.Payload.String())
@@ -149,58 +185,69 @@ func checksum(value []byte) []byte {
 	return snd[:4]
 }
 
-func (m *Message) Decode(r io.Reader) error {
-	enc := make([]byte, 4)
-	_, err := r.Read(enc)
+// MessageHeader is the fixed size part of every message
+// check: https://en.bitcoin.it/wiki/Protocol_documentation#Message_structure
+type MessageHeader struct {
+	Magic    Magic
+	Command  []byte
+	Length   uint32
+	Checksum []byte
+}
+
+// DecodeHeader reads exactly the 24 header bytes from the reader, it
+// fails if the announced payload length is bigger than MaxPayloadLength
+func DecodeHeader(r io.Reader) (*MessageHeader, error) {
+	enc := make([]byte, MessageCapWithoutPayloadInBytes)
+	_, err := io.ReadFull(r, enc)
 	if err != nil {
-		return fmt.Errorf("while reading magic: %w", err)
+		return nil, fmt.Errorf("while reading message header: %w", err)
 	}
-	m.Magic = Magic(binary.LittleEndian.Uint32(enc))
 
-	m.Command = make([]byte, 12)
-	_, err = r.Read(m.Command)
-	if err != nil {
-		return fmt.Errorf("while reading command: %w", err)
+	header := &MessageHeader{
+		Magic:    Magic(binary.LittleEndian.Uint32(enc[:4])),
+		Command:  bytes.TrimRight(enc[4:16], "\x00"),
+		Length:   binary.LittleEndian.Uint32(enc[16:20]),
+		Checksum: enc[20:24],
 	}
-	m.Command = bytes.TrimRight(m.Command, "\x00")
 
-	// just ignore the payload length, since the
-	// responsability of decoding the payload bytes
-	// is from the Payload field
-	enc = make([]byte, 4)
-	_, err = r.Read(enc)
-	if err != nil {
-		return fmt.Errorf("while reading payload length: %w", err)
+	if header.Length > MaxPayloadLength {
+		return nil, fmt.Errorf("%w: %d bytes, limit is %d", ErrPayloadTooLarge, header.Length, MaxPayloadLength)
 	}
-	payloadLength := binary.LittleEndian.Uint32(enc)
 
-	encPayloadChecksum := make([]byte, 4)
-	_, err = r.Read(encPayloadChecksum)
+	return header, nil
+}
+
+func (m *Message) Decode(r io.Reader) error {
+	header, err := DecodeHeader(r)
 	if err != nil {
-		return fmt.Errorf("while reading payload checksum: %w", err)
+		return err
 	}
 
-	if payloadLength > 0 {
-		return m.readAndCheckPayload(r, payloadLength, encPayloadChecksum)
+	m.Magic = header.Magic
+	m.Command = header.Command
+
+	if header.Length > 0 {
+		return m.ReadPayload(r, header)
 	}
 
 	return nil
 }
 
-func (m *Message) readAndCheckPayload(r io.Reader, length uint32, expectedChecksum []byte) error {
-	encodedPayload := make([]byte, length)
-	n, err := r.Read(encodedPayload)
+// ReadPayload reads exactly the payload announced by the header, checks
+// it against the header checksum and decodes it into the message Payload
+func (m *Message) ReadPayload(r io.Reader, header *MessageHeader) error {
+	encodedPayload := make([]byte, header.Length)
+	n, err := io.ReadFull(r, encodedPayload)
 	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w, expected %d, read: %d: %w", ErrPayloadSizeMismatch, header.Length, n, err)
+		}
 		return fmt.Errorf("while reading payload bytes: %w", err)
 	}
 
-	if length != uint32(n) {
-		return fmt.Errorf("%w, expected %d, read: %d", ErrPayloadSizeMismatch, length, n)
-	}
-
 	currentChecksum := checksum(encodedPayload)
-	if !bytes.Equal(expectedChecksum, currentChecksum) {
-		return fmt.Errorf("%w, received: 0x%x, calculated: 0x%x", ErrChecksumMismatch, encodedPayload, currentChecksum)
+	if !bytes.Equal(header.Checksum, currentChecksum) {
+		return fmt.Errorf("%w, received: 0x%x, calculated: 0x%x", ErrChecksumMismatch, header.Checksum, currentChecksum)
 	}
 
 	err = m.Payload.Decode(bytes.NewReader(encodedPayload))
@@ -214,12 +261,8 @@ func (m *Message) readAndCheckPayload(r io.Reader, length uint32, expectedChecks
 type NetworkAddress struct {
 	// the protocol specifies about a field called
 	// `time` which is not present when the net_addr
-	// is part of a version message.
-	// However my big question is: the protocol specify
-	// this field as uint32_i however the time (as timestamp)
-	// is a int64_i, so how is possible to encode w/o data loss?
-	//
-	// time uint32
+	// is part of a version message, addresses carrying
+	// it are represented by TimestampedNetworkAddress
 
 	Services uint64
 	IpV6V4   netip.Addr
@@ -234,36 +277,39 @@ func (n *NetworkAddress) Encode() ([]byte, error) {
 	enc := make([]byte, 26)
 	binary.LittleEndian.PutUint64(enc[:8], n.Services)
 
-	// since we're using ipv4 here, we will set the first
-	// 12 bytes (from 8 to 20) and the next 4 bytes are the
-	// actual encoded IPV4
-	copy(enc[8:20], IPV6Default)
-	copy(enc[20:24], n.IpV6V4.AsSlice())
+	// IPv4 addresses are encoded as IPv4-mapped IPv6 addresses, the
+	// first 12 bytes (from 8 to 20) are the IPV6Default prefix and the
+	// next 4 bytes are the actual IPV4, IPv6 addresses are used as is
+	if n.IpV6V4.IsValid() {
+		ip := n.IpV6V4.As16()
+		copy(enc[8:24], ip[:])
+	} else {
+		copy(enc[8:20], IPV6Default)
+	}
+
 	binary.BigEndian.PutUint16(enc[24:], n.Port)
 	return enc, nil
 }
 
 func (n *NetworkAddress) Decode(r io.Reader) error {
 	enc := make([]byte, 8)
-	_, err := r.Read(enc)
+	_, err := io.ReadFull(r, enc)
 	if err != nil {
 		return fmt.Errorf("reading services: %w", err)
 	}
 	n.Services = binary.LittleEndian.Uint64(enc)
 
 	enc = make([]byte, 16)
-	_, err = r.Read(enc)
+	_, err = io.ReadFull(r, enc)
 	if err != nil {
 		return fmt.Errorf("reading ipv6/v4 address: %w", err)
 	}
 
-	err = n.IpV6V4.UnmarshalBinary(enc[12:])
-	if err != nil {
-		return fmt.Errorf("unmarshaling ipv6/v4")
-	}
+	// IPv4-mapped addresses are kept as plain IPv4 addresses
+	n.IpV6V4 = netip.AddrFrom16([16]byte(enc)).Unmap()
 
 	enc = make([]byte, 2)
-	_, err = r.Read(enc)
+	_, err = io.ReadFull(r, enc)
 	if err != nil {
 		return fmt.Errorf("reading port: %w", err)
 	}
@@ -55,13 +55,84 @@ func TestNetworkAddressEncoding(t *testing.T) {
 		IpV6V4:   netip.MustParseAddr("10.0.0.1"),
 		Port:     8333,
 	}
-	require.Equal(t, expectedNetAddr, expectedNetAddr)
+	require.Equal(t, expectedNetAddr, netaddr)
 
 	encoded, err := expectedNetAddr.Encode()
 	require.NoError(t, err)
 	require.Equal(t, testEncoded, encoded)
 }
 
+func TestNetworkAddressIPv6Encoding(t *testing.T) {
+	testEncoded := []byte{
+		0x09, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // services
+		0x2A, 0x01, 0x04, 0xF8, 0x01, 0x0A, 0x1E, 0x54, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, // ip addr
+		0x20, 0x8D,
+	}
+
+	netaddr := &messages.NetworkAddress{}
+	err := netaddr.Decode(bytes.NewReader(testEncoded))
+	require.NoError(t, err)
+
+	expectedNetAddr := &messages.NetworkAddress{
+		Services: messages.NodeNetwork | messages.NodeWitness | messages.NodeNetworkLimited,
+		IpV6V4:   netip.MustParseAddr("2a01:4f8:10a:1e54::2"),
+		Port:     8333,
+	}
+	require.Equal(t, expectedNetAddr, netaddr)
+
+	encoded, err := netaddr.Encode()
+	require.NoError(t, err)
+	require.Equal(t, testEncoded, encoded)
+
+	// IPv4-mapped addresses are decoded as plain IPv4 addresses
+	mapped := &messages.NetworkAddress{IpV6V4: netip.MustParseAddr("::ffff:10.0.0.1"), Port: 8333}
+	encoded, err = mapped.Encode()
+	require.NoError(t, err)
+
+	err = netaddr.Decode(bytes.NewReader(encoded))
+	require.NoError(t, err)
+	require.Equal(t, netip.MustParseAddr("10.0.0.1"), netaddr.IpV6V4)
+}
+
+func TestNetworkAddressClassification(t *testing.T) {
+	cases := []struct {
+		addr     string
+		valid    bool
+		local    bool
+		rfc1918  bool
+		onionCat bool
+		routable bool
+	}{
+		{addr: "8.8.8.8", valid: true, routable: true},
+		{addr: "2a01:4f8:10a:1e54::2", valid: true, routable: true},
+		{addr: "10.0.0.1", valid: true, rfc1918: true},
+		{addr: "172.20.1.1", valid: true, rfc1918: true},
+		{addr: "192.168.0.10", valid: true, rfc1918: true},
+		{addr: "127.0.0.1", valid: true, local: true},
+		{addr: "::1", valid: true, local: true},
+		{addr: "0.0.0.0", local: true},
+		{addr: "::"},
+		{addr: "255.255.255.255"},
+		{addr: "2001:db8::1"},
+		{addr: "169.254.10.10", valid: true},
+		{addr: "100.64.0.1", valid: true},
+		{addr: "fe80::1", valid: true},
+		{addr: "fc00::1", valid: true},
+		{addr: "fd87:d87e:eb43:edb1:8e4:3588:e546:35ca", valid: true, onionCat: true, routable: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.addr, func(t *testing.T) {
+			netaddr := messages.NetworkAddress{IpV6V4: netip.MustParseAddr(tt.addr)}
+			require.Equal(t, tt.valid, netaddr.IsValid())
+			require.Equal(t, tt.local, netaddr.IsLocal())
+			require.Equal(t, tt.rfc1918, netaddr.IsRFC1918())
+			require.Equal(t, tt.onionCat, netaddr.IsOnionCat())
+			require.Equal(t, tt.routable, netaddr.IsRoutable())
+		})
+	}
+}
+
 func TestEmptyPayloadMessageDecode(t *testing.T) {
 	verackEncodedMessage := "f9beb4d976657261636b000000000000000000005df6e0e2"
 	encBytes, err := hex.DecodeString(verackEncodedMessage)
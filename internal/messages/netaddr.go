@@ -0,0 +1,86 @@
+package messages
+
+import "net/netip"
+
+// address ranges used to classify network addresses, the same
+// classification bitcoin core does in src/netaddress.cpp
+var (
+	rfc1918 = []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("172.16.0.0/12"),
+		netip.MustParsePrefix("192.168.0.0/16"),
+	}
+	rfc2544 = netip.MustParsePrefix("198.18.0.0/15")
+	rfc3927 = netip.MustParsePrefix("169.254.0.0/16")
+	rfc6598 = netip.MustParsePrefix("100.64.0.0/10")
+	rfc5737 = []netip.Prefix{
+		netip.MustParsePrefix("192.0.2.0/24"),
+		netip.MustParsePrefix("198.51.100.0/24"),
+		netip.MustParsePrefix("203.0.113.0/24"),
+	}
+	rfc3849 = netip.MustParsePrefix("2001:db8::/32")
+	rfc4193 = netip.MustParsePrefix("fc00::/7")
+	rfc4843 = netip.MustParsePrefix("2001:10::/28")
+	rfc7343 = netip.MustParsePrefix("2001:20::/28")
+	rfc4862 = netip.MustParsePrefix("fe80::/64")
+
+	// onionCat is the prefix used to encode Tor v2 addresses as IPv6, Tor v3
+	// addresses are 32 bytes long and can only be announced through addrv2
+	onionCat = netip.MustParsePrefix("fd87:d87e:eb43::/48")
+)
+
+func inAny(addr netip.Addr, prefixes ...netip.Prefix) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRFC1918 reports whether the address is an IPv4 private network address
+func (n NetworkAddress) IsRFC1918() bool {
+	return inAny(n.IpV6V4.Unmap(), rfc1918...)
+}
+
+// IsLocal reports whether the address is a loopback or belongs to 0.0.0.0/8
+func (n NetworkAddress) IsLocal() bool {
+	addr := n.IpV6V4.Unmap()
+	return addr.IsLoopback() || (addr.Is4() && addr.As4()[0] == 0)
+}
+
+// IsOnionCat reports whether the address is a Tor v2 address encoded as IPv6
+func (n NetworkAddress) IsOnionCat() bool {
+	return onionCat.Contains(n.IpV6V4)
+}
+
+// IsValid reports whether the address can be used at all, unspecified,
+// broadcast and documentation addresses are not valid
+func (n NetworkAddress) IsValid() bool {
+	addr := n.IpV6V4.Unmap()
+	if !addr.IsValid() || addr.IsUnspecified() {
+		return false
+	}
+
+	if addr == netip.AddrFrom4([4]byte{255, 255, 255, 255}) {
+		return false
+	}
+
+	return !inAny(addr, rfc3849)
+}
+
+// IsRoutable reports whether the address is reachable through the
+// public internet, private, link-local and reserved ranges are not
+func (n NetworkAddress) IsRoutable() bool {
+	if !n.IsValid() || n.IsLocal() || n.IsRFC1918() {
+		return false
+	}
+
+	addr := n.IpV6V4.Unmap()
+	if inAny(addr, rfc2544, rfc3927, rfc6598, rfc4843, rfc7343, rfc4862) || inAny(addr, rfc5737...) {
+		return false
+	}
+
+	// unique local addresses are not routable, except for onion-cat ones
+	return !rfc4193.Contains(addr) || n.IsOnionCat()
+}
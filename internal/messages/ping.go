@@ -0,0 +1,66 @@
+package messages
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+)
+
+var _ codec.Encodeable = (*Ping)(nil)
+var _ codec.Encodeable = (*Pong)(nil)
+
+// Ping is used to confirm the connection is still valid, since BIP31
+// it carries a nonce which must be sent back in the Pong message
+// check: https://en.bitcoin.it/wiki/Protocol_documentation#ping
+type Ping struct {
+	Nonce uint64
+}
+
+func (p *Ping) String() string {
+	return fmt.Sprintf("[nonce=%d]", p.Nonce)
+}
+
+func (p *Ping) Encode() ([]byte, error) {
+	return encodeNonce(p.Nonce), nil
+}
+
+func (p *Ping) Decode(r io.Reader) (err error) {
+	p.Nonce, err = decodeNonce(r)
+	return err
+}
+
+// Pong is sent in response to a Ping, carrying the same nonce
+// check: https://en.bitcoin.it/wiki/Protocol_documentation#pong
+type Pong struct {
+	Nonce uint64
+}
+
+func (p *Pong) String() string {
+	return fmt.Sprintf("[nonce=%d]", p.Nonce)
+}
+
+func (p *Pong) Encode() ([]byte, error) {
+	return encodeNonce(p.Nonce), nil
+}
+
+func (p *Pong) Decode(r io.Reader) (err error) {
+	p.Nonce, err = decodeNonce(r)
+	return err
+}
+
+func encodeNonce(nonce uint64) []byte {
+	enc := make([]byte, 8)
+	binary.LittleEndian.PutUint64(enc, nonce)
+	return enc
+}
+
+func decodeNonce(r io.Reader) (uint64, error) {
+	enc := make([]byte, 8)
+	_, err := io.ReadFull(r, enc)
+	if err != nil {
+		return 0, fmt.Errorf("while reading nonce: %w", err)
+	}
+	return binary.LittleEndian.Uint64(enc), nil
+}
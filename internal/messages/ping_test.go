@@ -0,0 +1,26 @@
+package messages_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPingPongEncoding(t *testing.T) {
+	testEncoded := []byte{0x3B, 0x2E, 0xB3, 0x5D, 0x8C, 0xE6, 0x17, 0x65}
+
+	ping := &messages.Ping{}
+	require.NoError(t, ping.Decode(bytes.NewReader(testEncoded)))
+	require.Equal(t, uint64(7284544412836900411), ping.Nonce)
+
+	pong := &messages.Pong{Nonce: ping.Nonce}
+	encoded, err := pong.Encode()
+	require.NoError(t, err)
+	require.Equal(t, testEncoded, encoded)
+
+	// since BIP31 the ping must carry a nonce
+	err = ping.Decode(bytes.NewReader(nil))
+	require.Error(t, err)
+}
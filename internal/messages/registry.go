@@ -0,0 +1,184 @@
+package messages
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+)
+
+const (
+	CmdVersion = "version"
+	CmdVerAck  = "verack"
+	CmdPing    = "ping"
+	CmdPong    = "pong"
+	CmdAddr    = "addr"
+	CmdGetAddr = "getaddr"
+
+	CmdAddrV2     = "addrv2"
+	CmdSendAddrV2 = "sendaddrv2"
+
+	CmdGetHeaders = "getheaders"
+	CmdHeaders    = "headers"
+
+	CmdInv      = "inv"
+	CmdGetData  = "getdata"
+	CmdNotFound = "notfound"
+
+	CmdBlock = "block"
+	CmdTx    = "tx"
+
+	CmdSendCmpct   = "sendcmpct"
+	CmdCmpctBlock  = "cmpctblock"
+	CmdGetBlockTxn = "getblocktxn"
+	CmdBlockTxn    = "blocktxn"
+
+	CmdGetCFilters  = "getcfilters"
+	CmdCFilter      = "cfilter"
+	CmdGetCFHeaders = "getcfheaders"
+	CmdCFHeaders    = "cfheaders"
+	CmdGetCFCheckpt = "getcfcheckpt"
+	CmdCFCheckpt    = "cfcheckpt"
+
+	CmdFilterLoad  = "filterload"
+	CmdFilterAdd   = "filteradd"
+	CmdFilterClear = "filterclear"
+	CmdMerkleBlock = "merkleblock"
+
+	CmdWtxidRelay  = "wtxidrelay"
+	CmdSendHeaders = "sendheaders"
+	CmdFeeFilter   = "feefilter"
+
+	CmdMemPool = "mempool"
+)
+
+var _ codec.Encodeable = (*UnknownPayload)(nil)
+
+// UnknownPayload is the payload of a message whose command
+// is not present in the registry, it carries the raw bytes
+// so callers can still inspect (or forward) it
+type UnknownPayload struct {
+	Command string
+	Raw     []byte
+}
+
+func (u *UnknownPayload) String() string {
+	return fmt.Sprintf("[unknown=%s] [raw=0x%x]", u.Command, u.Raw)
+}
+
+func (u *UnknownPayload) Encode() ([]byte, error) {
+	return u.Raw, nil
+}
+
+func (u *UnknownPayload) Decode(r io.Reader) error {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	u.Raw = raw
+	return nil
+}
+
+// PayloadConstructor returns a new, empty, payload ready to be decoded
+type PayloadConstructor func() codec.Encodeable
+
+// Registry maps message commands to the payload type
+// that should be used to decode its bytes
+type Registry struct {
+	constructors map[string]PayloadConstructor
+}
+
+func NewRegistry() *Registry {
+	return &Registry{constructors: make(map[string]PayloadConstructor)}
+}
+
+// DefaultRegistry returns a registry with every
+// payload supported by this package registered
+func DefaultRegistry() *Registry {
+	registry := NewRegistry()
+	registry.Register(CmdVersion, func() codec.Encodeable { return new(Version) })
+	registry.Register(CmdVerAck, func() codec.Encodeable { return EmptyPayload{} })
+	registry.Register(CmdPing, func() codec.Encodeable { return new(Ping) })
+	registry.Register(CmdPong, func() codec.Encodeable { return new(Pong) })
+	registry.Register(CmdAddr, func() codec.Encodeable { return new(Addr) })
+	registry.Register(CmdGetAddr, func() codec.Encodeable { return EmptyPayload{} })
+	registry.Register(CmdAddrV2, func() codec.Encodeable { return new(AddrV2) })
+	registry.Register(CmdSendAddrV2, func() codec.Encodeable { return EmptyPayload{} })
+	registry.Register(CmdGetHeaders, func() codec.Encodeable { return new(GetHeaders) })
+	registry.Register(CmdHeaders, func() codec.Encodeable { return new(Headers) })
+	registry.Register(CmdInv, func() codec.Encodeable { return new(Inv) })
+	registry.Register(CmdGetData, func() codec.Encodeable { return new(GetData) })
+	registry.Register(CmdNotFound, func() codec.Encodeable { return new(NotFound) })
+	registry.Register(CmdBlock, func() codec.Encodeable { return new(Block) })
+	registry.Register(CmdTx, func() codec.Encodeable { return new(Tx) })
+	registry.Register(CmdSendCmpct, func() codec.Encodeable { return new(SendCmpct) })
+	registry.Register(CmdCmpctBlock, func() codec.Encodeable { return new(CmpctBlock) })
+	registry.Register(CmdGetBlockTxn, func() codec.Encodeable { return new(GetBlockTxn) })
+	registry.Register(CmdBlockTxn, func() codec.Encodeable { return new(BlockTxn) })
+	registry.Register(CmdGetCFilters, func() codec.Encodeable { return new(GetCFilters) })
+	registry.Register(CmdCFilter, func() codec.Encodeable { return new(CFilter) })
+	registry.Register(CmdGetCFHeaders, func() codec.Encodeable { return new(GetCFHeaders) })
+	registry.Register(CmdCFHeaders, func() codec.Encodeable { return new(CFHeaders) })
+	registry.Register(CmdGetCFCheckpt, func() codec.Encodeable { return new(GetCFCheckpt) })
+	registry.Register(CmdCFCheckpt, func() codec.Encodeable { return new(CFCheckpt) })
+	registry.Register(CmdFilterLoad, func() codec.Encodeable { return new(FilterLoad) })
+	registry.Register(CmdFilterAdd, func() codec.Encodeable { return new(FilterAdd) })
+	registry.Register(CmdFilterClear, func() codec.Encodeable { return EmptyPayload{} })
+	registry.Register(CmdMerkleBlock, func() codec.Encodeable { return new(MerkleBlock) })
+	registry.Register(CmdWtxidRelay, func() codec.Encodeable { return EmptyPayload{} })
+	registry.Register(CmdSendHeaders, func() codec.Encodeable { return EmptyPayload{} })
+	registry.Register(CmdFeeFilter, func() codec.Encodeable { return new(FeeFilter) })
+	registry.Register(CmdMemPool, func() codec.Encodeable { return EmptyPayload{} })
+	return registry
+}
+
+// Register adds (or replaces) the constructor used for the given command
+func (r *Registry) Register(command string, constructor PayloadConstructor) {
+	r.constructors[command] = constructor
+}
+
+// Decode decodes the raw payload bytes into the payload registered for
+// the command, if the command is not registered an *UnknownPayload is returned
+func (r *Registry) Decode(command string, raw []byte) (codec.Encodeable, error) {
+	constructor, ok := r.constructors[command]
+	if !ok {
+		return &UnknownPayload{Command: command, Raw: raw}, nil
+	}
+
+	payload := constructor()
+	err := payload.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("while decoding %s payload: %w", command, err)
+	}
+
+	return payload, nil
+}
+
+// DecodeAny reads a whole message from the reader and
+// decodes its payload based on the message command
+func (r *Registry) DecodeAny(rd io.Reader) (*Message, error) {
+	header, err := DecodeHeader(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := new(RawPayload)
+	msg := &Message{
+		Magic:   header.Magic,
+		Command: header.Command,
+		Payload: raw,
+	}
+
+	err = msg.ReadPayload(rd, header)
+	if err != nil {
+		return nil, err
+	}
+
+	msg.Payload, err = r.Decode(string(header.Command), *raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,64 @@
+package messages_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryDecodeAny(t *testing.T) {
+	version := messages.NewVersion(
+		messages.WithNumber(60002),
+		messages.WithAddrRecv("10.0.0.1", 8333, 1),
+		messages.WithAddrFrom("0.0.0.0", 8080, 1),
+		messages.WithUserAgent("/Satoshi:0.7.2/"),
+	)
+
+	enc, err := messages.NewMainMessage([]byte(messages.CmdVersion), version).Encode()
+	require.NoError(t, err)
+
+	verack, err := hex.DecodeString("f9beb4d976657261636b000000000000000000005df6e0e2")
+	require.NoError(t, err)
+
+	registry := messages.DefaultRegistry()
+	stream := bytes.NewReader(append(enc, verack...))
+
+	msg, err := registry.DecodeAny(stream)
+	require.NoError(t, err)
+	require.Equal(t, version, msg.Payload)
+
+	msg, err = registry.DecodeAny(stream)
+	require.NoError(t, err)
+	require.Equal(t, messages.CmdVerAck, string(msg.Command))
+	require.Equal(t, messages.EmptyPayload{}, msg.Payload)
+}
+
+func TestRegistryUnknownCommand(t *testing.T) {
+	payload := messages.RawPayload{0xCA, 0xFE}
+	enc, err := messages.NewMainMessage([]byte("whatever"), &payload).Encode()
+	require.NoError(t, err)
+
+	msg, err := messages.DefaultRegistry().DecodeAny(bytes.NewReader(enc))
+	require.NoError(t, err)
+
+	expected := &messages.UnknownPayload{Command: "whatever", Raw: []byte{0xCA, 0xFE}}
+	require.Equal(t, expected, msg.Payload)
+
+	// the unknown payload encodes back to the very same bytes
+	reencoded, err := msg.Encode()
+	require.NoError(t, err)
+	require.Equal(t, enc, reencoded)
+}
+
+func TestRegistryDecodeFailure(t *testing.T) {
+	// a version command whose payload is too short to be a version
+	payload := messages.RawPayload{0x01, 0x02}
+	enc, err := messages.NewMainMessage([]byte(messages.CmdVersion), &payload).Encode()
+	require.NoError(t, err)
+
+	_, err = messages.DefaultRegistry().DecodeAny(bytes.NewReader(enc))
+	require.Error(t, err)
+}
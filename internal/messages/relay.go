@@ -0,0 +1,61 @@
+package messages
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+)
+
+const (
+	// SendHeadersMinVersion is the first protocol version knowing sendheaders (BIP130)
+	SendHeadersMinVersion = 70012
+	// FeeFilterMinVersion is the first protocol version knowing feefilter (BIP133)
+	FeeFilterMinVersion = 70013
+	// WtxidRelayMinVersion is the first protocol version knowing wtxidrelay (BIP339)
+	WtxidRelayMinVersion = 70016
+)
+
+// maxMoney is the most satoshis there will ever be, fee rates above it are nonsense
+const maxMoney = 21_000_000 * 100_000_000
+
+var ErrInvalidFeeFilter = errors.New("invalid fee filter")
+
+var _ codec.Encodeable = (*FeeFilter)(nil)
+
+// FeeFilter asks the remote to not announce transactions
+// paying less than the fee rate, in satoshis per kilobyte
+// check: https://github.com/bitcoin/bips/blob/master/bip-0133.mediawiki
+type FeeFilter struct {
+	FeeRate int64
+}
+
+func (f *FeeFilter) String() string {
+	return fmt.Sprintf("[feerate=%d sat/kvB]", f.FeeRate)
+}
+
+func (f *FeeFilter) Encode() ([]byte, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	return binary.LittleEndian.AppendUint64(nil, uint64(f.FeeRate)), nil
+}
+
+func (f *FeeFilter) Decode(r io.Reader) error {
+	feeRate, err := readUint64(r)
+	if err != nil {
+		return fmt.Errorf("while reading fee rate: %w", err)
+	}
+
+	f.FeeRate = int64(feeRate)
+	return f.validate()
+}
+
+func (f *FeeFilter) validate() error {
+	if f.FeeRate < 0 || f.FeeRate > maxMoney {
+		return fmt.Errorf("%w: fee rate %d", ErrInvalidFeeFilter, f.FeeRate)
+	}
+	return nil
+}
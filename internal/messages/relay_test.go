@@ -0,0 +1,29 @@
+package messages_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeeFilter(t *testing.T) {
+	payload := &messages.FeeFilter{FeeRate: 1000}
+	enc, err := payload.Encode()
+	require.NoError(t, err)
+	require.Equal(t, "e803000000000000", hex.EncodeToString(enc))
+
+	decoded := new(messages.FeeFilter)
+	require.NoError(t, decoded.Decode(bytes.NewReader(enc)))
+	require.Equal(t, payload, decoded)
+
+	_, err = (&messages.FeeFilter{FeeRate: -1}).Encode()
+	require.ErrorIs(t, err, messages.ErrInvalidFeeFilter)
+
+	// more than every satoshi there will ever be
+	enc, err = hex.DecodeString("0140075af0750700")
+	require.NoError(t, err)
+	require.ErrorIs(t, new(messages.FeeFilter).Decode(bytes.NewReader(enc)), messages.ErrInvalidFeeFilter)
+}
@@ -0,0 +1,343 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+)
+
+// the smallest encoded input (outpoint, empty script and sequence) and output
+// (value and empty script), they bound how many of them a payload can have
+const (
+	minTxInSize  = 32 + 4 + 1 + 4
+	minTxOutSize = 8 + 1
+	// version, input and output counts and lock time
+	minTxSize = 4 + 1 + 1 + 4
+)
+
+// the most witness items and script bytes allocated before reading them,
+// bigger counts grow as they are read since the payload may be much shorter
+const (
+	maxPreallocItems = 1024
+	maxPreallocBytes = 64 * 1024
+)
+
+// segwit transactions replace the input count by the marker and the flag
+// check: https://github.com/bitcoin/bips/blob/master/bip-0144.mediawiki
+const (
+	witnessMarker = 0x00
+	witnessFlag   = 0x01
+)
+
+var ErrInvalidTx = errors.New("invalid transaction")
+
+// OutPoint references the output of a previous transaction
+type OutPoint struct {
+	Hash  Hash
+	Index uint32
+}
+
+func (o OutPoint) String() string {
+	return fmt.Sprintf("%s:%d", o.Hash, o.Index)
+}
+
+type TxIn struct {
+	PreviousOutPoint OutPoint
+	SignatureScript  []byte
+	Sequence         uint32
+	// Witness is the stack of the segwit input, it is not part of the txid
+	Witness [][]byte
+}
+
+type TxOut struct {
+	// Value is the amount in satoshis
+	Value    int64
+	PkScript []byte
+}
+
+var _ codec.Encodeable = (*Tx)(nil)
+
+// Tx is a transaction, the witness serialization is used
+// whenever one of the inputs has a witness
+// check: https://en.bitcoin.it/wiki/Protocol_documentation#tx
+type Tx struct {
+	Version  int32
+	TxIn     []TxIn
+	TxOut    []TxOut
+	LockTime uint32
+}
+
+func (t *Tx) String() string {
+	return fmt.Sprintf("[txid=%s] [inputs=%d] [outputs=%d] [witness=%t]", t.TxHash(), len(t.TxIn), len(t.TxOut), t.HasWitness())
+}
+
+// HasWitness reports whether any input has a witness
+func (t *Tx) HasWitness() bool {
+	for idx := range t.TxIn {
+		if len(t.TxIn[idx].Witness) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// TxHash is the txid, the hash of the serialization without witnesses
+func (t *Tx) TxHash() Hash {
+	return DoubleSHA256(t.encode(false))
+}
+
+// WitnessHash is the wtxid, the hash of the serialization with witnesses,
+// it is the txid for transactions without witnesses
+func (t *Tx) WitnessHash() Hash {
+	return DoubleSHA256(t.encode(t.HasWitness()))
+}
+
+func (t *Tx) Encode() ([]byte, error) {
+	return t.encode(t.HasWitness()), nil
+}
+
+// EncodeNoWitness returns the legacy serialization, the one the txid commits to
+func (t *Tx) EncodeNoWitness() []byte {
+	return t.encode(false)
+}
+
+func (t *Tx) encode(witness bool) []byte {
+	enc := binary.LittleEndian.AppendUint32(nil, uint32(t.Version))
+	if witness {
+		enc = append(enc, witnessMarker, witnessFlag)
+	}
+
+	enc = append(enc, codec.EncodeToVarint(uint64(len(t.TxIn)))...)
+	for idx := range t.TxIn {
+		in := &t.TxIn[idx]
+		enc = append(enc, in.PreviousOutPoint.Hash[:]...)
+		enc = binary.LittleEndian.AppendUint32(enc, in.PreviousOutPoint.Index)
+		enc = appendVarBytes(enc, in.SignatureScript)
+		enc = binary.LittleEndian.AppendUint32(enc, in.Sequence)
+	}
+
+	enc = append(enc, codec.EncodeToVarint(uint64(len(t.TxOut)))...)
+	for idx := range t.TxOut {
+		enc = binary.LittleEndian.AppendUint64(enc, uint64(t.TxOut[idx].Value))
+		enc = appendVarBytes(enc, t.TxOut[idx].PkScript)
+	}
+
+	if witness {
+		for idx := range t.TxIn {
+			enc = append(enc, codec.EncodeToVarint(uint64(len(t.TxIn[idx].Witness)))...)
+			for _, item := range t.TxIn[idx].Witness {
+				enc = appendVarBytes(enc, item)
+			}
+		}
+	}
+
+	return binary.LittleEndian.AppendUint32(enc, t.LockTime)
+}
+
+func (t *Tx) Decode(r io.Reader) error {
+	version, err := readUint32(r)
+	if err != nil {
+		return fmt.Errorf("while reading version: %w", err)
+	}
+	t.Version = int32(version)
+
+	inputs, err := readCount(r, MaxPayloadLength/minTxInSize)
+	if err != nil {
+		return fmt.Errorf("while decoding inputs count: %w", err)
+	}
+
+	// an input count of zero is the segwit marker
+	witness := false
+	if inputs == 0 {
+		flag := make([]byte, 1)
+		if _, err := io.ReadFull(r, flag); err != nil {
+			return fmt.Errorf("while reading witness flag: %w", err)
+		}
+
+		if flag[0] != witnessFlag {
+			return fmt.Errorf("%w: unknown witness flag 0x%02x", ErrInvalidTx, flag[0])
+		}
+		witness = true
+
+		inputs, err = readCount(r, MaxPayloadLength/minTxInSize)
+		if err != nil {
+			return fmt.Errorf("while decoding inputs count: %w", err)
+		}
+	}
+
+	t.TxIn = make([]TxIn, inputs)
+	for idx := range t.TxIn {
+		if err := t.TxIn[idx].decode(r); err != nil {
+			return fmt.Errorf("while decoding input %d: %w", idx, err)
+		}
+	}
+
+	outputs, err := readCount(r, MaxPayloadLength/minTxOutSize)
+	if err != nil {
+		return fmt.Errorf("while decoding outputs count: %w", err)
+	}
+
+	t.TxOut = make([]TxOut, outputs)
+	for idx := range t.TxOut {
+		value, err := readUint64(r)
+		if err != nil {
+			return fmt.Errorf("while reading output %d value: %w", idx, err)
+		}
+		t.TxOut[idx].Value = int64(value)
+
+		t.TxOut[idx].PkScript, err = readVarBytes(r)
+		if err != nil {
+			return fmt.Errorf("while reading output %d script: %w", idx, err)
+		}
+	}
+
+	if witness {
+		for idx := range t.TxIn {
+			t.TxIn[idx].Witness, err = readWitness(r)
+			if err != nil {
+				return fmt.Errorf("while decoding input %d witness: %w", idx, err)
+			}
+		}
+
+		// the marker must not be used by transactions without witnesses
+		if !t.HasWitness() {
+			return fmt.Errorf("%w: witness flag without witnesses", ErrInvalidTx)
+		}
+	}
+
+	t.LockTime, err = readUint32(r)
+	if err != nil {
+		return fmt.Errorf("while reading lock time: %w", err)
+	}
+	return nil
+}
+
+func (in *TxIn) decode(r io.Reader) (err error) {
+	in.PreviousOutPoint.Hash, err = readHash(r)
+	if err != nil {
+		return fmt.Errorf("while reading previous output hash: %w", err)
+	}
+
+	in.PreviousOutPoint.Index, err = readUint32(r)
+	if err != nil {
+		return fmt.Errorf("while reading previous output index: %w", err)
+	}
+
+	in.SignatureScript, err = readVarBytes(r)
+	if err != nil {
+		return fmt.Errorf("while reading signature script: %w", err)
+	}
+
+	in.Sequence, err = readUint32(r)
+	if err != nil {
+		return fmt.Errorf("while reading sequence: %w", err)
+	}
+	return nil
+}
+
+func readWitness(r io.Reader) ([][]byte, error) {
+	count, err := readCount(r, MaxPayloadLength)
+	if err != nil {
+		return nil, fmt.Errorf("while decoding witness items count: %w", err)
+	}
+
+	if count == 0 {
+		return nil, nil
+	}
+
+	// the count is only bounded by the payload size, so the items are appended
+	// as they are read instead of allocating them all upfront
+	witness := make([][]byte, 0, min(count, maxPreallocItems))
+	for idx := uint64(0); idx < count; idx++ {
+		item, err := readVarBytes(r)
+		if err != nil {
+			return nil, fmt.Errorf("while reading witness item %d: %w", idx, err)
+		}
+		witness = append(witness, item)
+	}
+	return witness, nil
+}
+
+// readCount decodes a varint count, refusing counts above max so
+// a malicious count can not make us allocate more than a payload
+func readCount(r io.Reader, max uint64) (uint64, error) {
+	count, err := codec.DecodeFromVarint(r)
+	if err != nil {
+		return 0, err
+	}
+
+	if count > max {
+		return 0, fmt.Errorf("%w: count %d above the limit %d", ErrInvalidTx, count, max)
+	}
+	return count, nil
+}
+
+func readVarBytes(r io.Reader) ([]byte, error) {
+	size, err := readCount(r, MaxPayloadLength)
+	if err != nil {
+		return nil, err
+	}
+
+	if size <= maxPreallocBytes {
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+
+	// bigger ones grow as the bytes arrive, so a huge size does not
+	// make us allocate more than what the remote really sent
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(size)); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func appendVarBytes(enc, b []byte) []byte {
+	enc = append(enc, codec.EncodeToVarint(uint64(len(b)))...)
+	return append(enc, b...)
+}
+
+func readUint32(r io.Reader) (uint32, error) {
+	enc := make([]byte, 4)
+	if _, err := io.ReadFull(r, enc); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(enc), nil
+}
+
+func readUint64(r io.Reader) (uint64, error) {
+	enc := make([]byte, 8)
+	if _, err := io.ReadFull(r, enc); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(enc), nil
+}
+
+// decodeTxs decodes count transactions, used by the messages carrying blocks
+func decodeTxs(r io.Reader, count uint64) ([]*Tx, error) {
+	txs := make([]*Tx, count)
+	for idx := range txs {
+		txs[idx] = new(Tx)
+		if err := txs[idx].Decode(r); err != nil {
+			return nil, fmt.Errorf("while decoding transaction %d: %w", idx, err)
+		}
+	}
+	return txs, nil
+}
+
+// encodeTxs appends the transactions with their witnesses
+func encodeTxs(enc *bytes.Buffer, txs []*Tx) {
+	for _, tx := range txs {
+		enc.Write(tx.encode(tx.HasWitness()))
+	}
+}
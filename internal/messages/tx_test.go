@@ -0,0 +1,205 @@
+package messages_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"runtime"
+	"testing"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/stretchr/testify/require"
+)
+
+// the coinbase of the mainnet genesis block
+const genesisCoinbaseHex = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff" +
+	"4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e20" +
+	"6272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a010000" +
+	"00434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f3" +
+	"5504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+func TestLegacyTx(t *testing.T) {
+	enc, err := hex.DecodeString(genesisCoinbaseHex)
+	require.NoError(t, err)
+
+	tx := new(messages.Tx)
+	require.NoError(t, tx.Decode(bytes.NewReader(enc)))
+
+	require.Equal(t, int32(1), tx.Version)
+	require.Len(t, tx.TxIn, 1)
+	require.Equal(t, uint32(0xffffffff), tx.TxIn[0].PreviousOutPoint.Index)
+	require.Contains(t, string(tx.TxIn[0].SignatureScript), "Chancellor on brink of second bailout for banks")
+	require.Len(t, tx.TxOut, 1)
+	require.Equal(t, int64(50*100_000_000), tx.TxOut[0].Value)
+	require.False(t, tx.HasWitness())
+
+	txid := tx.TxHash()
+	require.Equal(t, "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b", txid.String())
+	require.Equal(t, txid, tx.WitnessHash())
+
+	reencoded, err := tx.Encode()
+	require.NoError(t, err)
+	require.Equal(t, enc, reencoded)
+}
+
+func segwitTx() *messages.Tx {
+	return &messages.Tx{
+		Version: 2,
+		TxIn: []messages.TxIn{
+			{
+				PreviousOutPoint: messages.OutPoint{Hash: messages.Hash{1}, Index: 0},
+				SignatureScript:  []byte{},
+				Sequence:         0xfffffffd,
+				Witness:          [][]byte{{0x30, 0x44}, {0x02, 0x21}},
+			},
+			{
+				// a legacy input spent in the same transaction
+				PreviousOutPoint: messages.OutPoint{Hash: messages.Hash{2}, Index: 3},
+				SignatureScript:  []byte{0x51},
+				Sequence:         0xffffffff,
+			},
+		},
+		TxOut: []messages.TxOut{
+			{Value: 1000, PkScript: []byte{0x00, 0x14}},
+		},
+		LockTime: 800000,
+	}
+}
+
+func TestWitnessTx(t *testing.T) {
+	tx := segwitTx()
+	require.True(t, tx.HasWitness())
+
+	enc, err := tx.Encode()
+	require.NoError(t, err)
+
+	// the marker and flag follow the version
+	require.Equal(t, []byte{0x00, 0x01}, enc[4:6])
+
+	decoded := new(messages.Tx)
+	require.NoError(t, decoded.Decode(bytes.NewReader(enc)))
+	require.Equal(t, tx, decoded)
+
+	// the txid commits to the legacy serialization only
+	require.Equal(t, messages.DoubleSHA256(tx.EncodeNoWitness()), tx.TxHash())
+	require.Equal(t, messages.DoubleSHA256(enc), tx.WitnessHash())
+	require.NotEqual(t, tx.TxHash(), tx.WitnessHash())
+
+	// changing the witness keeps the txid
+	txid := tx.TxHash()
+	tx.TxIn[0].Witness[0] = []byte{0xff}
+	require.Equal(t, txid, tx.TxHash())
+}
+
+func TestKnownWitnessTxs(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		txid  string
+		wtxid string
+	}{
+		{
+			// the native P2WPKH example, a P2PK input and a P2WPKH one
+			// check: https://github.com/bitcoin/bips/blob/master/bip-0143.mediawiki
+			name: "bip143 native p2wpkh",
+			raw: "01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f00000000" +
+				"494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b" +
+				"194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d2" +
+				"79655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a914" +
+				"8280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21" +
+				"b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb" +
+				"1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee012102" +
+				"5476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000",
+			txid:  "e8151a2af31c368a35053ddd4bdb285a8595c769a3ad83e0fa02314a602d4609",
+			wtxid: "c36c38370907df2324d9ce9d149d191192f338b37665a82e78e76a12c909b762",
+		},
+		{
+			// from block 23157 of segnet, the txid and wtxid btcd checks
+			// check: https://github.com/btcsuite/btcd/blob/master/wire/msgtx_test.go
+			name: "segnet p2wpkh",
+			raw: "01000000000101a53352d5135766f03076597418263da2d9c958315968fea823529467481ff9cd1300000000" +
+				"ffffffff010b070600000000001600149ddac6f39d51e0398e532a22c41ba189406a852302463043021f4d23" +
+				"81dc97f182abd8185f51753018523212f5ddc07cc4e63a8dc03658da190220608b5c4d92b86b6de7d78ef23a" +
+				"2fa735bcb59b914a48b0e187c5e7569a18197001210307ead084807eb76346df6977000c89392f45c76425b2" +
+				"6181f521d7f370066a8f00000000",
+			txid:  "0f167d1385a84d1518cfee208b653fc9163b605ccf1b75347e2850b3e2eb19f3",
+			wtxid: "0858eab78e77b6b033da30f46699996396cf48fcf625a783c85a51403e175e74",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := hex.DecodeString(tt.raw)
+			require.NoError(t, err)
+
+			tx := new(messages.Tx)
+			require.NoError(t, tx.Decode(bytes.NewReader(raw)))
+			require.True(t, tx.HasWitness())
+			require.Equal(t, tt.txid, tx.TxHash().String())
+			require.Equal(t, tt.wtxid, tx.WitnessHash().String())
+
+			enc, err := tx.Encode()
+			require.NoError(t, err)
+			require.Equal(t, raw, enc)
+		})
+	}
+}
+
+func TestTxWitnessAboveThePayload(t *testing.T) {
+	// a segwit tx with a single input and output, the payload ends
+	// right after the witness, which claims more than what was sent
+	payload := []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x01}
+	payload = append(payload, make([]byte, 32+4)...)
+	payload = append(payload, 0x00, 0xff, 0xff, 0xff, 0xff)
+	payload = append(payload, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0x00)
+
+	for _, witness := range [][]byte{
+		// four million items
+		{0xfe, 0x00, 0x09, 0x3d, 0x00},
+		// a single item of four million bytes
+		{0x01, 0xfe, 0x00, 0x09, 0x3d, 0x00, 0xaa},
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		err := new(messages.Tx).Decode(bytes.NewReader(append(payload, witness...)))
+		runtime.ReadMemStats(&after)
+
+		require.Error(t, err)
+		require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+	}
+}
+
+func TestTxRejectsSuperfluousWitness(t *testing.T) {
+	tx := segwitTx()
+	enc, err := tx.Encode()
+	require.NoError(t, err)
+
+	// the same transaction with empty witnesses but the marker set
+	tx.TxIn[0].Witness = nil
+	legacy := tx.EncodeNoWitness()
+	withMarker := append(append(append([]byte{}, legacy[:4]...), 0x00, 0x01), legacy[4:len(legacy)-4]...)
+	withMarker = append(withMarker, 0x00, 0x00)
+	withMarker = append(withMarker, legacy[len(legacy)-4:]...)
+
+	err = new(messages.Tx).Decode(bytes.NewReader(withMarker))
+	require.ErrorIs(t, err, messages.ErrInvalidTx)
+
+	unknownFlag := append([]byte{}, enc...)
+	unknownFlag[5] = 0x02
+	err = new(messages.Tx).Decode(bytes.NewReader(unknownFlag))
+	require.ErrorIs(t, err, messages.ErrInvalidTx)
+}
+
+func TestTxTruncated(t *testing.T) {
+	enc, err := segwitTx().Encode()
+	require.NoError(t, err)
+
+	for _, size := range []int{3, 10, 50, len(enc) - 1} {
+		err := new(messages.Tx).Decode(bytes.NewReader(enc[:size]))
+		require.Error(t, err, "size %d", size)
+	}
+
+	// a huge input count is refused before allocating
+	huge := []byte{0x01, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}
+	err = new(messages.Tx).Decode(bytes.NewReader(huge))
+	require.ErrorIs(t, err, messages.ErrInvalidTx)
+}
@@ -0,0 +1,63 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// the errors returned by the stream wrap one of these, so callers can
+// tell a slow peer from one that went away without parsing messages
+var (
+	// ErrTimeout means a deadline or timeout was reached
+	ErrTimeout = errors.New("timeout")
+	// ErrConnReset means the remote aborted the connection
+	ErrConnReset = errors.New("connection reset")
+	// ErrConnClosedByRemote means the remote closed the connection (EOF)
+	ErrConnClosedByRemote = errors.New("connection closed by remote")
+	// ErrConnClosed means the stream was closed on our side
+	ErrConnClosed = errors.New("connection closed")
+)
+
+// classify wraps err with the error kind it belongs to, the
+// original error is kept in the chain so errors.Is still matches it
+func classify(err error) error {
+	var kind error
+
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		kind = ErrTimeout
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.EPIPE):
+		kind = ErrConnReset
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		kind = ErrConnClosedByRemote
+	case errors.Is(err, net.ErrClosed), errors.Is(err, io.ErrClosedPipe):
+		kind = ErrConnClosed
+	default:
+		return err
+	}
+
+	if errors.Is(err, kind) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", kind, err)
+}
+
+// IsTimeout reports whether err is a timeout
+func IsTimeout(err error) bool {
+	return errors.Is(err, ErrTimeout)
+}
+
+// IsDisconnect reports whether err means the connection is gone,
+// either reset, closed by the remote or closed on our side
+func IsDisconnect(err error) bool {
+	return errors.Is(err, ErrConnReset) || errors.Is(err, ErrConnClosedByRemote) || errors.Is(err, ErrConnClosed)
+}
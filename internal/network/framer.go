@@ -0,0 +1,56 @@
+package network
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+// Framer reads whole messages off a stream of bytes, it does not
+// rely on how the bytes were split by the transport, so messages
+// spread across several TCP segments (or bigger than a single read)
+// are handled correctly
+type Framer struct {
+	r        io.Reader
+	magic    messages.Magic
+	registry *messages.Registry
+}
+
+func NewFramer(r io.Reader, magic messages.Magic, registry *messages.Registry) *Framer {
+	return &Framer{r: r, magic: magic, registry: registry}
+}
+
+// ReadMessage blocks until a full message is read, the header magic
+// and payload length are validated before reading the payload, which
+// is decoded into the type registered for the message command
+func (f *Framer) ReadMessage() (*messages.Message, error) {
+	header, err := messages.DecodeHeader(f.r)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Magic != f.magic {
+		return nil, fmt.Errorf("%w: expected %s (0x%08x), got: %s (0x%08x), is the remote on the same network?",
+			messages.ErrMagicMismatch, f.magic.String(), uint32(f.magic), header.Magic.String(), uint32(header.Magic))
+	}
+
+	raw := new(messages.RawPayload)
+	msg := &messages.Message{
+		Magic:   header.Magic,
+		Command: header.Command,
+		Payload: raw,
+	}
+
+	err = msg.ReadPayload(f.r, header)
+	if err != nil {
+		return nil, fmt.Errorf("while reading %s payload: %w", string(header.Command), err)
+	}
+
+	msg.Payload, err = f.registry.Decode(string(header.Command), *raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
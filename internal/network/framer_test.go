@@ -0,0 +1,92 @@
+package network_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+	"testing/iotest"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFramerReadsSplitMessages(t *testing.T) {
+	version := messages.NewVersion(
+		messages.WithNumber(60002),
+		messages.WithAddrRecv("10.0.0.1", 8333, 1),
+		messages.WithAddrFrom("0.0.0.0", 8080, 1),
+		messages.WithNonce(42),
+		messages.WithUserAgent("/Satoshi:0.7.2/"),
+	)
+
+	encVersion, err := messages.NewMainMessage([]byte("version"), version).Encode()
+	require.NoError(t, err)
+
+	encVerAck, err := messages.NewMainMessage([]byte("verack"), messages.EmptyPayload{}).Encode()
+	require.NoError(t, err)
+
+	// a reader that returns a single byte per read call
+	// behaves like the worst possible tcp segmentation
+	stream := iotest.OneByteReader(bytes.NewReader(append(encVersion, encVerAck...)))
+	framer := network.NewFramer(stream, messages.MagicMain, messages.DefaultRegistry())
+
+	msg, err := framer.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, []byte("version"), msg.Command)
+
+	require.Equal(t, version, msg.Payload)
+
+	msg, err = framer.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, []byte("verack"), msg.Command)
+	require.Equal(t, messages.EmptyPayload{}, msg.Payload)
+}
+
+func TestFramerRejectsWrongMagic(t *testing.T) {
+	verackEncodedMessage := "f9beb4d976657261636b000000000000000000005df6e0e2"
+	encBytes, err := hex.DecodeString(verackEncodedMessage)
+	require.NoError(t, err)
+
+	framer := network.NewFramer(bytes.NewReader(encBytes), messages.MagicTestNetRegTest, messages.DefaultRegistry())
+	_, err = framer.ReadMessage()
+	require.ErrorIs(t, err, messages.ErrMagicMismatch)
+}
+
+func TestFramerRejectsOversizedPayload(t *testing.T) {
+	// verack header announcing a 0xFFFFFFFF bytes payload
+	encBytes, err := hex.DecodeString("f9beb4d976657261636b000000000000ffffffff5df6e0e2")
+	require.NoError(t, err)
+
+	framer := network.NewFramer(bytes.NewReader(encBytes), messages.MagicMain, messages.DefaultRegistry())
+	_, err = framer.ReadMessage()
+	require.ErrorIs(t, err, messages.ErrPayloadTooLarge)
+}
+
+func TestFramerDetectsTruncatedPayload(t *testing.T) {
+	payload := messages.RawPayload(make([]byte, 100))
+	enc, err := messages.NewMainMessage([]byte("version"), &payload).Encode()
+	require.NoError(t, err)
+
+	framer := network.NewFramer(bytes.NewReader(enc[:len(enc)-10]), messages.MagicMain, messages.DefaultRegistry())
+	_, err = framer.ReadMessage()
+	require.ErrorIs(t, err, messages.ErrPayloadSizeMismatch)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestStreamRejectsOtherNetworks(t *testing.T) {
+	local, remote := net.Pipe()
+	regtest := network.NewStream(local, network.WithMagic(messages.MagicTestNetRegTest))
+	mainnet := network.NewStream(remote)
+	defer regtest.Close()
+	defer mainnet.Close()
+
+	go func() {
+		_ = regtest.SendMessage(messages.CmdVerAck, messages.EmptyPayload{})
+	}()
+
+	_, err := mainnet.ReadMessage()
+	require.ErrorIs(t, err, messages.ErrMagicMismatch)
+}
@@ -0,0 +1,172 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+var ErrPingTimeout = errors.New("ping timeout")
+
+// KeepAlive keeps a connection alive by sending pings on an interval
+// and answering the remote's pings, the round-trip of every ping is
+// recorded so the connection latency can be queried
+type KeepAlive struct {
+	stream   *Stream
+	interval time.Duration
+	timeout  time.Duration
+
+	mu           sync.Mutex
+	pendingNonce uint64
+	pingSentAt   time.Time
+	latency      time.Duration
+	pongReceived chan uint64
+}
+
+func NewKeepAlive(stream *Stream, interval, timeout time.Duration) *KeepAlive {
+	return &KeepAlive{
+		stream:       stream,
+		interval:     interval,
+		timeout:      timeout,
+		pongReceived: make(chan uint64, 1),
+	}
+}
+
+// Run sends a ping every interval until the context is done, if the remote
+// does not answer a ping within the timeout the stream is closed and
+// ErrPingTimeout is returned. Incoming messages should be given
+// to HandleMessage by the goroutine reading the stream
+func (k *KeepAlive) Run(ctx context.Context) error {
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+
+	// every ping gets its own timer, so a timer fired for a ping
+	// already answered can never be mistaken for the current one
+	var (
+		waitingNonce uint64
+		timeout      *time.Timer
+		timeoutC     <-chan time.Time
+	)
+	stopTimeout := func() {
+		if timeout != nil {
+			timeout.Stop()
+		}
+		waitingNonce, timeout, timeoutC = 0, nil, nil
+	}
+	defer stopTimeout()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case nonce := <-k.pongReceived:
+			// a pong signal of an older ping is ignored
+			if nonce == waitingNonce {
+				stopTimeout()
+			}
+		case <-timeoutC:
+			// the pong may have been handled while the timer was firing
+			if !k.isWaitingPong(waitingNonce) {
+				stopTimeout()
+				continue
+			}
+
+			k.stream.Close()
+			return fmt.Errorf("%w: no pong after %s", ErrPingTimeout, k.timeout)
+		case <-ticker.C:
+			// do not send a new ping while the previous one is not answered
+			if waitingNonce != 0 && k.isWaitingPong(waitingNonce) {
+				continue
+			}
+
+			nonce, err := k.sendPing()
+			if err != nil {
+				return err
+			}
+
+			stopTimeout()
+			waitingNonce = nonce
+			timeout = time.NewTimer(k.timeout)
+			timeoutC = timeout.C
+		}
+	}
+}
+
+// HandleMessage answers the remote's pings and records the latency when
+// a pong matching our last ping arrives, it returns true if the
+// message was a ping or a pong, meaning there is nothing left to do with it
+func (k *KeepAlive) HandleMessage(msg *messages.Message) (bool, error) {
+	switch payload := msg.Payload.(type) {
+	case *messages.Ping:
+		err := k.stream.SendMessage(messages.CmdPong, &messages.Pong{Nonce: payload.Nonce})
+		if err != nil {
+			return true, fmt.Errorf("while answering ping: %w", err)
+		}
+		return true, nil
+	case *messages.Pong:
+		k.handlePong(payload.Nonce)
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// Latency returns the round-trip time of the last answered
+// ping, the bool is false if no ping was answered yet
+func (k *KeepAlive) Latency() (time.Duration, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.latency, k.latency > 0
+}
+
+func (k *KeepAlive) sendPing() (uint64, error) {
+	k.mu.Lock()
+	// zero is not a valid nonce since it is what
+	// we use to signal there is no pending ping
+	nonce := rand.Uint64()
+	for nonce == 0 {
+		nonce = rand.Uint64()
+	}
+	k.pendingNonce = nonce
+	k.pingSentAt = time.Now()
+	k.mu.Unlock()
+
+	err := k.stream.SendMessage(messages.CmdPing, &messages.Ping{Nonce: nonce})
+	if err != nil {
+		return 0, fmt.Errorf("while sending ping: %w", err)
+	}
+
+	return nonce, nil
+}
+
+func (k *KeepAlive) handlePong(nonce uint64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// pongs that does not match our pending ping are ignored
+	if k.pendingNonce == 0 || nonce != k.pendingNonce {
+		return
+	}
+
+	k.latency = time.Since(k.pingSentAt)
+	k.pendingNonce = 0
+
+	// a signal of an older pong still in the buffer is replaced
+	select {
+	case <-k.pongReceived:
+	default:
+	}
+	k.pongReceived <- nonce
+}
+
+// isWaitingPong returns true if the ping with the nonce is still not answered
+func (k *KeepAlive) isWaitingPong(nonce uint64) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.pendingNonce != 0 && k.pendingNonce == nonce
+}
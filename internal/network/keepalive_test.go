@@ -0,0 +1,173 @@
+package network_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepAliveMeasuresLatency(t *testing.T) {
+	local, remote := net.Pipe()
+	localStream, remoteStream := network.NewStream(local), network.NewStream(remote)
+	defer localStream.Close()
+	defer remoteStream.Close()
+
+	// the remote answers every ping it receives
+	go func() {
+		for {
+			msg, err := remoteStream.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			ping, ok := msg.Payload.(*messages.Ping)
+			if !ok {
+				continue
+			}
+
+			err = remoteStream.SendMessage(messages.CmdPong, &messages.Pong{Nonce: ping.Nonce})
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := network.NewKeepAlive(localStream, 10*time.Millisecond, time.Second)
+	go func() {
+		for {
+			msg, err := localStream.ReadMessage()
+			if err != nil {
+				return
+			}
+			_, _ = keepAlive.HandleMessage(msg)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() { runErr <- keepAlive.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		_, ok := keepAlive.Latency()
+		return ok
+	}, time.Second, 5*time.Millisecond)
+
+	cancel()
+	require.ErrorIs(t, <-runErr, context.Canceled)
+}
+
+func TestKeepAliveDisconnectsOnTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	localStream, remoteStream := network.NewStream(local), network.NewStream(remote)
+	defer remoteStream.Close()
+
+	// the remote reads our pings but never answers them
+	go func() {
+		for {
+			if _, err := remoteStream.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := network.NewKeepAlive(localStream, 10*time.Millisecond, 50*time.Millisecond)
+	err := keepAlive.Run(context.Background())
+	require.ErrorIs(t, err, network.ErrPingTimeout)
+
+	_, ok := keepAlive.Latency()
+	require.False(t, ok)
+
+	// the stream was closed by the keep alive
+	_, err = localStream.ReadMessage()
+	require.Error(t, err)
+}
+
+func TestKeepAliveAnswersPings(t *testing.T) {
+	local, remote := net.Pipe()
+	localStream, remoteStream := network.NewStream(local), network.NewStream(remote)
+	defer localStream.Close()
+	defer remoteStream.Close()
+
+	keepAlive := network.NewKeepAlive(localStream, time.Minute, time.Minute)
+
+	go func() {
+		_ = remoteStream.SendMessage(messages.CmdPing, &messages.Ping{Nonce: 1234})
+	}()
+
+	msg, err := localStream.ReadMessage()
+	require.NoError(t, err)
+
+	handled := make(chan error, 1)
+	go func() {
+		ok, err := keepAlive.HandleMessage(msg)
+		require.True(t, ok)
+		handled <- err
+	}()
+
+	pong, err := remoteStream.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, &messages.Pong{Nonce: 1234}, pong.Payload)
+	require.NoError(t, <-handled)
+
+	ok, err := keepAlive.HandleMessage(messages.NewMainMessage([]byte(messages.CmdVerAck), messages.EmptyPayload{}))
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestKeepAliveDetectsDeadPeerWhenPongsRaceTheTicker(t *testing.T) {
+	local, remote := net.Pipe()
+	localStream, remoteStream := network.NewStream(local), network.NewStream(remote)
+	defer remoteStream.Close()
+
+	// the remote answers the first pings right away, so their pongs race
+	// the ticker, and then stops answering like a dead peer would
+	const answered = 50
+	go func() {
+		for pings := 0; ; {
+			msg, err := remoteStream.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			ping, ok := msg.Payload.(*messages.Ping)
+			if !ok || pings >= answered {
+				continue
+			}
+			pings++
+
+			err = remoteStream.SendMessage(messages.CmdPong, &messages.Pong{Nonce: ping.Nonce})
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := network.NewKeepAlive(localStream, time.Millisecond, 20*time.Millisecond)
+	go func() {
+		for {
+			msg, err := localStream.ReadMessage()
+			if err != nil {
+				return
+			}
+			_, _ = keepAlive.HandleMessage(msg)
+		}
+	}()
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- keepAlive.Run(context.Background()) }()
+
+	select {
+	case err := <-runErr:
+		require.ErrorIs(t, err, network.ErrPingTimeout)
+	case <-time.After(5 * time.Second):
+		t.Fatal("dead peer was not detected")
+	}
+
+	_, ok := keepAlive.Latency()
+	require.True(t, ok)
+}
@@ -0,0 +1,211 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultListenAddr = ":8080"
+	// DefaultMaxInbound is bitcoin core's 125 connections minus its outbound slots
+	DefaultMaxInbound = 117
+	DefaultMaxPerIP   = 3
+
+	// wait before accepting again after a temporary accept error
+	acceptBackoff = 50 * time.Millisecond
+	// how long an inbound connection has to complete the v2 handshake
+	v2HandshakeTimeout = 30 * time.Second
+)
+
+// Handler serves an inbound connection in its own goroutine, the stream is
+// closed once it returns and the context is done when the listener shuts down
+type Handler func(ctx context.Context, stream *Stream)
+
+type ListenConfig struct {
+	// Addr is the host:port to bind, defaults to DefaultListenAddr
+	Addr string
+	// MaxInbound is the maximum number of inbound connections at the same time
+	MaxInbound int
+	// MaxPerIP is the maximum number of connections from a single IP
+	MaxPerIP int
+	// Handler serves every accepted connection
+	Handler Handler
+	// StreamOpts are applied to every accepted stream, with WithV2Transport
+	// both v1 and v2 remotes are accepted
+	StreamOpts []StreamOpt
+}
+
+// Listener accepts inbound connections while enforcing the connection
+// limits, connections above the limits are closed right away
+type Listener struct {
+	cfg ListenConfig
+	lst net.Listener
+
+	mu      sync.Mutex
+	perIP   map[netip.Addr]int
+	streams map[*Stream]struct{}
+	wg      sync.WaitGroup
+}
+
+// NewListener binds the address, connections are only accepted once Serve is called
+func NewListener(cfg ListenConfig) (*Listener, error) {
+	if cfg.Addr == "" {
+		cfg.Addr = DefaultListenAddr
+	}
+
+	if cfg.MaxInbound <= 0 {
+		cfg.MaxInbound = DefaultMaxInbound
+	}
+
+	if cfg.MaxPerIP <= 0 {
+		cfg.MaxPerIP = DefaultMaxPerIP
+	}
+
+	if cfg.Handler == nil {
+		return nil, errors.New("listener handler is required")
+	}
+
+	lst, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("while setup tcp listener: %w", err)
+	}
+
+	return &Listener{
+		cfg:     cfg,
+		lst:     lst,
+		perIP:   make(map[netip.Addr]int),
+		streams: make(map[*Stream]struct{}),
+	}, nil
+}
+
+// Addr returns the bound address, useful when binding port 0
+func (l *Listener) Addr() net.Addr {
+	return l.lst.Addr()
+}
+
+// Len returns the number of inbound connections being served
+func (l *Listener) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.streams)
+}
+
+// Serve accepts connections until the context is done, then the listener
+// and every inbound connection are closed and Serve waits the handlers to return
+func (l *Listener) Serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		<-ctx.Done()
+		l.lst.Close()
+
+		l.mu.Lock()
+		for stream := range l.streams {
+			stream.Close()
+		}
+		l.mu.Unlock()
+	}()
+
+	defer l.wg.Wait()
+
+	for {
+		conn, err := l.lst.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return fmt.Errorf("while accepting incoming connection: %w", err)
+			}
+
+			// e.g too many open files, the listener is still usable
+			fmt.Fprintf(os.Stderr, "[ERROR] while accepting incoming connection: %s\n", err.Error())
+			time.Sleep(acceptBackoff)
+			continue
+		}
+
+		stream, ip, ok := l.admit(ctx, conn)
+		if !ok {
+			conn.Close()
+			continue
+		}
+
+		l.wg.Add(1)
+		go func() {
+			defer l.wg.Done()
+			defer l.release(stream, ip)
+
+			if stream.preferV2 {
+				if err := l.accept(ctx, stream); err != nil {
+					fmt.Fprintf(os.Stderr, "[ERROR] %s: %s\n", stream.RemoteAddr(), err.Error())
+					return
+				}
+			}
+
+			l.cfg.Handler(ctx, stream)
+		}()
+	}
+}
+
+// admit registers the connection if it is within the limits
+func (l *Listener) admit(ctx context.Context, conn net.Conn) (*Stream, netip.Addr, bool) {
+	addrPort, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		return nil, netip.Addr{}, false
+	}
+	ip := addrPort.Addr().Unmap()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the connection might race with the shutdown
+	if ctx.Err() != nil {
+		return nil, ip, false
+	}
+
+	if len(l.streams) >= l.cfg.MaxInbound {
+		fmt.Fprintf(os.Stderr, "rejecting %s: max inbound connections (%d) reached\n", addrPort, l.cfg.MaxInbound)
+		return nil, ip, false
+	}
+
+	if l.perIP[ip] >= l.cfg.MaxPerIP {
+		fmt.Fprintf(os.Stderr, "rejecting %s: max connections per ip (%d) reached\n", addrPort, l.cfg.MaxPerIP)
+		return nil, ip, false
+	}
+
+	stream := NewStream(conn, l.cfg.StreamOpts...)
+	l.streams[stream] = struct{}{}
+	l.perIP[ip]++
+	return stream, ip, true
+}
+
+// accept detects the transport used by the remote, running the v2 handshake if needed
+func (l *Listener) accept(ctx context.Context, stream *Stream) error {
+	ctx, cancel := context.WithTimeout(ctx, v2HandshakeTimeout)
+	defer cancel()
+
+	if err := stream.acceptV2(ctx); err != nil {
+		return fmt.Errorf("while negotiating transport: %w", err)
+	}
+	return nil
+}
+
+func (l *Listener) release(stream *Stream, ip netip.Addr) {
+	stream.Close()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.streams, stream)
+	if l.perIP[ip]--; l.perIP[ip] == 0 {
+		delete(l.perIP, ip)
+	}
+}
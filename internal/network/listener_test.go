@@ -0,0 +1,131 @@
+package network_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingHandler reports every served connection and
+// holds it until the remote closes it or the listener stops
+func blockingHandler(served chan<- string) network.Handler {
+	return func(ctx context.Context, stream *network.Stream) {
+		served <- stream.RemoteAddr().String()
+		stream.ReadMessage()
+	}
+}
+
+func serve(t *testing.T, cfg network.ListenConfig) (*network.Listener, context.CancelFunc, <-chan error) {
+	cfg.Addr = "127.0.0.1:0"
+	lst, err := network.NewListener(cfg)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- lst.Serve(ctx) }()
+
+	t.Cleanup(cancel)
+	return lst, cancel, done
+}
+
+func dialFrom(t *testing.T, ip string, lst *network.Listener) net.Conn {
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(ip)}}
+	conn, err := dialer.Dial("tcp", lst.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func waitServed(t *testing.T, served <-chan string) {
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		require.FailNow(t, "connection was not served")
+	}
+}
+
+// requireClosedByRemote asserts the listener closed the connection
+func requireClosedByRemote(t *testing.T, conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestListenerServesConcurrently(t *testing.T) {
+	served := make(chan string, 3)
+	lst, _, _ := serve(t, network.ListenConfig{Handler: blockingHandler(served)})
+
+	// every handler blocks, so all of them being served
+	// means they run in their own goroutines
+	for i := 0; i < 3; i++ {
+		dialFrom(t, "127.0.0.1", lst)
+		waitServed(t, served)
+	}
+	require.Equal(t, 3, lst.Len())
+}
+
+func TestListenerPerIPLimit(t *testing.T) {
+	served := make(chan string, 3)
+	lst, _, _ := serve(t, network.ListenConfig{MaxPerIP: 2, Handler: blockingHandler(served)})
+
+	dialFrom(t, "127.0.0.1", lst)
+	waitServed(t, served)
+	dialFrom(t, "127.0.0.1", lst)
+	waitServed(t, served)
+
+	requireClosedByRemote(t, dialFrom(t, "127.0.0.1", lst))
+
+	// another ip is still accepted
+	dialFrom(t, "127.0.0.2", lst)
+	waitServed(t, served)
+	require.Equal(t, 3, lst.Len())
+}
+
+func TestListenerMaxInbound(t *testing.T) {
+	served := make(chan string, 2)
+	lst, _, _ := serve(t, network.ListenConfig{MaxInbound: 1, Handler: blockingHandler(served)})
+
+	first := dialFrom(t, "127.0.0.1", lst)
+	waitServed(t, served)
+
+	requireClosedByRemote(t, dialFrom(t, "127.0.0.2", lst))
+
+	// once the first connection ends there is room again
+	first.Close()
+	require.Eventually(t, func() bool { return lst.Len() == 0 }, time.Second, 10*time.Millisecond)
+
+	dialFrom(t, "127.0.0.2", lst)
+	waitServed(t, served)
+}
+
+func TestListenerShutdown(t *testing.T) {
+	served := make(chan string, 1)
+	lst, cancel, done := serve(t, network.ListenConfig{Handler: blockingHandler(served)})
+
+	conn := dialFrom(t, "127.0.0.1", lst)
+	waitServed(t, served)
+
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		require.FailNow(t, "listener did not shut down")
+	}
+
+	requireClosedByRemote(t, conn)
+	require.Zero(t, lst.Len())
+
+	_, err := net.Dial("tcp", lst.Addr().String())
+	require.Error(t, err)
+}
+
+func TestListenerRequiresHandler(t *testing.T) {
+	_, err := network.NewListener(network.ListenConfig{Addr: "127.0.0.1:0"})
+	require.Error(t, err)
+}
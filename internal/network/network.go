@@ -1,80 +1,325 @@
 package network
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"net"
-	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
 )
 
+// DefaultDialTimeout is used by Dial, DialContext follows the context instead
+const DefaultDialTimeout = 30 * time.Second
+
 type Stream struct {
 	remote  net.Addr
 	tcpConn net.Conn
+	reader  io.Reader
+	framer  *Framer
+	magic   messages.Magic
+
+	// preferV2 tries the BIP324 transport, v2 is set once it is negotiated
+	preferV2 bool
+	v2       *v2Transport
+
+	// per message timeouts, zero means no timeout
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	// writeMu serializes writes since messages can be
+	// sent by different goroutines (e.g keep alive pings)
+	writeMu sync.Mutex
 }
 
-func (s Stream) RemoteAddr() net.Addr {
-	return s.remote
+type StreamOpt func(*Stream)
+
+// WithMagic sets the network magic used to send messages, messages
+// received with a different magic are rejected, defaults to mainnet
+func WithMagic(magic messages.Magic) StreamOpt {
+	return func(s *Stream) {
+		s.magic = magic
+	}
 }
 
-func Listen() (<-chan Stream, error) {
-	lst, err := net.Listen("tcp", ":8080")
-	if err != nil {
-		return nil, fmt.Errorf("while setup tcp listener: %w", err)
+// WithReadTimeout limits how long the stream waits for each message,
+// the timeout is renewed every ReadMessage so it works as an idle timeout.
+// It replaces any read deadline set before the read
+func WithReadTimeout(timeout time.Duration) StreamOpt {
+	return func(s *Stream) {
+		s.readTimeout = timeout
 	}
+}
 
-	fmt.Println("listening on 0.0.0.0:8080")
+// WithWriteTimeout limits how long sending each message can take,
+// it replaces any write deadline set before the write
+func WithWriteTimeout(timeout time.Duration) StreamOpt {
+	return func(s *Stream) {
+		s.writeTimeout = timeout
+	}
+}
 
-	connCh := make(chan Stream)
-	go func(ch chan<- Stream) {
-		// tells to every channel listener to stop listening
-		defer close(ch)
+// WithV2Transport tries the BIP324 encrypted transport, dialed streams
+// fall back to v1 when the remote does not support it and the listener
+// accepts both transports
+func WithV2Transport(enabled bool) StreamOpt {
+	return func(s *Stream) {
+		s.preferV2 = enabled
+	}
+}
 
-		for {
-			conn, err := lst.Accept()
-			if err != nil {
-				fmt.Printf("[ERROR] while accepting incomming connection: %s", err.Error())
-				return
-			}
+// NewStream wraps an established connection
+func NewStream(conn net.Conn, opts ...StreamOpt) *Stream {
+	stream := &Stream{
+		remote:  conn.RemoteAddr(),
+		tcpConn: conn,
+		magic:   messages.MagicMain,
+	}
 
-			ch <- Stream{tcpConn: conn, remote: conn.RemoteAddr()}
-		}
-	}(connCh)
+	for _, opt := range opts {
+		opt(stream)
+	}
 
-	return connCh, nil
+	stream.reader = conn
+	if stream.preferV2 {
+		// avoids a syscall per byte while scanning the v2 garbage
+		stream.reader = bufio.NewReader(conn)
+	}
+
+	stream.setReader(stream.reader)
+	return stream
 }
 
-func Dial(peerAddrPort string) (*Stream, error) {
-	addrPort, err := netip.ParseAddrPort(peerAddrPort)
-	if err != nil {
-		return nil, fmt.Errorf("parsing addr and port: %w", err)
+func (s *Stream) setReader(r io.Reader) {
+	s.reader = r
+	s.framer = NewFramer(r, s.magic, messages.DefaultRegistry())
+}
+
+// Encrypted reports whether the stream uses the BIP324 v2 transport
+func (s *Stream) Encrypted() bool {
+	return s.v2 != nil
+}
+
+// SessionID identifies the v2 session, both sides have the same value,
+// it is zero for v1 streams
+func (s *Stream) SessionID() [32]byte {
+	if s.v2 == nil {
+		return [32]byte{}
 	}
+	return s.v2.cipher.SessionID()
+}
+
+func (s *Stream) RemoteAddr() net.Addr {
+	return s.remote
+}
 
-	addr := net.TCPAddrFromAddrPort(addrPort)
-	conn, err := net.DialTCP("tcp", nil, addr)
+// Dial connects to the peer (host:port) giving up after DefaultDialTimeout
+func Dial(peerAddrPort string, opts ...StreamOpt) (*Stream, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDialTimeout)
+	defer cancel()
+
+	return DialContext(ctx, peerAddrPort, opts...)
+}
+
+// DialContext connects directly to the peer (host:port), the dial is
+// aborted once the context is done or its deadline is reached
+func DialContext(ctx context.Context, peerAddrPort string, opts ...StreamOpt) (*Stream, error) {
+	return DialVia(ctx, &Router{}, peerAddrPort, opts...)
+}
+
+// DialVia is like DialContext but the connection is opened by
+// dialer, e.g a SOCKS5 proxy or a Router choosing between proxies.
+// With WithV2Transport the v2 handshake is done before returning, a
+// remote that disconnects during it is dialed again using v1
+func DialVia(ctx context.Context, dialer ContextDialer, peerAddrPort string, opts ...StreamOpt) (*Stream, error) {
+	conn, err := dialer.DialContext(ctx, "tcp", peerAddrPort)
 	if err != nil {
-		return nil, fmt.Errorf("whiel dialing: %w", err)
+		return nil, fmt.Errorf("while dialing: %w", classify(err))
 	}
 
-	return &Stream{
-		tcpConn: conn,
-	}, nil
+	stream := NewStream(conn, opts...)
+	if !stream.preferV2 {
+		return stream, nil
+	}
+
+	err = stream.handshakeV2(ctx, true)
+	if err == nil {
+		return stream, nil
+	}
+	stream.Close()
+
+	// v1 only peers drop the connection since our key is not a valid message
+	if !IsDisconnect(err) || ctx.Err() != nil {
+		return nil, fmt.Errorf("while negotiating v2 transport: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s does not support v2 transport, falling back to v1\n", peerAddrPort)
+	return DialVia(ctx, dialer, peerAddrPort, append(opts, WithV2Transport(false))...)
 }
 
+// Send writes the bytes as they are, messages should be sent
+// with SendMessage which encrypts them on v2 streams
 func (s *Stream) Send(buff []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	return s.write(buff)
+}
+
+// write must be called holding writeMu
+func (s *Stream) write(buff []byte) error {
+	if s.writeTimeout > 0 {
+		err := s.tcpConn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		if err != nil {
+			return fmt.Errorf("while setting write deadline: %w", classify(err))
+		}
+	}
+
 	toBeSent := len(buff)
 	sent := 0
 
 	for sent != toBeSent {
-		n, err := s.tcpConn.Write(buff)
+		n, err := s.tcpConn.Write(buff[sent:])
 		if err != nil {
-			return fmt.Errorf("sent %d bytes, error while writing: %w", n, err)
+			return fmt.Errorf("sent %d bytes, error while writing: %w", n, classify(err))
 		}
 		sent += n
-		fmt.Printf("sent %d bytes (total %d)...\n", sent, toBeSent)
 	}
 
 	return nil
 }
 
-func (s *Stream) WaitResponse(buff []byte) (int, error) {
-	return s.tcpConn.Read(buff)
+// ReadMessage blocks until the remote sends a whole message, or the read
+// timeout (see WithReadTimeout) or the read deadline is reached
+func (s *Stream) ReadMessage() (*messages.Message, error) {
+	if s.readTimeout > 0 {
+		err := s.tcpConn.SetReadDeadline(time.Now().Add(s.readTimeout))
+		if err != nil {
+			return nil, fmt.Errorf("while setting read deadline: %w", classify(err))
+		}
+	}
+
+	var (
+		msg *messages.Message
+		err error
+	)
+	if s.v2 != nil {
+		msg, err = s.v2.readMessage(s.reader, s.magic, messages.DefaultRegistry())
+	} else {
+		msg, err = s.framer.ReadMessage()
+	}
+	if err != nil {
+		return nil, classify(err)
+	}
+	return msg, nil
+}
+
+// ReadMessageContext is like ReadMessage but gives up once the context is done
+func (s *Stream) ReadMessageContext(ctx context.Context) (*messages.Message, error) {
+	var msg *messages.Message
+	err := s.withContext(ctx, s.tcpConn.SetReadDeadline, func() (err error) {
+		msg, err = s.ReadMessage()
+		return err
+	})
+	return msg, err
+}
+
+// SendMessage encodes the payload within a message, using the
+// stream magic, and sends it to the remote
+func (s *Stream) SendMessage(command string, payload codec.Encodeable) error {
+	if s.v2 != nil {
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+
+		// packets must be written in the order they are encrypted
+		packet, err := s.v2.encodeMessage(command, payload)
+		if err != nil {
+			return err
+		}
+		return s.write(packet)
+	}
+
+	enc, err := messages.NewMessage(s.magic, []byte(command), payload).Encode()
+	if err != nil {
+		return fmt.Errorf("while encoding %s: %w", command, err)
+	}
+
+	return s.Send(enc)
+}
+
+// SendMessageContext is like SendMessage but gives up once the context is done
+func (s *Stream) SendMessageContext(ctx context.Context, command string, payload codec.Encodeable) error {
+	return s.withContext(ctx, s.tcpConn.SetWriteDeadline, func() error {
+		return s.SendMessage(command, payload)
+	})
+}
+
+// withContext runs op with the context deadline as the connection
+// deadline, cancelling the context unblocks op right away. A stream
+// whose operation was interrupted mid message should be closed
+func (s *Stream) withContext(ctx context.Context, setDeadline func(time.Time) error, op func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := setDeadline(deadline); err != nil {
+			return fmt.Errorf("while setting deadline: %w", classify(err))
+		}
+	}
+
+	// a deadline in the past wakes up the blocked read or write
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		setDeadline(time.Unix(1, 0))
+		close(interrupted)
+	})
+
+	// the deadline is cleared once the interruption, if any, is done
+	defer func() {
+		if !stop() {
+			<-interrupted
+		}
+		setDeadline(time.Time{})
+	}()
+
+	err := op()
+	if err == nil {
+		return nil
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %w", ctx.Err(), err)
+	}
+
+	// the connection deadline might fire right before the context one
+	if deadline, ok := ctx.Deadline(); ok && IsTimeout(err) && !time.Now().Before(deadline) {
+		return fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+	}
+	return err
+}
+
+func (s *Stream) Close() error {
+	return s.tcpConn.Close()
+}
+
+// SetDeadline sets the read and write deadlines of the underlying
+// connection, a zero value means reads and writes will not time out
+func (s *Stream) SetDeadline(t time.Time) error {
+	return s.tcpConn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for the next reads
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	return s.tcpConn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for the next writes
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	return s.tcpConn.SetWriteDeadline(t)
 }
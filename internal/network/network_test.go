@@ -0,0 +1,142 @@
+package network_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+	"github.com/stretchr/testify/require"
+)
+
+// tcpPair returns both ends of a loopback tcp connection
+func tcpPair(t *testing.T, opts ...network.StreamOpt) (*network.Stream, net.Conn) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lst.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, _ := lst.Accept()
+		accepted <- conn
+	}()
+
+	stream, err := network.DialContext(context.Background(), lst.Addr().String(), opts...)
+	require.NoError(t, err)
+
+	remote := <-accepted
+	require.NotNil(t, remote)
+
+	t.Cleanup(func() {
+		stream.Close()
+		remote.Close()
+	})
+	return stream, remote
+}
+
+func TestDialContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := network.DialContext(ctx, "127.0.0.1:1")
+	require.Error(t, err)
+}
+
+func TestDialContextRefused(t *testing.T) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := lst.Addr().String()
+	lst.Close()
+
+	_, err = network.DialContext(context.Background(), addr)
+	require.Error(t, err)
+}
+
+func TestReadTimeout(t *testing.T) {
+	stream, _ := tcpPair(t, network.WithReadTimeout(50*time.Millisecond))
+
+	_, err := stream.ReadMessage()
+	require.ErrorIs(t, err, network.ErrTimeout)
+	require.True(t, network.IsTimeout(err))
+	require.False(t, network.IsDisconnect(err))
+}
+
+func TestReadClosedByRemote(t *testing.T) {
+	stream, remote := tcpPair(t)
+	remote.Close()
+
+	_, err := stream.ReadMessage()
+	require.ErrorIs(t, err, network.ErrConnClosedByRemote)
+	require.True(t, network.IsDisconnect(err))
+}
+
+func TestReadClosedByRemoteMidPayload(t *testing.T) {
+	stream, remote := tcpPair(t)
+
+	payload := messages.RawPayload(make([]byte, 100))
+	enc, err := messages.NewMainMessage([]byte(messages.CmdPing), &payload).Encode()
+	require.NoError(t, err)
+
+	_, err = remote.Write(enc[:len(enc)-10])
+	require.NoError(t, err)
+	remote.Close()
+
+	_, err = stream.ReadMessage()
+	require.ErrorIs(t, err, network.ErrConnClosedByRemote)
+	require.True(t, network.IsDisconnect(err))
+}
+
+func TestReadClosedLocally(t *testing.T) {
+	stream, _ := tcpPair(t)
+	stream.Close()
+
+	_, err := stream.ReadMessage()
+	require.ErrorIs(t, err, network.ErrConnClosed)
+}
+
+func TestReadMessageContext(t *testing.T) {
+	stream, remote := tcpPair(t)
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := stream.ReadMessageContext(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.ErrorIs(t, err, network.ErrTimeout)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		_, err := stream.ReadMessageContext(ctx)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("message within deadline", func(t *testing.T) {
+		enc, err := messages.NewMessage(messages.MagicMain, []byte(messages.CmdPing), &messages.Ping{Nonce: 7}).Encode()
+		require.NoError(t, err)
+		_, err = remote.Write(enc)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		msg, err := stream.ReadMessageContext(ctx)
+		require.NoError(t, err)
+		require.Equal(t, &messages.Ping{Nonce: 7}, msg.Payload)
+	})
+}
+
+func TestSendMessageContextCancelled(t *testing.T) {
+	stream, _ := tcpPair(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := stream.SendMessageContext(ctx, messages.CmdPing, &messages.Ping{Nonce: 1})
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,332 @@
+package network
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SOCKS5 protocol constants, check: https://www.rfc-editor.org/rfc/rfc1928
+// and for the username/password authentication https://www.rfc-editor.org/rfc/rfc1929
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone         = 0x00
+	socks5AuthUserPassword = 0x02
+	socks5AuthUnacceptable = 0xFF
+
+	socks5UserPasswordVersion = 0x01
+
+	socks5CmdConnect = 0x01
+
+	socks5AtypIPv4   = 0x01
+	socks5AtypDomain = 0x03
+	socks5AtypIPv6   = 0x04
+
+	socks5Succeeded = 0x00
+)
+
+var (
+	ErrProxyAuthFailed      = errors.New("proxy authentication failed")
+	ErrProxyConnectFailed   = errors.New("proxy failed to connect")
+	ErrProxyProtocol        = errors.New("unexpected proxy reply")
+	ErrOnionRequiresProxy   = errors.New("onion addresses can only be reached through a proxy")
+	errSocks5HostnameLength = errors.New("hostname longer than 255 bytes")
+)
+
+// ContextDialer opens connections to host:port addresses, *net.Dialer,
+// *SOCKS5 and *Router satisfy it
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// SOCKS5 dials through a SOCKS5 proxy, e.g tor. Hostnames are sent to the
+// proxy unresolved, that is how .onion addresses and private DNS work
+type SOCKS5 struct {
+	// ProxyAddr is the proxy host:port
+	ProxyAddr string
+	// Username and Password authenticate against the proxy, leave
+	// them empty when the proxy does not require authentication
+	Username string
+	Password string
+	// IsolateStreams sends random credentials on every connection, tor
+	// then uses a different circuit for each of them (IsolateSOCKSAuth)
+	IsolateStreams bool
+	// Forward dials the proxy itself, defaults to a plain net.Dialer
+	Forward ContextDialer
+}
+
+// socks5Replies maps the reply field to its description
+var socks5Replies = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// proxiedAddr is the remote address of a proxied connection, the
+// connection itself only knows the address of the proxy
+type proxiedAddr string
+
+func (p proxiedAddr) Network() string { return "tcp" }
+func (p proxiedAddr) String() string  { return string(p) }
+
+type proxiedConn struct {
+	net.Conn
+	remote proxiedAddr
+}
+
+func (p *proxiedConn) RemoteAddr() net.Addr {
+	return p.remote
+}
+
+// DialContext connects to addr (host:port) through the proxy
+func (s *SOCKS5) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	forward := s.Forward
+	if forward == nil {
+		forward = &net.Dialer{}
+	}
+
+	conn, err := forward.DialContext(ctx, network, s.ProxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("while dialing proxy %s: %w", s.ProxyAddr, err)
+	}
+
+	// the negotiation follows the context, the deadline is cleared afterwards
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+
+	err = s.negotiate(conn, host, port)
+	if !stop() || ctx.Err() != nil {
+		conn.Close()
+		if err == nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("while negotiating with proxy %s: %w", s.ProxyAddr, err)
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("while negotiating with proxy %s: %w", s.ProxyAddr, err)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return &proxiedConn{Conn: conn, remote: proxiedAddr(addr)}, nil
+}
+
+func (s *SOCKS5) credentials() (username, password string, err error) {
+	if !s.IsolateStreams {
+		return s.Username, s.Password, nil
+	}
+
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", fmt.Errorf("while generating stream isolation credentials: %w", err)
+	}
+	return hex.EncodeToString(random[:4]), hex.EncodeToString(random[4:]), nil
+}
+
+func (s *SOCKS5) negotiate(conn net.Conn, host string, port uint16) error {
+	username, password, err := s.credentials()
+	if err != nil {
+		return err
+	}
+
+	method := byte(socks5AuthNone)
+	if username != "" || password != "" {
+		method = socks5AuthUserPassword
+	}
+
+	_, err = conn.Write([]byte{socks5Version, 1, method})
+	if err != nil {
+		return fmt.Errorf("while sending greeting: %w", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("while reading greeting reply: %w", err)
+	}
+
+	if reply[0] != socks5Version {
+		return fmt.Errorf("%w: version %d", ErrProxyProtocol, reply[0])
+	}
+
+	switch reply[1] {
+	case method:
+	case socks5AuthUnacceptable:
+		return fmt.Errorf("%w: no acceptable authentication method", ErrProxyAuthFailed)
+	default:
+		return fmt.Errorf("%w: authentication method %d was not offered", ErrProxyProtocol, reply[1])
+	}
+
+	if method == socks5AuthUserPassword {
+		if err := authenticate(conn, username, password); err != nil {
+			return err
+		}
+	}
+
+	return connect(conn, host, port)
+}
+
+func authenticate(conn net.Conn, username, password string) error {
+	if len(username) > 255 || len(password) > 255 {
+		return fmt.Errorf("%w: username and password must have at most 255 bytes", ErrProxyAuthFailed)
+	}
+
+	request := []byte{socks5UserPasswordVersion, byte(len(username))}
+	request = append(request, username...)
+	request = append(request, byte(len(password)))
+	request = append(request, password...)
+
+	if _, err := conn.Write(request); err != nil {
+		return fmt.Errorf("while sending credentials: %w", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("while reading authentication reply: %w", err)
+	}
+
+	if reply[1] != socks5Succeeded {
+		return fmt.Errorf("%w: status %d", ErrProxyAuthFailed, reply[1])
+	}
+	return nil
+}
+
+func connect(conn net.Conn, host string, port uint16) error {
+	request := []byte{socks5Version, socks5CmdConnect, 0x00}
+
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ip = ip.Unmap()
+		if ip.Is4() {
+			ip4 := ip.As4()
+			request = append(append(request, socks5AtypIPv4), ip4[:]...)
+		} else {
+			ip16 := ip.As16()
+			request = append(append(request, socks5AtypIPv6), ip16[:]...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errSocks5HostnameLength
+		}
+		request = append(request, socks5AtypDomain, byte(len(host)))
+		request = append(request, host...)
+	}
+	request = binary.BigEndian.AppendUint16(request, port)
+
+	if _, err := conn.Write(request); err != nil {
+		return fmt.Errorf("while sending connect request: %w", err)
+	}
+
+	// version, reply, reserved and the bound address type
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("while reading connect reply: %w", err)
+	}
+
+	if reply[0] != socks5Version {
+		return fmt.Errorf("%w: version %d", ErrProxyProtocol, reply[0])
+	}
+
+	if reply[1] != socks5Succeeded {
+		description, ok := socks5Replies[reply[1]]
+		if !ok {
+			description = fmt.Sprintf("reply %d", reply[1])
+		}
+		return fmt.Errorf("%w: %s", ErrProxyConnectFailed, description)
+	}
+
+	// the bound address is not used but must be consumed
+	var boundLen int
+	switch reply[3] {
+	case socks5AtypIPv4:
+		boundLen = 4
+	case socks5AtypIPv6:
+		boundLen = 16
+	case socks5AtypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return fmt.Errorf("while reading bound address: %w", err)
+		}
+		boundLen = int(length[0])
+	default:
+		return fmt.Errorf("%w: bound address type %d", ErrProxyProtocol, reply[3])
+	}
+
+	if _, err := io.ReadFull(conn, make([]byte, boundLen+2)); err != nil {
+		return fmt.Errorf("while reading bound address: %w", err)
+	}
+	return nil
+}
+
+func splitHostPort(addr string) (string, uint16, error) {
+	host, rawPort, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, fmt.Errorf("while parsing %s: %w", addr, err)
+	}
+
+	port, err := strconv.ParseUint(rawPort, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("while parsing %s port: %w", addr, err)
+	}
+	return host, uint16(port), nil
+}
+
+// IsOnion reports whether the host is a tor onion service
+func IsOnion(host string) bool {
+	return strings.HasSuffix(strings.ToLower(host), ".onion")
+}
+
+// Router picks how each address is dialed, onion addresses go through
+// OnionProxy (or Proxy when there is no onion specific proxy) and every
+// other address through Proxy, or directly when there is no proxy
+type Router struct {
+	Proxy      ContextDialer
+	OnionProxy ContextDialer
+	Direct     ContextDialer
+}
+
+func (r *Router) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing %s: %w", addr, err)
+	}
+
+	dialer := r.Proxy
+	if IsOnion(host) && r.OnionProxy != nil {
+		dialer = r.OnionProxy
+	}
+
+	if dialer == nil {
+		if IsOnion(host) {
+			return nil, fmt.Errorf("%w: %s", ErrOnionRequiresProxy, addr)
+		}
+
+		dialer = r.Direct
+		if dialer == nil {
+			dialer = &net.Dialer{}
+		}
+	}
+
+	return dialer.DialContext(ctx, network, addr)
+}
@@ -0,0 +1,307 @@
+package network_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+	"github.com/stretchr/testify/require"
+)
+
+type socksRequest struct {
+	username, password string
+	host               string
+	port               uint16
+}
+
+// socksProxy is a minimal SOCKS5 stand-in, every CONNECT is forwarded
+// to backend no matter the requested host, so onion hosts can be tested
+type socksProxy struct {
+	lst         net.Listener
+	backend     string
+	requireAuth bool
+	reply       byte
+	requests    chan socksRequest
+}
+
+func newSocksProxy(t *testing.T, backend string) *socksProxy {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { lst.Close() })
+
+	proxy := &socksProxy{lst: lst, backend: backend, requests: make(chan socksRequest, 10)}
+	go func() {
+		for {
+			conn, err := lst.Accept()
+			if err != nil {
+				return
+			}
+			go proxy.serve(conn)
+		}
+	}()
+	return proxy
+}
+
+func (p *socksProxy) addr() string {
+	return p.lst.Addr().String()
+}
+
+func (p *socksProxy) serve(conn net.Conn) {
+	defer conn.Close()
+	var req socksRequest
+
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return
+	}
+	methods := make([]byte, greeting[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	method := byte(0x00)
+	if p.requireAuth {
+		method = 0x02
+	}
+	if methods[0] != method {
+		conn.Write([]byte{0x05, 0xFF})
+		return
+	}
+	conn.Write([]byte{0x05, method})
+
+	if method == 0x02 {
+		header := make([]byte, 2)
+		io.ReadFull(conn, header)
+		username := make([]byte, header[1])
+		io.ReadFull(conn, username)
+		length := make([]byte, 1)
+		io.ReadFull(conn, length)
+		password := make([]byte, length[0])
+		io.ReadFull(conn, password)
+		req.username, req.password = string(username), string(password)
+		conn.Write([]byte{0x01, 0x00})
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+
+	switch header[3] {
+	case 0x01:
+		ip := make([]byte, 4)
+		io.ReadFull(conn, ip)
+		req.host = netip.AddrFrom4([4]byte(ip)).String()
+	case 0x04:
+		ip := make([]byte, 16)
+		io.ReadFull(conn, ip)
+		req.host = netip.AddrFrom16([16]byte(ip)).String()
+	case 0x03:
+		length := make([]byte, 1)
+		io.ReadFull(conn, length)
+		host := make([]byte, length[0])
+		io.ReadFull(conn, host)
+		req.host = string(host)
+	}
+
+	port := make([]byte, 2)
+	io.ReadFull(conn, port)
+	req.port = binary.BigEndian.Uint16(port)
+	p.requests <- req
+
+	if p.reply != 0x00 {
+		conn.Write([]byte{0x05, p.reply, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+
+	backend, err := net.Dial("tcp", p.backend)
+	if err != nil {
+		conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer backend.Close()
+
+	// the bound address is answered as a domain to exercise that path
+	conn.Write([]byte{0x05, 0x00, 0x00, 0x03, 4, 't', 'e', 's', 't', 0x20, 0x8d})
+
+	go io.Copy(backend, conn)
+	io.Copy(conn, backend)
+}
+
+// pingBackend answers a single ping with a pong
+func pingBackend(t *testing.T) string {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { lst.Close() })
+
+	go func() {
+		for {
+			conn, err := lst.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				stream := network.NewStream(conn)
+				defer stream.Close()
+
+				msg, err := stream.ReadMessage()
+				if err != nil {
+					return
+				}
+				if ping, ok := msg.Payload.(*messages.Ping); ok {
+					stream.SendMessage(messages.CmdPong, &messages.Pong{Nonce: ping.Nonce})
+				}
+			}()
+		}
+	}()
+	return lst.Addr().String()
+}
+
+func nextRequest(t *testing.T, proxy *socksProxy) socksRequest {
+	select {
+	case req := <-proxy.requests:
+		return req
+	case <-time.After(time.Second):
+		require.FailNow(t, "proxy did not receive the connect request")
+		return socksRequest{}
+	}
+}
+
+func requirePingPong(t *testing.T, stream *network.Stream) {
+	require.NoError(t, stream.SendMessage(messages.CmdPing, &messages.Ping{Nonce: 42}))
+	msg, err := stream.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, &messages.Pong{Nonce: 42}, msg.Payload)
+}
+
+func TestSOCKS5DialsOnionHost(t *testing.T) {
+	proxy := newSocksProxy(t, pingBackend(t))
+	onion := "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion:8333"
+
+	stream, err := network.DialVia(context.Background(), &network.SOCKS5{ProxyAddr: proxy.addr()}, onion)
+	require.NoError(t, err)
+	defer stream.Close()
+
+	// the hostname reaches the proxy unresolved
+	req := nextRequest(t, proxy)
+	require.Equal(t, "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion", req.host)
+	require.Equal(t, uint16(8333), req.port)
+	require.Equal(t, onion, stream.RemoteAddr().String())
+
+	requirePingPong(t, stream)
+}
+
+func TestSOCKS5DialsIPs(t *testing.T) {
+	proxy := newSocksProxy(t, pingBackend(t))
+	dialer := &network.SOCKS5{ProxyAddr: proxy.addr()}
+
+	for _, addr := range []string{"1.2.3.4:8333", "[2001:4860::8888]:18333"} {
+		stream, err := network.DialVia(context.Background(), dialer, addr)
+		require.NoError(t, err)
+
+		addrPort := netip.MustParseAddrPort(addr)
+		req := nextRequest(t, proxy)
+		require.Equal(t, addrPort.Addr().String(), req.host)
+		require.Equal(t, addrPort.Port(), req.port)
+
+		requirePingPong(t, stream)
+		stream.Close()
+	}
+}
+
+func TestSOCKS5Credentials(t *testing.T) {
+	proxy := newSocksProxy(t, pingBackend(t))
+	proxy.requireAuth = true
+
+	_, err := network.DialVia(context.Background(), &network.SOCKS5{ProxyAddr: proxy.addr()}, "1.2.3.4:8333")
+	require.ErrorIs(t, err, network.ErrProxyAuthFailed)
+
+	stream, err := network.DialVia(context.Background(),
+		&network.SOCKS5{ProxyAddr: proxy.addr(), Username: "satoshi", Password: "nakamoto"}, "1.2.3.4:8333")
+	require.NoError(t, err)
+	stream.Close()
+
+	req := nextRequest(t, proxy)
+	require.Equal(t, "satoshi", req.username)
+	require.Equal(t, "nakamoto", req.password)
+}
+
+func TestSOCKS5StreamIsolation(t *testing.T) {
+	proxy := newSocksProxy(t, pingBackend(t))
+	proxy.requireAuth = true
+	dialer := &network.SOCKS5{ProxyAddr: proxy.addr(), IsolateStreams: true}
+
+	seen := make(map[string]struct{})
+	for i := 0; i < 3; i++ {
+		stream, err := network.DialVia(context.Background(), dialer, "1.2.3.4:8333")
+		require.NoError(t, err)
+		stream.Close()
+
+		req := nextRequest(t, proxy)
+		require.NotEmpty(t, req.username)
+		seen[req.username+":"+req.password] = struct{}{}
+	}
+
+	// every connection uses its own credentials, so its own tor circuit
+	require.Len(t, seen, 3)
+}
+
+func TestSOCKS5ConnectFailure(t *testing.T) {
+	proxy := newSocksProxy(t, pingBackend(t))
+	proxy.reply = 0x04
+
+	_, err := network.DialVia(context.Background(), &network.SOCKS5{ProxyAddr: proxy.addr()}, "1.2.3.4:8333")
+	require.ErrorIs(t, err, network.ErrProxyConnectFailed)
+	require.ErrorContains(t, err, "host unreachable")
+}
+
+func TestSOCKS5ContextDeadline(t *testing.T) {
+	// a proxy that accepts but never answers
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lst.Close()
+	go func() {
+		conn, err := lst.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = network.DialVia(ctx, &network.SOCKS5{ProxyAddr: lst.Addr().String()}, "1.2.3.4:8333")
+	require.ErrorIs(t, err, network.ErrTimeout)
+}
+
+func TestRouter(t *testing.T) {
+	backend := pingBackend(t)
+	proxy, onionProxy := newSocksProxy(t, backend), newSocksProxy(t, backend)
+
+	router := &network.Router{
+		Proxy:      &network.SOCKS5{ProxyAddr: proxy.addr()},
+		OnionProxy: &network.SOCKS5{ProxyAddr: onionProxy.addr()},
+	}
+
+	stream, err := network.DialVia(context.Background(), router, "example.onion:8333")
+	require.NoError(t, err)
+	stream.Close()
+	require.Equal(t, "example.onion", nextRequest(t, onionProxy).host)
+
+	stream, err = network.DialVia(context.Background(), router, "1.2.3.4:8333")
+	require.NoError(t, err)
+	stream.Close()
+	require.Equal(t, "1.2.3.4", nextRequest(t, proxy).host)
+
+	// without any proxy onion addresses can not be reached
+	_, err = network.DialContext(context.Background(), "example.onion:8333")
+	require.ErrorIs(t, err, network.ErrOnionRequiresProxy)
+}
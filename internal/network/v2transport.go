@@ -0,0 +1,234 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/bip324"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/codec"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+)
+
+// maxContentsLength is the biggest v2 packet contents we accept,
+// the long message type encoding followed by the biggest payload
+const maxContentsLength = 1 + 12 + messages.MaxPayloadLength
+
+var (
+	ErrGarbageTerminatorNotFound = errors.New("garbage terminator not found")
+	ErrContentsTooLarge          = errors.New("v2 packet contents too large")
+)
+
+// v2Transport is the state of a BIP324 encrypted stream
+// check: https://github.com/bitcoin/bips/blob/master/bip-0324.mediawiki
+type v2Transport struct {
+	cipher *bip324.Cipher
+	// aad authenticates the remote garbage with its first packet
+	recvAAD []byte
+	// sendAAD authenticates our garbage with our first packet
+	sendAAD []byte
+}
+
+// v1Prefix is how every v1 connection starts, the magic followed by the
+// version command, a responder seeing it falls back to the v1 transport
+func v1Prefix(magic messages.Magic) []byte {
+	prefix := binary.LittleEndian.AppendUint32(nil, uint32(magic))
+	command := make([]byte, 12)
+	copy(command, messages.CmdVersion)
+	return append(prefix, command...)
+}
+
+func randomGarbage() ([]byte, error) {
+	size, err := rand.Int(rand.Reader, big.NewInt(bip324.MaxGarbageSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	garbage := make([]byte, size.Int64())
+	if _, err := rand.Read(garbage); err != nil {
+		return nil, err
+	}
+	return garbage, nil
+}
+
+// handshakeV2 runs the BIP324 handshake, as the initiator or as the responder,
+// every read and write of the handshake gives up once the context is done
+func (s *Stream) handshakeV2(ctx context.Context, initiator bool) error {
+	return s.withContext(ctx, s.tcpConn.SetDeadline, func() error {
+		cipher, err := bip324.NewCipher()
+		if err != nil {
+			return err
+		}
+
+		garbage, err := randomGarbage()
+		if err != nil {
+			return fmt.Errorf("while generating garbage: %w", err)
+		}
+
+		ours := cipher.EllSwift()
+		if err := s.Send(append(ours[:], garbage...)); err != nil {
+			return fmt.Errorf("while sending public key: %w", err)
+		}
+
+		var theirs [bip324.EllSwiftSize]byte
+		if _, err := io.ReadFull(s.reader, theirs[:]); err != nil {
+			return fmt.Errorf("while reading public key: %w", classify(err))
+		}
+
+		if err := cipher.Initialize(theirs, initiator, s.magic); err != nil {
+			return err
+		}
+
+		transport := &v2Transport{cipher: cipher, sendAAD: garbage}
+		terminator := cipher.SendGarbageTerminator()
+		if err := s.Send(terminator[:]); err != nil {
+			return fmt.Errorf("while sending garbage terminator: %w", err)
+		}
+
+		// the version packet has no contents, they are reserved for future use
+		version, err := transport.encrypt(nil, false)
+		if err != nil {
+			return err
+		}
+		if err := s.Send(version); err != nil {
+			return fmt.Errorf("while sending version packet: %w", err)
+		}
+
+		transport.recvAAD, err = readGarbage(s.reader, cipher.ReceiveGarbageTerminator())
+		if err != nil {
+			return err
+		}
+
+		if _, err := transport.readPacket(s.reader); err != nil {
+			return fmt.Errorf("while reading version packet: %w", err)
+		}
+
+		s.v2 = transport
+		return nil
+	})
+}
+
+// acceptV2 runs the responder side of the handshake unless the remote
+// started a v1 connection, in which case the stream stays v1
+func (s *Stream) acceptV2(ctx context.Context) error {
+	prefix := v1Prefix(s.magic)
+	peeked := make([]byte, len(prefix))
+
+	err := s.withContext(ctx, s.tcpConn.SetReadDeadline, func() error {
+		_, err := io.ReadFull(s.reader, peeked)
+		return classify(err)
+	})
+	if err != nil {
+		return fmt.Errorf("while reading transport prefix: %w", err)
+	}
+
+	// the peeked bytes are read again by whichever transport is used
+	s.setReader(io.MultiReader(bytes.NewReader(peeked), s.reader))
+	if bytes.Equal(peeked, prefix) {
+		return nil
+	}
+
+	return s.handshakeV2(ctx, false)
+}
+
+// readGarbage discards the remote garbage up to the terminator and returns it
+func readGarbage(r io.Reader, terminator [bip324.GarbageTerminatorSize]byte) ([]byte, error) {
+	buf := make([]byte, 0, bip324.MaxGarbageSize+bip324.GarbageTerminatorSize)
+	chunk := make([]byte, 1)
+
+	for len(buf) < cap(buf) {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, fmt.Errorf("while reading garbage: %w", classify(err))
+		}
+
+		buf = append(buf, chunk[0])
+		if bytes.HasSuffix(buf, terminator[:]) {
+			return buf[:len(buf)-bip324.GarbageTerminatorSize], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: read %d bytes", ErrGarbageTerminatorNotFound, len(buf))
+}
+
+// encrypt builds the next packet, the first one authenticates our garbage
+func (t *v2Transport) encrypt(contents []byte, ignore bool) ([]byte, error) {
+	aad := t.sendAAD
+	t.sendAAD = nil
+	return t.cipher.Encrypt(contents, aad, ignore)
+}
+
+// readPacket returns the contents of the next packet that is not a decoy
+func (t *v2Transport) readPacket(r io.Reader) ([]byte, error) {
+	for {
+		var encLength [bip324.LengthSize]byte
+		if _, err := io.ReadFull(r, encLength[:]); err != nil {
+			return nil, fmt.Errorf("while reading packet length: %w", classify(err))
+		}
+
+		length, err := t.cipher.DecryptLength(encLength)
+		if err != nil {
+			return nil, err
+		}
+
+		if length > maxContentsLength {
+			return nil, fmt.Errorf("%w: %d bytes, limit is %d", ErrContentsTooLarge, length, maxContentsLength)
+		}
+
+		packet := make([]byte, bip324.HeaderSize+int(length)+bip324.TagSize)
+		if _, err := io.ReadFull(r, packet); err != nil {
+			return nil, fmt.Errorf("while reading packet: %w", classify(err))
+		}
+
+		aad := t.recvAAD
+		t.recvAAD = nil
+
+		contents, ignore, err := t.cipher.Decrypt(packet, aad)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ignore {
+			return contents, nil
+		}
+	}
+}
+
+// readMessage reads the next message and decodes its payload
+func (t *v2Transport) readMessage(r io.Reader, magic messages.Magic, registry *messages.Registry) (*messages.Message, error) {
+	contents, err := t.readPacket(r)
+	if err != nil {
+		return nil, err
+	}
+
+	command, raw, err := bip324.DecodeContents(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := registry.Decode(command, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages.NewMessage(magic, []byte(command), payload), nil
+}
+
+// encodeMessage builds the packet carrying the message
+func (t *v2Transport) encodeMessage(command string, payload codec.Encodeable) ([]byte, error) {
+	raw, err := payload.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("while encoding %s: %w", command, err)
+	}
+
+	contents, err := bip324.EncodeContents(command, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.encrypt(contents, false)
+}
@@ -0,0 +1,103 @@
+package network_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/EclesioMeloJunior/btc-handshake/internal/messages"
+	"github.com/EclesioMeloJunior/btc-handshake/internal/network"
+	"github.com/stretchr/testify/require"
+)
+
+type servedStream struct {
+	encrypted bool
+	sessionID [32]byte
+}
+
+// pongHandler reports how the stream was negotiated and answers pings until the remote leaves
+func pongHandler(served chan<- servedStream) network.Handler {
+	return func(ctx context.Context, stream *network.Stream) {
+		served <- servedStream{encrypted: stream.Encrypted(), sessionID: stream.SessionID()}
+
+		for {
+			msg, err := stream.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			switch payload := msg.Payload.(type) {
+			case *messages.Ping:
+				stream.SendMessage(messages.CmdPong, &messages.Pong{Nonce: payload.Nonce})
+			case messages.EmptyPayload:
+				stream.SendMessage(string(msg.Command), payload)
+			}
+		}
+	}
+}
+
+func nextServed(t *testing.T, served <-chan servedStream) servedStream {
+	select {
+	case s := <-served:
+		return s
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "connection was not served")
+		return servedStream{}
+	}
+}
+
+func TestV2Transport(t *testing.T) {
+	served := make(chan servedStream, 1)
+	lst, _, _ := serve(t, network.ListenConfig{
+		Handler:    pongHandler(served),
+		StreamOpts: []network.StreamOpt{network.WithV2Transport(true)},
+	})
+
+	stream, err := network.DialContext(context.Background(), lst.Addr().String(), network.WithV2Transport(true))
+	require.NoError(t, err)
+	defer stream.Close()
+
+	remote := nextServed(t, served)
+	require.True(t, stream.Encrypted())
+	require.True(t, remote.encrypted)
+	require.Equal(t, stream.SessionID(), remote.sessionID)
+
+	// ping has a short message type, verack is sent in full
+	requirePingPong(t, stream)
+	require.NoError(t, stream.SendMessage(messages.CmdVerAck, messages.EmptyPayload{}))
+	msg, err := stream.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, messages.CmdVerAck, string(msg.Command))
+
+	// enough messages for both sides to rekey
+	for i := 0; i < 300; i++ {
+		requirePingPong(t, stream)
+	}
+}
+
+func TestV2TransportFallsBackToV1(t *testing.T) {
+	// the backend only speaks v1 and drops the v2 handshake
+	stream, err := network.DialContext(context.Background(), pingBackend(t), network.WithV2Transport(true))
+	require.NoError(t, err)
+	defer stream.Close()
+
+	require.False(t, stream.Encrypted())
+	requirePingPong(t, stream)
+}
+
+func TestV2ListenerAcceptsV1(t *testing.T) {
+	served := make(chan servedStream, 1)
+	lst, _, _ := serve(t, network.ListenConfig{
+		Handler:    pongHandler(served),
+		StreamOpts: []network.StreamOpt{network.WithV2Transport(true)},
+	})
+
+	stream, err := network.DialContext(context.Background(), lst.Addr().String())
+	require.NoError(t, err)
+	defer stream.Close()
+
+	// the listener only tells the transports apart once the version arrives
+	require.NoError(t, stream.SendMessage(messages.CmdVersion, &messages.Version{}))
+	require.False(t, nextServed(t, served).encrypted)
+	requirePingPong(t, stream)
+}